@@ -0,0 +1,112 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ActionMarker is hidden in the comment the action command posts, so that
+// when a pull request is updated and the action runs again, it replaces its
+// comment rather than adding another.
+const ActionMarker = "<!-- chatproxy-action -->"
+
+// PullRequestEvent is the part of a GitHub Actions pull_request event the
+// action command needs: the pull request, and the commits it merges from
+// and into.
+type PullRequestEvent struct {
+	Number int
+	Base   string
+	Head   string
+}
+
+// ReadPullRequestEvent reads the pull request from the event payload at
+// path, which GitHub Actions gives in GITHUB_EVENT_PATH.
+func ReadPullRequestEvent(path string) (PullRequestEvent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PullRequestEvent{}, err
+	}
+	var event struct {
+		PullRequest *struct {
+			Number int `json:"number"`
+			Base   struct {
+				SHA string `json:"sha"`
+			} `json:"base"`
+			Head struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+		} `json:"pull_request"`
+	}
+	err = json.Unmarshal(data, &event)
+	if err != nil {
+		return PullRequestEvent{}, fmt.Errorf("reading the event: %w", err)
+	}
+	if event.PullRequest == nil {
+		return PullRequestEvent{}, errors.New("the event isn't for a pull request; run the action on pull_request events")
+	}
+	return PullRequestEvent{
+		Number: event.PullRequest.Number,
+		Base:   event.PullRequest.Base.SHA,
+		Head:   event.PullRequest.Head.SHA,
+	}, nil
+}
+
+// ActionComment returns the comment the action command posts on a pull
+// request: a summary of the change, written as its description would be,
+// and the findings of a review of it.
+func ActionComment(pr PullRequest, findings []Finding) string {
+	comment := new(strings.Builder)
+	fmt.Fprintf(comment, "%s\n## %s\n\n%s\n\n### Review\n\n", ActionMarker, pr.Title, strings.TrimSpace(pr.Body))
+	if len(findings) == 0 {
+		comment.WriteString("No findings.\n")
+	}
+	for _, f := range findings {
+		fmt.Fprintf(comment, "- `%s:%d` **%s**: %s\n", f.File, f.Line, f.Severity, f.Issue)
+		if f.Suggestion != "" {
+			fmt.Fprintf(comment, "  Suggestion: %s\n", f.Suggestion)
+		}
+	}
+	comment.WriteString("\n_Written by an automated review; check it before acting on it._\n")
+	return comment.String()
+}
+
+// CommentOnce adds a comment to an issue or pull request, or if one of its
+// comments already contains marker, replaces that one, returning the
+// comment's URL.
+func (g *GitHub) CommentOnce(number int, marker, body string) (string, error) {
+	var comments []struct {
+		ID   int64  `json:"id"`
+		Body string `json:"body"`
+	}
+	err := g.do(http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", g.Repo, number), nil, &comments)
+	if err != nil {
+		return "", err
+	}
+	for _, comment := range comments {
+		if strings.Contains(comment.Body, marker) {
+			var updated posted
+			err := g.do(http.MethodPatch, fmt.Sprintf("/repos/%s/issues/comments/%d", g.Repo, comment.ID), map[string]string{"body": body}, &updated)
+			return updated.HTMLURL, err
+		}
+	}
+	return g.Comment(number, body)
+}
+
+// appendStepSummary adds text to the job summary GitHub Actions shows for
+// the run, at path, which it gives in GITHUB_STEP_SUMMARY.
+func appendStepSummary(path, text string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strings.ReplaceAll(text, ActionMarker+"\n", "") + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
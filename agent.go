@@ -0,0 +1,111 @@
+package chatproxy
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// AgentPrompt is appended to the purpose in agent mode, explaining to the
+// model how it may ask for shell commands to be run.
+const AgentPrompt = `
+You may run shell commands on the user's machine to help complete the task.
+To run a command, reply with it in a fenced code block tagged "sh", one command per block.
+The user will confirm each command before it runs, and its output will be sent back to you.
+When the task is complete, reply without any sh code blocks.`
+
+// AgentMaxSteps limits how many rounds of commands the agent may run for a single user message.
+var AgentMaxSteps = 5
+
+// AgentMaxOutput limits how many bytes of command output are sent back to the model.
+var AgentMaxOutput = 8000
+
+// WithAgent enables agent mode, where the assistant may propose shell commands
+// that are run, after confirmation, with their output fed back into the conversation.
+func WithAgent(agent bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.agent = agent
+		return c
+	}
+}
+
+type Agent struct{ input string }
+
+// Execute method for Agent strategy sends the user input
+// like the Default strategy, then runs any shell commands
+// the assistant proposes once the user confirms them,
+// feeding their output back until the task is complete.
+func (s Agent) Execute(c *ChatGPTClient) error {
+	c.RecordMessage(RoleUser, s.input)
+	for step := 0; step < AgentMaxSteps; step++ {
+		reply, err := c.GetCompletion()
+		if err != nil {
+			return err
+		}
+		c.RecordMessage(RoleBot, reply)
+		commands := ShellCommands(reply)
+		if len(commands) == 0 {
+			return nil
+		}
+		var results []string
+		for _, command := range commands {
+			result, err := c.confirmAndRun(command)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		c.RecordMessage(RoleUser, strings.Join(results, "\n\n"))
+	}
+	c.LogOut(fmt.Sprintf("Agent stopped after %d steps", AgentMaxSteps))
+	return nil
+}
+
+func (c *ChatGPTClient) confirmAndRun(command string) (string, error) {
+	c.Prompt(fmt.Sprintf("Run command? (y/N)\n%s", command))
+	answer, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		return fmt.Sprintf("The user declined to run:\n%s", command), nil
+	}
+	output, err := RunShellCommand(command)
+	status := "succeeded"
+	if err != nil {
+		status = fmt.Sprintf("failed (%s)", err)
+	}
+	c.LogOut(output)
+	return fmt.Sprintf("Command %s:\n%s\nOutput:\n%s", status, command, output), nil
+}
+
+// ShellCommands returns the shell commands proposed in a reply, which are
+// the contents of its sh, bash or shell tagged code blocks.
+func ShellCommands(reply string) []string {
+	var commands []string
+	for _, block := range CodeBlocks(reply) {
+		switch block.Lang {
+		case "sh", "bash", "shell", "console":
+			if cmd := strings.TrimSpace(block.Code); cmd != "" {
+				commands = append(commands, cmd)
+			}
+		}
+	}
+	return commands
+}
+
+// RunShellCommand runs command with sh, returning its combined output
+// truncated to AgentMaxOutput bytes.
+func RunShellCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	buf := new(bytes.Buffer)
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+	err := cmd.Run()
+	output := buf.String()
+	if len(output) > AgentMaxOutput {
+		output = output[:AgentMaxOutput] + "\n... output truncated"
+	}
+	return output, err
+}
@@ -0,0 +1,234 @@
+package chatproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The kinds of batch job.
+const (
+	BatchAsk  = "ask"
+	BatchTLDR = "tldr"
+)
+
+// BatchJob is a job in a batch file, which has one per line as JSON:
+//
+//	{"id": "q1", "prompt": "What is a goroutine?"}
+//	{"id": "q2", "prompt": "Which license does it use?", "paths": ["README.md"]}
+//	{"id": "s1", "type": "tldr", "paths": ["https://go.dev/blog/"], "length": "bullets"}
+//
+// An ask job, the default, answers the prompt about the files, directories
+// or URLs in paths, if any. A tldr job summarises paths, following the
+// prompt as instructions for the summary, if it has one.
+type BatchJob struct {
+	ID     string        `json:"id"`
+	Type   string        `json:"type"`
+	Prompt string        `json:"prompt"`
+	Paths  []string      `json:"paths"`
+	Length SummaryLength `json:"length"`
+	Model  string        `json:"model"`
+}
+
+// BatchResult is the result of a batch job, written as a line of JSON. A
+// job that failed has an error rather than output.
+type BatchResult struct {
+	ID     string `json:"id"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Usage  Usage  `json:"usage"`
+}
+
+// BatchOptions set how a batch is run.
+type BatchOptions struct {
+	// Concurrency is how many jobs run at once; one if it isn't positive.
+	Concurrency int
+	// RateLimit is the most jobs started in a minute, including retries, or
+	// 0 for no limit.
+	RateLimit int
+}
+
+// A job the API rate limits is tried BatchAttempts times, waiting
+// BatchRetryDelay, then twice that, between attempts.
+var (
+	BatchAttempts   = 3
+	BatchRetryDelay = 10 * time.Second
+)
+
+// ReadBatchJobs reads a batch file, checking each job. Blank lines are
+// skipped, and jobs without an id are given their line number.
+func ReadBatchJobs(r io.Reader) ([]BatchJob, error) {
+	var jobs []BatchJob
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(MaxUploadBytes))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var job BatchJob
+		err := json.Unmarshal([]byte(text), &job)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if job.ID == "" {
+			job.ID = fmt.Sprint(line)
+		}
+		if job.Type == "" {
+			job.Type = BatchAsk
+		}
+		err = job.validate()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+func (j BatchJob) validate() error {
+	switch j.Type {
+	case BatchAsk:
+		if strings.TrimSpace(j.Prompt) == "" {
+			return errors.New("an ask job needs a prompt")
+		}
+	case BatchTLDR:
+		if len(j.Paths) == 0 {
+			return errors.New("a tldr job needs paths to summarise")
+		}
+	default:
+		return fmt.Errorf("unknown job type %q, expected %s or %s", j.Type, BatchAsk, BatchTLDR)
+	}
+	_, err := ParseSummaryLength(string(j.Length))
+	return err
+}
+
+// RunBatch runs the jobs, each a conversation of its own with the settings
+// of c, writing the result of each to w as it finishes, so results are in
+// the order the jobs finish rather than the order they were given. It
+// returns how many jobs failed. A job that fails doesn't stop the others;
+// only a failure to write a result does.
+func (c *ChatGPTClient) RunBatch(jobs []BatchJob, opts BatchOptions, w io.Writer) (failed int, err error) {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var limiter *rateLimiter
+	if opts.RateLimit > 0 {
+		limiter = &rateLimiter{interval: time.Minute / time.Duration(opts.RateLimit)}
+	}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		writeErr error
+	)
+	enc := json.NewEncoder(w)
+	queue := make(chan BatchJob)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				result := c.runBatchJob(job, limiter)
+				mu.Lock()
+				if result.Error != "" {
+					failed++
+				}
+				if writeErr == nil {
+					writeErr = enc.Encode(result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		mu.Lock()
+		stop := writeErr != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	return failed, writeErr
+}
+
+// runBatchJob runs a job, trying it again if the API rate limits it.
+func (c *ChatGPTClient) runBatchJob(job BatchJob, limiter *rateLimiter) BatchResult {
+	result := BatchResult{ID: job.ID}
+	delay := BatchRetryDelay
+	for attempt := 1; ; attempt++ {
+		limiter.wait()
+		conv := c.newConversation()
+		// Nothing can be asked while a batch runs, so prompts over the cost
+		// or token limit are refused, unless --yes was given.
+		conv.input = bufio.NewReader(strings.NewReader(""))
+		conv.sessionUsage = Usage{}
+		if job.Model != "" {
+			conv.model = job.Model
+		}
+		output, err := conv.batchOutput(job)
+		result.Usage = result.Usage.Add(conv.SessionUsage())
+		if err == nil {
+			result.Output = output
+			return result
+		}
+		if !errors.Is(err, ErrRateLimited) || attempt >= BatchAttempts {
+			result.Error = err.Error()
+			return result
+		}
+		c.Logger().Debug("job rate limited, trying again", "job", job.ID, "delay", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// batchOutput does what the job asks.
+func (c *ChatGPTClient) batchOutput(job BatchJob) (string, error) {
+	if job.Type == BatchTLDR {
+		return c.TLDRAll(job.Paths, SummaryOptions{Length: job.Length, Instructions: job.Prompt})
+	}
+	if len(job.Paths) == 0 {
+		return c.Ask(job.Prompt)
+	}
+	contents := make([]string, 0, len(job.Paths))
+	for _, path := range job.Paths {
+		content, err := c.GetContent(path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		contents = append(contents, content)
+	}
+	return c.AskAbout(strings.Join(contents, "\n"), job.Prompt)
+}
+
+// rateLimiter spaces out the starts of jobs evenly. A nil rateLimiter
+// doesn't limit them.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait waits until the next job may start.
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
@@ -0,0 +1,129 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// BranchConfig sets the convention branch names follow, usually for a
+// repository in its project config:
+//
+//	branch:
+//	  format: "{type}/{ticket}-{slug}"
+//
+// In the format, {slug} is a short kebab-case description of the work,
+// {type} is the kind of change, one of ConventionalCommitTypes, and
+// {ticket} is the ticket id.
+type BranchConfig struct {
+	Format string `yaml:"format"`
+}
+
+// DefaultBranchFormat is the format of branch names when none is set.
+const DefaultBranchFormat = "{slug}"
+
+func (cfg BranchConfig) validate() error {
+	if cfg.Format != "" && !strings.Contains(cfg.Format, "{slug}") {
+		return fmt.Errorf("branch.format %q must contain {slug}", cfg.Format)
+	}
+	return nil
+}
+
+func (cfg *BranchConfig) override(o BranchConfig) {
+	if o.Format != "" {
+		cfg.Format = o.Format
+	}
+}
+
+// BranchSlugLimit is the longest slug a branch name is given.
+const BranchSlugLimit = 40
+
+var (
+	ticketID = regexp.MustCompile(`\b[A-Z][A-Z0-9]+-\d+\b`)
+	nonSlug  = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// dropTicket removes {ticket} from format, with the separator after it,
+// or if there isn't one, the separator before it.
+func dropTicket(format string) string {
+	for _, sep := range []string{"-", "_", "/"} {
+		if strings.Contains(format, "{ticket}"+sep) {
+			return strings.Replace(format, "{ticket}"+sep, "", 1)
+		}
+	}
+	for _, sep := range []string{"-", "_", "/"} {
+		if strings.Contains(format, sep+"{ticket}") {
+			return strings.Replace(format, sep+"{ticket}", "", 1)
+		}
+	}
+	return strings.Replace(format, "{ticket}", "", 1)
+}
+
+// kebabCase returns s in lower case with runs of anything but letters and
+// digits replaced by a hyphen, cut at a word boundary to at most limit
+// characters.
+func kebabCase(s string, limit int) string {
+	s = strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(s) > limit {
+		s = s[:limit]
+		if i := strings.LastIndex(s, "-"); i > 0 {
+			s = s[:i]
+		}
+	}
+	return strings.Trim(s, "-")
+}
+
+// BranchName asks the model for a branch name for the work described, in
+// the format given by cfg. The ticket id is taken from the description if
+// ticket is empty; a format with a {ticket} but no ticket id leaves it out.
+func (c *ChatGPTClient) BranchName(description, ticket string, cfg BranchConfig) (string, error) {
+	if cfg.Format == "" {
+		cfg.Format = DefaultBranchFormat
+	}
+	if ticket == "" {
+		ticket = ticketID.FindString(description)
+	}
+	c.SetPurpose(`Please name a git branch for the work the user describes.
+	The slug is a few words in kebab-case, such as "retry-failed-uploads", saying what the work does; leave out any ticket id.
+	The type is the kind of change, one of ` + strings.Join(ConventionalCommitTypes, ", ") + `.
+	Reply with only a JSON object, like this:
+	{"type": "feat", "slug": "retry-failed-uploads"}`)
+	c.RecordMessage(RoleUser, description)
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	var parts struct {
+		Type string `json:"type"`
+		Slug string `json:"slug"`
+	}
+	err = json.Unmarshal([]byte(reply), &parts)
+	if err != nil {
+		return "", errors.New("couldn't parse the branch name: " + err.Error())
+	}
+	slug := kebabCase(parts.Slug, BranchSlugLimit)
+	if slug == "" {
+		return "", errors.New("the model didn't suggest a branch name")
+	}
+	kind := kebabCase(parts.Type, BranchSlugLimit)
+	if !containsString(ConventionalCommitTypes, kind) {
+		kind = "feat"
+	}
+	name := cfg.Format
+	if ticket == "" {
+		name = dropTicket(name)
+	}
+	name = strings.NewReplacer("{slug}", slug, "{type}", kind, "{ticket}", ticket).Replace(name)
+	err = exec.Command("git", "check-ref-format", "--branch", name).Run()
+	if err != nil {
+		return "", fmt.Errorf("%q isn't a valid branch name", name)
+	}
+	return name, nil
+}
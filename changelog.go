@@ -0,0 +1,196 @@
+package chatproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ChangelogSections are the sections of a changelog entry, in the order
+// they are written: the Keep a Changelog sections, led by any breaking
+// changes.
+var ChangelogSections = []string{"Breaking Changes", "Added", "Changed", "Deprecated", "Removed", "Fixed", "Security"}
+
+// ChangelogEntry is the changelog entry for a release.
+type ChangelogEntry struct {
+	Version  string             `json:"version"`
+	Date     string             `json:"date,omitempty"`
+	Sections []ChangelogSection `json:"sections"`
+}
+
+// ChangelogSection lists the changes of one kind in a release.
+type ChangelogSection struct {
+	Title   string   `json:"title"`
+	Entries []string `json:"entries"`
+}
+
+// GitCommit is a commit read from git log.
+type GitCommit struct {
+	Hash    string `json:"hash"`
+	Subject string `json:"subject"`
+	Body    string `json:"body,omitempty"`
+}
+
+// gitLog returns the commits in revRange, such as v1.2.0..HEAD, newest
+// first. Merge commits are left out, or with merges true, are all that is
+// returned.
+func gitLog(revRange string, merges bool) ([]GitCommit, error) {
+	only := "--no-merges"
+	if merges {
+		only = "--merges"
+	}
+	cmd := exec.Command("git", "log", only, gitLogFormat, revRange, "--")
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	err := runGit(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitLog(out.String()), nil
+}
+
+// gitLogFormat is the git log format parseGitLog reads.
+const gitLogFormat = "--format=%H%x1f%s%x1f%b%x1e"
+
+// parseGitLog reads the commits from git output in gitLogFormat.
+func parseGitLog(out string) []GitCommit {
+	var commits []GitCommit
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		commits = append(commits, GitCommit{Hash: fields[0], Subject: fields[1], Body: strings.TrimSpace(fields[2])})
+	}
+	return commits
+}
+
+// releaseOf returns the version and date of the release ending at rev: the
+// tag at rev without any leading v, and the date of its commit, or
+// Unreleased and no date if rev isn't tagged.
+func releaseOf(rev string) (version, date string) {
+	out, err := exec.Command("git", "describe", "--tags", "--exact-match", rev).Output()
+	if err != nil {
+		return "Unreleased", ""
+	}
+	version = strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
+	out, err = exec.Command("git", "log", "-1", "--format=%cs", rev).Output()
+	if err != nil {
+		return version, ""
+	}
+	return version, strings.TrimSpace(string(out))
+}
+
+// ChangelogRange returns the revision range for a changelog given a
+// single ref, which covers the commits from ref to HEAD. A range such as
+// v1.2.0..v1.3.0 is returned as it is.
+func ChangelogRange(ref string) string {
+	if strings.Contains(ref, "..") {
+		return ref
+	}
+	return ref + "..HEAD"
+}
+
+// Changelog writes the changelog entry for the commits in revRange, such
+// as v1.2.0..HEAD, grouping the changes into ChangelogSections. The
+// release is named after the tag at the end of the range, or Unreleased.
+func (c *ChatGPTClient) Changelog(revRange string) (ChangelogEntry, error) {
+	commits, err := gitLog(revRange, false)
+	if err != nil {
+		return ChangelogEntry{}, err
+	}
+	if len(commits) == 0 {
+		return ChangelogEntry{}, fmt.Errorf("there are no commits in %s", revRange)
+	}
+	c.SetPurpose(`Please write a changelog entry for a release from the git commits provided, for the project's users.
+	Group the changes under these sections: ` + strings.Join(ChangelogSections, ", ") + `.
+	A change that breaks compatibility, such as a commit marked with ! or a BREAKING CHANGE footer, goes under Breaking Changes, saying what users need to do.
+	Each entry is one line describing one change, combining commits that make the same change.
+	Leave out changes that don't matter to users, such as refactoring, tests and CI.
+	Reply with only a JSON object whose keys are section names and whose values are arrays of entries, like this:
+	{"Added": ["A --timeout flag for every command"], "Fixed": ["Crash when the config file is empty"]}`)
+	c.RecordMessage(RoleUser, formatCommits(commits))
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return ChangelogEntry{}, err
+	}
+	sections, err := parseChangelogReply(reply)
+	if err != nil {
+		return ChangelogEntry{}, err
+	}
+	version, date := releaseOf(rangeEnd(revRange))
+	return ChangelogEntry{Version: version, Date: date, Sections: sections}, nil
+}
+
+// formatCommits lists commits for the model, one per line with their
+// bodies indented below.
+func formatCommits(commits []GitCommit) string {
+	history := new(strings.Builder)
+	for _, commit := range commits {
+		fmt.Fprintf(history, "- %s\n", commit.Subject)
+		if commit.Body != "" {
+			fmt.Fprintf(history, "  %s\n", strings.ReplaceAll(commit.Body, "\n", "\n  "))
+		}
+	}
+	return history.String()
+}
+
+// rangeEnd returns the revision at the end of revRange.
+func rangeEnd(revRange string) string {
+	_, end, _ := strings.Cut(revRange, "..")
+	end = strings.TrimPrefix(end, ".")
+	if end == "" {
+		return "HEAD"
+	}
+	return end
+}
+
+// parseChangelogReply reads the model's entries, in the order of
+// ChangelogSections. Sections without entries, or that aren't known, are
+// left out.
+func parseChangelogReply(reply string) ([]ChangelogSection, error) {
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	var entries map[string][]string
+	err := json.Unmarshal([]byte(reply), &entries)
+	if err != nil {
+		return nil, errors.New("couldn't parse the changelog: " + err.Error())
+	}
+	var sections []ChangelogSection
+	for _, title := range ChangelogSections {
+		for key, list := range entries {
+			if strings.EqualFold(key, title) && len(list) > 0 {
+				sections = append(sections, ChangelogSection{Title: title, Entries: list})
+			}
+		}
+	}
+	return sections, nil
+}
+
+// WriteChangelog writes the changelog entry to w as Markdown in the Keep a
+// Changelog format, or as JSON if format is OutputJSON.
+func WriteChangelog(w io.Writer, cl ChangelogEntry, format string) error {
+	if format == OutputJSON {
+		return json.NewEncoder(w).Encode(cl)
+	}
+	md := new(strings.Builder)
+	fmt.Fprintf(md, "## [%s]", cl.Version)
+	if cl.Date != "" {
+		fmt.Fprintf(md, " - %s", cl.Date)
+	}
+	md.WriteString("\n")
+	for _, section := range cl.Sections {
+		fmt.Fprintf(md, "\n### %s\n", section.Title)
+		for _, entry := range section.Entries {
+			fmt.Fprintf(md, "- %s\n", entry)
+		}
+	}
+	_, err := io.WriteString(w, md.String())
+	return err
+}
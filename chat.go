@@ -1,28 +1,54 @@
 package chatproxy
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Chat method handles the conversational flow for
 // the ChatGPTClient, aiming to provide a seamless
 // user experience by managing prompts and strategies.
+// Pressing Ctrl-C while a reply is streaming stops the
+// reply, keeping what was received, rather than exiting.
 func (c *ChatGPTClient) Chat() {
-	c.Prompt("Please describe the purpose of this assistant.")
-	scan := bufio.NewScanner(c.input)
-
-	for scan.Scan() {
-		line := scan.Text()
+	start := time.Now()
+	c.interruptible = true
+	defer func() { c.interruptible = false }()
+	c.startLineEditor()
+	defer c.stopLineEditor()
+	defer c.printSessionSummary(start)
+	c.Prompt(c.purposePrompt())
+	for {
+		line, err := c.readMessageWithTimeout()
+		if errors.Is(err, ErrIdleTimeout) {
+			c.stopLineEditor()
+			c.autosave()
+			break
+		}
+		if err == io.EOF {
+			fmt.Fprintln(c.output)
+			c.confirmExit()
+			break
+		}
+		if err != nil {
+			break
+		}
 		if len(c.chatHistory) == 0 {
-			c.SetPurpose(line)
+			c.SetPurpose(c.expandPurpose(line))
 			c.Prompt()
 			continue
 		}
+		err = c.RefreshWatched()
+		if err != nil {
+			c.LogErr(err)
+		}
 		strategy := c.GetStrategy(line)
-		err := strategy.Execute(c)
+		err = strategy.Execute(c)
 		if err == io.EOF {
 			break
 		}
@@ -33,19 +59,198 @@ func (c *ChatGPTClient) Chat() {
 	}
 }
 
-const (
-	QuestionPrompt = `Given the above text, generate some reading comprehension questions.
-	If I respond to the questions, you will give me a score out of 10 and how I can improve my answer.
-	Use Bloom's Taxonomy (2001) to generate the questions. Do not generate questions about Bloom's Taxonomy.
-	Produce only the questions, the user will provide the answers.
-	
-	BOT: Q: What is the end goal of teaching.
-	USER: A: To know the answers to questions.
-	BOT: Feedback: 2/10 - This demonstrates only a surface understanding. 
-	USER: A: To transfer knowledge in such a way that the learner can apply it in new situations.
-	BOT: Feedback: 10/10 - This gets a the heart of the answer.
-	`
-)
+// purposePrompt asks for the purpose of the assistant, listing the named
+// purposes from the config that can be chosen instead of describing one.
+func (c *ChatGPTClient) purposePrompt() string {
+	prompt := "Please describe the purpose of this assistant."
+	if len(c.config.Purposes) == 0 {
+		return prompt
+	}
+	var names []string
+	for name := range c.config.Purposes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return prompt + " Or choose one of: " + strings.Join(names, ", ")
+}
+
+// expandPurpose replaces the name of a purpose from the config with its text.
+func (c *ChatGPTClient) expandPurpose(purpose string) string {
+	if text, ok := c.config.Purposes[strings.TrimSpace(purpose)]; ok {
+		return text
+	}
+	return purpose
+}
+
+// ErrIdleTimeout is returned when no input is received within the idle timeout.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// WithIdleTimeout ends the chat, saving the session first, after no input
+// has been received for the given duration. A zero duration disables it.
+func WithIdleTimeout(d time.Duration) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.idleTimeout = d
+		return c
+	}
+}
+
+// readMessageWithTimeout reads a user message, giving up with
+// ErrIdleTimeout if the idle timeout passes first.
+func (c *ChatGPTClient) readMessageWithTimeout() (string, error) {
+	if c.idleTimeout <= 0 {
+		return c.readMessage()
+	}
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := c.readMessage()
+		ch <- result{line, err}
+	}()
+	timer := time.NewTimer(c.idleTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r.line, r.err
+	case <-timer.C:
+		return "", ErrIdleTimeout
+	}
+}
+
+// autosave saves the conversation to a timestamped session after an idle timeout.
+func (c *ChatGPTClient) autosave() {
+	fmt.Fprintln(c.output)
+	if len(c.chatHistory) == 0 {
+		c.LogOut(fmt.Sprintf("Idle for %s, exiting", c.idleTimeout))
+		return
+	}
+	path, err := c.saveTimestampedSession("autosave")
+	if err != nil {
+		c.LogErr(err)
+		return
+	}
+	c.LogOut(fmt.Sprintf("Idle for %s, session saved to %s", c.idleTimeout, path))
+}
+
+// saveTimestampedSession saves the conversation as a session named with
+// the prefix and the current time, returning the path it was saved to.
+func (c *ChatGPTClient) saveTimestampedSession(prefix string) (string, error) {
+	path, err := SessionPath(prefix + "-" + time.Now().Format("2006-01-02_15-04-05"))
+	if err != nil {
+		return "", err
+	}
+	return path, c.SaveSession(path)
+}
+
+// DefaultExitKeywords end the chat when entered on their own.
+var DefaultExitKeywords = []string{"exit", "/quit"}
+
+// WithExitKeywords replaces the words that end the chat when entered on
+// their own. Matching ignores case and surrounding whitespace. Ctrl-D
+// (end of input) always ends the chat.
+func WithExitKeywords(keywords ...string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.exitKeywords = keywords
+		return c
+	}
+}
+
+// WithExitConfirmation asks whether to save the session before the chat
+// exits, if the conversation has started.
+func WithExitConfirmation(confirm bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.confirmOnExit = confirm
+		return c
+	}
+}
+
+func (c *ChatGPTClient) isExitKeyword(input string) bool {
+	input = strings.TrimSpace(input)
+	for _, keyword := range c.exitKeywords {
+		if strings.EqualFold(input, strings.TrimSpace(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmExit offers to save the session before exiting, when exit
+// confirmation is enabled and there is a conversation to save.
+func (c *ChatGPTClient) confirmExit() {
+	if !c.confirmOnExit || len(c.chatHistory) == 0 {
+		return
+	}
+	c.Prompt("Save session before exiting? (y/N)")
+	answer, err := c.readLine()
+	if err != nil || strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		return
+	}
+	_, err = c.saveTimestampedSession("chat")
+	if err != nil {
+		c.LogErr(err)
+	}
+}
+
+// printSessionSummary reports how long the chat lasted and the tokens it used.
+func (c *ChatGPTClient) printSessionSummary(start time.Time) {
+	usage := c.SessionUsage()
+	c.theme.System.Fprintf(c.output, "Session lasted %s, %s tokens (~$%.2f)\n",
+		time.Since(start).Round(time.Second), formatThousands(usage.Total()), usage.Cost)
+}
+
+// MultilineDelimiter opens and closes a block of multi-line input in chat.
+const MultilineDelimiter = `"""`
+
+// readMessage reads a single user message, which may span several lines.
+// Lines between a pair of MultilineDelimiter lines are joined into one
+// message, as are lines ending in a backslash continuation.
+func (c *ChatGPTClient) readMessage() (string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(line) == MultilineDelimiter {
+		var lines []string
+		for {
+			next, err := c.readLine()
+			if err != nil || strings.TrimSpace(next) == MultilineDelimiter {
+				return strings.Join(lines, "\n"), nil
+			}
+			lines = append(lines, next)
+		}
+	}
+	var lines []string
+	for strings.HasSuffix(line, "\\") {
+		lines = append(lines, strings.TrimSuffix(line, "\\"))
+		next, err := c.readLine()
+		if err != nil {
+			line = ""
+			break
+		}
+		line = next
+	}
+	return strings.Join(append(lines, line), "\n"), nil
+}
+
+// readLine reads a single line of user input, without the trailing newline.
+// A final line that is not newline terminated is still returned.
+func (c *ChatGPTClient) readLine() (string, error) {
+	if c.editor != nil {
+		return c.editor.ReadLine()
+	}
+	line, err := c.input.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", err
+	}
+	c.recordEcho(line)
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// QuestionPrompt is the prompt used to generate comprehension questions
+// with the default quiz settings.
+var QuestionPrompt = DefaultQuizConfig().Prompt()
 
 type Strategy interface {
 	Execute(*ChatGPTClient) error
@@ -57,12 +262,19 @@ type FileLoad struct{ input string }
 // file contents to be processed by ChatGPTClient, which
 // enables users to provide input via files instead of
 // just through the chat interface.
+// Stdin can't be loaded with >-, as the chat reads its messages from it,
+// and reading it to the end would take every message still to come.
 func (s FileLoad) Execute(c *ChatGPTClient) error {
-	line, err := c.GetContent(s.input[1:])
+	path := s.input[1:]
+	if path == StdinPath {
+		return errors.New("can't load stdin in the chat, as it reads its messages from stdin; pipe the content to tldr or ask instead")
+	}
+	line, err := c.GetContent(path)
 	if err != nil {
 		c.LogErr(err)
 		return err
 	}
+	c.Watch(path)
 	c.RecordMessage(RoleUser, line)
 	reply, err := c.GetCompletion(WithFixedResponseAPIValidate("Files receieved!"))
 	if err != nil {
@@ -78,6 +290,7 @@ type FileWrite struct{ input string }
 // Execute method for FileWrite strategy allows writing
 // output from the chat interaction to a file, offering
 // an organized and convenient way to store results.
+// The user is asked before an existing file is overwritten.
 func (s FileWrite) Execute(c *ChatGPTClient) error {
 	path, line, ok := strings.Cut(s.input[1:], " ")
 	if !ok {
@@ -88,7 +301,20 @@ func (s FileWrite) Execute(c *ChatGPTClient) error {
 	if err != nil {
 		return err
 	}
-	return MessageToFile(code, path)
+	err = MessageToFile(code, path)
+	if !errors.Is(err, ErrFileExists) {
+		return err
+	}
+	c.Prompt(fmt.Sprintf("%s already exists. Overwrite? (y/N)", path))
+	answer, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		c.LogOut("Not overwriting", path)
+		return nil
+	}
+	return OverwriteFile(code, path)
 }
 
 type Default struct{ input string }
@@ -103,6 +329,45 @@ func (s Default) Execute(c *ChatGPTClient) error {
 		return err
 	}
 	c.RecordMessage(RoleBot, reply)
+	return c.recordQuizScores(reply)
+}
+
+type CopyCode struct{}
+
+// Execute method for CopyCode strategy copies the most
+// recent code block from the assistant to the clipboard,
+// saving a trip through the terminal's text selection.
+func (s CopyCode) Execute(c *ChatGPTClient) error {
+	code, err := c.CopyLastCodeBlock()
+	if err != nil {
+		return err
+	}
+	c.LogOut(fmt.Sprintf("Copied %d lines to the clipboard", strings.Count(code, "\n")+1))
+	return nil
+}
+
+type Retry struct{}
+
+// Execute method for Retry strategy resends the last
+// request after a failed completion, without the user
+// retyping it. If the last request did get a reply,
+// that reply is discarded and a new one requested.
+func (s Retry) Execute(c *ChatGPTClient) error {
+	if len(c.chatHistory) < 2 {
+		return errors.New("nothing to retry")
+	}
+	if c.chatHistory[len(c.chatHistory)-1].Role == RoleBot {
+		c.RollbackLastMessage()
+	}
+	if c.chatHistory[len(c.chatHistory)-1].Role != RoleUser {
+		return errors.New("nothing to retry")
+	}
+	c.Log(RoleSystem, "Retrying last request")
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return err
+	}
+	c.RecordMessage(RoleBot, reply)
 	return nil
 }
 
@@ -113,21 +378,84 @@ type Exit struct{}
 // decides to exit.
 func (s Exit) Execute(c *ChatGPTClient) error {
 	c.Log(RoleUser, "*exit*")
+	c.confirmExit()
 	return io.EOF
 }
 
+// StrategyFactory builds a Strategy from the user input that selected it.
+type StrategyFactory func(input string) Strategy
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]StrategyFactory{}
+)
+
+// RegisterStrategy adds a custom chat command, selected when user input
+// starts with prefix. This lets programs embedding the Chat loop add their
+// own commands without modifying chatproxy. Registered strategies take
+// precedence over the built in ones, and the longest matching prefix wins.
+// Registering a nil factory removes the prefix.
+func RegisterStrategy(prefix string, factory StrategyFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		delete(registry, prefix)
+		return
+	}
+	registry[prefix] = factory
+}
+
+func registeredStrategy(input string) (Strategy, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	longest := ""
+	var factory StrategyFactory
+	for prefix, f := range registry {
+		if strings.HasPrefix(input, prefix) && len(prefix) >= len(longest) {
+			longest, factory = prefix, f
+		}
+	}
+	if factory == nil {
+		return nil, false
+	}
+	return factory(input), true
+}
+
+// isCommand reports whether input is the command word, alone or followed by
+// its arguments, so that a message such as "/saved it" isn't taken for
+// /save.
+func isCommand(input, word string) bool {
+	name, _, _ := strings.Cut(input, " ")
+	return name == word
+}
+
 // GetStrategy method selects the appropriate strategy
 // based on the user input, ensuring the correct action
 // is taken to achieve the user's desired outcome.
 func (c *ChatGPTClient) GetStrategy(input string) Strategy {
+	if s, ok := registeredStrategy(input); ok {
+		return s
+	}
 	if strings.HasPrefix(input, ">") {
 		return FileLoad{input}
 	} else if strings.HasPrefix(input, "<") {
 		return FileWrite{input}
-	} else if input == "exit" {
+	} else if isCommand(input, "/save") {
+		return SaveSession{input}
+	} else if isCommand(input, "/load") {
+		return LoadSession{input}
+	} else if isCommand(input, "/template") {
+		return Template{input}
+	} else if input == "/retry" {
+		return Retry{}
+	} else if input == "/copy" {
+		return CopyCode{}
+	} else if c.isExitKeyword(input) {
 		return Exit{}
 	} else if strings.HasPrefix(input, "?") {
-		return Default{QuestionPrompt}
+		return Quiz{input}
+	} else if c.agent {
+		return Agent{input}
 	} else {
 		return Default{input}
 	}
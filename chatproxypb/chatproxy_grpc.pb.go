@@ -0,0 +1,293 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: proto/chatproxy.proto
+
+// The chatproxy service gives services in any language the chat,
+// question answering, embedding and retrieval features of the chatproxy
+// package. Regenerate the Go code in chatproxypb after changing it with:
+//
+//   protoc --go_out=. --go_opt=module=github.com/mr-joshcrane/chatproxy \
+//     --go-grpc_out=. --go-grpc_opt=module=github.com/mr-joshcrane/chatproxy \
+//     proto/chatproxy.proto
+
+package chatproxypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ChatProxy_Chat_FullMethodName  = "/chatproxy.v1.ChatProxy/Chat"
+	ChatProxy_Ask_FullMethodName   = "/chatproxy.v1.ChatProxy/Ask"
+	ChatProxy_Embed_FullMethodName = "/chatproxy.v1.ChatProxy/Embed"
+	ChatProxy_Query_FullMethodName = "/chatproxy.v1.ChatProxy/Query"
+)
+
+// ChatProxyClient is the client API for ChatProxy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ChatProxyClient interface {
+	// Chat replies to a conversation, streaming the reply as it is generated.
+	Chat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (ChatProxy_ChatClient, error)
+	// Ask answers a question, about the context given if any, streaming the
+	// answer as it is generated.
+	Ask(ctx context.Context, in *AskRequest, opts ...grpc.CallOption) (ChatProxy_AskClient, error)
+	// Embed returns an embedding vector for each text.
+	Embed(ctx context.Context, in *EmbedRequest, opts ...grpc.CallOption) (*EmbedResponse, error)
+	// Query returns the passages of the documents most relevant to a query.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+}
+
+type chatProxyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChatProxyClient(cc grpc.ClientConnInterface) ChatProxyClient {
+	return &chatProxyClient{cc}
+}
+
+func (c *chatProxyClient) Chat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (ChatProxy_ChatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ChatProxy_ServiceDesc.Streams[0], ChatProxy_Chat_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatProxyChatClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ChatProxy_ChatClient interface {
+	Recv() (*Reply, error)
+	grpc.ClientStream
+}
+
+type chatProxyChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatProxyChatClient) Recv() (*Reply, error) {
+	m := new(Reply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatProxyClient) Ask(ctx context.Context, in *AskRequest, opts ...grpc.CallOption) (ChatProxy_AskClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ChatProxy_ServiceDesc.Streams[1], ChatProxy_Ask_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatProxyAskClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ChatProxy_AskClient interface {
+	Recv() (*Reply, error)
+	grpc.ClientStream
+}
+
+type chatProxyAskClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatProxyAskClient) Recv() (*Reply, error) {
+	m := new(Reply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatProxyClient) Embed(ctx context.Context, in *EmbedRequest, opts ...grpc.CallOption) (*EmbedResponse, error) {
+	out := new(EmbedResponse)
+	err := c.cc.Invoke(ctx, ChatProxy_Embed_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatProxyClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, ChatProxy_Query_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChatProxyServer is the server API for ChatProxy service.
+// All implementations must embed UnimplementedChatProxyServer
+// for forward compatibility
+type ChatProxyServer interface {
+	// Chat replies to a conversation, streaming the reply as it is generated.
+	Chat(*ChatRequest, ChatProxy_ChatServer) error
+	// Ask answers a question, about the context given if any, streaming the
+	// answer as it is generated.
+	Ask(*AskRequest, ChatProxy_AskServer) error
+	// Embed returns an embedding vector for each text.
+	Embed(context.Context, *EmbedRequest) (*EmbedResponse, error)
+	// Query returns the passages of the documents most relevant to a query.
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	mustEmbedUnimplementedChatProxyServer()
+}
+
+// UnimplementedChatProxyServer must be embedded to have forward compatible implementations.
+type UnimplementedChatProxyServer struct {
+}
+
+func (UnimplementedChatProxyServer) Chat(*ChatRequest, ChatProxy_ChatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
+func (UnimplementedChatProxyServer) Ask(*AskRequest, ChatProxy_AskServer) error {
+	return status.Errorf(codes.Unimplemented, "method Ask not implemented")
+}
+func (UnimplementedChatProxyServer) Embed(context.Context, *EmbedRequest) (*EmbedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Embed not implemented")
+}
+func (UnimplementedChatProxyServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedChatProxyServer) mustEmbedUnimplementedChatProxyServer() {}
+
+// UnsafeChatProxyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChatProxyServer will
+// result in compilation errors.
+type UnsafeChatProxyServer interface {
+	mustEmbedUnimplementedChatProxyServer()
+}
+
+func RegisterChatProxyServer(s grpc.ServiceRegistrar, srv ChatProxyServer) {
+	s.RegisterService(&ChatProxy_ServiceDesc, srv)
+}
+
+func _ChatProxy_Chat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChatRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChatProxyServer).Chat(m, &chatProxyChatServer{stream})
+}
+
+type ChatProxy_ChatServer interface {
+	Send(*Reply) error
+	grpc.ServerStream
+}
+
+type chatProxyChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatProxyChatServer) Send(m *Reply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ChatProxy_Ask_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AskRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChatProxyServer).Ask(m, &chatProxyAskServer{stream})
+}
+
+type ChatProxy_AskServer interface {
+	Send(*Reply) error
+	grpc.ServerStream
+}
+
+type chatProxyAskServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatProxyAskServer) Send(m *Reply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ChatProxy_Embed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmbedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatProxyServer).Embed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChatProxy_Embed_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatProxyServer).Embed(ctx, req.(*EmbedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatProxy_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatProxyServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChatProxy_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatProxyServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChatProxy_ServiceDesc is the grpc.ServiceDesc for ChatProxy service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChatProxy_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chatproxy.v1.ChatProxy",
+	HandlerType: (*ChatProxyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Embed",
+			Handler:    _ChatProxy_Embed_Handler,
+		},
+		{
+			MethodName: "Query",
+			Handler:    _ChatProxy_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Chat",
+			Handler:       _ChatProxy_Chat_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Ask",
+			Handler:       _ChatProxy_Ask_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/chatproxy.proto",
+}
@@ -0,0 +1,228 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Criterion is a requirement that the checklist command evaluates a project
+// against. In YAML a criterion is either just its description, or a mapping
+// with an id:
+//
+//	checklist:
+//	  - Every exported identifier has a doc comment
+//	  - id: tests
+//	    description: Every package has tests
+//	  - id: style
+//	    description: Names follow Effective Go
+//	    required: false
+//
+// Criteria in YAML are required unless they say otherwise, and the checklist
+// command fails if a required criterion does.
+type Criterion struct {
+	ID          string `yaml:"id" json:"id"`
+	Description string `yaml:"description" json:"description"`
+	Required    bool   `yaml:"required" json:"required"`
+}
+
+// UnmarshalYAML accepts a criterion written as a plain string as well as a
+// mapping, and makes it required unless it says otherwise.
+func (c *Criterion) UnmarshalYAML(value *yaml.Node) error {
+	c.Required = true
+	if value.Kind == yaml.ScalarNode {
+		c.Description = value.Value
+		return nil
+	}
+	type criterion Criterion
+	return value.Decode((*criterion)(c))
+}
+
+// DefaultChecklist is evaluated when neither a criteria file nor the
+// project config gives a checklist.
+var DefaultChecklist = []Criterion{
+	{ID: "tests", Description: "The code has tests covering its main behaviour", Required: true},
+	{ID: "errors", Description: "Errors are checked and handled or returned, never silently ignored", Required: true},
+	{ID: "docs", Description: "Exported identifiers have doc comments"},
+	{ID: "readme", Description: "A README explains what the project is and how to use it"},
+	{ID: "structure", Description: "The code is organised into small, focused packages and functions"},
+}
+
+// LoadChecklist reads criteria from a YAML file, in the same form as the
+// checklist in a project config.
+func LoadChecklist(path string) ([]Criterion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Checklist []Criterion `yaml:"checklist"`
+	}
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("reading checklist %s: %w", path, err)
+	}
+	if len(file.Checklist) == 0 {
+		return nil, fmt.Errorf("reading checklist %s: no criteria under checklist", path)
+	}
+	return numberCriteria(file.Checklist), nil
+}
+
+// numberCriteria gives criteria without an id their position in the list,
+// so that every result can be matched to its criterion.
+func numberCriteria(criteria []Criterion) []Criterion {
+	numbered := make([]Criterion, len(criteria))
+	for i, c := range criteria {
+		if c.ID == "" {
+			c.ID = strconv.Itoa(i + 1)
+		}
+		numbered[i] = c
+	}
+	return numbered
+}
+
+// CriterionResult is the evaluation of one criterion.
+type CriterionResult struct {
+	Criterion
+	Pass     bool   `json:"pass"`
+	Evidence string `json:"evidence"`
+}
+
+// ChecklistReport is the evaluation of a project against a checklist.
+type ChecklistReport struct {
+	Path    string            `json:"path"`
+	Model   string            `json:"model"`
+	Results []CriterionResult `json:"results"`
+}
+
+// Passed returns the number of criteria that passed.
+func (r ChecklistReport) Passed() int {
+	passed := 0
+	for _, result := range r.Results {
+		if result.Pass {
+			passed++
+		}
+	}
+	return passed
+}
+
+// FailedRequired returns the results of the required criteria that failed.
+func (r ChecklistReport) FailedRequired() []CriterionResult {
+	var failed []CriterionResult
+	for _, result := range r.Results {
+		if result.Required && !result.Pass {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err returns an error wrapping ErrChecklistFailed and naming the required
+// criteria that failed, or nil if none did.
+func (r ChecklistReport) Err() error {
+	failed := r.FailedRequired()
+	if len(failed) == 0 {
+		return nil
+	}
+	ids := make([]string, len(failed))
+	for i, result := range failed {
+		ids[i] = result.ID
+	}
+	return fmt.Errorf("%w: %s", ErrChecklistFailed, strings.Join(ids, ", "))
+}
+
+// Checklist evaluates the file, directory or URL at path against the
+// criteria, asking the model for a pass or fail with evidence for each.
+func (c *ChatGPTClient) Checklist(path string, criteria []Criterion) (ChecklistReport, error) {
+	criteria = numberCriteria(criteria)
+	c.SetPurpose(`Please evaluate the user's project against each criterion in their checklist.
+		Base every judgement on the provided content, and quote or name the files, functions or lines that are your evidence.
+		If the content doesn't show whether a criterion is met, it fails, and the evidence says what is missing.
+		Reply with only a JSON array, with one object per criterion in the order given, like this:
+		[{"id": "tests", "pass": true, "evidence": "handler_test.go covers every handler"}]`)
+	msg, err := c.GetContent(path)
+	if err != nil {
+		return ChecklistReport{}, err
+	}
+	c.RecordMessage(RoleUser, msg)
+	list := new(strings.Builder)
+	list.WriteString("Checklist:\n")
+	for _, criterion := range criteria {
+		fmt.Fprintf(list, "- id: %s\n  description: %s\n", criterion.ID, criterion.Description)
+	}
+	c.RecordMessage(RoleUser, list.String())
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return ChecklistReport{}, err
+	}
+	results, err := parseChecklistReply(reply, criteria)
+	if err != nil {
+		return ChecklistReport{}, err
+	}
+	return ChecklistReport{Path: path, Model: c.model, Results: results}, nil
+}
+
+// parseChecklistReply matches the model's evaluations to the criteria. A
+// criterion the model didn't evaluate fails.
+func parseChecklistReply(reply string, criteria []Criterion) ([]CriterionResult, error) {
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	var evaluations []struct {
+		ID       string `json:"id"`
+		Pass     bool   `json:"pass"`
+		Evidence string `json:"evidence"`
+	}
+	err := json.Unmarshal([]byte(reply), &evaluations)
+	if err != nil {
+		return nil, errors.New("couldn't parse the checklist evaluation: " + err.Error())
+	}
+	results := make([]CriterionResult, len(criteria))
+	for i, criterion := range criteria {
+		results[i] = CriterionResult{Criterion: criterion, Evidence: "Not evaluated"}
+		for _, e := range evaluations {
+			if e.ID == criterion.ID {
+				results[i].Pass, results[i].Evidence = e.Pass, e.Evidence
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// WriteChecklistReport writes the report to w as Markdown, or as JSON if
+// format is OutputJSON.
+func WriteChecklistReport(w io.Writer, report ChecklistReport, format string) error {
+	if format == OutputJSON {
+		return json.NewEncoder(w).Encode(report)
+	}
+	md := new(strings.Builder)
+	fmt.Fprintf(md, "# Checklist: %s\n\n", report.Path)
+	for _, result := range report.Results {
+		box := " "
+		if result.Pass {
+			box = "x"
+		}
+		optional := ""
+		if !result.Required {
+			optional = " (optional)"
+		}
+		fmt.Fprintf(md, "- [%s] **%s**%s: %s\n", box, result.ID, optional, result.Description)
+		if result.Evidence != "" {
+			fmt.Fprintf(md, "  %s\n", strings.ReplaceAll(result.Evidence, "\n", "\n  "))
+		}
+	}
+	fmt.Fprintf(md, "\n%d of %d criteria passed.\n", report.Passed(), len(report.Results))
+	if failed := report.FailedRequired(); len(failed) > 0 {
+		fmt.Fprintf(md, "%d required criteria failed.\n", len(failed))
+	}
+	_, err := io.WriteString(w, md.String())
+	return err
+}
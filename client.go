@@ -7,22 +7,28 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/sashabaranov/go-openai"
 )
 
 // ChatMessage represents a message in the chat, providing context and
 // a way to model conversation between different participant roles (e.g., user, bot, system).
 type ChatMessage struct {
-	Content string
-	Role    string
+	Content string `json:"content"`
+	Role    string `json:"role"`
+	// Name is the tool an assistant message calls, with Arguments, or whose
+	// result a function message is.
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
 }
 
 // Role constants that represent the role of the message sender
@@ -30,6 +36,8 @@ const (
 	RoleUser   = "user"
 	RoleBot    = "assistant"
 	RoleSystem = "system"
+	// RoleFunction messages are the results of tool calls.
+	RoleFunction = "function"
 )
 
 // ChatGPTClient manages interactions with a GPT-based chatbot, providing a way
@@ -37,13 +45,73 @@ const (
 type ChatGPTClient struct {
 	client        *openai.Client
 	chatHistory   []ChatMessage
-	input         io.Reader
+	input         *bufio.Reader
+	inputSource   io.Reader
 	output        io.Writer
 	errorStream   io.Writer
 	transcript    io.Writer
 	fixedResponse string
 	streaming     bool
 	embeddings    []Embedding
+	preprocessors []Preprocessor
+	watcher       *Watcher
+	lineEditing   bool
+	editor        *lineEditor
+	keyBindings   KeyBindings
+	markdown      bool
+	agent         bool
+	theme         Theme
+	model         string
+	usageReport   bool
+	lastUsage     Usage
+	sessionUsage  Usage
+	interruptible bool
+	quiz          QuizConfig
+	quizActive    bool
+	idleTimeout   time.Duration
+	exitKeywords  []string
+	recording     io.Writer
+	recorder      *AsciicastRecorder
+	onToken       func(string)
+	temperature   float32
+	replyStreamed bool
+	provider      string
+	config        Config
+	command       string
+
+	verbosity          Verbosity
+	profile            string
+	dryRun             bool
+	confirmCost        float64
+	confirmTokens      int
+	assumeYes          bool
+	usageLog           io.Writer
+	transcriptDisabled bool
+	transcriptDir      string
+	confirmOnExit      bool
+	token              *string
+	baseURL            string
+	httpClient         *http.Client
+	timeout            time.Duration
+	callCtx            context.Context
+	tools              []clientTool
+	webhooks           []string
+	metrics            *Metrics
+	fallbacks          []fallbackModel
+	answeredBy         fallbackModel
+	logHandlers        []slog.Handler
+	logStream          slog.Handler
+	redactPatterns     []*regexp.Regexp
+	redactPrompts      bool
+	maskPII            bool
+	piiNames           []string
+	pii                *piiMasker
+	feedReads          []feedState
+	serving            bool
+	moderatePrompts    string
+	moderateReplies    string
+	moderationModel    string
+	sessionID          string
 }
 
 type Embedding struct {
@@ -71,7 +139,27 @@ type ClientOption func(*ChatGPTClient) *ChatGPTClient
 // when creating a new ChatGPTClient.
 func WithToken(token string) ClientOption {
 	return func(c *ChatGPTClient) *ChatGPTClient {
-		c.client = openai.NewClient(token)
+		c.token = &token
+		return c
+	}
+}
+
+// WithBaseURL sends API requests to baseURL, such as an OpenAI compatible
+// gateway, instead of https://api.openai.com/v1.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.baseURL = baseURL
+		return c
+	}
+}
+
+// WithHTTPClient makes API requests with hc, for example to use a custom
+// transport or TLS configuration. By default requests go through the proxy
+// set in $HTTPS_PROXY or $HTTP_PROXY, which a custom client's transport
+// must arrange itself.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.httpClient = hc
 		return c
 	}
 }
@@ -99,7 +187,8 @@ func WithTranscript(audit io.Writer) ClientOption {
 // from any source, offering improved flexibility and adaptability.
 func WithInput(input io.Reader) ClientOption {
 	return func(c *ChatGPTClient) *ChatGPTClient {
-		c.input = input
+		c.input = bufio.NewReader(input)
+		c.inputSource = input
 		return c
 	}
 }
@@ -122,33 +211,83 @@ func WithStreaming(streaming bool) ClientOption {
 	}
 }
 
+// WithModel selects the chat model used for completions.
+func WithModel(model string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.model = model
+		return c
+	}
+}
+
+// WithTemperature sets the sampling temperature, between 0 and 2, used for
+// completions. Lower values give more focused and deterministic replies.
+// Zero uses the API's default.
+func WithTemperature(temperature float32) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.temperature = temperature
+		return c
+	}
+}
+
 var NewChatGPTClient = DefaultGPTClient
 
 // NewChatGPTClient initializes the ChatGPTClient with the desired options, allowing customization
 // through functional options so the client can be tailored to specific needs or requirements.
 func DefaultGPTClient(opts ...ClientOption) (*ChatGPTClient, error) {
-	file, err := CreateAuditLog()
+	cfg, err := LoadConfig()
 	if err != nil {
 		return nil, err
 	}
 	c := &ChatGPTClient{
-		client:      nil,
-		chatHistory: []ChatMessage{},
-		transcript:  file,
-		input:       os.Stdin,
-		output:      os.Stdout,
-		errorStream: os.Stderr,
-		streaming:   false,
+		client:        nil,
+		chatHistory:   []ChatMessage{},
+		input:         bufio.NewReader(os.Stdin),
+		inputSource:   os.Stdin,
+		output:        os.Stdout,
+		errorStream:   os.Stderr,
+		streaming:     false,
+		preprocessors: DefaultPreprocessors(),
+		theme:         DefaultTheme(),
+		keyBindings:   EmacsKeyBindings,
+		model:         openai.GPT4,
+		quiz:          DefaultQuizConfig(),
+		exitKeywords:  DefaultExitKeywords,
+		provider:      "openai",
+		confirmCost:   DefaultConfirmCost,
+		timeout:       DefaultTimeout,
+		config:        cfg,
+		sessionID:     newSessionID(),
+	}
+	err = c.applyDefaults()
+	if err != nil {
+		return nil, err
 	}
 	for _, opt := range opts {
 		c = opt(c)
 	}
+	handler, err := envLogHandler(c.errorStream)
+	if err != nil {
+		return nil, err
+	}
+	if handler != nil {
+		c.logStream = handler
+	}
+	c.theme.applyColorMode(ColorEnabled(c.output))
+	if c.transcript == nil {
+		c.transcript, err = c.openTranscript()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.startRecording()
+	if err != nil {
+		return nil, err
+	}
 	if c.client == nil {
-		token, ok := os.LookupEnv("OPENAI_API_KEY")
-		if !ok {
-			return nil, errors.New("must have OPENAI_API_KEY env var set or pass token explicitly")
+		c.client, err = c.newAPIClient()
+		if err != nil {
+			return nil, err
 		}
-		c.client = openai.NewClient(token)
 	}
 	return c, nil
 }
@@ -168,9 +307,18 @@ func (c *ChatGPTClient) Ask(question string) (answer string, err error) {
 	return c.GetCompletion()
 }
 
+// AskAbout asks a question about some content, such as the output of another
+// command, sending the content first so the question can refer to it.
+func (c *ChatGPTClient) AskAbout(content, question string) (answer string, err error) {
+	c.SetPurpose("Please answer the following question about the provided content as best you can.")
+	c.RecordMessage(RoleUser, content)
+	c.RecordMessage(RoleUser, question)
+	return c.GetCompletion()
+}
+
 // Card creates flashcards using the content from a given file or URL.
 // This method, part of the ChatGPTClient, uses the GPT-4 API to break down and condense information into manageable flashcards.
-func (c *ChatGPTClient) Card(path string) (cards []string, err error) {
+func (c *ChatGPTClient) Card(path string) (cards []Flashcard, err error) {
 	c.SetPurpose(`Please generate flashcards from the user provided information.
 		Answers should be short.
 		A good flashcard look like this:
@@ -191,41 +339,14 @@ func (c *ChatGPTClient) Card(path string) (cards []string, err error) {
 	if err != nil {
 		return nil, err
 	}
-	cards = strings.Split(msg, "---")
-	return cards, nil
+	return ParseFlashcards(msg), nil
 
 }
 
 // TLDR generates a brief summary of the content from a file or URL.
 // This method is part of the ChatGPTClient and leverages the GPT-4 API to present an abstract of the main text, providing a quick overview.
 func (c *ChatGPTClient) TLDR(path string) (summary string, err error) {
-	c.SetPurpose("Please summarise the provided text as best you can. The shorter the better.")
-	var msg string
-	msg, err = c.GetContent(path)
-	if err != nil {
-		return "", err
-	}
-	c.RecordMessage(RoleUser, msg)
-	return c.GetCompletion()
-}
-
-// Commit parses the diff of staged Git files and generates an appropriate commit message.
-// This method, part of the ChatGPTClient, helps users maintain clear commit history and conveys changes in a concise and descriptive manner.
-func (c *ChatGPTClient) Commit() (summary string, err error) {
-	c.SetPurpose(`Please read the git diff provided and write an appropriate commit message.
-	Focus on the lines that start with a + (line added) or - (line removed)`)
-	cmd := exec.Command("git", "diff", "--cached")
-	buf := bytes.Buffer{}
-	cmd.Stdout = &buf
-	err = cmd.Run()
-	if err != nil {
-		return "", err
-	}
-	if len(buf.String()) == 0 {
-		return "", errors.New("no files staged for commit")
-	}
-	c.RecordMessage(RoleUser, buf.String())
-	return c.GetCompletion()
+	return c.TLDRAll([]string{path}, SummaryOptions{})
 }
 
 // CompletionOption is used to customize the behavior of the openai.ChatCompletionRequest
@@ -247,6 +368,9 @@ func WithFixedResponseAPIValidate(response string) CompletionOption {
 // to follow, and aligning the conversation towards a specific topic or goal.
 func (c *ChatGPTClient) SetPurpose(prompt string) {
 	purpose := "PURPOSE: " + prompt
+	if c.agent {
+		purpose += "\n" + AgentPrompt
+	}
 	m := ChatMessage{
 		Content: purpose,
 		Role:    RoleSystem,
@@ -259,58 +383,194 @@ func (c *ChatGPTClient) SetPurpose(prompt string) {
 	c.Log(RoleSystem, purpose)
 }
 
-// GetCompletion retrieves a response from the chatbot based on the conversation history and any
-// additional options applied.
-func (c *ChatGPTClient) GetCompletion(opts ...CompletionOption) (string, error) {
-	if c.fixedResponse != "" {
-		return c.fixedResponse, nil
-	}
+// completionRequest returns the request for a completion of the chat
+// history, customised by opts.
+func (c *ChatGPTClient) completionRequest(opts ...CompletionOption) openai.ChatCompletionRequest {
 	messages := make([]openai.ChatCompletionMessage, len(c.chatHistory))
 	for i, message := range c.chatHistory {
 		messages[i] = openai.ChatCompletionMessage{
 			Content: message.Content,
 			Role:    message.Role,
+			Name:    message.Name,
+		}
+		if c.redactPrompts {
+			messages[i].Content = c.redact(message.Content)
+		}
+		messages[i].Content = c.maskPIIIn(messages[i].Content)
+		if message.Role == RoleBot && message.Name != "" {
+			messages[i].Name = ""
+			messages[i].FunctionCall = &openai.FunctionCall{Name: message.Name, Arguments: message.Arguments}
 		}
 	}
 	req := openai.ChatCompletionRequest{
-		Model:    openai.GPT4,
-		Messages: messages,
-		Stream:   true,
+		Model:       c.model,
+		Messages:    messages,
+		Stream:      true,
+		Temperature: c.temperature,
 	}
 	for _, opt := range opts {
 		opt(&req)
 	}
+	return req
+}
 
-	stream, err := c.client.CreateChatCompletionStream(context.Background(), req)
+// promptText returns the text of every message in the request.
+func promptText(req openai.ChatCompletionRequest) string {
+	prompt := new(strings.Builder)
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Content)
+	}
+	return prompt.String()
+}
+
+// GetCompletion retrieves a response from the chatbot based on the conversation history and any
+// additional options applied, from the client's fallbacks in turn if its model fails. Prompts and
+// replies are checked with the moderation endpoint if the client moderates them, and only replies
+// that pass are posted to the client's webhooks.
+func (c *ChatGPTClient) GetCompletion(opts ...CompletionOption) (string, error) {
+	if c.dryRun {
+		return c.getCompletion(opts...)
+	}
+	err := c.moderatePrompt(opts...)
 	if err != nil {
-		var apiErr *openai.APIError
-		if errors.As(err, &apiErr) {
-			if apiErr.HTTPStatusCode == http.StatusBadRequest {
-				c.LogErr(err)
-				c.RollbackLastMessage()
-				return fmt.Sprintf("Backing out of transaction: %s", apiErr.Message), nil
-			}
-			if apiErr.HTTPStatusCode == http.StatusUnauthorized {
-				c.LogErr(err)
-				return "", errors.New("unauthorized. Please check your OPENAI_API_KEY env var or pass a token in explicitly")
-			}
-		}
 		return "", err
 	}
+	if c.moderateReplies == ModerationBlock && c.streaming {
+		// A reply can't be taken back once it has been streamed.
+		c.streaming = false
+		defer func() { c.streaming = true }()
+	}
+	var reply string
+	if len(c.fallbacks) > 0 {
+		reply, err = c.completeWithFallbacks(opts...)
+	} else {
+		reply, err = c.getCompletion(opts...)
+	}
+	if err != nil {
+		return "", err
+	}
+	err = c.moderate(c.moderateReplies, "reply", reply)
+	if err != nil {
+		c.RollbackLastMessage()
+		return "", err
+	}
+	if c.answeredBy.model != "" {
+		c.notifyWebhooks(c.answeredBy.model, reply)
+	}
+	c.saveFeedReads()
+	return reply, nil
+}
+
+// getCompletion asks the client's model for a reply.
+func (c *ChatGPTClient) getCompletion(opts ...CompletionOption) (string, error) {
+	c.replyStreamed = false
+	c.answeredBy = fallbackModel{}
+	req := c.completionRequest(opts...)
+	if c.dryRun {
+		return c.dryRunCompletion(req)
+	}
+	model, err := c.routeModel(req)
+	if err != nil {
+		return "", err
+	}
+	req.Model = model
+	err = c.confirmPromptCost(req)
+	if err != nil {
+		return "", err
+	}
+	if c.fixedResponse != "" {
+		return c.fixedResponse, nil
+	}
+	c.Logger().Debug("requesting a reply", "model", req.Model, "messages", len(req.Messages))
+	if len(c.tools) > 0 {
+		return c.completeWithTools(req)
+	}
+	start := time.Now()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	if c.streaming && c.interruptible {
+		// Ctrl-C stops the stream rather than the process while a reply is streamed.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+	}
+	var spinner *Spinner
+	if !c.streaming {
+		spinner = StartSpinner(c.output)
+	}
+	defer spinner.Stop()
+	stream, err := c.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return c.completionError(ctx, err)
+	}
 	defer stream.Close()
 
 	discardStreamResp := req.Stop != nil && len(req.Stop) > 0
 	if discardStreamResp {
 		return req.Stop[0], nil
 	}
+	var reply string
 	if c.streaming {
-		return streamedResponse(c, stream)
+		reply, err = streamedResponse(ctx, c, stream)
+		c.replyStreamed = err == nil
+	} else {
+		reply, err = bufferedResponse(stream)
+		spinner.Stop()
 	}
-	return bufferedResponse(stream)
+	if err != nil {
+		return "", c.timeoutError(ctx, err)
+	}
+	c.recordUsage(req.Model, promptText(req), reply, time.Since(start))
+	return reply, nil
 }
 
+// completionError returns the error to report for a failed request for a
+// reply. A request the API rejects as invalid is rolled back, with a reply
+// saying so, so that the conversation can go on.
+func (c *ChatGPTClient) completionError(ctx context.Context, err error) (string, error) {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == "context_length_exceeded" {
+			c.RollbackLastMessage()
+			return "", fmt.Errorf("%w: %s", ErrContextTooLong, apiErr.Message)
+		}
+		if apiErr.HTTPStatusCode == http.StatusBadRequest {
+			c.LogErr(err)
+			c.RollbackLastMessage()
+			return fmt.Sprintf("Backing out of transaction: %s", apiErr.Message), nil
+		}
+		if apiErr.HTTPStatusCode == http.StatusUnauthorized {
+			return "", fmt.Errorf("%w: please check your %s env var or pass a token in explicitly", ErrUnauthorized, specFor(c.provider).keyEnv)
+		}
+		if apiErr.HTTPStatusCode == http.StatusTooManyRequests {
+			return "", fmt.Errorf("%w: %s", ErrRateLimited, apiErr.Message)
+		}
+	}
+	return "", c.timeoutError(ctx, err)
+}
+
+// CreateEmbeddings chunks and vectorizes the contents, storing the embeddings
+// on the client for later retrieval. Go source, identified by an origin ending
+// in .go, is chunked along declaration boundaries.
 func (c *ChatGPTClient) CreateEmbeddings(origin string, contents io.Reader) {
-	chunks := c.Chunk(contents, 500)
+	var chunks []string
+	if strings.HasSuffix(origin, ".go") {
+		src, err := io.ReadAll(contents)
+		if err != nil {
+			c.LogErr(err)
+			return
+		}
+		chunks, err = c.ChunkGo(src, 500)
+		if err != nil {
+			chunks = c.Chunk(bytes.NewReader(src), 500)
+		}
+	} else {
+		chunks = c.Chunk(contents, 500)
+	}
+	if _, err := os.Stat(origin); err == nil {
+		c.Watch(origin)
+	}
 	// Create batches of 500
 	var batches [][]string
 	for i := 0; i < len(chunks); i += 500 {
@@ -365,9 +625,11 @@ func (c *ChatGPTClient) Vectorize(origin string, s []string) ([]Embedding, error
 		Model: openai.AdaEmbeddingV2,
 		Input: emb,
 	}
-	resp, err := c.client.CreateEmbeddings(context.Background(), req)
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	resp, err := c.client.CreateEmbeddings(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, c.timeoutError(ctx, err)
 	}
 
 	for i, embedding := range resp.Data {
@@ -409,6 +671,36 @@ func (c *ChatGPTClient) Relevant(query string) (Similarities, error) {
 	return similarities, nil
 }
 
+// embedDocument splits content into passages and adds their embeddings to
+// the client's, as coming from origin. Unlike CreateEmbeddings, it reports
+// an error rather than logging it, and doesn't watch the origin for changes.
+func (c *ChatGPTClient) embedDocument(origin, content string) error {
+	chunks := c.Chunk(strings.NewReader(content), 500)
+	embeddings, err := c.Vectorize(origin, chunks)
+	if err != nil {
+		return err
+	}
+	c.embeddings = append(c.embeddings, embeddings...)
+	return nil
+}
+
+// relevantPassages returns the passages of the client's embeddings most
+// relevant to query, the most relevant first, and at most limit of them.
+func (c *ChatGPTClient) relevantPassages(query string, limit int) ([]Similarity, error) {
+	similarities, err := c.Relevant(query)
+	if err != nil {
+		return nil, err
+	}
+	passages := similarities.RelevantVectors
+	sort.SliceStable(passages, func(i, j int) bool {
+		return passages[i].Score > passages[j].Score
+	})
+	if len(passages) > limit {
+		passages = passages[:limit]
+	}
+	return passages, nil
+}
+
 func (s Similarities) Top(n int) []string {
 	var top []string
 	sort.Slice(s.RelevantVectors, func(i, j int) bool {
@@ -453,22 +745,60 @@ func (c *ChatGPTClient) RollbackLastMessage() []ChatMessage {
 	return c.chatHistory
 }
 
-func streamedResponse(c *ChatGPTClient, stream *openai.ChatCompletionStream) (message string, err error) {
-	color.New(color.FgGreen).Fprint(c.output, "ASSISTANT) ")
+// rollbackUnanswered rolls back the user's message after a failed
+// completion, unless GetCompletion has already rolled it back.
+func (c *ChatGPTClient) rollbackUnanswered() {
+	if n := len(c.chatHistory); n > 1 && c.chatHistory[n-1].Role == RoleUser {
+		c.RollbackLastMessage()
+	}
+}
+
+func streamedResponse(ctx context.Context, c *ChatGPTClient, stream *openai.ChatCompletionStream) (message string, err error) {
+	c.theme.Assistant.Fprint(c.output, "ASSISTANT) ")
+	// When rendering markdown, tokens are held back until their line is complete.
+	renderer := new(MarkdownRenderer)
+	pending := ""
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			color.New(color.FgGreen).Fprintln(c.output)
+			if c.markdown {
+				fmt.Fprint(c.output, renderer.RenderLine(pending))
+			}
+			c.theme.Assistant.Fprintln(c.output)
+			c.DisplayImages(message)
 			return message, nil
 		}
 
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			// Interrupted by the user, so keep what was received so far.
+			if c.markdown {
+				fmt.Fprint(c.output, renderer.RenderLine(pending))
+			}
+			c.theme.System.Fprintln(c.output, " [interrupted]")
+			return message, nil
+		}
 		if err != nil {
 			return "", err
 		}
 		token := response.Choices[0].Delta.Content
 		message += token
+		if c.onToken != nil {
+			c.onToken(token)
+		}
 
-		color.New(color.FgGreen).Fprint(c.output, token)
+		if !c.markdown {
+			c.theme.Assistant.Fprint(c.output, token)
+			continue
+		}
+		pending += token
+		for {
+			line, rest, ok := strings.Cut(pending, "\n")
+			if !ok {
+				break
+			}
+			fmt.Fprintln(c.output, renderer.RenderLine(line))
+			pending = rest
+		}
 	}
 }
 
@@ -0,0 +1,65 @@
+package chatproxy
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrNoCodeBlock is returned when there is no fenced code block to copy.
+var ErrNoCodeBlock = errors.New("no code block found in the last reply")
+
+// LastCodeBlock returns the contents of the final fenced code block in the
+// markdown text, without its fences or language tag.
+func LastCodeBlock(text string) (string, bool) {
+	blocks := CodeBlocks(text)
+	if len(blocks) == 0 {
+		return "", false
+	}
+	return blocks[len(blocks)-1].Code, true
+}
+
+// CopyLastCodeBlock copies the most recent fenced code block from the
+// assistant's replies to the system clipboard, returning the copied code.
+func (c *ChatGPTClient) CopyLastCodeBlock() (string, error) {
+	for i := len(c.chatHistory) - 1; i >= 0; i-- {
+		m := c.chatHistory[i]
+		if m.Role != RoleBot {
+			continue
+		}
+		code, ok := LastCodeBlock(m.Content)
+		if !ok {
+			return "", ErrNoCodeBlock
+		}
+		return code, WriteClipboard(code)
+	}
+	return "", ErrNoCodeBlock
+}
+
+// WriteClipboard places text on the system clipboard, using whichever
+// clipboard utility is available for the platform.
+func WriteClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		candidates = [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+	}
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard utility found, install one of pbcopy, wl-copy, xclip or xsel")
+}
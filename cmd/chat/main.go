@@ -7,5 +7,5 @@ import (
 )
 
 func main() {
-	os.Exit(chatproxy.Chat())
+	os.Exit(chatproxy.Chat(os.Args))
 }
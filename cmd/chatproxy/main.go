@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/mr-joshcrane/chatproxy"
+)
+
+func main() {
+	os.Exit(chatproxy.Main(os.Args))
+}
@@ -7,5 +7,5 @@ import (
 )
 
 func main() {
-	os.Exit(chatproxy.Commit())
+	os.Exit(chatproxy.Commit(os.Args))
 }
@@ -0,0 +1,393 @@
+package chatproxy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"text/tabwriter"
+)
+
+// Version is the version of chatproxy. Release builds set it with
+// -ldflags "-X github.com/mr-joshcrane/chatproxy.Version=v1.2.3"; otherwise
+// the module version recorded by go install is used.
+var Version = ""
+
+// Command is a subcommand of the chatproxy binary. Run receives the
+// arguments starting with the command's name, and returns an exit code.
+// Usage and Description are shown in the command's --help and man page.
+type Command struct {
+	Name        string
+	Summary     string
+	Usage       string
+	Description string
+	Run         func(args []string) int
+}
+
+// Commands are the subcommands of the chatproxy binary, in the order they
+// are listed in its help. They are set by init, as the commands look up
+// their own help here.
+var Commands []Command
+
+func init() {
+	Commands = []Command{
+		{
+			Name:    "ask",
+			Summary: "Ask a question",
+			Usage:   "[question...]",
+			Description: `Ask sends a question and prints the answer. Content piped to standard input is sent with the question, so that the question can be about the output of another command; with no arguments, the piped content is the question.
+
+Files, directories and URLs given with --context are loaded and sent with the question in the same way.`,
+			Run: Ask,
+		},
+		{
+			Name:    "chat",
+			Summary: "Chat interactively, loading files and saving sessions",
+			Description: `Chat starts an interactive conversation. The first message sets the purpose of the assistant.
+
+In the chat, >path loads a file, directory or URL into the conversation, <path writes the next reply to a file, and ? asks the assistant for questions to check your understanding.`,
+			Run: Chat,
+		},
+		{
+			Name:    "tldr",
+			Summary: "Summarise a file, directory, URL or stdin",
+			Usage:   "[path-or-url... | instructions...]",
+			Description: `Tldr summarises files, directories or URLs. Several sources are summarised together, or compared with --compare.
+
+When content is piped to standard input it is summarised instead, and the arguments are instructions for the summary.`,
+			Run: TLDR,
+		},
+		{
+			Name:    "batch",
+			Summary: "Run many ask and tldr jobs from a file concurrently",
+			Usage:   "jobs.jsonl",
+			Description: `Batch runs the jobs in a file of JSON lines, or - for stdin, several at once, and writes the result of each as a line of JSON to stdout or --out, as it finishes. Each job is an ask job, the default, which answers its prompt about the files, directories or URLs in its paths, if any, or a tldr job, which summarises its paths:
+
+  {"id": "q1", "prompt": "What is a goroutine?"}
+  {"id": "s1", "type": "tldr", "paths": ["https://go.dev/blog/"], "length": "bullets"}
+
+Each result has the job's id, its output or error, and its estimated usage. --concurrency sets how many jobs run at once, and --rate-limit the most jobs started in a minute; jobs the API rate limits are tried again. A job that fails doesn't stop the others, but batch exits with status 1 if any did. Prompts over the cost or token limit set in the config are refused, unless --yes is given.`,
+			Run: Batch,
+		},
+		{
+			Name:    "card",
+			Summary: "Generate flashcards from a file or URL",
+			Usage:   "path-or-url | review [deck...]",
+			Description: `Card generates flashcards from a file, directory or URL, printing them in the format given by --format and saving them to a deck for review.
+
+Card review quizzes you on the cards that are due in the named decks, or in every deck, grading each answer with the model and scheduling the next review with the SM-2 algorithm.`,
+			Run: Card,
+		},
+		{
+			Name:    "commit",
+			Summary: "Write a commit message for the staged changes",
+			Usage:   "[-- git-commit-flags]",
+			Description: `Commit writes a commit message for the changes staged in git, and commits them with it once accepted. The message has a subject of at most 50 characters and a body wrapped at 72 columns. With --amend, the HEAD commit's message is revised to cover the staged changes too, and the commit is amended. Diffs too large for the model are summarised file by file first, and the diffs of lockfiles, vendored code, generated files and paths matching the exclude patterns in the commit config are left out. Choose edit to change the message in $VISUAL or $EDITOR before committing, or regenerate for an alternative message, optionally with guidance for it.
+
+With --format conventional, or format: conventional under commit in the config, the message follows the Conventional Commits format, type(scope): subject, with a BREAKING CHANGE footer for breaking changes. The message is checked, and the model is asked to correct one that doesn't follow the format. List the allowed scopes under scopes in the commit config, and map paths to the scope for changes to them under scope_paths.
+
+In scripts, which have no terminal to ask on, use --yes to commit with the generated message without asking, or --print to only print it.
+
+Flags after -- are passed on to git commit, such as -- -s -S --no-verify to sign off, sign the commit with GPG and skip the commit hooks. Flags that set the message, such as -m, can't be passed on.`,
+			Run: Commit,
+		},
+		{
+			Name:    "changelog",
+			Summary: "Write a changelog entry for a range of commits",
+			Usage:   "range",
+			Description: `Changelog writes a changelog entry in the Keep a Changelog format for the commits in a range, such as v1.2.0..HEAD, or from a single ref, such as v1.2.0, to HEAD. The changes are grouped into breaking changes, added, changed, deprecated, removed, fixed and security, leaving out those that don't matter to users.
+
+The entry is named after the tag at the end of the range, with its date, or Unreleased; --version names it instead.`,
+			Run: Changelog,
+		},
+		{
+			Name:    "release-notes",
+			Summary: "Write release notes for a range of commits",
+			Usage:   "range",
+			Description: `Release-notes writes user-facing release notes for the commits and merged pull requests in a range, such as v1.2.0..v1.3.0, or from a single ref to HEAD. They are less technical than a changelog: a summary of the highlights, the notable changes, and anything users must do to upgrade.
+
+Set who the notes are for and their tone with --audience and --tone, or with audience and tone under release_notes in the config.`,
+			Run: ReleaseNotes,
+		},
+		{
+			Name:    "review",
+			Summary: "Review a diff and report findings",
+			Usage:   "[ref | patch-file]",
+			Description: `Review reviews the changes in a patch file, or from a git ref such as main to the working tree, or the uncommitted changes if neither is given. Each finding names the file and line, rates its severity as high, medium or low, and suggests a fix.
+
+--format prints the findings as text, as JSON, or with github as the request body of GitHub's create a review API, with a comment on each finding's line.
+
+--post posts the findings as a review of the GitHub pull request with that number, using the token in GITHUB_TOKEN.`,
+			Run: Review,
+		},
+		{
+			Name:    "pr",
+			Summary: "Write a pull request description for the current branch",
+			Usage:   "[base]",
+			Description: `PR writes the title and description of a pull request that merges the current branch into base, by default the branch origin/HEAD points to, from the branch's commits and diff.
+
+--create opens the pull request on GitHub, and --post replaces the title and description of an existing one, using the token in GITHUB_TOKEN. The repository is the one the origin remote is on, or --repo.`,
+			Run: PR,
+		},
+		{
+			Name:        "action",
+			Summary:     "Review a pull request from a GitHub Actions workflow",
+			Description: `Action summarises and reviews a pull request from inside a GitHub Actions workflow run on pull_request events, and posts the result as a comment on the pull request. It takes no arguments and never prompts: the pull request is read from GITHUB_EVENT_PATH, its diff from the checkout, which needs the base commit (fetch-depth: 0), and the comment is posted with the token in GITHUB_TOKEN to GITHUB_REPOSITORY. When the action runs again, it replaces its earlier comment. The comment is also added to the job summary.`,
+			Run:         Action,
+		},
+		{
+			Name:    "mr",
+			Summary: "Write a GitLab merge request description for the current branch",
+			Usage:   "[target]",
+			Description: `MR writes the title and description of a GitLab merge request of the current branch into target, by default the branch origin/HEAD points to, from the branch's commits and diff.
+
+--create opens the merge request, and --post replaces the title and description of an existing one, given its iid, using the token in GITLAB_TOKEN. The project is the one the origin remote is on, or --project.`,
+			Run: MR,
+		},
+		{
+			Name:        "issue",
+			Summary:     "Summarise a GitHub issue and its discussion",
+			Usage:       "number",
+			Description: `Issue summarises a GitHub issue and its comments for someone catching up on it: what it asks for, what has been found or decided, and what is still open. The repository is the one the origin remote is on, or --repo. With --post, the summary is added to the issue as a comment, using the token in GITHUB_TOKEN.`,
+			Run:         Issue,
+		},
+		{
+			Name:        "triage",
+			Summary:     "Cluster, label and prioritise a repository's open issues",
+			Usage:       "[owner/repo]",
+			Description: `Triage reads the open issues of a GitHub repository, the one the origin remote is on if none is given, and prints a report grouping them into clusters about the same problem, with a one-sentence summary of each issue, a priority and the labels it should have. Labels are suggested from those the repository defines. With --apply, the suggested labels are added to the issues, using the token in GITHUB_TOKEN.`,
+			Run:         Triage,
+		},
+		{
+			Name:        "semver",
+			Summary:     "Suggest the next semantic version",
+			Description: `Semver suggests whether the next release should be a major, minor or patch release, from the commits and the diff since the latest tag, and prints the next version with the reasoning for it. Without a tag, every commit is considered, starting from v0.0.0.`,
+			Run:         Semver,
+		},
+		{
+			Name:        "branch",
+			Summary:     "Suggest a branch name for a piece of work",
+			Usage:       "description",
+			Description: `Branch suggests a kebab-case branch name for the work described, in the format set under branch in the config, such as {type}/{ticket}-{slug}. The ticket id comes from --ticket or the description. With --switch, the branch is created and checked out.`,
+			Run:         Branch,
+		},
+		{
+			Name:        "explain-history",
+			Summary:     "Explain why code is the way it is from its git history",
+			Usage:       "file[:line]",
+			Description: `Explain-history explains why the code in a file is the way it is, from git blame and the messages of the commits that wrote it. Given a line, such as client.go:42, it looks at the lines around it; a whole file must be small enough to fit in the model's context.`,
+			Run:         ExplainHistory,
+		},
+		{
+			Name:        "explain-diff",
+			Summary:     "Walk a reviewer through a change",
+			Usage:       "[ref|file.patch]",
+			Description: `Explain-diff walks a reviewer through a change before they read it: what changed, why it is probably structured the way it is, and where the risks are. The change is the diff from a git ref to the working tree, a patch file, or without either, the changes not yet committed. Unlike review, it explains the change rather than listing problems with it.`,
+			Run:         ExplainDiff,
+		},
+		{
+			Name:    "hook",
+			Summary: "Install a git hook that pre-fills commit messages",
+			Usage:   "install | uninstall | run message-file",
+			Description: `Hook install writes a prepare-commit-msg hook to the current repository, which pre-fills the message of a plain git commit with one written for the staged changes, in the format set in the commit config. It works with git commit and with GUI clients, and never stops a commit: if no message can be written, the message is left empty as usual. An existing hook is only replaced with --force.
+
+Hook uninstall removes the hook, and hook run is what the hook runs.`,
+			Run: Hook,
+		},
+		{
+			Name:    "checklist",
+			Summary: "Evaluate a project against a checklist of criteria",
+			Usage:   "[path-or-url]",
+			Description: `Checklist evaluates a project, the current directory by default, against a checklist of criteria, reporting whether each passed with the evidence for it.
+
+The criteria come from the --criteria file, the checklist in the project's .chatproxy.yaml, or else a built in set of general checks. If a required criterion fails, checklist exits with status 8, so that it can gate a CI pipeline.`,
+			Run: Checklist,
+		},
+		{
+			Name:        "botfield",
+			Summary:     "Answer questions using the Go specification",
+			Usage:       "question...",
+			Description: `Botfield answers questions about Go, using the most relevant parts of the Go specification as context.`,
+			Run:         BotField,
+		},
+		{
+			Name:        "models",
+			Summary:     "List the models available to your API key",
+			Description: `Models lists the models available to your API key, with their context sizes and pricing. The current model is marked with a *.`,
+			Run:         Models,
+		},
+		{
+			Name:    "setup",
+			Summary: "Set up your API key, default model and transcripts",
+			Description: `Setup asks for your API key, a default model and whether to record transcripts, and writes them to the user config file. If the system has a keychain, through the security tool on macOS or secret-tool on Linux, the key can be stored there instead of in the config file. Setup never overwrites an existing config file.
+
+Commands offer to run setup when there is no API key and no config file yet.`,
+			Run: Setup,
+		},
+		{
+			Name:        "doctor",
+			Summary:     "Check the config, API key, network and model access",
+			Description: `Doctor checks that chatproxy is set up to work: that the config files are valid, the transcript and usage log directories are writable, an API key is available, the API can be reached through any proxy in $HTTPS_PROXY, and the key can use the model. Each failed check is printed with how to fix it, and doctor exits with status 1 if any fail.`,
+			Run:         Doctor,
+		},
+		{
+			Name:        "usage",
+			Summary:     "Report tokens used and their cost per day, command or model",
+			Description: `Usage reports the tokens used and their estimated cost, from the usage log that every completion is recorded in.`,
+			Run:         ReportUsage,
+		},
+		{
+			Name:        "transcript",
+			Summary:     "Print or follow a chat transcript",
+			Usage:       "[session]",
+			Description: `Transcript prints a recorded transcript, the most recent one unless a session is named, with its roles colorized. With --follow it keeps printing new lines as they are written, so that a running chat can be watched from another terminal.`,
+			Run:         Transcript,
+		},
+		{
+			Name:        "web",
+			Summary:     "Serve a browser chat UI",
+			Description: `Web serves a chat UI on a local address, for using chatproxy from a browser. It also serves an OpenAI compatible chat completions API at /v1/chat/completions, which streams replies as server-sent events in the OpenAI format when asked to, for HTTP clients that can't use the UI's WebSocket.`,
+			Run:         Web,
+		},
+		{
+			Name:        "editor",
+			Summary:     "Serve a JSON-RPC API for editor plugins",
+			Description: `Editor serves a JSON-RPC 2.0 API on a loopback address for editor plugins to call, with requests posted to /rpc as application/json. explain explains the code given, docComment writes a doc comment for it, and tests writes tests for it; each takes the workspace the editor has open, the file and language the code is from, and the code. Each workspace has a session of its own, kept while the server runs, so that later requests can build on earlier ones, and reset starts it again. Prompts over the cost or token limit set in the config are refused, as there is no one to confirm them.`,
+			Run:         Editor,
+		},
+		{
+			Name:        "gateway",
+			Summary:     "Serve an OpenAI compatible API that forwards to the configured provider",
+			Description: `Gateway serves the OpenAI chat completions API at /v1/chat/completions, forwarding each request to the configured provider with the configured API key, so that a team can point their SDKs at it instead of the provider. Each exchange is recorded in the transcript. Callers authenticate with the keys set under gateway.keys in the user config, and may make at most gateway.rate_limit requests a minute, or --rate-limit. Without keys, it only serves on a loopback address.`,
+			Run:         Gateway,
+		},
+		{
+			Name:        "grpc",
+			Summary:     "Serve the chat, ask, embed and query features over gRPC",
+			Description: `Grpc serves the ChatProxy gRPC service defined in proto/chatproxy.proto, so that services in any language can chat and ask questions, with replies streamed as they are generated, embed text, and find the passages of documents most relevant to a query. Each call is a conversation of its own, recorded in a transcript. Callers authenticate with the keys set under gateway.keys in the user config, sent as "authorization: Bearer <key>" metadata. Without keys, it only serves on a loopback address.`,
+			Run:         GRPC,
+		},
+		{
+			Name:        "digest",
+			Summary:     "Email a digest of what changed in a set of sources",
+			Description: `Digest reads the files, directories and URLs listed under digest.sources in the user config, summarises what changed in each since the last digest, and emails the digest to digest.smtp.to, or prints it with --print. Sources that haven't changed are left out, and nothing is sent if none did. What each source said is remembered in the XDG state directory. With --daemon it keeps running, sending a digest every digest.every, 24h by default. The SMTP password is read from CHATPROXY_SMTP_PASSWORD.`,
+			Run:         Digest,
+		},
+		{
+			Name:        "mcp",
+			Summary:     "Serve chatproxy's features as Model Context Protocol tools",
+			Description: `MCP serves chatproxy's features as Model Context Protocol tools over stdin and stdout, so that editors and other LLM clients can call them: read reads a file, directory or URL as --context does, query finds the passages of files, directories or URLs most relevant to a query, tldr summarises them, and commit_message writes a commit message for the staged changes of the repository the server runs in. Configure the client to start "chatproxy mcp". Prompts over the cost or token limit set in the config are refused, as there is no one to confirm them.`,
+			Run:         MCP,
+		},
+		{
+			Name:        "telegram",
+			Summary:     "Chat with the assistant over Telegram",
+			Description: `Telegram chats with the people given by --allow over Telegram, as the bot whose token is in TELEGRAM_BOT_TOKEN, so that the assistant can be used from a phone. Each chat is a conversation of its own, which starts by describing the purpose of the assistant or naming one of the purposes in the config, as the chat command does; /new starts another. Documents sent to the bot are read as the --context flag reads files, and their caption is sent as a message.`,
+			Run:         Telegram,
+		},
+		{
+			Name:        "matrix",
+			Summary:     "Chat with the assistant in Matrix rooms",
+			Description: `Matrix chats with the people given by --allow in the Matrix rooms they invite it to, as the user whose access token is in MATRIX_ACCESS_TOKEN on the homeserver at MATRIX_HOMESERVER_URL, for teams that run their own chat. Each room is a conversation of its own, kept as a saved session named matrix- and the room id, which starts by describing the purpose of the assistant or naming one of the purposes in the config, as the chat command does; !new starts another. Files sent to the room are read as the --context flag reads files. For end-to-end encrypted rooms, set MATRIX_HOMESERVER_URL to an E2EE aware proxy such as pantalaimon.`,
+			Run:         Matrix,
+		},
+	}
+}
+
+// lookupCommand returns the command with the given name.
+func lookupCommand(name string) (Command, bool) {
+	for _, cmd := range Commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// Main runs the chatproxy binary: args[1] names the subcommand to run, and
+// the remaining arguments are passed to it. A name that isn't one of the
+// Commands runs the plugin of that name on PATH, if there is one.
+func Main(args []string) int {
+	if len(args) < 2 {
+		printUsage(os.Stderr)
+		return 2
+	}
+	switch name := args[1]; name {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 2 {
+			cmd, ok := lookupCommand(args[2])
+			if !ok {
+				if path, err := exec.LookPath(PluginPrefix + args[2]); err == nil {
+					return runPlugin(args[2], path, []string{"--help"})
+				}
+				fmt.Fprintf(os.Stderr, "chatproxy: unknown command %q\n", args[2])
+				return 2
+			}
+			printCommandHelp(os.Stdout, cmd, commandFlagSet(cmd))
+			return 0
+		}
+		printUsage(os.Stdout)
+		return 0
+	case "version", "-version", "--version":
+		fmt.Println("chatproxy", version())
+		return 0
+	case "man":
+		dir := "."
+		if len(args) > 2 {
+			dir = args[2]
+		}
+		err := WriteManPages(dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitCode(err)
+		}
+		return 0
+	default:
+		if cmd, ok := lookupCommand(name); ok {
+			return cmd.Run(args[1:])
+		}
+		if path, err := exec.LookPath(PluginPrefix + name); err == nil {
+			return runPlugin(name, path, args[2:])
+		}
+		fmt.Fprintf(os.Stderr, "chatproxy: unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return 2
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: chatproxy <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range Commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(tw, "  %s\t%s\n", "version", "Print the chatproxy version")
+	fmt.Fprintf(tw, "  %s\t%s\n", "help", "Print this help, or a command's help")
+	fmt.Fprintf(tw, "  %s\t%s\n", "man", "Write man pages for chatproxy and its commands to a directory")
+	tw.Flush()
+	fmt.Fprintln(w)
+	if plugins := PluginNames(); len(plugins) > 0 {
+		fmt.Fprintln(w, "Plugins:")
+		for _, name := range plugins {
+			fmt.Fprintf(tw, "  %s\t%s\n", name, "Run "+PluginPrefix+name)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "Run 'chatproxy help <command>' for details of a command and its flags.")
+}
+
+// version returns Version if it was set at build time, or else the module
+// version from the build info.
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
@@ -0,0 +1,809 @@
+package chatproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// CommitConfig configures the commit messages written by the commit
+// command, usually for a repository in its project config:
+//
+//	commit:
+//	  format: conventional
+//	  scopes: [cli, client, docs]
+//	  scope_paths:
+//	    cmd/**: cli
+//	    client.go: client
+//	  exclude: [testdata/**, "*.gen.go"]
+//	  language: Japanese
+//	  tense: past
+//	  emoji: none
+//	  forbidden_words: [WIP, misc]
+//
+// Scope is the scope every Conventional Commit must have. If it isn't set,
+// it is inferred with ScopePaths, which maps patterns for paths, in the
+// syntax of Exclude, to the scope for changes to them: when every staged
+// file that matches a pattern maps to the same scope, the message must use
+// it. A file matching several patterns takes the scope of the longest.
+//
+// Exclude lists patterns for files whose changes are left out of the diff
+// the message is written from, in addition to DefaultCommitExclude.
+//
+// Language, Tense and Emoji set the style of the message, and the model is
+// told not to use any of the forbidden words. Messages are checked for
+// everything but the language, and the model is asked to correct one that
+// doesn't follow the style.
+type CommitConfig struct {
+	Format     string            `yaml:"format"`
+	Scopes     []string          `yaml:"scopes"`
+	Scope      string            `yaml:"scope"`
+	ScopePaths map[string]string `yaml:"scope_paths"`
+	Exclude    []string          `yaml:"exclude"`
+	Language   string            `yaml:"language"`
+	Tense      string            `yaml:"tense"`
+	Emoji      string            `yaml:"emoji"`
+	Forbidden  []string          `yaml:"forbidden_words"`
+}
+
+// DefaultCommitExclude are patterns for lockfiles, vendored dependencies
+// and generated code, whose changes are left out of the diff a commit
+// message is written from. The files are still named, so that the message
+// can mention them.
+var DefaultCommitExclude = []string{
+	"go.sum",
+	"package-lock.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Cargo.lock",
+	"poetry.lock",
+	"Gemfile.lock",
+	"composer.lock",
+	"vendor/**",
+	"node_modules/**",
+	"*.pb.go",
+	"*.min.js",
+}
+
+// The commit message formats that can be selected with --format or the
+// commit format setting.
+const (
+	CommitFormatPlain        = "plain"
+	CommitFormatConventional = "conventional"
+)
+
+// CommitFormats are the commit message formats.
+var CommitFormats = []string{CommitFormatPlain, CommitFormatConventional}
+
+// ConventionalCommitTypes are the types a Conventional Commit may have.
+var ConventionalCommitTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+// The tenses a commit subject can be written in: "Add retries", "Added
+// retries" or "Adds retries".
+const (
+	CommitTenseImperative = "imperative"
+	CommitTensePast       = "past"
+	CommitTensePresent    = "present"
+)
+
+// CommitTenses are the tenses a commit subject can be written in.
+var CommitTenses = []string{CommitTenseImperative, CommitTensePast, CommitTensePresent}
+
+// The emoji policies for commit messages: none forbids emoji, allow leaves
+// them to the model, and require starts every subject with one, as gitmoji
+// does.
+const (
+	CommitEmojiNone    = "none"
+	CommitEmojiAllow   = "allow"
+	CommitEmojiRequire = "require"
+)
+
+// CommitEmojiPolicies are the emoji policies for commit messages.
+var CommitEmojiPolicies = []string{CommitEmojiNone, CommitEmojiAllow, CommitEmojiRequire}
+
+func (cfg CommitConfig) validate() error {
+	if cfg.Format != "" && !containsString(CommitFormats, cfg.Format) {
+		return fmt.Errorf("commit.format: unknown format %q, expected one of %s", cfg.Format, strings.Join(CommitFormats, ", "))
+	}
+	if cfg.Tense != "" && !containsString(CommitTenses, cfg.Tense) {
+		return fmt.Errorf("commit.tense: unknown tense %q, expected one of %s", cfg.Tense, strings.Join(CommitTenses, ", "))
+	}
+	if cfg.Emoji != "" && !containsString(CommitEmojiPolicies, cfg.Emoji) {
+		return fmt.Errorf("commit.emoji: unknown policy %q, expected one of %s", cfg.Emoji, strings.Join(CommitEmojiPolicies, ", "))
+	}
+	for pattern, scope := range cfg.ScopePaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("commit.scope_paths: bad pattern %q", pattern)
+		}
+		if strings.TrimSpace(scope) == "" {
+			return fmt.Errorf("commit.scope_paths: %q has no scope", pattern)
+		}
+	}
+	return nil
+}
+
+func (cfg *CommitConfig) override(o CommitConfig) {
+	if o.Format != "" {
+		cfg.Format = o.Format
+	}
+	if o.Scopes != nil {
+		cfg.Scopes = o.Scopes
+	}
+	if o.Scope != "" {
+		cfg.Scope = o.Scope
+	}
+	if len(o.ScopePaths) > 0 && cfg.ScopePaths == nil {
+		cfg.ScopePaths = map[string]string{}
+	}
+	for pattern, scope := range o.ScopePaths {
+		cfg.ScopePaths[pattern] = scope
+	}
+	cfg.Exclude = append(cfg.Exclude, o.Exclude...)
+	if o.Language != "" {
+		cfg.Language = o.Language
+	}
+	if o.Tense != "" {
+		cfg.Tense = o.Tense
+	}
+	if o.Emoji != "" {
+		cfg.Emoji = o.Emoji
+	}
+	cfg.Forbidden = append(cfg.Forbidden, o.Forbidden...)
+}
+
+// Commit parses the diff of staged Git files and generates an appropriate commit message.
+// This method, part of the ChatGPTClient, helps users maintain clear commit history and conveys changes in a concise and descriptive manner.
+// The message is in the format set by the commit settings in the config.
+func (c *ChatGPTClient) Commit() (summary string, err error) {
+	return c.CommitWith(c.config.Commit)
+}
+
+// CommitWith generates a commit message for the staged changes in the
+// format given by cfg. A Conventional Commit is checked, and the model is
+// asked once to correct a message that isn't one. A diff too large for the
+// model's context is summarised file by file, and the message is written
+// from the summaries.
+func (c *ChatGPTClient) CommitWith(cfg CommitConfig) (string, error) {
+	return c.writeCommit(cfg, "", "")
+}
+
+// AmendCommit generates a message for amending the HEAD commit with the
+// staged changes, revising the commit's existing message to cover them as
+// well. Otherwise it works as CommitWith does.
+func (c *ChatGPTClient) AmendCommit(cfg CommitConfig) (string, error) {
+	cmd := exec.Command("git", "log", "-1", "--format=%B")
+	head := new(bytes.Buffer)
+	cmd.Stdout = head
+	err := runGit(cmd)
+	if err != nil {
+		return "", err
+	}
+	note := `
+	The changes amend an existing commit. Revise its message, which is given before the diff, so that it also covers the new changes, keeping whatever still applies.`
+	return c.writeCommit(cfg, note, "The existing commit message:\n"+strings.TrimSpace(head.String()))
+}
+
+// writeCommit generates a commit message for the staged changes with the
+// purpose for cfg followed by note, sending previous, if it isn't empty,
+// before the diff.
+func (c *ChatGPTClient) writeCommit(cfg CommitConfig, note, previous string) (string, error) {
+	c.SetPurpose(cfg.purpose() + note)
+	diff, err := stagedDiff()
+	if err != nil {
+		return "", err
+	}
+	if scoped := cfg.withInferredScope(); scoped.Scope != cfg.Scope {
+		cfg = scoped
+		c.SetPurpose(cfg.purpose() + note)
+	}
+	diff = cfg.excludeFromDiff(diff)
+	if budget := c.diffBudget(); guessTokens(diff) > budget {
+		summaries, err := c.summariseDiff(diff, budget)
+		if err != nil {
+			return "", err
+		}
+		c.chatHistory = []ChatMessage{}
+		c.SetPurpose(cfg.purpose() + note)
+		diff = "The staged diff is too large to show in full, so here are summaries of its changes, file by file:\n\n" + summaries
+	}
+	if previous != "" {
+		c.RecordMessage(RoleUser, previous)
+	}
+	c.RecordMessage(RoleUser, diff)
+	return c.completeCommit(cfg)
+}
+
+// commitTenses describes each tense for the model, with an example.
+var commitTenses = map[string]struct{ name, example string }{
+	CommitTenseImperative: {"the imperative mood", "Add retries to the client"},
+	CommitTensePast:       {"the past tense", "Added retries to the client"},
+	CommitTensePresent:    {"the present tense", "Adds retries to the client"},
+}
+
+// purpose returns the purpose for writing a commit message in the format
+// and style given by cfg.
+func (cfg CommitConfig) purpose() string {
+	tense, ok := commitTenses[cfg.Tense]
+	if !ok {
+		tense = commitTenses[CommitTenseImperative]
+	}
+	if cfg.Format != CommitFormatConventional {
+		return `Please read the git diff provided and write an appropriate commit message.
+	Focus on the lines that start with a + (line added) or - (line removed)
+	Start with a subject line of at most 50 characters in ` + tense.name + `, such as "` + tense.example + `".
+	If the change needs explaining, follow it with a blank line and a body saying what changed and why.` + cfg.style() + `
+	Reply with only the commit message.`
+	}
+	scope := "The scope is optional, and names the part of the project that changed."
+	switch {
+	case cfg.Scope != "":
+		scope = "The scope is " + cfg.Scope + ", the part of the project every change is in."
+	case len(cfg.Scopes) > 0:
+		scope = "The scope is optional, and if given is one of " + strings.Join(cfg.Scopes, ", ") + "."
+	}
+	return `Please read the git diff provided and write a commit message in the Conventional Commits format.
+	Focus on the lines that start with a + (line added) or - (line removed).
+	The first line is "type(scope): subject", at most 50 characters, where the type is one of ` + strings.Join(ConventionalCommitTypes, ", ") + `.
+	` + scope + `
+	Write the subject in ` + tense.name + `, in lower case, without a full stop.
+	After a blank line, the body explains what changed and why.
+	If the change breaks compatibility, put a ! before the colon and end the message with a "BREAKING CHANGE: " footer describing what breaks.` + cfg.style() + `
+	Reply with only the commit message.`
+}
+
+// style returns the instructions for the language, emoji and forbidden
+// words set by cfg, each on a line of its own.
+func (cfg CommitConfig) style() string {
+	var rules []string
+	if cfg.Language != "" {
+		rule := "Write the message in " + cfg.Language + "."
+		if cfg.Format == CommitFormatConventional {
+			rule += " Keep the type, the scope and any BREAKING CHANGE footer as they are."
+		}
+		rules = append(rules, rule)
+	}
+	switch cfg.Emoji {
+	case CommitEmojiNone:
+		rules = append(rules, "Don't use emoji.")
+	case CommitEmojiRequire:
+		where := "Start the subject"
+		if cfg.Format == CommitFormatConventional {
+			where = "Start the subject, after the type and scope,"
+		}
+		rules = append(rules, where+" with one emoji that suits the change, as gitmoji does, such as ✨ for a new feature or 🐛 for a bug fix.")
+	}
+	if len(cfg.Forbidden) > 0 {
+		rules = append(rules, "Never use these words: "+strings.Join(cfg.Forbidden, ", ")+".")
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return "\n\t" + strings.Join(rules, "\n\t")
+}
+
+// diffBudget returns the number of tokens a diff may take up before it is
+// summarised in chunks: half the model's context window, leaving room for
+// the purpose and the reply. Models chatproxy doesn't know about are
+// assumed to have the smallest context window of those it does.
+func (c *ChatGPTClient) diffBudget() int {
+	info, _ := describeModel(c.model)
+	size := info.ContextSize
+	if size == 0 {
+		size = ModelContextSize[openai.GPT3Dot5Turbo]
+	}
+	return size / 2
+}
+
+// summariseDiff summarises each chunk of diff, made of whole files that fit
+// in budget tokens, in a conversation of its own, and returns the
+// summaries.
+func (c *ChatGPTClient) summariseDiff(diff string, budget int) (string, error) {
+	chunks := chunkDiff(diff, budget)
+	summaries := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		c.progressf("Summarising part %d of %d of the staged diff\n", i+1, len(chunks))
+		c.chatHistory = []ChatMessage{}
+		c.SetPurpose(`Please summarise the changes in this part of a git diff, for someone writing its commit message.
+	For each file, list what changed, and why if the diff shows it, in a few short bullet points.`)
+		c.RecordMessage(RoleUser, chunk)
+		summary, err := c.GetCompletion()
+		if err != nil {
+			return "", err
+		}
+		summaries[i] = summary
+	}
+	return strings.Join(summaries, "\n\n"), nil
+}
+
+// splitDiff splits diff into the diffs of each file.
+func splitDiff(diff string) []string {
+	files := strings.Split(diff, "\ndiff --git ")
+	for i := range files {
+		if i > 0 {
+			files[i] = "diff --git " + files[i]
+		}
+		if i < len(files)-1 {
+			files[i] += "\n"
+		}
+	}
+	return files
+}
+
+// diffPath returns the path of the file a single file's diff is for.
+func diffPath(file string) string {
+	header, _, _ := strings.Cut(file, "\n")
+	if i := strings.LastIndex(header, " b/"); i >= 0 {
+		return header[i+len(" b/"):]
+	}
+	return strings.TrimPrefix(header, "diff --git ")
+}
+
+// excludeFromDiff removes the diffs of files matching DefaultCommitExclude
+// or the configured patterns, noting which files they were.
+func (cfg CommitConfig) excludeFromDiff(diff string) string {
+	patterns := append(append([]string{}, DefaultCommitExclude...), cfg.Exclude...)
+	kept := new(strings.Builder)
+	var excluded []string
+	for _, file := range splitDiff(diff) {
+		name := diffPath(file)
+		if matchesAnyPath(patterns, name) {
+			excluded = append(excluded, name)
+			continue
+		}
+		kept.WriteString(file)
+	}
+	if len(excluded) > 0 {
+		fmt.Fprintf(kept, "\nThese files also changed, but their diffs are left out as they are lockfiles, vendored or generated: %s\n", strings.Join(excluded, ", "))
+	}
+	return kept.String()
+}
+
+// withInferredScope returns cfg with the Scope that ScopePaths gives the
+// staged files, for a Conventional Commit without one. There is no scope if
+// the files don't match any of the patterns, or if they map to different
+// scopes.
+func (cfg CommitConfig) withInferredScope() CommitConfig {
+	if cfg.Format != CommitFormatConventional || cfg.Scope != "" || len(cfg.ScopePaths) == 0 {
+		return cfg
+	}
+	out, err := exec.Command("git", "diff", "--cached", "--name-only").Output()
+	if err != nil {
+		return cfg
+	}
+	cfg.Scope = inferScope(cfg.ScopePaths, strings.Fields(string(out)))
+	return cfg
+}
+
+// inferScope returns the scope that scopePaths gives every one of files
+// that matches a pattern, or the empty string if there isn't just one.
+func inferScope(scopePaths map[string]string, files []string) string {
+	scope := ""
+	for _, file := range files {
+		best := ""
+		for pattern := range scopePaths {
+			if !matchesAnyPath([]string{pattern}, file) {
+				continue
+			}
+			if len(pattern) > len(best) || len(pattern) == len(best) && pattern < best {
+				best = pattern
+			}
+		}
+		if best == "" {
+			continue
+		}
+		if scope != "" && scopePaths[best] != scope {
+			return ""
+		}
+		scope = scopePaths[best]
+	}
+	return scope
+}
+
+// matchesAnyPath reports whether file, a slash separated path, matches
+// any of the patterns. As in the ignore setting, patterns use path.Match
+// syntax and a trailing /** matches everything beneath a directory. They
+// are matched against the whole path, its base name, and each directory
+// the file is in, so that vendor/** matches vendor at any depth.
+func matchesAnyPath(patterns []string, file string) bool {
+	elems := strings.Split(file, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "/**"), "/")
+		for i := range elems {
+			for _, name := range []string{strings.Join(elems[:i+1], "/"), elems[i]} {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// chunkDiff splits diff into chunks of whole files that each fit in budget
+// tokens. The diff of a file that doesn't fit on its own is truncated.
+func chunkDiff(diff string, budget int) []string {
+	var chunks []string
+	chunk := ""
+	for _, file := range splitDiff(diff) {
+		if guessTokens(file) > budget {
+			limit := budget * 2
+			if end := strings.LastIndex(file[:limit], "\n"); end > 0 {
+				limit = end + 1
+			}
+			file = file[:limit] + "[the rest of this file's diff is truncated]\n"
+		}
+		if chunk != "" && guessTokens(chunk+file) > budget {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+		chunk += file
+	}
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// RegenerateCommit asks for an alternative to the commit message previous,
+// written by CommitWith with the same cfg, optionally following the user's
+// guidance, such as "mention the API change".
+func (c *ChatGPTClient) RegenerateCommit(cfg CommitConfig, previous, guidance string) (string, error) {
+	cfg = cfg.withInferredScope()
+	c.RecordMessage(RoleBot, previous)
+	request := "Please write a different commit message for the same changes."
+	if guidance = strings.TrimSpace(guidance); guidance != "" {
+		request += " " + guidance
+	}
+	c.RecordMessage(RoleUser, request)
+	return c.completeCommit(cfg)
+}
+
+// completeCommit gets a commit message from the model, formats it with
+// FormatCommitMessage and checks it, asking the model once to correct a
+// message that isn't valid.
+func (c *ChatGPTClient) completeCommit(cfg CommitConfig) (string, error) {
+	msg, err := c.GetCompletion()
+	if err != nil {
+		return "", err
+	}
+	msg = FormatCommitMessage(msg)
+	invalid := cfg.check(msg)
+	if invalid == nil {
+		return msg, nil
+	}
+	c.RecordMessage(RoleBot, msg)
+	c.RecordMessage(RoleUser, fmt.Sprintf("That commit message isn't valid: %v. Please reply with only the corrected commit message.", invalid))
+	msg, err = c.GetCompletion()
+	if err != nil {
+		return "", err
+	}
+	msg = FormatCommitMessage(msg)
+	invalid = cfg.check(msg)
+	if invalid != nil {
+		return "", fmt.Errorf("the generated commit message isn't valid: %w", invalid)
+	}
+	return msg, nil
+}
+
+// check validates the shape of msg, that it is a Conventional Commit if
+// that is the configured format, and that it follows the configured style.
+func (cfg CommitConfig) check(msg string) error {
+	err := ValidateCommitMessage(msg)
+	if err != nil {
+		return err
+	}
+	subject, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	if cfg.Format == CommitFormatConventional {
+		err = ValidateConventionalCommit(msg, cfg.Scopes)
+		if err != nil {
+			return err
+		}
+		m := conventionalSubject.FindStringSubmatch(subject)
+		if cfg.Scope != "" && m[2] != cfg.Scope {
+			return fmt.Errorf("the scope must be %s, as every change is in it", cfg.Scope)
+		}
+		subject = m[4]
+	}
+	return cfg.checkStyle(msg, subject)
+}
+
+// commitEmoji matches emoji, and gitmoji codes such as :sparkles:.
+var commitEmoji = regexp.MustCompile(`[\x{1F000}-\x{1FAFF}\x{2600}-\x{27BF}\x{2B00}-\x{2BFF}]|:[a-z0-9_+-]+:`)
+
+// irregularPast are the past tenses of verbs common in commit subjects that
+// don't end in -ed.
+var irregularPast = []string{"built", "cut", "did", "drew", "got", "kept", "left", "let", "made", "put", "ran", "read", "rewrote", "set", "split", "took", "threw", "wrote"}
+
+// edVerbs are verbs that end in -ed, other than those in -eed such as
+// proceed, but aren't in the past tense.
+var edVerbs = []string{"bed", "embed", "shed", "shred"}
+
+// checkStyle checks that msg, whose subject text is subject, follows the
+// emoji policy and forbidden words of cfg, and for a message in English,
+// the tense if one is set.
+func (cfg CommitConfig) checkStyle(msg, subject string) error {
+	for _, word := range cfg.Forbidden {
+		forbidden := regexp.MustCompile(`(?i)(^|\W)` + regexp.QuoteMeta(word) + `($|\W)`)
+		if forbidden.MatchString(msg) {
+			return fmt.Errorf("the message uses the forbidden word %q", word)
+		}
+	}
+	switch cfg.Emoji {
+	case CommitEmojiNone:
+		if e := commitEmoji.FindString(msg); e != "" {
+			return fmt.Errorf("the message uses the emoji %s, but emoji aren't allowed", e)
+		}
+	case CommitEmojiRequire:
+		if loc := commitEmoji.FindStringIndex(subject); loc == nil || loc[0] != 0 {
+			return errors.New("the subject must start with an emoji")
+		}
+		subject = strings.TrimSpace(commitEmoji.ReplaceAllString(subject, ""))
+	}
+	if cfg.Language != "" && !strings.EqualFold(cfg.Language, "English") {
+		return nil
+	}
+	verb, _, _ := strings.Cut(strings.ToLower(subject), " ")
+	past := strings.HasSuffix(verb, "ed") && !strings.HasSuffix(verb, "eed") && !containsString(edVerbs, verb)
+	switch cfg.Tense {
+	case CommitTensePast:
+		if !past && !containsString(irregularPast, verb) {
+			return fmt.Errorf("the subject must be in the past tense, such as %q", commitTenses[CommitTensePast].example)
+		}
+	case CommitTensePresent:
+		if !strings.HasSuffix(verb, "s") {
+			return fmt.Errorf("the subject must be in the present tense, such as %q", commitTenses[CommitTensePresent].example)
+		}
+	case CommitTenseImperative:
+		if past {
+			return fmt.Errorf("the subject must be in the imperative mood, such as %q", commitTenses[CommitTenseImperative].example)
+		}
+	}
+	return nil
+}
+
+// The limits on the shape of a commit message, following git convention:
+// a subject short enough for one line of git log --oneline, and a body
+// that fits an 80 column terminal with the indentation git log adds.
+const (
+	CommitSubjectLimit = 50
+	CommitBodyWidth    = 72
+)
+
+var (
+	commitListItem = regexp.MustCompile(`^([-*]|\d+\.) `)
+	commitTrailer  = regexp.MustCompile(`^(BREAKING[ -]CHANGE|[A-Za-z][\w-]*): `)
+)
+
+// FormatCommitMessage tidies a generated commit message into git's shape.
+// It drops a full stop from the end of the subject, puts a blank line
+// between the subject and the body, and wraps the body's paragraphs and
+// list items at CommitBodyWidth columns. Trailers such as "BREAKING
+// CHANGE: " start a new line, and indented lines, such as code, are left
+// as they are.
+func FormatCommitMessage(msg string) string {
+	msg = strings.ReplaceAll(strings.TrimSpace(msg), "\r\n", "\n")
+	subject, body, _ := strings.Cut(msg, "\n")
+	subject = strings.TrimSuffix(strings.TrimSpace(subject), ".")
+	var paragraphs []string
+	for _, para := range strings.Split(body, "\n\n") {
+		para = strings.TrimRight(strings.Trim(para, "\n"), " \t\n")
+		if strings.TrimSpace(para) != "" {
+			paragraphs = append(paragraphs, wrapCommitParagraph(para))
+		}
+	}
+	if len(paragraphs) == 0 {
+		return subject
+	}
+	return subject + "\n\n" + strings.Join(paragraphs, "\n\n")
+}
+
+// wrapCommitParagraph wraps a paragraph of a commit message body. List
+// items are wrapped with a hanging indent under their text.
+func wrapCommitParagraph(para string) string {
+	var lines []string
+	marker, words := "", []string(nil)
+	flush := func() {
+		if len(words) == 0 {
+			return
+		}
+		indent := strings.Repeat(" ", len(marker))
+		for i, line := range strings.Split(wrapText(strings.Join(words, " "), CommitBodyWidth-len(marker)), "\n") {
+			if i == 0 {
+				lines = append(lines, marker+line)
+			} else {
+				lines = append(lines, indent+line)
+			}
+		}
+		marker, words = "", nil
+	}
+	for _, line := range strings.Split(para, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		switch {
+		case trimmed == "":
+		case indented && (marker == "" || commitListItem.MatchString(trimmed)):
+			// Indented text that doesn't continue a list item, such as
+			// code or a nested list, is kept as it is.
+			flush()
+			lines = append(lines, strings.TrimRight(line, " \t"))
+		case commitListItem.MatchString(line):
+			flush()
+			marker = commitListItem.FindString(line)
+			words = strings.Fields(strings.TrimPrefix(line, marker))
+		case commitTrailer.MatchString(line):
+			flush()
+			words = strings.Fields(line)
+		default:
+			words = append(words, strings.Fields(line)...)
+		}
+	}
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+// ValidateCommitMessage checks that msg has a subject of at most
+// CommitSubjectLimit characters, separated from any body by a blank line,
+// and that the body's lines are at most CommitBodyWidth columns, apart
+// from indented lines and single words, such as URLs, that can't be
+// wrapped.
+func ValidateCommitMessage(msg string) error {
+	lines := strings.Split(msg, "\n")
+	subject := strings.TrimSpace(lines[0])
+	if subject == "" {
+		return errors.New("the subject is empty")
+	}
+	if n := utf8.RuneCountInString(subject); n > CommitSubjectLimit {
+		return fmt.Errorf("the subject is %d characters, more than %d", n, CommitSubjectLimit)
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		return errors.New("the subject must be followed by a blank line")
+	}
+	for i, line := range lines[1:] {
+		if utf8.RuneCountInString(line) <= CommitBodyWidth || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if len(strings.Fields(line)) > 1 {
+			return fmt.Errorf("line %d is longer than %d columns", i+2, CommitBodyWidth)
+		}
+	}
+	return nil
+}
+
+// messageFlags are the git commit flags that set or edit the message, or
+// amend, which commit can't pass on to git as it writes the message itself.
+var messageFlags = []string{"-m", "--message", "-F", "--file", "-C", "--reuse-message", "-c", "--reedit-message", "-t", "--template", "-e", "--edit", "--fixup", "--squash", "--amend"}
+
+// checkGitCommitArgs checks that args, to be passed on to git commit,
+// don't include any of messageFlags.
+func checkGitCommitArgs(args []string) error {
+	for _, arg := range args {
+		for _, flag := range messageFlags {
+			short := len(flag) == 2 && strings.HasPrefix(arg, flag)
+			if arg == flag || short || strings.HasPrefix(arg, flag+"=") {
+				if flag == "--amend" {
+					return errors.New("use commit --amend, before the --, to amend the HEAD commit")
+				}
+				return fmt.Errorf("%s can't be passed to git commit, as commit writes the message", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// editMessage opens msg in the user's editor, $VISUAL or $EDITOR or else
+// vi, and returns the message once the editor exits. As with git, lines
+// starting with # are dropped.
+func editMessage(msg string) (string, error) {
+	f, err := os.CreateTemp("", "COMMIT_EDITMSG-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(msg + "\n\n# Edit the commit message. Lines starting with # are ignored,\n# and an empty message aborts the commit.\n")
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("running editor %s: %w", editor, err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// checkRepository returns ErrGitNotFound if git can't be run, or
+// ErrNotARepository if the current directory isn't in a git work tree.
+func checkRepository() error {
+	_, err := exec.LookPath("git")
+	if err != nil {
+		return ErrGitNotFound
+	}
+	err = exec.Command("git", "rev-parse", "--is-inside-work-tree").Run()
+	if err != nil {
+		return ErrNotARepository
+	}
+	return nil
+}
+
+// stagedDiff returns the diff of the changes staged in git, or
+// ErrNoStagedChanges if there aren't any. It fails with ErrGitNotFound or
+// ErrNotARepository if there is no repository to diff.
+func stagedDiff() (string, error) {
+	err := checkRepository()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "diff", "--cached")
+	buf := bytes.Buffer{}
+	cmd.Stdout = &buf
+	err = runGit(cmd)
+	if err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", ErrNoStagedChanges
+	}
+	return buf.String(), nil
+}
+
+var (
+	conventionalSubject = regexp.MustCompile(`^(\w+)(?:\(([^()]+)\))?(!)?: (\S.*)$`)
+	breakingFooter      = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: \S`)
+)
+
+// ValidateConventionalCommit checks that msg is a Conventional Commit: a
+// "type(scope)!: subject" line with a known type, and a scope from scopes
+// if any are given, then optionally a body after a blank line. A message
+// marked as breaking with ! needs a BREAKING CHANGE footer.
+func ValidateConventionalCommit(msg string, scopes []string) error {
+	lines := strings.Split(strings.TrimSpace(msg), "\n")
+	m := conventionalSubject.FindStringSubmatch(lines[0])
+	if m == nil {
+		return fmt.Errorf("the first line %q isn't in the form type(scope): subject", lines[0])
+	}
+	if !containsString(ConventionalCommitTypes, m[1]) {
+		return fmt.Errorf("unknown type %q, expected one of %s", m[1], strings.Join(ConventionalCommitTypes, ", "))
+	}
+	if m[2] != "" && len(scopes) > 0 && !containsString(scopes, m[2]) {
+		return fmt.Errorf("unknown scope %q, expected one of %s", m[2], strings.Join(scopes, ", "))
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		return errors.New("the subject must be followed by a blank line")
+	}
+	if m[3] == "!" && !breakingFooter.MatchString(msg) {
+		return errors.New("a breaking change needs a BREAKING CHANGE footer")
+	}
+	return nil
+}
@@ -0,0 +1,700 @@
+package chatproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the defaults read from the user config file and the project
+// config file. Settings at the top level apply to every command, and those
+// under commands override them for the named command, for example:
+//
+//	model: gpt-4
+//	streaming: true
+//	transcript:
+//	  dir: ~/notes/chat-logs
+//	commands:
+//	  tldr:
+//	    model: gpt-3.5-turbo
+//	    temperature: 0.2
+//
+// A project config can also name purposes for the chat, list patterns for
+// files to ignore when loading directories, give checklist criteria, set
+// the format of commit messages and branch names, the audience and tone of
+// release notes, and the questions of quizzes in chat.
+// Credential profiles, the gateway's keys, MCP servers, the digest, webhooks
+// and the base URL can only be set in the user config, as a project config comes
+// with whatever repository it is found in.
+type Config struct {
+	Settings  `yaml:",inline"`
+	Commands  map[string]Settings `yaml:"commands"`
+	Purposes  map[string]string   `yaml:"purposes"`
+	Ignore    []string            `yaml:"ignore"`
+	Checklist []Criterion         `yaml:"checklist"`
+	Commit    CommitConfig        `yaml:"commit"`
+	Release   ReleaseNotesConfig  `yaml:"release_notes"`
+	Branch    BranchConfig        `yaml:"branch"`
+	Quiz      QuizConfig          `yaml:"quiz"`
+	Profiles  map[string]Profile  `yaml:"profiles"`
+	Gateway   GatewayConfig       `yaml:"gateway"`
+	// MCPServers are the MCP servers whose tools chat sessions offer to the
+	// model, by name.
+	MCPServers map[string]MCPServerConfig `yaml:"mcp_servers"`
+	Digest     DigestConfig               `yaml:"digest"`
+	// Webhooks are the URLs that server commands post each completion to.
+	Webhooks []string `yaml:"webhooks"`
+}
+
+// ProjectConfigName is the name of the project config file, which is found
+// by searching the current directory and its parents.
+const ProjectConfigName = ".chatproxy.yaml"
+
+// Settings are the client defaults that can be set in the config file.
+// Unset fields leave the built in default unchanged.
+type Settings struct {
+	Model         string           `yaml:"model"`
+	Streaming     *bool            `yaml:"streaming"`
+	Temperature   *float32         `yaml:"temperature"`
+	Provider      string           `yaml:"provider"`
+	Transcript    TranscriptConfig `yaml:"transcript"`
+	Theme         string           `yaml:"theme"`
+	KeyBindings   string           `yaml:"keybindings"`
+	ExitKeywords  []string         `yaml:"exit_keywords"`
+	ConfirmExit   *bool            `yaml:"confirm_exit"`
+	IdleTimeout   *time.Duration   `yaml:"idle_timeout"`
+	Profile       string           `yaml:"profile"`
+	ConfirmCost   *float64         `yaml:"confirm_cost"`
+	ConfirmTokens *int             `yaml:"confirm_tokens"`
+	BaseURL       string           `yaml:"base_url"`
+	Timeout       *time.Duration   `yaml:"timeout"`
+	Fallback      []string         `yaml:"fallback"`
+	Redact        RedactConfig     `yaml:"redact"`
+	PII           PIIConfig        `yaml:"pii"`
+	Moderation    ModerationConfig `yaml:"moderation"`
+}
+
+// TranscriptConfig controls where transcripts are recorded, or disables
+// recording them altogether.
+type TranscriptConfig struct {
+	Enabled *bool  `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+}
+
+// RedactConfig adds patterns for secrets to redact from messages, as well
+// as API keys, AWS credentials and JWTs, and controls whether they are also
+// redacted from prompts sent to the API.
+type RedactConfig struct {
+	Patterns []string `yaml:"patterns"`
+	Prompts  *bool    `yaml:"prompts"`
+}
+
+// PIIConfig enables masking personal information from prompts, with
+// names to mask as well as those chatproxy recognises.
+type PIIConfig struct {
+	Mask  *bool    `yaml:"mask"`
+	Names []string `yaml:"names"`
+}
+
+// ModerationConfig sets what to do with prompts and replies flagged by the
+// moderation endpoint, one of ModerationActions each, and the moderation
+// model to use, if not the endpoint's default.
+type ModerationConfig struct {
+	Prompts string `yaml:"prompts"`
+	Replies string `yaml:"replies"`
+	Model   string `yaml:"model"`
+}
+
+// ConfigPath returns the path of the user config file: $CHATPROXY_CONFIG
+// if it is set, or else config.yaml in the XDG config directory.
+func ConfigPath() (string, error) {
+	if path, ok := os.LookupEnv("CHATPROXY_CONFIG"); ok {
+		return path, nil
+	}
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// FindProjectConfig searches dir and its parents for a project config
+// file, returning its path if one is found.
+func FindProjectConfig(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads the user config file, then the project config file for
+// the current directory, whose settings take precedence. Missing files are
+// not an error, and give an empty config. If CHATPROXY_CONFIG_DATA is set,
+// its YAML is the config instead, and no files are read, for containers
+// configured by their environment; in stateless mode, without it, the
+// config is empty.
+func LoadConfig() (Config, error) {
+	if data, ok := os.LookupEnv("CHATPROXY_CONFIG_DATA"); ok {
+		cfg, err := ParseConfig([]byte(data))
+		if err != nil {
+			return Config{}, fmt.Errorf("reading CHATPROXY_CONFIG_DATA: %w", err)
+		}
+		return cfg, nil
+	}
+	if stateless() {
+		return Config{}, nil
+	}
+	path, err := ConfigPath()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return cfg, nil
+	}
+	if path, ok := FindProjectConfig(wd); ok {
+		project, err := loadConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		if project.Profiles != nil {
+			return Config{}, fmt.Errorf("reading config %s: profiles can only be defined in the user config", path)
+		}
+		if project.Gateway.Keys != nil || project.Gateway.RateLimit != 0 {
+			return Config{}, fmt.Errorf("reading config %s: gateway can only be set in the user config", path)
+		}
+		if project.Digest.Sources != nil || project.Digest.SMTP.Addr != "" {
+			return Config{}, fmt.Errorf("reading config %s: digest can only be set in the user config", path)
+		}
+		if project.MCPServers != nil {
+			return Config{}, fmt.Errorf("reading config %s: mcp_servers can only be set in the user config", path)
+		}
+		if project.Webhooks != nil {
+			return Config{}, fmt.Errorf("reading config %s: webhooks can only be set in the user config", path)
+		}
+		if project.Quiz.ScoresPath != "" {
+			return Config{}, fmt.Errorf("reading config %s: quiz.scores_path can only be set in the user config", path)
+		}
+		if project.sendsKeyElsewhere() {
+			return Config{}, fmt.Errorf("reading config %s: base_url can only be set in the user config", path)
+		}
+		cfg.override(project)
+	}
+	return cfg, nil
+}
+
+func loadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// sendsKeyElsewhere reports whether the config changes the base URL, which
+// the API key is sent to.
+func (c Config) sendsKeyElsewhere() bool {
+	if c.BaseURL != "" {
+		return true
+	}
+	for _, s := range c.Commands {
+		if s.BaseURL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// override replaces the settings in c with those set in o. Purposes and
+// command settings are merged, ignore and redaction patterns and PII names
+// are combined, and a checklist replaces the existing one.
+func (c *Config) override(o Config) {
+	c.Settings.override(o.Settings)
+	for name, s := range o.Commands {
+		if c.Commands == nil {
+			c.Commands = map[string]Settings{}
+		}
+		merged := c.Commands[name]
+		merged.override(s)
+		c.Commands[name] = merged
+	}
+	for name, purpose := range o.Purposes {
+		if c.Purposes == nil {
+			c.Purposes = map[string]string{}
+		}
+		c.Purposes[name] = purpose
+	}
+	c.Ignore = append(c.Ignore, o.Ignore...)
+	if o.Checklist != nil {
+		c.Checklist = o.Checklist
+	}
+	c.Commit.override(o.Commit)
+	c.Release.override(o.Release)
+	c.Branch.override(o.Branch)
+	c.Quiz.override(o.Quiz)
+}
+
+func (s *Settings) override(o Settings) {
+	if o.Model != "" {
+		s.Model = o.Model
+	}
+	if o.Streaming != nil {
+		s.Streaming = o.Streaming
+	}
+	if o.Temperature != nil {
+		s.Temperature = o.Temperature
+	}
+	if o.Provider != "" {
+		s.Provider = o.Provider
+	}
+	if o.Transcript.Enabled != nil {
+		s.Transcript.Enabled = o.Transcript.Enabled
+	}
+	if o.Transcript.Dir != "" {
+		s.Transcript.Dir = o.Transcript.Dir
+	}
+	if o.Theme != "" {
+		s.Theme = o.Theme
+	}
+	if o.KeyBindings != "" {
+		s.KeyBindings = o.KeyBindings
+	}
+	if o.ExitKeywords != nil {
+		s.ExitKeywords = o.ExitKeywords
+	}
+	if o.ConfirmExit != nil {
+		s.ConfirmExit = o.ConfirmExit
+	}
+	if o.IdleTimeout != nil {
+		s.IdleTimeout = o.IdleTimeout
+	}
+	if o.Profile != "" {
+		s.Profile = o.Profile
+	}
+	if o.ConfirmCost != nil {
+		s.ConfirmCost = o.ConfirmCost
+	}
+	if o.ConfirmTokens != nil {
+		s.ConfirmTokens = o.ConfirmTokens
+	}
+	if o.BaseURL != "" {
+		s.BaseURL = o.BaseURL
+	}
+	if o.Timeout != nil {
+		s.Timeout = o.Timeout
+	}
+	if o.Fallback != nil {
+		s.Fallback = o.Fallback
+	}
+	s.Redact.Patterns = append(s.Redact.Patterns, o.Redact.Patterns...)
+	if o.Redact.Prompts != nil {
+		s.Redact.Prompts = o.Redact.Prompts
+	}
+	if o.PII.Mask != nil {
+		s.PII.Mask = o.PII.Mask
+	}
+	s.PII.Names = append(s.PII.Names, o.PII.Names...)
+	if o.Moderation.Prompts != "" {
+		s.Moderation.Prompts = o.Moderation.Prompts
+	}
+	if o.Moderation.Replies != "" {
+		s.Moderation.Replies = o.Moderation.Replies
+	}
+	if o.Moderation.Model != "" {
+		s.Moderation.Model = o.Moderation.Model
+	}
+}
+
+// Ignored reports whether file, found while loading the directory root,
+// matches one of the config's ignore patterns. Patterns use path.Match
+// syntax and are matched against both the path relative to root and its
+// base name; a trailing /** matches everything beneath a directory.
+func (c Config) Ignored(root, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		rel = file
+	}
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(file)
+	for _, pattern := range c.Ignore {
+		pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "/**"), "/")
+		for _, name := range []string{rel, base} {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseConfig parses and validates a config file. Unknown keys are
+// rejected, so that typos don't silently go unapplied.
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(&cfg)
+	if err != nil && err != io.EOF {
+		return Config{}, err
+	}
+	err = cfg.Settings.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	for name, s := range cfg.Commands {
+		err := s.validate()
+		if err != nil {
+			return Config{}, fmt.Errorf("commands.%s: %w", name, err)
+		}
+	}
+	err = cfg.Commit.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	err = cfg.Branch.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	err = cfg.Quiz.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	err = cfg.Gateway.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	err = cfg.Digest.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	for _, webhook := range cfg.Webhooks {
+		err := validateWebhook(webhook)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	for name, server := range cfg.MCPServers {
+		err := server.validate(name)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	for name, p := range cfg.Profiles {
+		if p.Provider != "" && !containsString(Providers, p.Provider) {
+			return Config{}, fmt.Errorf("profiles.%s: unknown provider %q, expected one of %s", name, p.Provider, strings.Join(Providers, ", "))
+		}
+	}
+	return cfg, nil
+}
+
+func (s Settings) validate() error {
+	if s.Theme != "" {
+		_, err := ParseTheme(s.Theme)
+		if err != nil {
+			return err
+		}
+	}
+	if s.KeyBindings != "" {
+		_, err := ParseKeyBindings(s.KeyBindings)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Provider != "" && !containsString(Providers, s.Provider) {
+		return fmt.Errorf("unknown provider %q, expected one of %s", s.Provider, strings.Join(Providers, ", "))
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		return fmt.Errorf("temperature %g is outside the range 0 to 2", *s.Temperature)
+	}
+	if s.ConfirmCost != nil && *s.ConfirmCost < 0 {
+		return fmt.Errorf("confirm_cost %g must not be negative", *s.ConfirmCost)
+	}
+	if s.ConfirmTokens != nil && *s.ConfirmTokens < 0 {
+		return fmt.Errorf("confirm_tokens %d must not be negative", *s.ConfirmTokens)
+	}
+	if s.Timeout != nil && *s.Timeout < 0 {
+		return fmt.Errorf("timeout %s must not be negative", *s.Timeout)
+	}
+	if s.IdleTimeout != nil && *s.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout %s must not be negative", *s.IdleTimeout)
+	}
+	if s.BaseURL != "" {
+		err := validateBaseURL(s.BaseURL)
+		if err != nil {
+			return fmt.Errorf("base_url: %w", err)
+		}
+	}
+	if _, err := compileRedactPatterns(s.Redact.Patterns); err != nil {
+		return fmt.Errorf("redact.patterns: %w", err)
+	}
+	for key, action := range map[string]string{"prompts": s.Moderation.Prompts, "replies": s.Moderation.Replies} {
+		if action != "" && !containsString(ModerationActions, action) {
+			return fmt.Errorf("moderation.%s: unknown action %q, expected one of %s", key, action, strings.Join(ModerationActions, ", "))
+		}
+	}
+	return nil
+}
+
+// validateBaseURL checks that s is an absolute http or https URL.
+func validateBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an http or https URL", s)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the client defaults given in the settings. The settings have
+// already been validated, so parsing can't fail.
+func (s Settings) apply(c *ChatGPTClient) {
+	if s.Model != "" {
+		c.model = s.Model
+	}
+	if s.Streaming != nil {
+		c.streaming = *s.Streaming
+	}
+	if s.Temperature != nil {
+		c.temperature = *s.Temperature
+	}
+	if s.Provider != "" {
+		// A provider given without a model uses its default model, so that
+		// a command can switch provider with one setting.
+		model := c.model
+		c.setProvider(s.Provider)
+		if s.Model != "" {
+			c.model = model
+		}
+	}
+	if s.Transcript.Enabled != nil {
+		c.transcriptDisabled = !*s.Transcript.Enabled
+	}
+	if s.Transcript.Dir != "" {
+		c.transcriptDir = expandHome(s.Transcript.Dir)
+	}
+	if s.Theme != "" {
+		c.theme, _ = ParseTheme(s.Theme)
+	}
+	if s.KeyBindings != "" {
+		c.keyBindings, _ = ParseKeyBindings(s.KeyBindings)
+	}
+	if s.ExitKeywords != nil {
+		c.exitKeywords = s.ExitKeywords
+	}
+	if s.ConfirmExit != nil {
+		c.confirmOnExit = *s.ConfirmExit
+	}
+	if s.IdleTimeout != nil {
+		c.idleTimeout = *s.IdleTimeout
+	}
+	if s.Profile != "" {
+		c.profile = s.Profile
+	}
+	if s.ConfirmCost != nil {
+		c.confirmCost = *s.ConfirmCost
+	}
+	if s.ConfirmTokens != nil {
+		c.confirmTokens = *s.ConfirmTokens
+	}
+	if s.BaseURL != "" {
+		c.baseURL = s.BaseURL
+	}
+	if s.Timeout != nil {
+		c.timeout = *s.Timeout
+	}
+	if s.Fallback != nil {
+		c.setFallbacks(s.Fallback)
+	}
+	if s.Redact.Patterns != nil {
+		c.redactPatterns, _ = compileRedactPatterns(s.Redact.Patterns)
+	}
+	if s.Redact.Prompts != nil {
+		c.redactPrompts = *s.Redact.Prompts
+	}
+	if s.PII.Mask != nil {
+		c.maskPII = *s.PII.Mask
+	}
+	if s.PII.Names != nil {
+		c.piiNames = s.PII.Names
+	}
+	if s.Moderation.Prompts != "" {
+		c.moderatePrompts = s.Moderation.Prompts
+	}
+	if s.Moderation.Replies != "" {
+		c.moderateReplies = s.Moderation.Replies
+	}
+	if s.Moderation.Model != "" {
+		c.moderationModel = s.Moderation.Model
+	}
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok || (rest != "" && rest[0] != '/' && rest[0] != filepath.Separator) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
+
+// applyDefaults applies the settings from the config file, then those for
+// the client's command, and finally those from environment variables.
+func (c *ChatGPTClient) applyDefaults() error {
+	c.config.Settings.apply(c)
+	quiz := c.config.Quiz
+	quiz.ScoresPath = expandHome(quiz.ScoresPath)
+	c.quiz.override(quiz)
+	if s, ok := c.config.Commands[c.command]; ok {
+		s.apply(c)
+	}
+	env, err := envSettings()
+	if err != nil {
+		return err
+	}
+	env.apply(c)
+	if spec, ok := os.LookupEnv("CHATPROXY_THEME"); ok {
+		theme, err := ParseTheme(spec)
+		if err != nil {
+			return err
+		}
+		c.theme = theme
+	}
+	if name, ok := os.LookupEnv("CHATPROXY_PROFILE"); ok {
+		c.profile = name
+	}
+	if baseURL, ok := os.LookupEnv("OPENAI_BASE_URL"); ok {
+		err := validateBaseURL(baseURL)
+		if err != nil {
+			return fmt.Errorf("OPENAI_BASE_URL: %w", err)
+		}
+		c.baseURL = baseURL
+	}
+	if name, ok := os.LookupEnv("CHATPROXY_KEYBINDINGS"); ok {
+		kb, err := ParseKeyBindings(name)
+		if err != nil {
+			return err
+		}
+		c.keyBindings = kb
+	}
+	return nil
+}
+
+// envSettings returns the settings given by environment variables, which
+// override the config: CHATPROXY_MODEL, CHATPROXY_STREAMING,
+// CHATPROXY_TEMPERATURE, CHATPROXY_PROVIDER, CHATPROXY_TIMEOUT,
+// CHATPROXY_CONFIRM_COST and CHATPROXY_CONFIRM_TOKENS.
+func envSettings() (Settings, error) {
+	var s Settings
+	s.Model = os.Getenv("CHATPROXY_MODEL")
+	s.Provider = os.Getenv("CHATPROXY_PROVIDER")
+	if v, ok := os.LookupEnv("CHATPROXY_STREAMING"); ok {
+		streaming, err := strconv.ParseBool(v)
+		if err != nil {
+			return s, fmt.Errorf("CHATPROXY_STREAMING %q must be true or false", v)
+		}
+		s.Streaming = &streaming
+	}
+	if v, ok := os.LookupEnv("CHATPROXY_TEMPERATURE"); ok {
+		temperature, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return s, fmt.Errorf("CHATPROXY_TEMPERATURE %q must be a number", v)
+		}
+		t := float32(temperature)
+		s.Temperature = &t
+	}
+	if v, ok := os.LookupEnv("CHATPROXY_TIMEOUT"); ok {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return s, fmt.Errorf("CHATPROXY_TIMEOUT %q must be a duration, such as 2m", v)
+		}
+		s.Timeout = &timeout
+	}
+	if v, ok := os.LookupEnv("CHATPROXY_CONFIRM_COST"); ok {
+		cost, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return s, fmt.Errorf("CHATPROXY_CONFIRM_COST %q must be a number", v)
+		}
+		s.ConfirmCost = &cost
+	}
+	if v, ok := os.LookupEnv("CHATPROXY_CONFIRM_TOKENS"); ok {
+		tokens, err := strconv.Atoi(v)
+		if err != nil {
+			return s, fmt.Errorf("CHATPROXY_CONFIRM_TOKENS %q must be a whole number", v)
+		}
+		s.ConfirmTokens = &tokens
+	}
+	err := s.validate()
+	if err != nil {
+		return s, fmt.Errorf("environment: %w", err)
+	}
+	return s, nil
+}
+
+// WithConfig replaces the settings read from the user config file. Options
+// before it are overridden by the config, and options after it override it.
+func WithConfig(cfg Config) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.config = cfg
+		c.applyDefaults()
+		return c
+	}
+}
+
+// WithCommand names the command the client is being used by, applying the
+// config file with any overrides for that command. It should come after
+// the command's own defaults, which the config overrides, and before
+// options given on the command line, which override the config.
+func WithCommand(name string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.command = name
+		c.applyDefaults()
+		return c
+	}
+}
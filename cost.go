@@ -0,0 +1,66 @@
+package chatproxy
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// DefaultConfirmCost is the estimated prompt cost, in US dollars, above which
+// the client asks before sending a prompt.
+const DefaultConfirmCost = 0.50
+
+// ErrCostDeclined is returned when the user declines to send an expensive
+// prompt.
+var ErrCostDeclined = errors.New("prompt not sent: cost not confirmed")
+
+// WithConfirmAbove makes the client ask before sending a prompt estimated to
+// cost more than cost US dollars, or to use more than tokens prompt tokens.
+// Zero disables either limit.
+func WithConfirmAbove(cost float64, tokens int) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.confirmCost = cost
+		c.confirmTokens = tokens
+		return c
+	}
+}
+
+// WithAssumeYes sends expensive prompts without asking, for use in scripts.
+func WithAssumeYes(yes bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.assumeYes = yes
+		return c
+	}
+}
+
+// unattended refuses prompts over the cost or token limit rather than
+// asking about them, for commands with no one at the terminal to confirm
+// them, such as servers, bots and CI jobs.
+func unattended() ClientOption {
+	return WithInput(strings.NewReader(""))
+}
+
+// confirmPromptCost asks the user whether to send a prompt that is over the
+// client's cost or token limit. If they decline, the last message, such as
+// a directory loaded into the chat, is rolled back so that it isn't sent
+// with the next prompt either.
+func (c *ChatGPTClient) confirmPromptCost(req openai.ChatCompletionRequest) error {
+	if c.assumeYes {
+		return nil
+	}
+	usage := EstimateUsage(req.Model, promptText(req), "")
+	overCost := c.confirmCost > 0 && usage.Cost > c.confirmCost
+	overTokens := c.confirmTokens > 0 && usage.PromptTokens > c.confirmTokens
+	if !overCost && !overTokens {
+		return nil
+	}
+	c.theme.System.Fprintf(c.errorStream, "This prompt is about %s tokens and will cost ~$%.2f. Continue? (y/N) ",
+		formatThousands(usage.PromptTokens), usage.Cost)
+	answer, err := c.readLine()
+	if err == nil && strings.ToLower(strings.TrimSpace(answer)) == "y" {
+		return nil
+	}
+	c.RollbackLastMessage()
+	return ErrCostDeclined
+}
@@ -0,0 +1,224 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deck is a set of flashcards saved for review, with each card's spaced
+// repetition schedule.
+type Deck struct {
+	Name   string       `json:"name"`
+	Source string       `json:"source"`
+	Cards  []ReviewCard `json:"cards"`
+}
+
+// ReviewCard is a flashcard with its schedule under the SM-2 algorithm.
+type ReviewCard struct {
+	Flashcard
+	EaseFactor  float64   `json:"ease_factor"`
+	Interval    int       `json:"interval_days"`
+	Repetitions int       `json:"repetitions"`
+	Due         time.Time `json:"due"`
+}
+
+// DefaultEaseFactor is the SM-2 ease factor of a new card.
+const DefaultEaseFactor = 2.5
+
+// Add adds cards to the deck, due for review now. Cards whose question is
+// already in the deck are skipped, keeping their schedule.
+func (d *Deck) Add(cards []Flashcard, now time.Time) {
+	known := map[string]bool{}
+	for _, card := range d.Cards {
+		known[card.Question] = true
+	}
+	for _, card := range cards {
+		if known[card.Question] {
+			continue
+		}
+		known[card.Question] = true
+		d.Cards = append(d.Cards, ReviewCard{Flashcard: card, EaseFactor: DefaultEaseFactor, Due: now})
+	}
+}
+
+// Due returns the indexes of the cards due for review at now.
+func (d Deck) Due(now time.Time) []int {
+	var due []int
+	for i, card := range d.Cards {
+		if !card.Due.After(now) {
+			due = append(due, i)
+		}
+	}
+	return due
+}
+
+// Schedule reschedules the card after a review, using the SM-2 algorithm.
+// Quality grades the answer from 0, a complete blackout, to 5, a perfect
+// response; below 3 the card starts again from a one day interval.
+func (r *ReviewCard) Schedule(quality int, now time.Time) {
+	if quality < 0 {
+		quality = 0
+	}
+	if quality > 5 {
+		quality = 5
+	}
+	if quality >= 3 {
+		switch r.Repetitions {
+		case 0:
+			r.Interval = 1
+		case 1:
+			r.Interval = 6
+		default:
+			r.Interval = int(math.Round(float64(r.Interval) * r.EaseFactor))
+		}
+		r.Repetitions++
+	} else {
+		r.Repetitions = 0
+		r.Interval = 1
+	}
+	q := float64(5 - quality)
+	r.EaseFactor += 0.1 - q*(0.08+q*0.02)
+	if r.EaseFactor < 1.3 {
+		r.EaseFactor = 1.3
+	}
+	r.Due = now.AddDate(0, 0, r.Interval)
+}
+
+// DeckName returns the name a deck generated from the file or URL at path
+// is saved under.
+func DeckName(path string) string {
+	if u, err := url.Parse(path); err == nil && u.Host != "" {
+		path = u.Host + u.Path
+	}
+	name := strings.TrimSuffix(filepath.Base(strings.TrimSuffix(path, "/")), filepath.Ext(path))
+	name = deckNameUnsafe.ReplaceAllString(name, "-")
+	if name == "" || name == "." || name == "-" {
+		return "deck"
+	}
+	return name
+}
+
+var deckNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func deckPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid deck name %q", name)
+	}
+	dir, err := stateFileDir("decks")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// LoadDeck loads the named deck. A deck that hasn't been saved is empty.
+func LoadDeck(name string) (Deck, error) {
+	path, err := deckPath(name)
+	if err != nil {
+		return Deck{}, err
+	}
+	data, err := readState(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Deck{Name: name}, nil
+	}
+	if err != nil {
+		return Deck{}, err
+	}
+	var deck Deck
+	err = json.Unmarshal(data, &deck)
+	if err != nil {
+		return Deck{}, fmt.Errorf("reading deck %s: %w", name, err)
+	}
+	return deck, nil
+}
+
+// SaveDeck saves the deck in the application's state directory.
+func SaveDeck(deck Deck) error {
+	path, err := deckPath(deck.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(deck, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeState(path, data)
+}
+
+// DeckNames returns the names of the saved decks.
+func DeckNames() ([]string, error) {
+	dir, err := stateFileDir("decks")
+	if err != nil {
+		return nil, err
+	}
+	return stateNames(dir, ".json")
+}
+
+var gradeScore = regexp.MustCompile(`Grade:\s*(\d)\s*/\s*5`)
+
+// GradeAnswer asks the model to grade an answer to a card from 0 to 5, the
+// scale used by SM-2, returning the grade and the model's feedback.
+func (c *ChatGPTClient) GradeAnswer(card Flashcard, answer string) (int, string, error) {
+	c.chatHistory = []ChatMessage{}
+	c.SetPurpose(`Please grade the user's answer to a flashcard against the expected answer.
+	Grade from 0 to 5: 0 is no answer or completely wrong, 3 is correct but with serious difficulty or missing detail, and 5 is a perfect answer.
+	Judge the meaning, not the wording.
+	Always reply in the form "Grade: N/5 - feedback", with one sentence of feedback.`)
+	c.RecordMessage(RoleUser, fmt.Sprintf("Question: %s\nExpected answer: %s\nMy answer: %s", card.Question, card.Answer, answer))
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return 0, "", err
+	}
+	m := gradeScore.FindStringSubmatch(reply)
+	if m == nil {
+		return 0, reply, fmt.Errorf("couldn't find a grade in %q", reply)
+	}
+	grade, _ := strconv.Atoi(m[1])
+	return grade, reply, nil
+}
+
+// Review quizzes the user on the deck's due cards, grading each answer with
+// the model and rescheduling the card. The deck is saved after every card,
+// so progress is kept if the review is abandoned part way. An empty answer
+// counts as not knowing it. It returns the number of cards reviewed.
+func (c *ChatGPTClient) Review(deck *Deck, now time.Time) (int, error) {
+	reviewed := 0
+	for _, i := range deck.Due(now) {
+		card := &deck.Cards[i]
+		c.Prompt("Q: " + card.Question)
+		answer, err := c.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return reviewed, err
+		}
+		grade := 0
+		if strings.TrimSpace(answer) != "" {
+			var feedback string
+			grade, feedback, err = c.GradeAnswer(card.Flashcard, answer)
+			if err != nil {
+				return reviewed, err
+			}
+			c.theme.Assistant.Fprintln(c.output, feedback)
+		}
+		c.theme.System.Fprintln(c.output, "A: "+card.Answer)
+		card.Schedule(grade, now)
+		reviewed++
+		err = SaveDeck(*deck)
+		if err != nil {
+			return reviewed, err
+		}
+	}
+	return reviewed, nil
+}
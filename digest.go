@@ -0,0 +1,229 @@
+package chatproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DigestConfig sets what the digest command summarises, how often, and who
+// it emails the digest to, in the user config:
+//
+//	digest:
+//	  every: 24h
+//	  sources:
+//	    - https://go.dev/blog/feed.atom
+//	    - https://status.example.com
+//	    - ~/notes/inbox
+//	  smtp:
+//	    addr: smtp.example.com:587
+//	    username: me@example.com
+//	    from: me@example.com
+//	    to: [me@example.com]
+//
+// Sources are files, directories or URLs, as for --context. The SMTP
+// password is read from CHATPROXY_SMTP_PASSWORD, rather than the config.
+type DigestConfig struct {
+	Sources []string      `yaml:"sources"`
+	Every   time.Duration `yaml:"every"`
+	SMTP    SMTPConfig    `yaml:"smtp"`
+}
+
+// SMTPConfig is the mail server digests are sent through, and who they are
+// sent to.
+type SMTPConfig struct {
+	Addr     string   `yaml:"addr"`
+	Username string   `yaml:"username"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// DefaultDigestEvery is how often a digest is sent if the config doesn't
+// say.
+const DefaultDigestEvery = 24 * time.Hour
+
+func (cfg DigestConfig) validate() error {
+	if cfg.Every < 0 {
+		return fmt.Errorf("digest.every %s must not be negative", cfg.Every)
+	}
+	if cfg.SMTP.Addr != "" {
+		if _, _, err := net.SplitHostPort(cfg.SMTP.Addr); err != nil {
+			return fmt.Errorf("digest.smtp.addr %q must be a host and port, such as smtp.example.com:587", cfg.SMTP.Addr)
+		}
+		if cfg.SMTP.From == "" || len(cfg.SMTP.To) == 0 {
+			return errors.New("digest.smtp needs from and to addresses")
+		}
+	}
+	return nil
+}
+
+// DigestItem is the summary of what changed in a source.
+type DigestItem struct {
+	Source  string `json:"source"`
+	New     bool   `json:"new"`
+	Summary string `json:"summary"`
+}
+
+// DigestReport is a summary of what changed in the digest's sources since
+// the last one.
+type DigestReport struct {
+	Date  time.Time    `json:"date"`
+	Items []DigestItem `json:"items"`
+}
+
+// DigestState is what the digest command remembers of each source between
+// runs, so that a digest only covers what changed.
+type DigestState struct {
+	LastSent time.Time                    `json:"last_sent"`
+	Sources  map[string]DigestSourceState `json:"sources"`
+}
+
+// DigestSourceState is a source's content as of the last digest.
+type DigestSourceState struct {
+	Hash    string `json:"hash"`
+	Content string `json:"content"`
+}
+
+// DigestStatePath returns the path of the digest command's state, in the
+// application's XDG state directory.
+func DigestStatePath() (string, error) {
+	dir, err := stateFileDir("digest")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// LoadDigestState reads the state at path. A missing file is an empty state.
+func LoadDigestState(path string) (DigestState, error) {
+	state := DigestState{Sources: map[string]DigestSourceState{}}
+	data, err := readState(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return state, fmt.Errorf("reading digest state %s: %w", path, err)
+	}
+	if state.Sources == nil {
+		state.Sources = map[string]DigestSourceState{}
+	}
+	return state, nil
+}
+
+// Save writes the state to path, or in stateless mode, keeps it in memory.
+func (s DigestState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeState(path, data)
+}
+
+// Digest reads each source and summarises what changed in those that did
+// since state was recorded, updating state with their content. A source
+// not in state is summarised as a whole. A source that can't be read is
+// reported in the digest rather than failing it.
+func (c *ChatGPTClient) Digest(sources []string, state *DigestState, now time.Time) (DigestReport, error) {
+	digest := DigestReport{Date: now}
+	for _, source := range sources {
+		content, err := c.GetContent(expandHome(source))
+		if err != nil {
+			if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrCostDeclined) {
+				return DigestReport{}, err
+			}
+			digest.Items = append(digest.Items, DigestItem{Source: source, Summary: "Couldn't read this source: " + err.Error()})
+			continue
+		}
+		sum := sha256.Sum256([]byte(content))
+		hash := hex.EncodeToString(sum[:])
+		previous, seen := state.Sources[source]
+		if seen && previous.Hash == hash {
+			continue
+		}
+		summary, err := c.newConversation().summariseChange(previous.Content, content)
+		if err != nil {
+			return DigestReport{}, fmt.Errorf("summarising %s: %w", source, err)
+		}
+		digest.Items = append(digest.Items, DigestItem{Source: source, New: !seen, Summary: summary})
+		state.Sources[source] = DigestSourceState{Hash: hash, Content: content}
+		c.saveFeedReads()
+	}
+	return digest, nil
+}
+
+// summariseChange summarises what is new in current compared to previous,
+// or all of current if there is no previous version, or the two are too
+// large to compare.
+func (c *ChatGPTClient) summariseChange(previous, current string) (string, error) {
+	if previous == "" || guessTokens(previous)+guessTokens(current) > c.diffBudget() {
+		return c.Summarise(current, SummaryOptions{Length: SummaryBullets})
+	}
+	c.SetPurpose(`Please summarise what is new or changed in the current version of the source provided, compared to the previous version, for a digest someone reads to catch up.
+	Ignore changes that don't matter to a reader, such as timestamps, counters and reordering.
+	Be brief, use Markdown bullet points, and reply with only the summary.`)
+	c.RecordMessage(RoleUser, "Previous version:\n"+previous+"\n\nCurrent version:\n"+current)
+	return c.GetCompletion()
+}
+
+// WriteDigest writes the digest to w in Markdown, with a section for each
+// source that changed.
+func WriteDigest(w io.Writer, d DigestReport) error {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "# Digest for %s\n", d.Date.Format("Monday 2 January 2006"))
+	if len(d.Items) == 0 {
+		text.WriteString("\nNothing has changed since the last digest.\n")
+	}
+	for _, item := range d.Items {
+		fmt.Fprintf(text, "\n## %s", item.Source)
+		if item.New {
+			text.WriteString(" (new)")
+		}
+		fmt.Fprintf(text, "\n\n%s\n", strings.TrimSpace(item.Summary))
+	}
+	_, err := io.WriteString(w, text.String())
+	return err
+}
+
+// digestMessage returns the digest as an email message from and to the
+// addresses in cfg.
+func digestMessage(cfg SMTPConfig, d DigestReport) []byte {
+	body := new(strings.Builder)
+	WriteDigest(body, d)
+	msg := new(strings.Builder)
+	fmt.Fprintf(msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(msg, "Subject: chatproxy digest: %d sources changed\r\n", len(d.Items))
+	fmt.Fprintf(msg, "Date: %s\r\n", d.Date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return []byte(msg.String())
+}
+
+// SendDigest emails the digest through the server in cfg, authenticating
+// with its username and the password in CHATPROXY_SMTP_PASSWORD if it has
+// a username. The connection is upgraded to TLS where the server offers it.
+func SendDigest(cfg SMTPConfig, d DigestReport) error {
+	if cfg.Addr == "" {
+		return errors.New("set digest.smtp in the config to email digests")
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		auth = smtp.PlainAuth("", cfg.Username, os.Getenv("CHATPROXY_SMTP_PASSWORD"), host)
+	}
+	return smtp.SendMail(cfg.Addr, auth, cfg.From, cfg.To, digestMessage(cfg, d))
+}
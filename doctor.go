@@ -0,0 +1,219 @@
+package chatproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Diagnosis is the outcome of one of the doctor command's checks, with a
+// suggested fix if it failed.
+type Diagnosis struct {
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// doctorTimeout limits how long the network and API checks wait.
+const doctorTimeout = 10 * time.Second
+
+// Diagnose checks that chatproxy is set up to work: that the config files
+// are valid, the state directories are writable, an API key is available,
+// the API can be reached through any proxy, and the key can use the model.
+// Checks that depend on an earlier one that failed are skipped. The options
+// are applied to the client used for the API checks.
+func Diagnose(ctx context.Context, opts ...ClientOption) []Diagnosis {
+	cfg, diagnosis := checkConfig()
+	diagnoses := []Diagnosis{diagnosis}
+	if !diagnosis.OK {
+		return diagnoses
+	}
+	transcriptDir := expandHome(cfg.Transcript.Dir)
+	if transcriptDir == "" {
+		transcriptDir = "audit_logs"
+	}
+	opts = append([]ClientOption{WithTranscript(io.Discard), WithOutput(io.Discard, io.Discard)}, opts...)
+	client, err := NewChatGPTClient(opts...)
+	baseURL, hc := cfg.BaseURL, http.DefaultClient
+	if err == nil {
+		baseURL = client.apiBaseURL()
+		if client.httpClient != nil {
+			hc = client.httpClient
+		}
+	}
+	if baseURL == "" {
+		baseURL = specFor(cfg.Provider).baseURL
+	}
+	network := checkNetwork(ctx, hc, strings.TrimSuffix(baseURL, "/"))
+	diagnoses = append(diagnoses,
+		checkWritable("transcripts", transcriptDir),
+		checkWritable("usage log", "usage"),
+		network,
+	)
+	if err != nil {
+		return append(diagnoses, Diagnosis{
+			Check:  "api key",
+			Detail: err.Error(),
+			Fix:    "run 'chatproxy setup', export OPENAI_API_KEY=<your key>, or select a credential profile from the config file with --profile or $CHATPROXY_PROFILE",
+		})
+	}
+	source := "$" + specFor(client.provider).keyEnv
+	if client.profile != "" {
+		source = "profile " + client.profile
+	}
+	diagnoses = append(diagnoses, Diagnosis{Check: "api key", OK: true, Detail: "from " + source})
+	if !network.OK {
+		return diagnoses
+	}
+	return append(diagnoses, client.checkModelAccess(ctx))
+}
+
+func checkConfig() (Config, Diagnosis) {
+	d := Diagnosis{Check: "config"}
+	path, err := ConfigPath()
+	if err != nil {
+		d.Detail = err.Error()
+		d.Fix = "set $HOME, or $CHATPROXY_CONFIG to the path of the config file"
+		return Config{}, d
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		d.Detail = err.Error()
+		d.Fix = "correct the setting named in the error; the README lists the valid settings"
+		return Config{}, d
+	}
+	d.OK = true
+	d.Detail = path
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		d.Detail += " (not created, using defaults)"
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if project, ok := FindProjectConfig(wd); ok {
+			d.Detail += ", " + project
+		}
+	}
+	return cfg, d
+}
+
+// checkWritable checks that a file can be created in dir, which is either
+// an absolute path or the name of a state directory.
+func checkWritable(check, dir string) Diagnosis {
+	d := Diagnosis{Check: check}
+	var err error
+	if !filepath.IsAbs(dir) {
+		dir, err = getStateDir(dir)
+	} else {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err == nil {
+		var f *os.File
+		f, err = os.CreateTemp(dir, ".doctor-*")
+		if err == nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		d.Detail = err.Error()
+		d.Fix = fmt.Sprintf("make %s a directory you can write to, or set another location in the config file", dir)
+		return d
+	}
+	d.OK = true
+	d.Detail = dir
+	return d
+}
+
+// checkNetwork checks that the API at baseURL can be reached with hc,
+// through the proxy set in the environment if there is one. Any HTTP
+// response counts, as the request isn't authorized.
+func checkNetwork(ctx context.Context, hc *http.Client, baseURL string) Diagnosis {
+	d := Diagnosis{Check: "network"}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/models", nil)
+	if err != nil {
+		d.Detail = err.Error()
+		return d
+	}
+	via := ""
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		d.Detail = err.Error()
+		d.Fix = "correct the proxy URL in $HTTPS_PROXY or $HTTP_PROXY"
+		return d
+	}
+	if proxy != nil {
+		via = " via proxy " + proxy.Redacted()
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		d.Detail = fmt.Sprintf("can't reach %s%s: %v", req.URL.Host, via, err)
+		d.Fix = "check your internet connection; behind a corporate proxy, set $HTTPS_PROXY to its URL"
+		if proxy != nil {
+			d.Fix = "check that the proxy is running and allows " + req.URL.Host + ", or correct $HTTPS_PROXY"
+		}
+		return d
+	}
+	resp.Body.Close()
+	d.OK = true
+	d.Detail = "reached " + req.URL.Host + via
+	return d
+}
+
+// checkModelAccess checks that the API accepts the client's key and that
+// the key can use the client's model.
+func (c *ChatGPTClient) checkModelAccess(ctx context.Context) Diagnosis {
+	d := Diagnosis{Check: "model"}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	list, err := c.client.ListModels(ctx)
+	if err != nil {
+		d.Detail = err.Error()
+		var apiErr *openai.APIError
+		if errors.As(err, &apiErr) && apiErr.HTTPStatusCode == http.StatusUnauthorized {
+			d.Fix = "the API key was rejected: check it hasn't been revoked, and that it belongs to the organization in use"
+		}
+		return d
+	}
+	for _, m := range list.Models {
+		if m.ID == c.model {
+			d.OK = true
+			d.Detail = c.model + " is available"
+			return d
+		}
+	}
+	d.Detail = c.model + " isn't available to this API key"
+	d.Fix = "choose a model listed by 'chatproxy models' with --model or the model setting in the config file"
+	return d
+}
+
+// writeDiagnoses prints each diagnosis on a line, with the fix for those
+// that failed indented below.
+func writeDiagnoses(w io.Writer, diagnoses []Diagnosis) error {
+	for _, d := range diagnoses {
+		status := "ok"
+		if !d.OK {
+			status = "FAIL"
+		}
+		_, err := fmt.Fprintf(w, "%-4s  %-11s  %s\n", status, d.Check, d.Detail)
+		if err != nil {
+			return err
+		}
+		if d.Fix != "" {
+			_, err = fmt.Fprintf(w, "%-4s  %-11s  fix: %s\n", "", "", d.Fix)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package chatproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrDryRun is returned in place of a reply when the client is in dry-run
+// mode, as the prompt was printed rather than sent.
+var ErrDryRun = errors.New("dry run: the prompt was not sent")
+
+// WithDryRun makes the client print each prompt, with its estimated tokens
+// and cost, instead of sending it to the API. This helps when debugging how
+// a prompt is constructed.
+func WithDryRun(dryRun bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.dryRun = dryRun
+		return c
+	}
+}
+
+// dryRunCompletion prints the request that would have been sent. Requests
+// made only to validate the prompt, which stop immediately with a known
+// reply, are skipped and get that reply.
+func (c *ChatGPTClient) dryRunCompletion(req openai.ChatCompletionRequest) (string, error) {
+	if len(req.Stop) > 0 {
+		return req.Stop[0], nil
+	}
+	usage := EstimateUsage(req.Model, promptText(req), "")
+	c.theme.System.Fprintf(c.output, "--- dry run: %d messages for %s ---\n", len(req.Messages), req.Model)
+	for _, m := range req.Messages {
+		fmt.Fprintf(c.output, "%s) %s\n", strings.ToUpper(m.Role), m.Content)
+	}
+	c.theme.System.Fprintf(c.output, "--- about %s prompt tokens, ~$%.2f before the reply ---\n",
+		formatThousands(usage.PromptTokens), usage.Cost)
+	return "", ErrDryRun
+}
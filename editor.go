@@ -0,0 +1,205 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// EditorPath is the path the editor server answers JSON-RPC requests on.
+const EditorPath = "/rpc"
+
+// EditorHistory is how many messages of a workspace's session are kept,
+// besides its purpose, so that a long running session fits in the model's
+// context.
+var EditorHistory = 20
+
+// EditorServer answers JSON-RPC 2.0 requests from editor plugins, posted to
+// EditorPath: explain explains a selection, docComment writes a doc comment
+// for a declaration, and tests writes tests for some code. Each workspace
+// has a session of its own, kept between requests, so that a request can
+// refer to the code of the ones before it; reset starts it again.
+type EditorServer struct {
+	client   *ChatGPTClient
+	mu       sync.Mutex
+	sessions map[string]*editorSession
+}
+
+// editorSession is the conversation of one workspace.
+type editorSession struct {
+	mu     sync.Mutex
+	client *ChatGPTClient
+}
+
+// NewEditorServer returns an EditorServer whose sessions have the settings
+// of c.
+func NewEditorServer(c *ChatGPTClient) *EditorServer {
+	return &EditorServer{client: c, sessions: map[string]*editorSession{}}
+}
+
+// EditorRequest is the params of a request to the editor server. Workspace
+// is the root of the project the editor has open, which picks the session;
+// File and Language say where the code is from.
+type EditorRequest struct {
+	Workspace string `json:"workspace"`
+	File      string `json:"file"`
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+}
+
+// editorMethods are the instructions for each method that works on code.
+// Those that write code are asked for a single code block, which is what
+// they reply with.
+var editorMethods = map[string]struct {
+	instruction string
+	code        bool
+}{
+	"explain": {
+		instruction: "Explain what this code does, how it works, and anything surprising about it, briefly and in Markdown.",
+	},
+	"docComment": {
+		instruction: "Write a doc comment for this code, in the style idiomatic for its language and matching any comments around it. Reply with only the comment, in a single code block.",
+		code:        true,
+	},
+	"tests": {
+		instruction: "Write tests for this code, using the test framework idiomatic for its language and covering its edge cases. Reply with only the tests, in a single code block.",
+		code:        true,
+	},
+}
+
+// ServeHTTP answers a JSON-RPC request posted to EditorPath. Requests must
+// be sent as application/json, and not from web pages, which could
+// otherwise reach the server on localhost.
+func (s *EditorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != EditorPath {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "requests must be sent as application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req jsonRPCMessage
+	resp := jsonRPCMessage{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	err := json.NewDecoder(io.LimitReader(r.Body, MaxUploadBytes)).Decode(&req)
+	if err != nil {
+		resp.Error = &jsonRPCError{Code: jsonRPCParseError, Message: err.Error()}
+	} else {
+		if req.ID != nil {
+			resp.ID = req.ID
+		}
+		resp.Result, resp.Error = s.handle(req.Method, req.Params)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handle answers a request, returning its result as JSON.
+func (s *EditorServer) handle(method string, params json.RawMessage) (json.RawMessage, *jsonRPCError) {
+	var req EditorRequest
+	err := json.Unmarshal(params, &req)
+	if err != nil {
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: err.Error()}
+	}
+	if req.Workspace == "" {
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "workspace is required"}
+	}
+	var result any
+	switch m, ok := editorMethods[method]; {
+	case method == "reset":
+		s.mu.Lock()
+		delete(s.sessions, req.Workspace)
+		s.mu.Unlock()
+		result = map[string]any{}
+	case ok:
+		if strings.TrimSpace(req.Code) == "" {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "code is required"}
+		}
+		text, err := s.session(req.Workspace).ask(m.instruction, req)
+		if err != nil {
+			return nil, &jsonRPCError{Code: editorErrorCode(err), Message: err.Error()}
+		}
+		if m.code {
+			if blocks := CodeBlocks(text); len(blocks) > 0 {
+				text = blocks[0].Code
+			}
+		}
+		result = map[string]string{"text": text}
+	default:
+		return nil, &jsonRPCError{Code: jsonRPCMethodNotFound, Message: "method not found: " + method}
+	}
+	data, _ := json.Marshal(result)
+	return data, nil
+}
+
+// session returns the session of a workspace, starting one if it has none.
+func (s *EditorServer) session(workspace string) *editorSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[workspace]
+	if !ok {
+		c := s.client.newConversation()
+		c.SetPurpose(fmt.Sprintf(`You are a programming assistant in the editor of someone working on the project at %s.
+		Each message has an instruction and some code from a file of the project; follow the instruction for that code.
+		Earlier messages are earlier requests from the same project, which may help you understand the code.`, workspace))
+		session = &editorSession{client: c}
+		s.sessions[workspace] = session
+	}
+	return session
+}
+
+// ask sends the instruction and code to the session's conversation,
+// returning the reply. Requests to a workspace are answered one at a time.
+func (e *editorSession) ask(instruction string, req EditorRequest) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.client
+	if extra := len(c.chatHistory) - 1 - EditorHistory; extra > 0 {
+		c.chatHistory = append(c.chatHistory[:1], c.chatHistory[1+extra:]...)
+	}
+	msg := new(strings.Builder)
+	fmt.Fprintf(msg, "%s\n\n", instruction)
+	if req.File != "" {
+		fmt.Fprintf(msg, "From %s:\n", req.File)
+	}
+	fmt.Fprintf(msg, "```%s\n%s\n```", req.Language, strings.TrimRight(req.Code, "\n"))
+	c.RecordMessage(RoleUser, msg.String())
+	reply, err := c.GetCompletion()
+	if err != nil {
+		c.rollbackUnanswered()
+		return "", err
+	}
+	c.RecordMessage(RoleBot, reply)
+	return reply, nil
+}
+
+// editorErrorCode returns the JSON-RPC error code for a failed request, in
+// the range the spec leaves to servers.
+func editorErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return -32001
+	case errors.Is(err, ErrRateLimited):
+		return -32002
+	case errors.Is(err, ErrContextTooLong):
+		return -32003
+	case errors.Is(err, ErrCostDeclined):
+		return -32004
+	}
+	return -32000
+}
@@ -0,0 +1,114 @@
+package chatproxy
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Errors that callers may want to handle, which the CLI tools report with
+// their own exit codes.
+var (
+	// ErrUnauthorized means the API key is missing or was rejected.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited means the API refused the request because of rate
+	// limits or an exhausted quota.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrContextTooLong means the conversation is too long for the model's
+	// context window.
+	ErrContextTooLong = errors.New("conversation is too long for the model's context window")
+	// ErrNoStagedChanges means there are no staged changes to write a
+	// commit message for.
+	ErrNoStagedChanges = errors.New("no files staged for commit")
+	// ErrChecklistFailed means a required checklist criterion failed.
+	ErrChecklistFailed = errors.New("required checklist criteria failed")
+	// ErrGitNotFound means git isn't installed, or isn't on the PATH.
+	ErrGitNotFound = errors.New("git isn't installed, or isn't on the PATH")
+	// ErrNotARepository means the current directory isn't in a git
+	// repository.
+	ErrNotARepository = errors.New("not in a git repository")
+	// ErrNoTerminal means a command needed to ask the user something, but
+	// isn't running in a terminal.
+	ErrNoTerminal = errors.New("not running in a terminal")
+	// ErrModerated means a prompt or reply was blocked because the
+	// moderation endpoint flagged it.
+	ErrModerated = errors.New("blocked by moderation")
+)
+
+// Exit codes of the CLI tools.
+const (
+	ExitOK               = 0
+	ExitError            = 1
+	ExitUsage            = 2
+	ExitUnauthorized     = 3
+	ExitRateLimited      = 4
+	ExitContextTooLong   = 5
+	ExitNoStagedChanges  = 6
+	ExitCostNotConfirmed = 7
+	ExitChecklistFailed  = 8
+	ExitGitNotFound      = 9
+	ExitNotARepository   = 10
+	ExitNoTerminal       = 11
+	ExitModerated        = 12
+)
+
+// ExitCode returns the exit code for err: ExitOK for nil or a dry run, a
+// specific code for the errors above, and ExitError for anything else.
+func ExitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, ErrDryRun), errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, ErrUnauthorized):
+		return ExitUnauthorized
+	case errors.Is(err, ErrRateLimited):
+		return ExitRateLimited
+	case errors.Is(err, ErrContextTooLong):
+		return ExitContextTooLong
+	case errors.Is(err, ErrNoStagedChanges):
+		return ExitNoStagedChanges
+	case errors.Is(err, ErrCostDeclined):
+		return ExitCostNotConfirmed
+	case errors.Is(err, ErrChecklistFailed):
+		return ExitChecklistFailed
+	case errors.Is(err, ErrGitNotFound):
+		return ExitGitNotFound
+	case errors.Is(err, ErrNotARepository):
+		return ExitNotARepository
+	case errors.Is(err, ErrNoTerminal):
+		return ExitNoTerminal
+	case errors.Is(err, ErrModerated):
+		return ExitModerated
+	}
+	return ExitError
+}
+
+// runGit runs a git command, including what git printed in any error, so
+// that failures are reported as more than an exit status. If git can't be
+// found, the error is ErrGitNotFound.
+func runGit(cmd *exec.Cmd) error {
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return ErrGitNotFound
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", strings.Join(cmd.Args, " "), msg)
+		}
+		return fmt.Errorf("%s: %w", strings.Join(cmd.Args, " "), err)
+	}
+	return nil
+}
+
+// exitWith reports err, unless it is a dry run, and returns the exit code
+// for it.
+func (c *ChatGPTClient) exitWith(err error) int {
+	if !errors.Is(err, ErrDryRun) {
+		c.LogErr(err)
+	}
+	return ExitCode(err)
+}
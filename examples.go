@@ -1,63 +1,112 @@
 package chatproxy
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Ask sends a question to the GPT-4 API, aiming to receive a relevant and informed answer.
 // It facilitates user interaction with GPT-4 for knowledge retrieval or problem-solving.
+// Content piped to stdin is sent along with the question, so that
+// git log | ask "what changed this week?" asks about the log. Files,
+// directories and URLs given with --context are sent with it in the same way.
 func Ask(args []string) int {
-	client, err := NewChatGPTClient()
+	flags := newCommandFlags("ask").addOutputFlag()
+	var contextPaths stringList
+	flags.Var(&contextPaths, "context", "load the file, directory or URL at `path` as context for the question; may be repeated")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	opts := []ClientOption{WithCommand("ask"), WithMarkdown(!flags.plain && stdoutIsTerminal())}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return ExitCode(err)
 	}
-	if len(args) == 1 {
+	out := client.resultWriter(flags.output)
+	content, piped, err := client.PipedInput()
+	if err != nil {
+		return client.exitWith(err)
+	}
+	question := strings.Join(args, " ")
+	if piped && question == "" {
+		question, piped = content, false
+	}
+	if question == "" {
 		client.LogErr(fmt.Errorf("must ask a question"))
-		return 1
+		return ExitUsage
+	}
+	var sources []string
+	if piped {
+		sources = append(sources, content)
+	}
+	for _, path := range contextPaths {
+		msg, err := client.GetContent(path)
+		if err != nil {
+			return client.exitWith(fmt.Errorf("%s: %w", path, err))
+		}
+		sources = append(sources, msg)
+	}
+	var answer string
+	if len(sources) > 0 {
+		answer, err = client.AskAbout(strings.Join(sources, "\n"), question)
+	} else {
+		answer, err = client.Ask(question)
 	}
-	question := strings.Join(args[1:], " ")
-	answer, err := client.Ask(question)
 	if err != nil {
-		client.LogErr(err)
-		return 1
+		return client.exitWith(err)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(answer, contextPaths...))
 	}
-	client.LogOut(answer)
+	client.LogReply(answer)
 	if client.TranscriptPath() != "" {
-		fmt.Fprintln(client.output, "Transcript available at ", client.TranscriptPath())
+		client.progressf("Transcript available at %s\n", client.TranscriptPath())
 	}
 	return 0
 
 }
 
 func BotField(args []string) int {
-	c, err := NewChatGPTClient()
+	flags := newCommandFlags("botfield")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	c, err := newCommandClient(append([]ClientOption{WithCommand("botfield")}, flags.options()...)...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return ExitCode(err)
 	}
 	c.output = os.Stdout
 	c.errorStream = os.Stderr
-	if len(args) == 1 {
+	if len(args) == 0 {
 		c.LogErr(fmt.Errorf("must ask a question"))
-		return 1
+		return ExitUsage
 	}
 	content, err := c.GetContent("https://go.dev/ref/spec")
 	if err != nil {
-		c.LogErr(err)
-		return 1
+		return c.exitWith(err)
 	}
 	r := strings.NewReader(content)
 	c.CreateEmbeddings("GO SPECIFICATION", r)
-	question := strings.Join(args[1:], " ")
+	question := strings.Join(args, " ")
 	similarities, err := c.Relevant(question)
 	if err != nil {
-		c.LogErr(err)
-		return 1
+		return c.exitWith(err)
 	}
 	top := similarities.Top(3)
 	c.RecordMessage(RoleUser, `What follows are some snippets of the latest Golang Specification
@@ -67,8 +116,7 @@ func BotField(args []string) int {
 	}
 	msg, err := c.Ask(question)
 	if err != nil {
-		c.LogErr(err)
-		return 1
+		return c.exitWith(err)
 	}
 	c.LogOut(msg)
 	return 0
@@ -76,23 +124,92 @@ func BotField(args []string) int {
 
 // Card generates a set of flashcards from a given file or URL, aiming to enhance learning by summarizing important concepts.
 // It uses GPT-4 for extracting key information in a compact and easy-to-review format.
+// The cards can be exported with --format for import into Anki or other tools,
+// and are saved to a deck that "card review" quizzes the user on, scheduling
+// each card with spaced repetition.
 func Card(args []string) int {
-	client, err := NewChatGPTClient()
+	flags := newCommandFlags("card").addOutputFlag()
+	format := flags.String("format", CardFormatText, "format to export the cards in: "+strings.Join(CardFormats, ", "))
+	deckName := flags.String("deck", "", "deck to save the cards to for review; defaults to the name of the source")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if !containsString(CardFormats, *format) {
+		fmt.Fprintf(os.Stderr, "unknown card format %q, expected one of %s\n", *format, strings.Join(CardFormats, ", "))
+		return ExitUsage
+	}
+	client, err := newCommandClient(append([]ClientOption{WithCommand("card")}, flags.options()...)...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return ExitCode(err)
 	}
-	if len(args) == 1 {
+	out := client.resultWriter(flags.output)
+	if len(args) > 0 && args[0] == "review" {
+		return reviewDecks(client, args[1:])
+	}
+	if len(args) == 0 {
 		client.LogErr(fmt.Errorf("must ask a question"))
-		return 1
+		return ExitUsage
 	}
-	path := strings.Join(args[1:], " ")
+	path := strings.Join(args, " ")
 	cards, err := client.Card(path)
 	if err != nil {
-		client.LogErr(err)
-		return 1
+		return client.exitWith(err)
+	}
+	if *deckName == "" {
+		*deckName = DeckName(path)
+	}
+	deck, err := LoadDeck(*deckName)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	deck.Source = path
+	deck.Add(cards, time.Now())
+	err = SaveDeck(deck)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	client.progressf("Saved to deck %s; review it with: card review %s\n", deck.Name, deck.Name)
+	if flags.output == OutputJSON {
+		r := client.result("", path)
+		r.Cards = cards
+		return client.printResult(out, r)
+	}
+	err = WriteFlashcards(client.output, cards, *format)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// reviewDecks quizzes the user on the cards due in the named decks, or in
+// every deck if none are named.
+func reviewDecks(client *ChatGPTClient, names []string) int {
+	if len(names) == 0 {
+		var err error
+		names, err = DeckNames()
+		if err != nil {
+			return client.exitWith(err)
+		}
 	}
-	client.LogOut(cards)
+	now := time.Now()
+	total := 0
+	for _, name := range names {
+		deck, err := LoadDeck(name)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		if len(deck.Cards) == 0 {
+			return client.exitWith(fmt.Errorf("no cards in deck %s", name))
+		}
+		reviewed, err := client.Review(&deck, now)
+		total += reviewed
+		if err != nil {
+			return client.exitWith(err)
+		}
+	}
+	client.LogOut(fmt.Sprintf("Reviewed %d cards", total))
 	return 0
 }
 
@@ -100,12 +217,55 @@ func Card(args []string) int {
 // enables interaction between user and the chat proxy.
 // It orchestrates the entire conversational experience
 // with the purpose of assisting the user in various tasks.
-func Chat() int {
-	client, err := NewChatGPTClient(WithStreaming(true))
+func Chat(args []string) int {
+	flags := newCommandFlags("chat")
+	record := flags.String("record", "", "record the session to this file in asciicast v2 format")
+	agent := flags.Bool("agent", false, "let the assistant propose shell commands, run once you confirm each one")
+	idleTimeout := flags.Duration("idle-timeout", 0, "save the session and exit after no input for this long, such as 30m (default from the config)")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if *idleTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "--idle-timeout must not be negative")
+		return ExitUsage
+	}
+	opts := []ClientOption{
+		WithStreaming(true),
+		WithWatch(true),
+		WithLineEditing(true),
+		WithMarkdown(!flags.plain && stdoutIsTerminal()),
+		WithAgent(*agent),
+		WithCommand("chat"),
+	}
+	opts = append(opts, flags.options()...)
+	if *idleTimeout > 0 {
+		opts = append(opts, WithIdleTimeout(*idleTimeout))
+	}
+	if *record != "" {
+		cast, err := os.Create(*record)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitCode(err)
+		}
+		defer cast.Close()
+		opts = append(opts, WithRecording(cast))
+	}
+	client, err := newCommandClient(opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	servers, err := startMCPServers(client.config.MCPServers)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return ExitCode(err)
 	}
+	for _, server := range servers {
+		defer server.Close()
+		client.progressf("Connected to MCP server %s: %s\n", server.Name, strings.Join(server.Tools(), ", "))
+	}
+	WithMCP(servers...)(client)
 	client.Chat()
 
 	return 0
@@ -113,62 +273,1473 @@ func Chat() int {
 
 // Commit analyzes staged Git files, parsing the diff, and generates a meaningful commit message.
 // It aims to streamline the process of creating accurate and informative commit descriptions for better version control.
-func Commit() int {
-	client, err := NewChatGPTClient()
+// Arguments after -- are passed on to git commit. Without a terminal to
+// ask on, it needs --yes to commit without asking, or --print to only print
+// the message.
+func Commit(args []string) int {
+	flags := newCommandFlags("commit").addOutputFlag()
+	format := flags.String("format", "", "commit message `format`: plain or conventional (default from the config, or plain)")
+	amend := flags.Bool("amend", false, "revise the HEAD commit's message to cover the staged changes, and amend the commit")
+	printOnly := flags.Bool("print", false, "print the message without committing")
+	gitArgs, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if *format != "" && !containsString(CommitFormats, *format) {
+		fmt.Fprintf(os.Stderr, "unknown commit format %q, expected one of %s\n", *format, strings.Join(CommitFormats, ", "))
+		return ExitUsage
+	}
+	err = checkGitCommitArgs(gitArgs)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return ExitUsage
 	}
-	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
-	err = cmd.Run()
+	err = checkRepository()
 	if err != nil {
-		client.LogErr(fmt.Errorf("not a git repository"))
-		return 1
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
 	}
-	commitMsg, err := client.Commit()
+	client, err := newCommandClient(append([]ClientOption{WithCommand("commit")}, flags.options()...)...)
 	if err != nil {
-		client.LogErr(err)
-		return 1
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	interactive := !*printOnly && !client.assumeYes && !client.dryRun && flags.output != OutputJSON
+	if interactive && !client.inputIsTerminal() {
+		return client.exitWith(fmt.Errorf("%w to ask whether to accept the message; use --yes to commit without asking, or --print to only print it", ErrNoTerminal))
+	}
+	cfg := client.config.Commit
+	if *format != "" {
+		cfg.Format = *format
 	}
-	fmt.Fprintln(client.output, "Accept Generated Message? (Y)es/(N)o \n"+commitMsg)
-	input := bufio.NewReader(client.input)
-	char, _, err := input.ReadRune()
+	generate := client.CommitWith
+	if *amend {
+		generate = client.AmendCommit
+	}
+	commitMsg, err := generate(cfg)
 	if err != nil {
-		client.LogErr(err)
-		return 1
+		return client.exitWith(err)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(commitMsg))
 	}
-	r := strings.ToUpper(string(char))
-	if r != "Y" {
-		client.LogOut("Commit rejected")
+	if *printOnly {
+		fmt.Fprintln(out, commitMsg)
 		return 0
 	}
-	cmd = exec.Command("git", "commit", "-m", commitMsg)
-	err = cmd.Run()
+	for accepted := client.assumeYes; !accepted; {
+		fmt.Fprintln(client.output, "Accept Generated Message? (Y)es/(E)dit/(R)egenerate/(N)o \n"+commitMsg)
+		answer, err := client.readLine()
+		if err != nil {
+			return client.exitWith(err)
+		}
+		switch r := strings.ToUpper(strings.TrimSpace(answer)); {
+		case strings.HasPrefix(r, "Y"):
+			accepted = true
+		case strings.HasPrefix(r, "E"):
+			commitMsg, err = editMessage(commitMsg)
+			if err != nil {
+				return client.exitWith(err)
+			}
+			if commitMsg == "" {
+				client.LogOut("Commit rejected: the edited message is empty")
+				return 0
+			}
+			accepted = true
+		case strings.HasPrefix(r, "R"):
+			fmt.Fprint(client.output, "Guidance for the new message (optional): ")
+			guidance, err := client.readLine()
+			if err != nil && err != io.EOF {
+				return client.exitWith(err)
+			}
+			commitMsg, err = client.RegenerateCommit(cfg, commitMsg, guidance)
+			if err != nil {
+				return client.exitWith(err)
+			}
+		default:
+			client.LogOut("Commit rejected")
+			return 0
+		}
+	}
+	// The message goes before the arguments passed on, which may end with
+	// -- and pathspecs.
+	commitArgs := []string{"commit", "-m", commitMsg}
+	if *amend {
+		commitArgs = append(commitArgs, "--amend")
+	}
+	err = runGit(exec.Command("git", append(commitArgs, gitArgs...)...))
 	if err != nil {
-		client.LogErr(err)
-		return 1
+		return client.exitWith(err)
 	}
 	return 0
 }
 
-// TLDR generates a concise summary of content from a file or URL, aiming to condense important information.
-// It utilizes GPT-4 to help users quickly grasp the key points of large texts.
-func TLDR(args []string) int {
-	client, err := NewChatGPTClient()
+// Hook installs or uninstalls a prepare-commit-msg git hook that pre-fills
+// commit messages, and is what the hook runs to do so. As the hook runs
+// without a terminal, it writes the message without asking for anything.
+func Hook(args []string) int {
+	flags := newCommandFlags("hook")
+	force := flags.Bool("force", false, "replace an existing prepare-commit-msg hook")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "hook needs a subcommand: install, uninstall or run")
+		return ExitUsage
+	}
+	switch args[0] {
+	case "install":
+		path, err := InstallHook(*force)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		fmt.Fprintln(os.Stderr, "Installed "+path)
+		return 0
+	case "uninstall":
+		path, err := UninstallHook()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		fmt.Fprintln(os.Stderr, "Removed "+path)
+		return 0
+	case "run":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "hook run needs the path of the commit message file")
+			return ExitUsage
+		}
+		opts := []ClientOption{WithCommand("commit"), WithStreaming(false), WithOutput(os.Stderr, os.Stderr)}
+		client, err := NewChatGPTClient(append(opts, flags.options()...)...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitCode(err)
+		}
+		err = client.PrefillCommitMessage(args[1])
+		if err != nil {
+			return client.exitWith(err)
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown hook subcommand %q, expected install, uninstall or run\n", args[0])
+	return ExitUsage
+}
+
+// Changelog writes a changelog entry in the Keep a Changelog format for
+// the commits in a range such as v1.2.0..HEAD, or from a single ref to
+// HEAD, as Markdown or as JSON with --output json.
+func Changelog(args []string) int {
+	flags := newCommandFlags("changelog").addOutputFlag()
+	version := flags.String("version", "", "`name` of the release, instead of the tag at the end of the range")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "changelog needs a range of commits, such as v1.2.0..HEAD")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("changelog"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	cl, err := client.Changelog(ChangelogRange(args[0]))
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *version != "" {
+		cl.Version = *version
+	}
+	err = WriteChangelog(out, cl, flags.output)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// ReleaseNotes writes user-facing release notes for the commits and merged
+// pull requests in a range such as v1.2.0..v1.3.0, or from a single ref to
+// HEAD, for the audience and in the tone set in the config or by flags.
+func ReleaseNotes(args []string) int {
+	flags := newCommandFlags("release-notes").addOutputFlag()
+	audience := flags.String("audience", "", "who the notes are `for`, such as \"administrators upgrading the server\"")
+	tone := flags.String("tone", "", "the `tone` of the notes, such as \"formal\"")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "release-notes needs a range of commits, such as v1.2.0..v1.3.0")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("release-notes"), WithMarkdown(!flags.plain && stdoutIsTerminal())}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	cfg := client.config.Release
+	cfg.override(ReleaseNotesConfig{Audience: *audience, Tone: *tone})
+	notes, err := client.ReleaseNotes(ChangelogRange(args[0]), cfg)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(notes))
+	}
+	client.LogReply(notes)
+	return 0
+}
+
+// Review reviews a diff, from a patch file or from a git ref to the working
+// tree, or the uncommitted changes if neither is given, and prints the
+// findings as text, as JSON, or in the form of GitHub's create a review
+// API with --format github.
+func Review(args []string) int {
+	flags := newCommandFlags("review").addOutputFlag()
+	format := flags.String("format", ReviewFormatText, "format to print the findings in: "+strings.Join(ReviewFormats, ", "))
+	post := flags.Int("post", 0, "post the findings as a review of the GitHub pull request with this `number`")
+	repo := flags.String("repo", "", "GitHub repository for --post, as `owner/name` (default from the origin remote)")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if !containsString(ReviewFormats, *format) {
+		fmt.Fprintf(os.Stderr, "unknown review format %q, expected one of %s\n", *format, strings.Join(ReviewFormats, ", "))
+		return ExitUsage
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "review takes a single git ref or patch file")
+		return ExitUsage
+	}
+	if flags.output == OutputJSON {
+		*format = ReviewFormatJSON
+	}
+	opts := []ClientOption{WithCommand("review"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return ExitCode(err)
 	}
+	out := client.resultWriter(flags.output)
+	target := ""
 	if len(args) == 1 {
-		client.LogErr(fmt.Errorf("must ask a question"))
-		return 1
+		target = args[0]
+	}
+	diff, err := ReviewDiff(target)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	findings, err := client.ReviewCode(diff)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *post > 0 {
+		gh, err := newCommandGitHub(*repo, flags)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		url, err := gh.CreateReview(*post, findings)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		client.progressf("Posted the review: %s\n", url)
+	}
+	err = WriteFindings(out, findings, *format)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// Action reviews a pull request from inside GitHub Actions, where it is
+// configured by the environment rather than a terminal: it reads the pull
+// request from GITHUB_EVENT_PATH and its diff from the checkout, summarises
+// and reviews the change, and posts the result as a comment on the pull
+// request with GITHUB_TOKEN. The comment is also added to the job summary.
+func Action(args []string) int {
+	flags := newCommandFlags("action")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "action takes no arguments; it is configured by the GitHub Actions environment")
+		return ExitUsage
+	}
+	eventPath := os.Getenv("GITHUB_EVENT_PATH")
+	if eventPath == "" {
+		fmt.Fprintln(os.Stderr, "GITHUB_EVENT_PATH isn't set; action runs in a GitHub Actions pull_request workflow")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("action"), WithStreaming(false), unattended()}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	event, err := ReadPullRequestEvent(eventPath)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	gh, err := newCommandGitHub(os.Getenv("GITHUB_REPOSITORY"), flags)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	client.progressf("Summarising pull request #%d\n", event.Number)
+	pr, err := client.newConversation().pullRequestBetween(event.Base, event.Head)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	client.progressf("Reviewing pull request #%d\n", event.Number)
+	diff, err := ReviewDiff(event.Base + "..." + event.Head)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	findings, err := client.newConversation().ReviewCode(diff)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	comment := ActionComment(pr, findings)
+	url, err := gh.CommentOnce(event.Number, ActionMarker, comment)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	client.progressf("Posted the review: %s\n", url)
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		err = appendStepSummary(path, comment)
+		if err != nil {
+			return client.exitWith(err)
+		}
+	}
+	return 0
+}
+
+// PR writes the title and description of a pull request for the current
+// branch, and with --create or --post opens the pull request on GitHub or
+// updates an existing one with them.
+func PR(args []string) int {
+	flags := newCommandFlags("pr").addOutputFlag()
+	create := flags.Bool("create", false, "open a pull request on GitHub for the current branch")
+	post := flags.Int("post", 0, "replace the title and description of the GitHub pull request with this `number`")
+	repo := flags.String("repo", "", "GitHub repository, as `owner/name` (default from the origin remote)")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "pr takes a single base branch")
+		return ExitUsage
+	}
+	if *create && *post > 0 {
+		fmt.Fprintln(os.Stderr, "--create and --post can't be used together")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("pr"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	base := DefaultBranch()
+	if len(args) == 1 {
+		base = args[0]
+	}
+	pr, err := client.PullRequestFor(base)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *create || *post > 0 {
+		gh, err := newCommandGitHub(*repo, flags)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		var url string
+		if *create {
+			head, err := currentBranch()
+			if err != nil {
+				return client.exitWith(err)
+			}
+			url, err = gh.CreatePullRequest(head, strings.TrimPrefix(base, "origin/"), pr)
+		} else {
+			url, err = gh.UpdatePullRequest(*post, pr)
+		}
+		if err != nil {
+			return client.exitWith(err)
+		}
+		client.progressf("Posted the pull request: %s\n", url)
+	}
+	err = WritePullRequest(out, pr, flags.output)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// MR writes the title and description of a GitLab merge request for the
+// current branch, and with --create or --post opens the merge request or
+// updates an existing one with them.
+func MR(args []string) int {
+	flags := newCommandFlags("mr").addOutputFlag()
+	create := flags.Bool("create", false, "open a merge request on GitLab for the current branch")
+	post := flags.Int("post", 0, "replace the title and description of the GitLab merge request with this `iid`")
+	project := flags.String("project", "", "GitLab project `path`, such as group/name (default from the origin remote)")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "mr takes a single target branch")
+		return ExitUsage
+	}
+	if *create && *post > 0 {
+		fmt.Fprintln(os.Stderr, "--create and --post can't be used together")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("mr"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	target := DefaultBranch()
+	if len(args) == 1 {
+		target = args[0]
+	}
+	mr, err := client.PullRequestFor(target)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *create || *post > 0 {
+		gl, err := NewGitLab(*project)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		gl.HTTPClient = &http.Client{Timeout: flags.timeout}
+		var url string
+		if *create {
+			source, err := currentBranch()
+			if err != nil {
+				return client.exitWith(err)
+			}
+			url, err = gl.CreateMergeRequest(source, strings.TrimPrefix(target, "origin/"), mr)
+		} else {
+			url, err = gl.UpdateMergeRequest(*post, mr)
+		}
+		if err != nil {
+			return client.exitWith(err)
+		}
+		client.progressf("Posted the merge request: %s\n", url)
+	}
+	err = WritePullRequest(out, mr, flags.output)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// Issue summarises a GitHub issue and its discussion, and with --post adds
+// the summary to the issue as a comment.
+func Issue(args []string) int {
+	flags := newCommandFlags("issue").addOutputFlag()
+	post := flags.Bool("post", false, "add the summary to the issue as a comment")
+	repo := flags.String("repo", "", "GitHub repository, as `owner/name` (default from the origin remote)")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "issue needs the number of a GitHub issue")
+		return ExitUsage
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil || number < 1 {
+		fmt.Fprintf(os.Stderr, "%q isn't an issue number\n", args[0])
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("issue"), WithMarkdown(!flags.plain && stdoutIsTerminal())}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	gh, err := newCommandGitHub(*repo, flags)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	issue, err := gh.Issue(number)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	summary, err := client.SummariseIssue(issue)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *post {
+		url, err := gh.Comment(number, summary)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		client.progressf("Posted the summary: %s\n", url)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(summary))
+	}
+	client.LogReply(summary)
+	return 0
+}
+
+// Triage reads the open issues of a GitHub repository, groups them into
+// clusters, suggests labels and a priority for each, and prints a triage
+// report. With --apply, it adds the suggested labels to the issues.
+func Triage(args []string) int {
+	flags := newCommandFlags("triage").addOutputFlag()
+	apply := flags.Bool("apply", false, "add the suggested labels to the issues (needs GITHUB_TOKEN)")
+	limit := flags.Int("limit", TriageLimit, "the most open issues to read")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "triage takes at most one repository, as owner/name")
+		return ExitUsage
+	}
+	if *limit < 1 {
+		fmt.Fprintln(os.Stderr, "--limit must be at least 1")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("triage"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	repo := ""
+	if len(args) == 1 {
+		repo = args[0]
+	}
+	gh, err := newCommandGitHub(repo, flags)
+	if err != nil {
+		return client.exitWith(err)
 	}
-	path := strings.Join(args[1:], " ")
-	summary, err := client.TLDR(path)
+	if *apply && gh.Token == "" {
+		return client.exitWith(fmt.Errorf("%w: set GITHUB_TOKEN to apply labels", ErrUnauthorized))
+	}
+	issues, err := gh.OpenIssues(*limit)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	labels, err := gh.Labels()
+	if err != nil {
+		return client.exitWith(err)
+	}
+	client.progressf("Triaging %d open issues in %s...\n", len(issues), gh.Repo)
+	report, err := client.TriageIssues(gh.Repo, issues, labels)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *apply {
+		for _, cluster := range report.Clusters {
+			for _, issue := range cluster.Issues {
+				if len(issue.Labels) == 0 {
+					continue
+				}
+				err := gh.AddLabels(issue.Number, issue.Labels)
+				if err != nil {
+					return client.exitWith(err)
+				}
+				client.progressf("Labelled #%d: %s\n", issue.Number, strings.Join(issue.Labels, ", "))
+			}
+		}
+	}
+	err = WriteTriageReport(out, report, flags.output)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// newCommandGitHub returns the GitHub client for a command's --repo flag,
+// giving up on requests after the command's --timeout.
+func newCommandGitHub(repo string, flags *commandFlags) (*GitHub, error) {
+	gh, err := NewGitHub(repo)
+	if err != nil {
+		return nil, err
+	}
+	gh.HTTPClient = &http.Client{Timeout: flags.timeout}
+	return gh, nil
+}
+
+// Semver suggests whether the next release should be a major, minor or
+// patch release, from the commits and diff since the latest tag, and
+// prints the next version with the reasoning for it.
+func Semver(args []string) int {
+	flags := newCommandFlags("semver").addOutputFlag()
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "semver takes no arguments")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("semver"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	bump, err := client.SuggestVersionBump()
+	if err != nil {
+		return client.exitWith(err)
+	}
+	err = WriteVersionBump(out, bump, flags.output)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// Branch suggests a branch name for the work described, following the
+// branch format in the config, and with --switch creates the branch and
+// switches to it.
+func Branch(args []string) int {
+	flags := newCommandFlags("branch").addOutputFlag()
+	ticket := flags.String("ticket", "", "ticket `id` for the {ticket} in the branch format, if it isn't in the description")
+	switchTo := flags.Bool("switch", false, "create the branch and switch to it with git switch -c")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "branch needs a description of the work")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("branch"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	name, err := client.BranchName(strings.Join(args, " "), *ticket, client.config.Branch)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if *switchTo {
+		err = runGit(exec.Command("git", "switch", "-c", name))
+		if err != nil {
+			return client.exitWith(err)
+		}
+		client.progressf("Switched to a new branch %s\n", name)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(name))
+	}
+	fmt.Fprintln(out, name)
+	return 0
+}
+
+// ExplainHistory explains why the code in a file, or around a line of it
+// given as file:line, is the way it is, from git blame and the messages of
+// the commits that wrote it.
+func ExplainHistory(args []string) int {
+	flags := newCommandFlags("explain-history").addOutputFlag()
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "explain-history needs a file, or a line of one such as client.go:42")
+		return ExitUsage
+	}
+	file, line, err := ParseHistoryTarget(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("explain-history"), WithMarkdown(!flags.plain && stdoutIsTerminal())}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	explanation, err := client.ExplainHistory(file, line)
 	if err != nil {
-		client.LogErr(err)
-		return 1
+		return client.exitWith(err)
 	}
-	client.LogOut(summary)
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(explanation))
+	}
+	client.LogReply(explanation)
 	return 0
 }
+
+// ExplainDiff prints a walkthrough of a change for its reviewer, from the
+// changes from a git ref to the working tree, a patch file, or the changes
+// not yet committed.
+func ExplainDiff(args []string) int {
+	flags := newCommandFlags("explain-diff").addOutputFlag()
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "explain-diff takes a single git ref or patch file")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("explain-diff"), WithMarkdown(!flags.plain && stdoutIsTerminal())}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	target := ""
+	if len(args) == 1 {
+		target = args[0]
+	}
+	diff, err := ReviewDiff(target)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	explanation, err := client.ExplainDiff(diff)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(explanation))
+	}
+	client.LogReply(explanation)
+	return 0
+}
+
+// Checklist evaluates a project against a checklist of criteria, reporting
+// whether each passed and the evidence for it as Markdown, or as JSON with
+// --output json. The criteria come from the --criteria file, the checklist
+// in the project config, or else DefaultChecklist. It exits with
+// ExitChecklistFailed if any required criterion fails, so that it can gate
+// a CI pipeline.
+func Checklist(args []string) int {
+	flags := newCommandFlags("checklist").addOutputFlag()
+	criteriaPath := flags.String("criteria", "", "YAML file of checklist criteria")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	opts := []ClientOption{WithCommand("checklist"), WithStreaming(false)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	criteria := client.config.Checklist
+	if *criteriaPath != "" {
+		criteria, err = LoadChecklist(*criteriaPath)
+		if err != nil {
+			return client.exitWith(err)
+		}
+	}
+	if len(criteria) == 0 {
+		criteria = DefaultChecklist
+	}
+	path := "."
+	if len(args) > 0 {
+		path = strings.Join(args, " ")
+	}
+	report, err := client.Checklist(path, criteria)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	err = WriteChecklistReport(out, report, flags.output)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if err := report.Err(); err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// Setup asks for an API key, a default model and whether to record
+// transcripts, and writes them to a new user config file.
+func Setup(args []string) int {
+	fs := flag.NewFlagSet("setup", flag.ContinueOnError)
+	fs.Usage = func() {
+		showHelp(fs.Output(), "setup", fs)
+	}
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return parseExitCode(err)
+	}
+	err = RunSetup(os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Doctor checks that chatproxy is set up to work, printing each check and
+// how to fix those that fail. It exits with ExitError if any check fails.
+func Doctor(args []string) int {
+	flags := newCommandFlags("doctor").addOutputFlag()
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	diagnoses := Diagnose(context.Background(), append([]ClientOption{WithCommand("doctor")}, flags.options()...)...)
+	if flags.output == OutputJSON {
+		err = json.NewEncoder(os.Stdout).Encode(diagnoses)
+	} else {
+		err = writeDiagnoses(os.Stdout, diagnoses)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	for _, d := range diagnoses {
+		if !d.OK {
+			return ExitError
+		}
+	}
+	return 0
+}
+
+// Models lists the models available to the API key, with their context
+// sizes and pricing, so that valid values for --model can be chosen.
+func Models(args []string) int {
+	flags := newCommandFlags("models").addOutputFlag()
+	all := flags.Bool("all", false, "include models other than chat models")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	opts := []ClientOption{WithCommand("models"), WithTranscript(io.Discard)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	models, err := client.Models(context.Background(), *all)
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if flags.output == OutputJSON {
+		err = json.NewEncoder(client.output).Encode(models)
+	} else {
+		err = writeModels(client.output, models, client.model)
+	}
+	if err != nil {
+		return client.exitWith(err)
+	}
+	return 0
+}
+
+// TLDR generates a concise summary of content from a file or URL, aiming to condense important information.
+// It utilizes GPT-4 to help users quickly grasp the key points of large texts.
+// Several paths or URLs are summarised together, combined or compared.
+func TLDR(args []string) int {
+	flags := newCommandFlags("tldr").addOutputFlag()
+	length := flags.String("length", "", "length of the summary: one-line, paragraph or bullets")
+	compare := flags.Bool("compare", false, "compare several sources rather than combining them")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	summaryOpts := SummaryOptions{Compare: *compare}
+	summaryOpts.Length, err = ParseSummaryLength(*length)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("tldr"), WithMarkdown(!flags.plain && stdoutIsTerminal())}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := client.resultWriter(flags.output)
+	content, piped, err := client.PipedInput()
+	if err != nil {
+		return client.exitWith(err)
+	}
+	var summary string
+	var citations []string
+	switch {
+	case piped:
+		summaryOpts.Instructions = strings.Join(args, " ")
+		if summaryOpts.Instructions == StdinPath {
+			summaryOpts.Instructions = ""
+		}
+		summary, err = client.Summarise(content, summaryOpts)
+	case len(args) > 0:
+		citations = args
+		summary, err = client.TLDRAll(args, summaryOpts)
+	default:
+		client.LogErr(fmt.Errorf("must give a path or URL to summarise, or pipe content to stdin"))
+		return ExitUsage
+	}
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if flags.output == OutputJSON {
+		return client.printResult(out, client.result(summary, citations...))
+	}
+	client.LogReply(summary)
+	return 0
+}
+
+// Batch runs the ask and tldr jobs in a batch file concurrently, writing
+// the result of each to stdout, or --out, as a line of JSON.
+func Batch(args []string) int {
+	flags := newCommandFlags("batch").addWebhookFlag()
+	concurrency := flags.Int("concurrency", 4, "how many jobs to run at once")
+	rateLimit := flags.Int("rate-limit", 0, "the most jobs to start in a minute; 0 for no limit")
+	outPath := flags.String("out", "", "`file` to write the results to, rather than stdout")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "batch takes one batch file, or - for stdin")
+		return ExitUsage
+	}
+	if *concurrency < 1 || *rateLimit < 0 {
+		fmt.Fprintln(os.Stderr, "--concurrency must be at least 1, and --rate-limit must not be negative")
+		return ExitUsage
+	}
+	in := io.Reader(os.Stdin)
+	if args[0] != StdinPath {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		defer f.Close()
+		in = f
+	}
+	jobs, err := ReadBatchJobs(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading %s: %v\n", args[0], err)
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("batch"), WithStreaming(false), WithOutput(io.Discard, os.Stderr), unattended()}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	out := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	failed, err := client.RunBatch(jobs, BatchOptions{Concurrency: *concurrency, RateLimit: *rateLimit}, out)
+	WaitForWebhooks()
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d jobs failed\n", failed, len(jobs))
+		return ExitError
+	}
+	return 0
+}
+
+// ReportUsage reports the tokens used and their estimated cost, from the
+// usage log, per day, command or model.
+func ReportUsage(args []string) int {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	by := fs.String("by", UsageByDay, "group usage by day, command or model")
+	since := fs.String("since", "30d", "report usage since a date such as 2023-06-01, days such as 7d, or a duration; empty for all")
+	output := fs.String("output", OutputText, "output format: text or json")
+	fs.Usage = func() {
+		showHelp(fs.Output(), "usage", fs)
+	}
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return parseExitCode(err)
+	}
+	start, err := ParseSince(*since, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+	path, err := UsageLogPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	var records []UsageRecord
+	f, err := os.Open(path)
+	if err == nil {
+		records, err = ReadUsageLog(f)
+		f.Close()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	summaries, err := SummariseUsage(records, *by, start)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+	if format == OutputJSON {
+		err = json.NewEncoder(os.Stdout).Encode(summaries)
+	} else {
+		err = writeUsageSummaries(os.Stdout, *by, summaries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Transcript prints a recorded transcript with its roles colorized. With
+// --follow it keeps printing new lines as they are written, so a running
+// chat can be monitored from another terminal.
+func Transcript(args []string) int {
+	fs := flag.NewFlagSet("transcript", flag.ContinueOnError)
+	follow := fs.Bool("follow", false, "keep printing lines as they are written")
+	fs.BoolVar(follow, "f", false, "shorthand for --follow")
+	fs.Usage = func() {
+		showHelp(fs.Output(), "transcript", fs)
+	}
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return parseExitCode(err)
+	}
+	path, err := FindTranscript(strings.Join(fs.Args(), " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	spec := cfg.Theme
+	if env, ok := os.LookupEnv("CHATPROXY_THEME"); ok {
+		spec = env
+	}
+	theme, err := ParseTheme(spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	theme.applyColorMode(ColorEnabled(os.Stdout))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = FollowTranscript(ctx, path, os.Stdout, theme, *follow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Web serves a browser chat UI on a local address, for using chatproxy
+// without a terminal.
+func Web(args []string) int {
+	flags := newCommandFlags("web").addWebhookFlag()
+	addr := flags.String("addr", "localhost:8080", "loopback address to serve the chat UI on")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if !isLoopback(*addr) {
+		fmt.Fprintf(os.Stderr, "refusing to serve on %s, as anyone who can reach the chat UI or its API could use your API key; use a loopback address such as localhost:8080, or the gateway command with keys\n", *addr)
+		return ExitUsage
+	}
+	opts := []ClientOption{WithStreaming(true), WithOutput(io.Discard, os.Stderr), WithCommand("web")}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	fmt.Fprintf(os.Stderr, "Serving chat UI on http://%s\n", *addr)
+	err = http.ListenAndServe(*addr, NewWebServer(client))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Editor serves the JSON-RPC API that editor plugins call to explain code
+// and write doc comments and tests, on a local address.
+func Editor(args []string) int {
+	flags := newCommandFlags("editor").addWebhookFlag()
+	addr := flags.String("addr", "localhost:8765", "loopback address to serve the API on")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if !isLoopback(*addr) {
+		fmt.Fprintf(os.Stderr, "refusing to serve on %s, as the editor API has no keys; use a loopback address such as localhost:8765\n", *addr)
+		return ExitUsage
+	}
+	opts := []ClientOption{WithStreaming(false), WithOutput(io.Discard, os.Stderr), unattended(), WithCommand("editor")}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	fmt.Fprintf(os.Stderr, "Serving the editor API on http://%s%s\n", *addr, EditorPath)
+	err = http.ListenAndServe(*addr, NewEditorServer(client))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Gateway serves an OpenAI compatible chat completions API that forwards
+// requests to the configured provider with the configured key, so that
+// SDKs can be pointed at chatproxy instead of the provider.
+func Gateway(args []string) int {
+	flags := newCommandFlags("gateway").addWebhookFlag()
+	addr := flags.String("addr", "localhost:8081", "address to serve the API on")
+	rateLimit := flags.Int("rate-limit", 0, "the most requests each caller may make in a minute (default from the config)")
+	metricsAddr := flags.String("metrics-addr", "", "address to serve Prometheus metrics on at /metrics, such as :9090")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if *rateLimit < 0 {
+		fmt.Fprintln(os.Stderr, "--rate-limit must not be negative")
+		return ExitUsage
+	}
+	metrics := NewMetrics()
+	opts := []ClientOption{WithOutput(io.Discard, os.Stderr), WithCommand("gateway"), WithMetrics(metrics)}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	gateway, err := NewGatewayServer(client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	if *rateLimit > 0 {
+		gateway.RateLimit = *rateLimit
+	}
+	if len(gateway.Keys) == 0 && !isLoopback(*addr) {
+		fmt.Fprintf(os.Stderr, "refusing to serve on %s without gateway keys, as anyone who can reach it could use your API key; set gateway.keys in the config\n", *addr)
+		return ExitUsage
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr, metrics)
+	}
+	fmt.Fprintf(os.Stderr, "Serving the OpenAI API on http://%s/v1\n", *addr)
+	err = http.ListenAndServe(*addr, gateway)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// GRPC serves the ChatProxy gRPC service, defined in
+// proto/chatproxy.proto, for services in other languages.
+func GRPC(args []string) int {
+	flags := newCommandFlags("grpc").addWebhookFlag()
+	addr := flags.String("addr", "localhost:50051", "address to serve the gRPC service on")
+	metricsAddr := flags.String("metrics-addr", "", "address to serve Prometheus metrics on at /metrics, such as :9090")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	opts := append([]ClientOption{WithCommand("grpc")}, flags.options()...)
+	// Check the key and config before serving, rather than on the first call.
+	client, err := newCommandClient(append([]ClientOption{WithOutput(io.Discard, os.Stderr)}, opts...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	server, err := NewGRPCServer(append(opts, WithTranscript(client.transcript))...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	server.Keys = client.config.Gateway.Keys
+	server.Metrics = NewMetrics()
+	if len(server.Keys) == 0 && !isLoopback(*addr) {
+		fmt.Fprintf(os.Stderr, "refusing to serve on %s without gateway keys, as anyone who can reach it could use your API key; set gateway.keys in the config\n", *addr)
+		return ExitUsage
+	}
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr, server.Metrics)
+	}
+	fmt.Fprintf(os.Stderr, "Serving the gRPC service on %s\n", lis.Addr())
+	err = server.Serve(lis)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Digest summarises what changed in the sources set under digest in the
+// config since the last digest, and emails the digest, or prints it with
+// --print. With --daemon it keeps running, sending one every digest.every.
+func Digest(args []string) int {
+	flags := newCommandFlags("digest")
+	printOnly := flags.Bool("print", false, "print the digest rather than emailing it")
+	daemon := flags.Bool("daemon", false, "keep running, sending a digest every digest.every")
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "digest takes no arguments; set its sources under digest in the config")
+		return ExitUsage
+	}
+	opts := []ClientOption{WithCommand("digest"), WithStreaming(false)}
+	if *daemon {
+		opts = append(opts, unattended())
+	}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	cfg := client.config.Digest
+	if len(cfg.Sources) == 0 {
+		fmt.Fprintln(os.Stderr, "set digest.sources in the config to the files, directories or URLs to summarise")
+		return ExitUsage
+	}
+	if cfg.SMTP.Addr == "" && !*printOnly {
+		fmt.Fprintln(os.Stderr, "set digest.smtp in the config to email digests, or use --print")
+		return ExitUsage
+	}
+	path, err := DigestStatePath()
+	if err != nil {
+		return client.exitWith(err)
+	}
+	if !*daemon {
+		err = sendDigest(client, cfg, path, *printOnly)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		return 0
+	}
+	every := cfg.Every
+	if every == 0 {
+		every = DefaultDigestEvery
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for {
+		state, err := LoadDigestState(path)
+		if err != nil {
+			return client.exitWith(err)
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(time.Until(state.LastSent.Add(every))):
+		}
+		err = sendDigest(client, cfg, path, *printOnly)
+		if err != nil {
+			client.LogErr(err)
+			// Try again at the next digest rather than at once.
+			state.LastSent = time.Now()
+			state.Save(path)
+		}
+	}
+}
+
+// sendDigest sends or prints a digest of the changes since the one whose
+// state is at path, and records the new state.
+func sendDigest(client *ChatGPTClient, cfg DigestConfig, path string, printOnly bool) error {
+	state, err := LoadDigestState(path)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	report, err := client.Digest(cfg.Sources, &state, now)
+	if err != nil {
+		return err
+	}
+	switch {
+	case printOnly:
+		err = WriteDigest(client.output, report)
+	case len(report.Items) == 0:
+		client.progressf("Nothing has changed since the last digest\n")
+	default:
+		err = SendDigest(cfg.SMTP, report)
+		if err == nil {
+			client.progressf("Sent a digest of %d changed sources to %s\n", len(report.Items), strings.Join(cfg.SMTP.To, ", "))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	state.LastSent = now
+	return state.Save(path)
+}
+
+// MCP serves chatproxy's features as Model Context Protocol tools over
+// stdin and stdout, for an editor or other LLM client to start and call.
+func MCP(args []string) int {
+	flags := newCommandFlags("mcp").addWebhookFlag()
+	args, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "mcp takes no arguments")
+		return ExitUsage
+	}
+	// Stdin and stdout carry the protocol, so nothing else may be written
+	// to stdout.
+	opts := []ClientOption{WithCommand("mcp"), WithStreaming(false), WithOutput(io.Discard, os.Stderr), unattended()}
+	client, err := newCommandClient(append(opts, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	err = NewMCPServer(client).Serve(os.Stdin, os.Stdout)
+	WaitForWebhooks()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Telegram chats over Telegram with the people given by --allow, as the
+// bot whose token is in TELEGRAM_BOT_TOKEN, until interrupted.
+func Telegram(args []string) int {
+	flags := newCommandFlags("telegram").addWebhookFlag()
+	allow := flags.String("allow", "", "comma separated `usernames` or user ids of the people the bot answers")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	var allowed []string
+	for _, a := range strings.Split(*allow, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			allowed = append(allowed, a)
+		}
+	}
+	if len(allowed) == 0 {
+		fmt.Fprintln(os.Stderr, "--allow is required, as anyone who finds the bot could otherwise use your API key")
+		return ExitUsage
+	}
+	api, err := NewTelegramAPI()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	client, err := newCommandClient(append([]ClientOption{WithCommand("telegram"), WithOutput(io.Discard, os.Stderr), unattended()}, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Answering %s on Telegram\n", strings.Join(allowed, ", "))
+	err = NewTelegramBot(api, client, allowed).Run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// Matrix chats in Matrix rooms with the people given by --allow, as the
+// user whose access token is in MATRIX_ACCESS_TOKEN, until interrupted.
+func Matrix(args []string) int {
+	flags := newCommandFlags("matrix").addWebhookFlag()
+	allow := flags.String("allow", "", "comma separated Matrix `user ids`, such as @alice:example.org, of the people the bot answers")
+	_, err := flags.parse(args)
+	if err != nil {
+		return parseExitCode(err)
+	}
+	var allowed []string
+	for _, a := range strings.Split(*allow, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			allowed = append(allowed, a)
+		}
+	}
+	if len(allowed) == 0 {
+		fmt.Fprintln(os.Stderr, "--allow is required, as anyone who invites the bot could otherwise use your API key")
+		return ExitUsage
+	}
+	api, err := NewMatrixAPI()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	client, err := newCommandClient(append([]ClientOption{WithCommand("matrix"), WithOutput(io.Discard, os.Stderr), unattended()}, flags.options()...)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Answering %s on Matrix as %s\n", strings.Join(allowed, ", "), api.UserID)
+	err = NewMatrixBot(api, client, allowed).Run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	return 0
+}
+
+// serveMetrics serves the metrics on addr for Prometheus to scrape. The
+// server the metrics are of goes on if they can't be served.
+func serveMetrics(addr string, m *Metrics) {
+	fmt.Fprintf(os.Stderr, "Serving metrics on http://%s%s\n", addr, MetricsPath)
+	err := http.ListenAndServe(addr, m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "serving metrics:", err)
+	}
+}
+
+// isLoopback reports whether addr only listens on this machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
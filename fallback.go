@@ -0,0 +1,114 @@
+package chatproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fallbackModel is a model to ask for a reply when the models before it
+// fail, and its provider, or "" for the provider of the client's model.
+type fallbackModel struct {
+	provider string
+	model    string
+}
+
+// parseFallback parses a model, optionally prefixed with one of Providers
+// and a colon, such as "groq:llama-3.1-8b-instant". A provider with no
+// model, such as "mistral:", means the provider's default model.
+func parseFallback(s string) fallbackModel {
+	provider, model, ok := strings.Cut(s, ":")
+	if !ok || !containsString(Providers, provider) {
+		return fallbackModel{model: s}
+	}
+	if model == "" {
+		model = specFor(provider).defaultModel
+	}
+	return fallbackModel{provider: provider, model: model}
+}
+
+// WithFallback sets the model to use, and the models to ask in turn when
+// it fails, by returning an error or timing out, until one replies. Each is
+// a model, or a provider and model such as "groq:llama-3.1-8b-instant".
+// A model on another provider than the client's is sent with that
+// provider's key, from its environment variable, rather than the client's
+// token, profile and base URL. AnsweredBy reports which model replied.
+func WithFallback(primary string, secondaries ...string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		f := parseFallback(primary)
+		if f.provider != "" {
+			c.setProvider(f.provider)
+		}
+		c.model = f.model
+		c.setFallbacks(secondaries)
+		return c
+	}
+}
+
+func (c *ChatGPTClient) setFallbacks(models []string) {
+	c.fallbacks = nil
+	for _, m := range models {
+		c.fallbacks = append(c.fallbacks, parseFallback(m))
+	}
+}
+
+// AnsweredBy returns the provider and model that gave the last reply,
+// which, with fallbacks, may not be the client's model.
+func (c *ChatGPTClient) AnsweredBy() (provider, model string) {
+	return c.answeredBy.provider, c.answeredBy.model
+}
+
+// fallsBack reports whether a completion that failed with err should be
+// retried with the next model. Replies the user declined or interrupted,
+// and prompts too long for the conversation to go on, aren't retried.
+func fallsBack(err error) bool {
+	return !errors.Is(err, ErrCostDeclined) &&
+		!errors.Is(err, ErrContextTooLong) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// completeWithFallbacks asks the client's model for a reply, then each of
+// its fallbacks in turn until one replies, returning every model's error
+// if none does.
+func (c *ChatGPTClient) completeWithFallbacks(opts ...CompletionOption) (string, error) {
+	provider, model, client := c.provider, c.model, c.client
+	baseURL, token, profile := c.baseURL, c.token, c.profile
+	defer func() {
+		c.provider, c.model, c.client = provider, model, client
+		c.baseURL, c.token, c.profile = baseURL, token, profile
+	}()
+	models := append([]fallbackModel{{provider: provider, model: model}}, c.fallbacks...)
+	var errs []error
+	for i, f := range models {
+		if f.provider == "" {
+			f.provider = provider
+		}
+		c.provider, c.model, c.client = provider, f.model, client
+		c.baseURL, c.token, c.profile = baseURL, token, profile
+		if f.provider != provider {
+			c.provider, c.baseURL, c.token, c.profile = f.provider, "", nil, ""
+			var err error
+			c.client, err = c.newAPIClient()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s:%s: %w", f.provider, f.model, err))
+				continue
+			}
+		}
+		reply, err := c.getCompletion(opts...)
+		if err == nil {
+			if i > 0 {
+				c.Logger().Info(recordNote, "content", fmt.Sprintf("Answered by %s:%s", c.answeredBy.provider, c.answeredBy.model))
+			}
+			return reply, nil
+		}
+		if !fallsBack(err) {
+			return "", err
+		}
+		errs = append(errs, fmt.Errorf("%s:%s: %w", f.provider, f.model, err))
+		if i < len(models)-1 {
+			c.Logger().Warn("model failed, trying the next", "provider", f.provider, "model", f.model, "error", err)
+		}
+	}
+	return "", errors.Join(errs...)
+}
@@ -0,0 +1,264 @@
+package chatproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FeedMaxEntries limits how many new entries of a feed are read at once,
+// the newest first, so that the first read of a long feed fits in the
+// model's context. The rest are read the next time.
+var FeedMaxEntries = 20
+
+// feedMaxSeen limits how many entry ids are remembered for each feed.
+const feedMaxSeen = 1000
+
+// FeedEntry is an entry of an RSS or Atom feed.
+type FeedEntry struct {
+	ID        string
+	Title     string
+	Link      string
+	Published string
+	Summary   string
+}
+
+// Feed is an RSS or Atom feed.
+type Feed struct {
+	Title   string
+	Entries []FeedEntry
+}
+
+// isFeed reports whether a response with the given content type and body is
+// an RSS or Atom feed, rather than a web page.
+func isFeed(contentType string, body []byte) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.Contains(contentType, "html") {
+		return false
+	}
+	head := body
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	return bytes.Contains(head, []byte("<rss")) || bytes.Contains(head, []byte("<feed")) || bytes.Contains(head, []byte("<rdf:RDF"))
+}
+
+// ParseFeed parses an RSS 2.0, RSS 1.0 or Atom feed.
+func ParseFeed(data []byte) (Feed, error) {
+	var doc struct {
+		XMLName xml.Name
+		// Atom
+		Title   string `xml:"title"`
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title string `xml:"title"`
+			Links []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"link"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+			Summary   string `xml:"summary"`
+			Content   string `xml:"content"`
+		} `xml:"entry"`
+		// RSS 2.0 has its items in a channel, and RSS 1.0 beside it.
+		Channel struct {
+			Title string    `xml:"title"`
+			Items []rssItem `xml:"item"`
+		} `xml:"channel"`
+		Items []rssItem `xml:"item"`
+	}
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return Feed{}, fmt.Errorf("reading the feed: %w", err)
+	}
+	var feed Feed
+	switch doc.XMLName.Local {
+	case "feed":
+		feed.Title = doc.Title
+		for _, e := range doc.Entries {
+			entry := FeedEntry{ID: e.ID, Title: e.Title, Published: e.Published, Summary: e.Summary}
+			for _, link := range e.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					entry.Link = link.Href
+					break
+				}
+			}
+			if entry.Published == "" {
+				entry.Published = e.Updated
+			}
+			if entry.Summary == "" {
+				entry.Summary = e.Content
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+	case "rss", "RDF":
+		feed.Title = doc.Channel.Title
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			feed.Entries = append(feed.Entries, item.entry())
+		}
+	default:
+		return Feed{}, fmt.Errorf("<%s> isn't an RSS or Atom feed", doc.XMLName.Local)
+	}
+	for i, e := range feed.Entries {
+		if e.ID == "" {
+			e.ID = e.Link
+		}
+		if e.ID == "" {
+			e.ID = e.Title
+		}
+		e.Title = strings.TrimSpace(e.Title)
+		e.Summary = stripHTML(e.Summary)
+		feed.Entries[i] = e
+	}
+	return feed, nil
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"date"`
+	Description string `xml:"description"`
+}
+
+func (item rssItem) entry() FeedEntry {
+	published := item.PubDate
+	if published == "" {
+		published = item.Date
+	}
+	return FeedEntry{ID: item.GUID, Title: item.Title, Link: item.Link, Published: published, Summary: item.Description}
+}
+
+var (
+	htmlBlockTag = regexp.MustCompile(`(?i)</?(p|br|div|li|ul|ol|h[1-6]|blockquote|pre|tr|td)\b[^>]*>`)
+	htmlTag      = regexp.MustCompile(`<[^>]*>`)
+	whitespace   = regexp.MustCompile(`\s+`)
+)
+
+// stripHTML returns the text of an entry's HTML summary.
+func stripHTML(s string) string {
+	s = htmlBlockTag.ReplaceAllString(s, " ")
+	s = htmlTag.ReplaceAllString(s, "")
+	return strings.TrimSpace(whitespace.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+// feedState is the ids of the entries of a feed that have been read.
+type feedState struct {
+	URL  string   `json:"url"`
+	Seen []string `json:"seen"`
+}
+
+// feedStatePath returns the path of the state of the feed at url, in the
+// application's XDG state directory.
+func feedStatePath(url string) (string, error) {
+	dir, err := stateFileDir("feeds")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// newFeedEntries returns the text of the entries of the feed at url that
+// haven't been read before, and the state of the feed once they have been.
+func newFeedEntries(url string, data []byte) (string, feedState, error) {
+	feed, err := ParseFeed(data)
+	if err != nil {
+		return "", feedState{}, err
+	}
+	path, err := feedStatePath(url)
+	if err != nil {
+		return "", feedState{}, err
+	}
+	state := feedState{URL: url}
+	saved, err := readState(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", feedState{}, err
+	}
+	if err == nil {
+		err = json.Unmarshal(saved, &state)
+		if err != nil {
+			return "", feedState{}, fmt.Errorf("reading feed state %s: %w", path, err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, id := range state.Seen {
+		seen[id] = true
+	}
+	var entries []FeedEntry
+	for _, e := range feed.Entries {
+		if len(entries) == FeedMaxEntries {
+			break
+		}
+		if seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+		state.Seen = append(state.Seen, e.ID)
+		entries = append(entries, e)
+	}
+	if len(state.Seen) > feedMaxSeen {
+		state.Seen = state.Seen[len(state.Seen)-feedMaxSeen:]
+	}
+	return formatFeed(feed.Title, entries), state, nil
+}
+
+// save records the feed's entries as read.
+func (s feedState) save() error {
+	path, err := feedStatePath(s.URL)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeState(path, data)
+}
+
+// saveFeedReads records the entries of the feeds the client has read as
+// read, once they have been used, so that entries aren't lost to a failed
+// completion.
+func (c *ChatGPTClient) saveFeedReads() {
+	for _, s := range c.feedReads {
+		err := s.save()
+		if err != nil {
+			c.LogErr(err)
+		}
+	}
+	c.feedReads = nil
+}
+
+// formatFeed returns the text of a feed's entries.
+func formatFeed(title string, entries []FeedEntry) string {
+	text := new(strings.Builder)
+	if len(entries) == 0 {
+		fmt.Fprintf(text, "The feed %s has no new entries.\n", title)
+		return text.String()
+	}
+	fmt.Fprintf(text, "New entries in the feed %s:\n", title)
+	for _, e := range entries {
+		fmt.Fprintf(text, "\n%s\n", e.Title)
+		if e.Published != "" {
+			fmt.Fprintf(text, "Published: %s\n", e.Published)
+		}
+		if e.Link != "" {
+			fmt.Fprintf(text, "Link: %s\n", e.Link)
+		}
+		if e.Summary != "" {
+			fmt.Fprintf(text, "%s\n", e.Summary)
+		}
+	}
+	return text.String()
+}
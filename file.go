@@ -2,11 +2,17 @@ package chatproxy
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cixtor/readability"
@@ -15,18 +21,29 @@ import (
 // MessageFromFile reads the contents of a file, and returns a formatted
 // message with the file name and content, as well as an estimation of
 // the token count. This function enables the bot to include file
-// contents when sending messages to the user.
+// contents when sending messages to the user. Files matched by one of
+// the DefaultPreprocessors are transformed rather than included verbatim.
 func MessageFromFile(path string) (message string, tokenLen int, err error) {
-	file, err := os.Open(path)
+	return messageFromFile(path, DefaultPreprocessors())
+}
+
+func messageFromFile(path string, preprocessors []Preprocessor) (message string, tokenLen int, err error) {
+	raw, err := os.ReadFile(path)
 	if err != nil {
 		return "", 0, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	content := ""
-	for scanner.Scan() {
-		content += scanner.Text() + "\n"
+	content, ok, err := preprocess(preprocessors, path, raw)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		b := new(strings.Builder)
+		for scanner.Scan() {
+			b.WriteString(scanner.Text())
+			b.WriteString("\n")
+		}
+		content = b.String()
 	}
 
 	message = fmt.Sprintf("--%s--\n%s\n", path, content)
@@ -34,19 +51,31 @@ func MessageFromFile(path string) (message string, tokenLen int, err error) {
 	return message, tokenLen, nil
 }
 
+// MaxFileReaders bounds the number of files MessageFromFiles reads concurrently.
+var MaxFileReaders = runtime.NumCPU()
+
 // MessageFromFiles reads the contents of multiple files in a directory,
 // and returns a combined formatted message with file names and contents.
 // This function allows the bot to send messages with content from multiple
-// files at once to the user without making multiple calls.
+// files at once to the user without making multiple calls. Files are read
+// concurrently, but appear in the message in directory walk order. Files
+// matching the ignore patterns in the config are skipped.
 func (c *ChatGPTClient) MessageFromFiles(path string) (string, error) {
-	message := ""
-	totalTokenLength := 0
-
+	root := path
+	var paths []string
 	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
+		// Ignore files matching the config's ignore patterns
+		if path != root && c.config.Ignored(root, path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Ignore hidden files
 		if filepath.Base(path)[0] == '.' {
 			if info.IsDir() {
@@ -56,13 +85,7 @@ func (c *ChatGPTClient) MessageFromFiles(path string) (string, error) {
 		}
 
 		if !info.IsDir() { // check if it's a file and not a directory
-			m, tl, err := MessageFromFile(path)
-			if err != nil {
-				return err
-			}
-			fmt.Fprintf(c.output, "Tokens: %d -> %s\n", tl, path)
-			message += m
-			totalTokenLength += tl
+			paths = append(paths, path)
 		}
 
 		return nil
@@ -70,21 +93,141 @@ func (c *ChatGPTClient) MessageFromFiles(path string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	fmt.Fprintf(c.output, "Estimated Total Tokens: %d\n", totalTokenLength)
 
-	return message, nil
+	type result struct {
+		message  string
+		tokenLen int
+		err      error
+	}
+	results := make([]result, len(paths))
+	jobs := make(chan int)
+	workers := MaxFileReaders
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				m, tl, err := messageFromFile(paths[i], c.preprocessors)
+				results[i] = result{m, tl, err}
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	message := new(strings.Builder)
+	totalTokenLength := 0
+	for i, r := range results {
+		if r.err != nil {
+			return "", r.err
+		}
+		c.progressf("Tokens: %d -> %s\n", r.tokenLen, paths[i])
+		message.WriteString(r.message)
+		totalTokenLength += r.tokenLen
+	}
+	c.progressf("Estimated Total Tokens: %d\n", totalTokenLength)
+
+	return message.String(), nil
 }
 
+// ErrFileExists is returned by MessageToFile when the destination already exists.
+var ErrFileExists = errors.New("file already exists")
+
 // MessageToFile writes the given content string to a file with the
 // specified path. This function enables the bot to save conversation
-// logs in a file or write user-generated content to a file.
+// logs in a file or write user-generated content to a file. Missing
+// parent directories are created, and an existing file is never
+// clobbered; ErrFileExists is returned instead.
 func MessageToFile(content string, path string) error {
-	file, err := os.Create(path)
+	_, err := os.Stat(path)
+	if err == nil {
+		return fmt.Errorf("%s: %w", path, ErrFileExists)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return OverwriteFile(content, path)
+}
+
+// OverwriteFile writes the given content string to a file with the
+// specified path, replacing any existing file. The content is written to
+// a temporary file which is then renamed into place, so readers never
+// observe a partially written file.
+func OverwriteFile(content string, path string) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = fmt.Fprintln(tmp, content)
 	if err != nil {
+		tmp.Close()
 		return err
 	}
-	fmt.Fprintln(file, content)
-	return nil
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	// Keep the mode of the file being replaced, so that overwriting a
+	// private or executable file doesn't change who can read or run it.
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MessageFromStdin reads the client's input until EOF, and returns its
+// contents. This allows content to be piped into the bot from other commands.
+func (c *ChatGPTClient) MessageFromStdin() (string, error) {
+	content, err := io.ReadAll(c.input)
+	if err != nil {
+		return "", err
+	}
+	if len(content) == 0 {
+		return "", errors.New("no content received on stdin")
+	}
+	c.progressf("Tokens: %d -> %s\n", guessTokens(string(content)), "stdin")
+	return string(content), nil
+}
+
+// PipedInput returns the content piped or redirected to the client's input.
+// It reports false, without reading anything, if the input is a terminal, and
+// also if nothing was piped. Readers given to WithInput count as piped.
+func (c *ChatGPTClient) PipedInput() (string, bool, error) {
+	if f, ok := c.inputSource.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return "", false, nil
+		}
+		if info.Mode()&os.ModeNamedPipe == 0 && !info.Mode().IsRegular() {
+			return "", false, nil
+		}
+	}
+	content, err := io.ReadAll(c.input)
+	if err != nil {
+		return "", false, err
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return "", false, nil
+	}
+	return string(content), true, nil
 }
 
 func guessTokens(input string) int {
@@ -100,11 +243,67 @@ func CreateAuditLog() (*os.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	return createAuditLog(auditLogDir)
+}
+
+func createAuditLog(auditLogDir string) (*os.File, error) {
 	dateTimeString := time.Now().Format("2006-01-02_15-04-05")
 	return os.Create(filepath.Join(auditLogDir, fmt.Sprintf("%s.log", dateTimeString)))
 }
 
+// openTranscript opens the transcript for a new client as configured: a
+// new audit log, in the configured directory if there is one, JSON on
+// the error stream in stateless mode, or nothing at all if transcripts are disabled.
+func (c *ChatGPTClient) openTranscript() (io.Writer, error) {
+	if c.transcriptDisabled {
+		return io.Discard, nil
+	}
+	if stateless() {
+		return &jsonTranscript{w: c.errorStream, command: c.command}, nil
+	}
+	dir := c.transcriptDir
+	if dir == "" {
+		var err error
+		dir, err = getAuditLogDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	file, err := createAuditLog(dir)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 func getAuditLogDir() (string, error) {
+	return getStateDir("audit_logs")
+}
+
+// getStateDir returns, creating if needed, a directory for storing the
+// application's persistent state.
+func getStateDir(name string) (string, error) {
+	appStateDir, err := stateDirPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	// Create your application's specific directory for storing state
+	err = os.MkdirAll(appStateDir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	return appStateDir, nil
+}
+
+// stateDirPath returns the path of the named directory of the application's
+// XDG state directory, without creating it.
+func stateDirPath(name string) (string, error) {
 	// Use XDG_STATE_HOME if available, otherwise fallback to default
 	xdgStateHome := os.Getenv("XDG_STATE_HOME")
 	if xdgStateHome == "" {
@@ -114,20 +313,35 @@ func getAuditLogDir() (string, error) {
 		}
 		xdgStateHome = filepath.Join(home, ".local", "state")
 	}
+	return filepath.Join(xdgStateHome, "chatproxy", name), nil
+}
 
-	// Create your application's specific directory for storing audit logs
-	appAuditLogDir := filepath.Join(xdgStateHome, "chatproxy", "audit_logs")
-	err := os.MkdirAll(appAuditLogDir, 0700)
-	if err != nil {
-		return "", err
+// getConfigDir returns the directory holding the application's user
+// configuration, honouring XDG_CONFIG_HOME. The directory may not exist.
+func getConfigDir() (string, error) {
+	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	if xdgConfigHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		xdgConfigHome = filepath.Join(home, ".config")
 	}
-
-	return appAuditLogDir, nil
+	return filepath.Join(xdgConfigHome, "chatproxy"), nil
 }
 
-// GetContent takes a path, checks if it is a file or URL, and returns the
-// contents of the file or the text of the URL.
+// StdinPath is the path that, when passed to GetContent, reads content from
+// the client's input instead of a file or URL, enabling shell pipelines.
+const StdinPath = "-"
+
+// GetContent takes a path, checks if it is stdin, a file or URL, and returns the
+// contents of the input, the file or the text of the URL. An RSS or Atom feed
+// gives only the entries that are new since it was last read, which are
+// recorded as read by the next successful completion.
 func (c *ChatGPTClient) GetContent(path string) (msg string, err error) {
+	if path == StdinPath {
+		return c.MessageFromStdin()
+	}
 	_, err = os.Stat(path)
 	if err == nil {
 		msg, err = c.MessageFromFiles(path)
@@ -144,8 +358,20 @@ func (c *ChatGPTClient) GetContent(path string) (msg string, err error) {
 			return "", err
 		}
 		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, MaxUploadBytes))
+		if err != nil {
+			return "", err
+		}
+		if isFeed(resp.Header.Get("Content-Type"), body) {
+			text, state, err := newFeedEntries(path, body)
+			if err != nil {
+				return "", err
+			}
+			c.feedReads = append(c.feedReads, state)
+			return text, nil
+		}
 		r := readability.New()
-		article, err := r.Parse(resp.Body, path)
+		article, err := r.Parse(bytes.NewReader(body), path)
 		if err != nil {
 			return "", err
 		}
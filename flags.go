@@ -0,0 +1,207 @@
+package chatproxy
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// commandFlags are the flags shared by every command, so that the client
+// can be tuned when a command is run rather than through the environment.
+type commandFlags struct {
+	*flag.FlagSet
+	model        string
+	provider     string
+	profile      string
+	stream       bool
+	temperature  float64
+	noTranscript bool
+	plain        bool
+	usage        bool
+	dryRun       bool
+	yes          bool
+	quiet        bool
+	verbose      bool
+	maskPII      bool
+	output       string
+	timeout      time.Duration
+	webhooks     stringList
+	server       bool
+}
+
+// newCommandFlags returns the standard flags for the named command, whose
+// help is taken from its entry in Commands.
+func newCommandFlags(name string) *commandFlags {
+	f := &commandFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.StringVar(&f.model, "model", "", "chat model to use, such as gpt-4")
+	f.StringVar(&f.provider, "provider", "", "API provider: "+strings.Join(Providers, ", ")+" (default from the config)")
+	f.StringVar(&f.profile, "profile", "", "credential profile from the config file")
+	f.BoolVar(&f.stream, "stream", false, "print the reply as it is generated")
+	f.Float64Var(&f.temperature, "temperature", 0, "sampling temperature between 0 and 2; lower is more deterministic")
+	f.BoolVar(&f.noTranscript, "no-transcript", false, "don't record a transcript")
+	f.BoolVar(&f.plain, "plain", false, "print replies as raw markdown")
+	f.BoolVar(&f.usage, "usage", false, "report token usage and estimated cost")
+	f.BoolVar(&f.dryRun, "dry-run", false, "print the prompt and its estimated cost instead of sending it")
+	f.BoolVar(&f.yes, "yes", false, "send expensive prompts without asking")
+	f.BoolVar(&f.yes, "y", false, "shorthand for --yes")
+	f.BoolVar(&f.quiet, "quiet", false, "don't print progress information")
+	f.BoolVar(&f.quiet, "q", false, "shorthand for --quiet")
+	f.BoolVar(&f.verbose, "verbose", false, "print details of each request")
+	f.BoolVar(&f.verbose, "v", false, "shorthand for --verbose")
+	f.BoolVar(&f.maskPII, "mask-pii", false, "mask emails, phone numbers and names in prompts before sending them")
+	f.DurationVar(&f.timeout, "timeout", DefaultTimeout, "give up on a request after this long, such as 90s; 0 waits forever")
+	f.Usage = func() {
+		showHelp(f.Output(), name, f.FlagSet)
+	}
+	return f
+}
+
+// addOutputFlag adds the --output flag, for commands that can print their
+// result as JSON.
+func (f *commandFlags) addOutputFlag() *commandFlags {
+	f.StringVar(&f.output, "output", OutputText, "output format: text or json")
+	return f
+}
+
+// addWebhookFlag adds the --webhook flag, for server commands, which post
+// their completions to the webhooks it gives, or else those in the config.
+func (f *commandFlags) addWebhookFlag() *commandFlags {
+	f.Var(&f.webhooks, "webhook", "`URL` to post each completion to as JSON; may be repeated (default from the config)")
+	f.server = true
+	return f
+}
+
+// parse parses the flags in args, which start with the command name. Flags
+// may come before, after or between the positional arguments, which are
+// returned. Arguments after -- are never treated as flags.
+func (f *commandFlags) parse(args []string) ([]string, error) {
+	var positional []string
+	args = args[1:]
+	for {
+		err := f.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := f.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	if f.temperature < 0 || f.temperature > 2 {
+		err := errors.New("temperature must be between 0 and 2")
+		fmt.Fprintln(f.Output(), err)
+		return nil, err
+	}
+	if f.timeout < 0 {
+		err := errors.New("timeout must not be negative")
+		fmt.Fprintln(f.Output(), err)
+		return nil, err
+	}
+	if f.provider != "" && !containsString(Providers, f.provider) {
+		err := fmt.Errorf("unknown provider %q, expected one of %s", f.provider, strings.Join(Providers, ", "))
+		fmt.Fprintln(f.Output(), err)
+		return nil, err
+	}
+	if f.quiet && f.verbose {
+		err := errors.New("--quiet and --verbose can't be used together")
+		fmt.Fprintln(f.Output(), err)
+		return nil, err
+	}
+	for _, webhook := range f.webhooks {
+		err := validateWebhook(webhook)
+		if err != nil {
+			fmt.Fprintln(f.Output(), err)
+			return nil, err
+		}
+	}
+	if f.output != "" {
+		_, err := parseOutputFormat(f.output)
+		if err != nil {
+			fmt.Fprintln(f.Output(), err)
+			return nil, err
+		}
+	}
+	return positional, nil
+}
+
+// options returns client options for the flags that were given. Flags that
+// weren't given leave the command's defaults and the config file in effect.
+func (f *commandFlags) options() []ClientOption {
+	var opts []ClientOption
+	// The provider comes first, as it changes the model, which --model
+	// overrides.
+	if f.provider != "" {
+		opts = append(opts, WithProvider(f.provider))
+	}
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "model":
+			opts = append(opts, WithModel(f.model))
+		case "profile":
+			opts = append(opts, WithProfile(f.profile))
+		case "stream":
+			opts = append(opts, WithStreaming(f.stream))
+		case "temperature":
+			opts = append(opts, WithTemperature(float32(f.temperature)))
+		case "no-transcript":
+			if f.noTranscript {
+				opts = append(opts, WithTranscript(io.Discard))
+			}
+		case "usage":
+			opts = append(opts, WithUsageReport(f.usage))
+		case "dry-run":
+			opts = append(opts, WithDryRun(f.dryRun))
+		case "yes", "y":
+			opts = append(opts, WithAssumeYes(f.yes))
+		case "quiet", "q":
+			if f.quiet {
+				opts = append(opts, WithVerbosity(VerbosityQuiet))
+			}
+		case "verbose", "v":
+			if f.verbose {
+				opts = append(opts, WithVerbosity(VerbosityVerbose))
+			}
+		case "mask-pii":
+			opts = append(opts, WithPIIMasking(f.maskPII))
+		case "timeout":
+			opts = append(opts, WithTimeout(f.timeout))
+		case "webhook":
+			opts = append(opts, WithWebhooks(f.webhooks...))
+		}
+	})
+	if f.server {
+		opts = append(opts, withConfigWebhooks(), asServer())
+	}
+	return opts
+}
+
+// stringList is a flag that can be given more than once, collecting every
+// value in order.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// parseExitCode returns the exit code for a failure to parse flags: zero
+// if help was asked for, or else the code for a usage error.
+func parseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitUsage
+}
@@ -0,0 +1,105 @@
+package chatproxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Flashcard is a question and its answer, generated by Card.
+type Flashcard struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// Formats that flashcards can be exported in.
+const (
+	// CardFormatText is the Question:/Answer: format the cards are
+	// generated in, separated by --- lines.
+	CardFormatText = "text"
+	// CardFormatTSV is tab separated values with HTML fields, which Anki
+	// imports directly.
+	CardFormatTSV = "tsv"
+	// CardFormatCSV is comma separated values with a header row.
+	CardFormatCSV = "csv"
+	// CardFormatJSON is a JSON array of cards.
+	CardFormatJSON = "json"
+)
+
+// CardFormats are the formats accepted by WriteFlashcards.
+var CardFormats = []string{CardFormatText, CardFormatTSV, CardFormatCSV, CardFormatJSON}
+
+// ParseFlashcards parses cards in the format Card asks for: a Question:
+// line and an Answer:, which may continue over several lines, with cards
+// separated by --- lines. Text that isn't a question and answer is skipped.
+func ParseFlashcards(text string) []Flashcard {
+	var cards []Flashcard
+	for _, block := range strings.Split(text, "---") {
+		var card Flashcard
+		var field *string
+		for _, line := range strings.Split(block, "\n") {
+			line = strings.TrimSpace(line)
+			if q, ok := strings.CutPrefix(line, "Question:"); ok {
+				card.Question, field = strings.TrimSpace(q), &card.Question
+			} else if a, ok := strings.CutPrefix(line, "Answer:"); ok {
+				card.Answer, field = strings.TrimSpace(a), &card.Answer
+			} else if field != nil && line != "" {
+				*field += "\n" + line
+			}
+		}
+		if card.Question != "" && card.Answer != "" {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+// WriteFlashcards writes cards to w in one of the CardFormats.
+func WriteFlashcards(w io.Writer, cards []Flashcard, format string) error {
+	switch format {
+	case CardFormatText:
+		for _, card := range cards {
+			_, err := fmt.Fprintf(w, "Question: %s\nAnswer: %s\n---\n", card.Question, card.Answer)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case CardFormatTSV:
+		_, err := fmt.Fprint(w, "#separator:tab\n#html:true\n")
+		if err != nil {
+			return err
+		}
+		for _, card := range cards {
+			_, err := fmt.Fprintf(w, "%s\t%s\n", ankiField(card.Question), ankiField(card.Answer))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case CardFormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"question", "answer"})
+		for _, card := range cards {
+			cw.Write([]string{card.Question, card.Answer})
+		}
+		cw.Flush()
+		return cw.Error()
+	case CardFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(cards)
+	}
+	return fmt.Errorf("unknown card format %q, expected one of %s", format, strings.Join(CardFormats, ", "))
+}
+
+// ankiField escapes s as an HTML field, which can't contain tabs or
+// newlines in Anki's import format.
+func ankiField(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "\t", " ")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
@@ -0,0 +1,481 @@
+package chatproxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// GatewayConfig sets who may use the gateway and how often, in the user
+// config:
+//
+//	gateway:
+//	  rate_limit: 60
+//	  keys:
+//	    alice: cpk-3f9a...
+//	    ci: cpk-81c2...
+//
+// Callers send one of the keys, by name, as their API key, and the gateway
+// replaces it with the real one. The rate limit is the most requests a
+// caller may make in a minute; without one, there is no limit.
+type GatewayConfig struct {
+	Keys      map[string]string `yaml:"keys"`
+	RateLimit int               `yaml:"rate_limit"`
+}
+
+func (cfg GatewayConfig) validate() error {
+	if cfg.RateLimit < 0 {
+		return fmt.Errorf("gateway.rate_limit %d must not be negative", cfg.RateLimit)
+	}
+	seen := map[string]string{}
+	for name, key := range cfg.Keys {
+		if key == "" {
+			return fmt.Errorf("gateway.keys.%s is empty", name)
+		}
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("gateway.keys.%s is the same as gateway.keys.%s", name, other)
+		}
+		seen[key] = name
+	}
+	return nil
+}
+
+// GatewayPath is the path of the chat completions API the gateway serves,
+// so that an OpenAI SDK can use it with a base URL ending in /v1.
+const GatewayPath = "/v1/chat/completions"
+
+// GatewayServer is an OpenAI compatible API that forwards chat completion
+// requests to the provider the client is configured for, so that a team
+// can point their SDKs at it. It injects the client's API key, so that
+// callers don't need one of their own, records each exchange in the
+// client's transcript, and limits how often each caller may make requests.
+type GatewayServer struct {
+	// Keys maps the name of each caller to the key it sends as its API key.
+	// With no keys, anyone who can reach the gateway may use it.
+	Keys map[string]string
+	// RateLimit is the most requests a caller may make in a minute, or 0
+	// for no limit.
+	RateLimit int
+
+	client   *ChatGPTClient
+	upstream *url.URL
+	key      string
+	org      string
+	proxy    *httputil.ReverseProxy
+	mu       sync.Mutex
+	requests map[string][]time.Time
+}
+
+// NewGatewayServer returns a GatewayServer that forwards requests to the
+// client's base URL with its API key, taking the callers' keys and rate
+// limit from the gateway section of the config.
+func NewGatewayServer(c *ChatGPTClient) (*GatewayServer, error) {
+	key, org, err := c.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := url.Parse(c.apiBaseURL())
+	if err != nil {
+		return nil, err
+	}
+	g := &GatewayServer{
+		Keys:      c.config.Gateway.Keys,
+		RateLimit: c.config.Gateway.RateLimit,
+		client:    c,
+		upstream:  upstream,
+		key:       key,
+		org:       org,
+		requests:  map[string][]time.Time{},
+	}
+	g.proxy = &httputil.ReverseProxy{Director: g.direct, FlushInterval: -1}
+	if c.httpClient != nil {
+		g.proxy.Transport = c.httpClient.Transport
+	}
+	return g, nil
+}
+
+// direct sends a request on to the provider's chat completions API, with
+// the gateway's key in place of the caller's.
+func (g *GatewayServer) direct(r *http.Request) {
+	r.URL.Scheme = g.upstream.Scheme
+	r.URL.Host = g.upstream.Host
+	r.URL.Path = g.upstream.Path + "/chat/completions"
+	r.URL.RawPath = ""
+	r.Host = g.upstream.Host
+	r.Header.Set("Authorization", "Bearer "+g.key)
+	r.Header.Del("OpenAI-Organization")
+	if g.org != "" {
+		r.Header.Set("OpenAI-Organization", g.org)
+	}
+	if g.client.provider == "openrouter" {
+		setOpenRouterHeaders(r.Header)
+	}
+	// Don't tell the provider who the callers are.
+	r.Header["X-Forwarded-For"] = nil
+	// The reply is read to record and moderate it, so it mustn't come back
+	// compressed for the caller; the transport asks for and decodes gzip
+	// itself.
+	r.Header.Del("Accept-Encoding")
+}
+
+// gatewayRequest is the part of a chat completion request the gateway
+// records. Content is either a string or, from newer SDKs, a list of parts.
+type gatewayRequest struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+// ServeHTTP checks the caller's key and rate limit, records the request's
+// last message, forwards it, and records the reply, posting it to the
+// client's webhooks and counting it in the client's metrics.
+func (g *GatewayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	caller := ""
+	rec := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	defer func() {
+		g.client.metrics.ObserveRequest("gateway", caller, strconv.Itoa(rec.status), time.Since(start))
+	}()
+	if r.URL.Path != GatewayPath {
+		writeAPIError(w, http.StatusNotFound, "invalid_request_error", "only "+GatewayPath+" is served")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed")
+		return
+	}
+	caller, ok := g.authorize(r)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "invalid_request_error", "incorrect API key for this gateway")
+		return
+	}
+	if wait, ok := g.allow(caller); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limit_error", fmt.Sprintf("rate limit of %d requests a minute reached", g.RateLimit))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxUploadBytes))
+	if err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", err.Error())
+		return
+	}
+	var req gatewayRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil || len(req.Messages) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", "the body must be a chat completion request with messages")
+		return
+	}
+	conv := g.client.newConversation()
+	if conv.redactPrompts || conv.maskPII {
+		body, err = rewriteContent(body, func(s string) string {
+			if conv.redactPrompts {
+				s = conv.redact(s)
+			}
+			return conv.maskPIIIn(s)
+		})
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request_error", "the body must be a chat completion request with messages")
+			return
+		}
+	}
+	last := req.Messages[len(req.Messages)-1]
+	role := last.Role
+	if role != RoleSystem && role != RoleBot {
+		role = RoleUser
+	}
+	g.log(RoleSystem, fmt.Sprintf("Gateway request from %s for %s", caller, req.Model))
+	g.log(role, messageText(last.Content))
+	err = conv.moderate(conv.moderatePrompts, "prompt", gatewayPrompt(body))
+	if err != nil {
+		g.refuse(w, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if conv.moderateReplies != ModerationBlock {
+		g.proxy.ServeHTTP(rec, r)
+	} else {
+		// The reply is held back until it has been checked, so it isn't
+		// streamed.
+		held := &heldResponse{header: http.Header{}, status: http.StatusOK}
+		g.proxy.ServeHTTP(held, r)
+		if held.status == http.StatusOK {
+			err = conv.moderate(conv.moderateReplies, "reply", gatewayReply(held.body.Bytes(), req.Stream))
+			if err != nil {
+				g.refuse(w, err)
+				return
+			}
+		}
+		held.release(rec)
+	}
+	if rec.status != http.StatusOK {
+		g.log(RoleSystem, fmt.Sprintf("Gateway request failed: %d %s", rec.status, http.StatusText(rec.status)))
+		return
+	}
+	reply := gatewayReply(rec.body.Bytes(), req.Stream)
+	g.log(RoleBot, reply)
+	if conv.moderateReplies == ModerationFlag {
+		conv.moderate(conv.moderateReplies, "reply", reply)
+	}
+	texts := make([]string, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		texts = append(texts, messageText(m.Content))
+	}
+	usage := EstimateUsage(req.Model, strings.Join(texts, "\n"), reply)
+	g.client.metrics.ObserveUsage(req.Model, usage)
+	if len(g.client.webhooks) > 0 {
+		g.client.sendWebhooks(WebhookEvent{
+			Time:    time.Now(),
+			Command: g.client.command,
+			Caller:  caller,
+			Model:   req.Model,
+			Prompt:  conv.maskPIIIn(messageText(last.Content)),
+			Reply:   reply,
+			Usage:   usage,
+		})
+	}
+}
+
+// refuse replies with the error that stopped a request being forwarded, or
+// its reply returned, and records it in the transcript.
+func (g *GatewayServer) refuse(w http.ResponseWriter, err error) {
+	g.log(RoleSystem, "Gateway request refused: "+err.Error())
+	status, kind := apiErrorStatus(err)
+	writeAPIError(w, status, kind, err.Error())
+}
+
+// authorize returns the name of the caller whose key the request carries.
+// With no keys configured, every caller is anonymous.
+func (g *GatewayServer) authorize(r *http.Request) (string, bool) {
+	return callerFor(g.Keys, r.Header.Get("Authorization"))
+}
+
+// callerFor returns the name of the caller whose key is the bearer token in
+// an Authorization header. With no keys, every caller is anonymous.
+func callerFor(keys map[string]string, authorization string) (string, bool) {
+	if len(keys) == 0 {
+		return "anonymous", true
+	}
+	given, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return "", false
+	}
+	for name, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// allow records a request from caller if it is within the rate limit, or
+// otherwise returns how long until it would be.
+func (g *GatewayServer) allow(caller string) (time.Duration, bool) {
+	if g.RateLimit <= 0 {
+		return 0, true
+	}
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var recent []time.Time
+	for _, t := range g.requests[caller] {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= g.RateLimit {
+		g.requests[caller] = recent
+		return recent[0].Add(time.Minute).Sub(now), false
+	}
+	g.requests[caller] = append(recent, now)
+	return 0, true
+}
+
+// log records a message in the client's transcript, one request at a time.
+func (g *GatewayServer) log(role, message string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.client.Log(role, message)
+}
+
+// messageText returns the text of a message's content, whether it is a
+// string or a list of parts.
+func messageText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(content, &parts)
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// gatewayPrompt returns the text of the messages of a chat completion
+// request that the user has sent since the last reply.
+func gatewayPrompt(body []byte) string {
+	var req gatewayRequest
+	json.Unmarshal(body, &req)
+	var prompt []string
+	for i := len(req.Messages) - 1; i >= 0 && req.Messages[i].Role != RoleBot; i-- {
+		if req.Messages[i].Role == RoleUser {
+			prompt = append([]string{messageText(req.Messages[i].Content)}, prompt...)
+		}
+	}
+	return strings.Join(prompt, "\n\n")
+}
+
+// rewriteContent returns a chat completion request body with the text of
+// each message's content passed through transform, and the rest of the
+// request as the caller sent it.
+func rewriteContent(body []byte, transform func(string) string) ([]byte, error) {
+	var req map[string]json.RawMessage
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, err
+	}
+	var messages []map[string]json.RawMessage
+	err = json.Unmarshal(req["messages"], &messages)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		content, ok := m["content"]
+		if !ok || string(content) == "null" {
+			continue
+		}
+		var text string
+		if json.Unmarshal(content, &text) == nil {
+			m["content"], _ = json.Marshal(transform(text))
+			continue
+		}
+		var parts []map[string]any
+		if json.Unmarshal(content, &parts) != nil {
+			continue
+		}
+		for _, part := range parts {
+			if text, ok := part["text"].(string); ok && part["type"] == "text" {
+				part["text"] = transform(text)
+			}
+		}
+		m["content"], _ = json.Marshal(parts)
+	}
+	req["messages"], err = json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(req)
+}
+
+// gatewayReply returns the text of the first choice in a chat completion,
+// or in a stream of server-sent chunks of one.
+func gatewayReply(body []byte, stream bool) string {
+	if !stream {
+		var resp openai.ChatCompletionResponse
+		json.Unmarshal(body, &resp)
+		if len(resp.Choices) == 0 {
+			return ""
+		}
+		return resp.Choices[0].Message.Content
+	}
+	reply := new(strings.Builder)
+	for _, line := range strings.Split(string(body), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		data = strings.TrimSpace(data)
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionStreamResponse
+		if json.Unmarshal([]byte(data), &chunk) == nil && len(chunk.Choices) > 0 {
+			reply.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	return reply.String()
+}
+
+// capturingWriter keeps a copy of a response as it is written, flushing
+// each write so that streamed replies reach the caller as they arrive.
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *capturingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// heldResponse keeps a whole response, so that it can be checked before
+// it is released to the caller.
+type heldResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (h *heldResponse) Header() http.Header {
+	return h.header
+}
+
+func (h *heldResponse) WriteHeader(status int) {
+	h.status = status
+}
+
+func (h *heldResponse) Write(p []byte) (int, error) {
+	return h.body.Write(p)
+}
+
+// release writes the response held to w.
+func (h *heldResponse) release(w http.ResponseWriter) {
+	for k, v := range h.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(h.status)
+	w.Write(h.body.Bytes())
+}
+
+// writeAPIError replies with an error in the form the OpenAI API uses, so
+// that SDKs report it.
+func writeAPIError(w http.ResponseWriter, status int, kind, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message, "type": kind},
+	})
+}
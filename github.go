@@ -0,0 +1,258 @@
+package chatproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// DefaultGitHubAPI is the GitHub REST API used unless GITHUB_API_URL is
+// set, as it is in GitHub Actions and for GitHub Enterprise Server.
+const DefaultGitHubAPI = "https://api.github.com"
+
+// GitHub is a client for the parts of the GitHub REST API that the git
+// tools use to post what they write: pull request descriptions, reviews and
+// issue comments. Reading a public repository needs no token, but posting
+// to one does.
+type GitHub struct {
+	// Repo is the repository, as owner/name.
+	Repo       string
+	Token      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewGitHub returns a GitHub client for repo, given as owner/name, or if
+// repo is empty, for the repository the origin remote is on. The token is
+// read from GITHUB_TOKEN, and the API from GITHUB_API_URL.
+func NewGitHub(repo string) (*GitHub, error) {
+	if repo == "" {
+		out, err := exec.Command("git", "remote", "get-url", "origin").Output()
+		if err != nil {
+			return nil, fmt.Errorf("no origin remote to find the GitHub repository from; give it as owner/name")
+		}
+		repo, err = ParseRepoURL(strings.TrimSpace(string(out)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.Count(repo, "/") != 1 {
+		return nil, fmt.Errorf("%q isn't a repository of the form owner/name", repo)
+	}
+	baseURL := os.Getenv("GITHUB_API_URL")
+	if baseURL == "" {
+		baseURL = DefaultGitHubAPI
+	}
+	return &GitHub{Repo: repo, Token: os.Getenv("GITHUB_TOKEN"), BaseURL: baseURL}, nil
+}
+
+var repoURL = regexp.MustCompile(`([^/:]+/[^/]+?)(?:\.git)?/?$`)
+
+// ParseRepoURL returns the repository a git remote URL is for, as
+// owner/name, from an SSH URL such as git@github.com:owner/name.git or an
+// HTTPS one such as https://github.com/owner/name.
+func ParseRepoURL(url string) (string, error) {
+	m := repoURL.FindStringSubmatch(url)
+	if m == nil || !strings.ContainsAny(url, ":/") {
+		return "", fmt.Errorf("can't tell the repository from the remote %q", url)
+	}
+	return m[1], nil
+}
+
+// do sends a request to the API, encoding body as JSON if it isn't nil,
+// and decodes the reply into result if it isn't nil. Requests that change
+// anything need a token.
+func (g *GitHub) do(method, path string, body, result any) error {
+	if g.Token == "" && method != http.MethodGet {
+		return fmt.Errorf("%w: set GITHUB_TOKEN to post to GitHub", ErrUnauthorized)
+	}
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.Token != "" {
+		header.Set("Authorization", "Bearer "+g.Token)
+	}
+	return doJSON(g.HTTPClient, method, strings.TrimSuffix(g.BaseURL, "/")+path, header, body, result)
+}
+
+// doJSON sends a request to a JSON API, with the header given and body
+// encoded as JSON if it isn't nil, and decodes the reply into result if it
+// isn't nil. An error reply is reported with the API's message, as
+// ErrUnauthorized or ErrRateLimited where it is one of those.
+func doJSON(hc *http.Client, method, url string, header http.Header, body, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message json.RawMessage `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		msg := string(apiErr.Message)
+		var text string
+		if json.Unmarshal(apiErr.Message, &text) == nil {
+			msg = text
+		}
+		err := fmt.Errorf("%s %s: %s %s", method, req.URL.Path, resp.Status, msg)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		case http.StatusTooManyRequests:
+			return fmt.Errorf("%w: %v", ErrRateLimited, err)
+		}
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// posted is the part of the API's reply to a post that says where it is.
+type posted struct {
+	HTMLURL string `json:"html_url"`
+}
+
+// CreatePullRequest opens a pull request to merge the branch head into
+// base, returning its URL.
+func (g *GitHub) CreatePullRequest(head, base string, pr PullRequest) (string, error) {
+	var created posted
+	err := g.do(http.MethodPost, "/repos/"+g.Repo+"/pulls", map[string]string{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"head":  head,
+		"base":  base,
+	}, &created)
+	return created.HTMLURL, err
+}
+
+// UpdatePullRequest replaces the title and description of a pull request,
+// returning its URL.
+func (g *GitHub) UpdatePullRequest(number int, pr PullRequest) (string, error) {
+	var updated posted
+	err := g.do(http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", g.Repo, number), map[string]string{
+		"title": pr.Title,
+		"body":  pr.Body,
+	}, &updated)
+	return updated.HTMLURL, err
+}
+
+// CreateReview posts the findings as a review of a pull request, with a
+// comment on the line of each one, returning the review's URL.
+func (g *GitHub) CreateReview(number int, findings []Finding) (string, error) {
+	var created posted
+	err := g.do(http.MethodPost, fmt.Sprintf("/repos/%s/pulls/%d/reviews", g.Repo, number), githubReview(findings), &created)
+	return created.HTMLURL, err
+}
+
+// Comment adds a comment to an issue or pull request, returning its URL.
+func (g *GitHub) Comment(number int, body string) (string, error) {
+	var created posted
+	err := g.do(http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", g.Repo, number), map[string]string{"body": body}, &created)
+	return created.HTMLURL, err
+}
+
+// GitHubIssue is an issue, or a pull request, with its discussion.
+type GitHubIssue struct {
+	Number   int            `json:"number"`
+	Title    string         `json:"title"`
+	Body     string         `json:"body"`
+	Author   string         `json:"author"`
+	State    string         `json:"state"`
+	Labels   []string       `json:"labels"`
+	Comments []IssueComment `json:"comments,omitempty"`
+}
+
+// IssueComment is a comment on an issue.
+type IssueComment struct {
+	Author string `json:"author"`
+	Body   string `json:"body"`
+}
+
+// apiIssue is an issue as the GitHub API returns it.
+type apiIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	State  string `json:"state"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+func (gi apiIssue) issue() GitHubIssue {
+	issue := GitHubIssue{Number: gi.Number, Title: gi.Title, Body: gi.Body, Author: gi.User.Login, State: gi.State}
+	for _, label := range gi.Labels {
+		issue.Labels = append(issue.Labels, label.Name)
+	}
+	return issue
+}
+
+// Issue returns an issue with its comments.
+func (g *GitHub) Issue(number int) (GitHubIssue, error) {
+	var gi apiIssue
+	err := g.do(http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d", g.Repo, number), nil, &gi)
+	if err != nil {
+		return GitHubIssue{}, err
+	}
+	var comments []struct {
+		Body string `json:"body"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	err = g.do(http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", g.Repo, number), nil, &comments)
+	if err != nil {
+		return GitHubIssue{}, err
+	}
+	issue := gi.issue()
+	for _, comment := range comments {
+		issue.Comments = append(issue.Comments, IssueComment{Author: comment.User.Login, Body: comment.Body})
+	}
+	return issue, nil
+}
+
+// SummariseIssue summarises an issue and its discussion, in Markdown, for
+// someone catching up on it.
+func (c *ChatGPTClient) SummariseIssue(issue GitHubIssue) (string, error) {
+	c.SetPurpose(`Please summarise the GitHub issue and its discussion provided, for someone catching up on it.
+	Say what the problem or request is, what has been found, tried or decided so far, and what is still open, naming who said what where it matters.
+	Keep it short, and reply with only the summary, in Markdown.`)
+	thread := new(strings.Builder)
+	fmt.Fprintf(thread, "#%d %s (%s), opened by %s\n\n%s\n", issue.Number, issue.Title, issue.State, issue.Author, issue.Body)
+	for _, comment := range issue.Comments {
+		fmt.Fprintf(thread, "\n%s commented:\n%s\n", comment.Author, comment.Body)
+	}
+	c.RecordMessage(RoleUser, thread.String())
+	return c.GetCompletion()
+}
@@ -0,0 +1,109 @@
+package chatproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DefaultGitLabAPI is the GitLab REST API used unless GITLAB_API_URL, or in
+// GitLab CI, CI_API_V4_URL is set.
+const DefaultGitLabAPI = "https://gitlab.com/api/v4"
+
+// GitLab is a client for the parts of the GitLab REST API that the git
+// tools use to open and update merge requests. It needs a token with the
+// api scope.
+type GitLab struct {
+	// Project is the project's path, such as group/subgroup/name.
+	Project    string
+	Token      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewGitLab returns a GitLab client for project, given as its path, or if
+// project is empty, for the project the origin remote is on. The token is
+// read from GITLAB_TOKEN, and the API from GITLAB_API_URL or CI_API_V4_URL.
+func NewGitLab(project string) (*GitLab, error) {
+	if project == "" {
+		out, err := exec.Command("git", "remote", "get-url", "origin").Output()
+		if err != nil {
+			return nil, fmt.Errorf("no origin remote to find the GitLab project from; give its path")
+		}
+		project, err = ParseProjectURL(strings.TrimSpace(string(out)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	baseURL := os.Getenv("GITLAB_API_URL")
+	if baseURL == "" {
+		baseURL = os.Getenv("CI_API_V4_URL")
+	}
+	if baseURL == "" {
+		baseURL = DefaultGitLabAPI
+	}
+	return &GitLab{Project: project, Token: os.Getenv("GITLAB_TOKEN"), BaseURL: baseURL}, nil
+}
+
+// ParseProjectURL returns the path of the project a git remote URL is for,
+// which on GitLab may include subgroups, from an SSH URL such as
+// git@gitlab.com:group/subgroup/name.git or an HTTPS one such as
+// https://gitlab.com/group/subgroup/name.
+func ParseProjectURL(remote string) (string, error) {
+	path := ""
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		path = u.Path
+	} else if _, after, ok := strings.Cut(remote, ":"); ok {
+		path = after
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if !strings.Contains(path, "/") {
+		return "", fmt.Errorf("can't tell the project from the remote %q", remote)
+	}
+	return path, nil
+}
+
+// do sends a request to the API for the project, encoding body as JSON if
+// it isn't nil, and decodes the reply into result if it isn't nil.
+func (g *GitLab) do(method, path string, body, result any) error {
+	if g.Token == "" {
+		return fmt.Errorf("%w: set GITLAB_TOKEN to post to GitLab", ErrUnauthorized)
+	}
+	header := http.Header{}
+	header.Set("PRIVATE-TOKEN", g.Token)
+	endpoint := strings.TrimSuffix(g.BaseURL, "/") + "/projects/" + url.PathEscape(g.Project) + path
+	return doJSON(g.HTTPClient, method, endpoint, header, body, result)
+}
+
+// mergeRequest is the part of the API's reply about a merge request that
+// says where it is.
+type mergeRequest struct {
+	WebURL string `json:"web_url"`
+}
+
+// CreateMergeRequest opens a merge request of the branch source into
+// target, returning its URL.
+func (g *GitLab) CreateMergeRequest(source, target string, mr PullRequest) (string, error) {
+	var created mergeRequest
+	err := g.do(http.MethodPost, "/merge_requests", map[string]string{
+		"source_branch": source,
+		"target_branch": target,
+		"title":         mr.Title,
+		"description":   mr.Body,
+	}, &created)
+	return created.WebURL, err
+}
+
+// UpdateMergeRequest replaces the title and description of the merge
+// request with the project-level id iid, returning its URL.
+func (g *GitLab) UpdateMergeRequest(iid int, mr PullRequest) (string, error) {
+	var updated mergeRequest
+	err := g.do(http.MethodPut, fmt.Sprintf("/merge_requests/%d", iid), map[string]string{
+		"title":       mr.Title,
+		"description": mr.Body,
+	}, &updated)
+	return updated.WebURL, err
+}
@@ -0,0 +1,61 @@
+package chatproxy
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// ChunkGo splits Go source along declaration boundaries, so that each chunk
+// contains only complete functions, types, constants and variables along
+// with their doc comments. Small declarations are grouped together up to
+// chunkSize words, while a declaration larger than chunkSize is kept whole.
+// Each chunk is prefixed with the package clause to keep it self describing.
+func (c *ChatGPTClient) ChunkGo(src []byte, chunkSize int) ([]string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("package %s\n\n", f.Name.Name)
+
+	var chunks []string
+	var current []string
+	words := 0
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, header+strings.Join(current, "\n\n"))
+			current, words = nil, 0
+		}
+	}
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		start := decl.Pos()
+		if doc := declDoc(decl); doc != nil {
+			start = doc.Pos()
+		}
+		text := string(src[fset.Position(start).Offset:fset.Position(decl.End()).Offset])
+		n := len(strings.Fields(text))
+		if words > 0 && words+n > chunkSize {
+			flush()
+		}
+		current = append(current, text)
+		words += n
+	}
+	flush()
+	return chunks, nil
+}
+
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.Doc
+	case *ast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
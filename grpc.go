@@ -0,0 +1,255 @@
+package chatproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mr-joshcrane/chatproxy/chatproxypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// QueryLimit is the number of passages a Query returns unless the request
+// asks for another number.
+const QueryLimit = 3
+
+// GRPCServer implements the ChatProxy gRPC service defined in
+// proto/chatproxy.proto, so that services in other languages can chat, ask
+// questions, embed text and query documents. Each call is a conversation of
+// its own, with a client made by NewChatGPTClient.
+type GRPCServer struct {
+	chatproxypb.UnimplementedChatProxyServer
+
+	// Keys maps the name of each caller to the key it sends as a bearer
+	// token in its authorization metadata. With no keys, anyone who can
+	// reach the server may use it.
+	Keys map[string]string
+	// Metrics, if set, counts the calls the server answers and the tokens
+	// their completions use.
+	Metrics *Metrics
+
+	opts []ClientOption
+}
+
+// NewGRPCServer returns a GRPCServer whose clients are made with opts.
+// Prompts over the cost or token limit set in the config are refused, as
+// there is no one to confirm them. The calls share one transcript, opened
+// now unless opts give one, rather than each opening an audit log.
+func NewGRPCServer(opts ...ClientOption) (*GRPCServer, error) {
+	c, err := NewChatGPTClient(append([]ClientOption{WithOutput(io.Discard, io.Discard), unattended()}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], WithTranscript(c.transcript))
+	return &GRPCServer{opts: opts}, nil
+}
+
+// Serve accepts connections on lis and serves the service on them until
+// lis is closed.
+func (s *GRPCServer) Serve(lis net.Listener) error {
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+			start := time.Now()
+			caller, err := s.authorize(ctx)
+			defer func() {
+				s.Metrics.ObserveRequest("grpc", caller, status.Code(err).String(), time.Since(start))
+			}()
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+			start := time.Now()
+			caller, err := s.authorize(ss.Context())
+			defer func() {
+				s.Metrics.ObserveRequest("grpc", caller, status.Code(err).String(), time.Since(start))
+			}()
+			if err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	chatproxypb.RegisterChatProxyServer(gs, s)
+	return gs.Serve(lis)
+}
+
+// authorize checks that the call carries one of the server's keys,
+// returning the name of the caller whose key it is.
+func (s *GRPCServer) authorize(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authorization := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	caller, ok := callerFor(s.Keys, authorization)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "incorrect key for this server")
+	}
+	return caller, nil
+}
+
+// newClient returns a client for the call whose context is ctx, using model
+// if it isn't empty. Its requests are cancelled if the call is.
+func (s *GRPCServer) newClient(ctx context.Context, model string) (*ChatGPTClient, error) {
+	opts := append([]ClientOption{WithOutput(io.Discard, io.Discard), unattended(), withCallContext(ctx)}, s.opts...)
+	if model != "" {
+		opts = append(opts, WithModel(model))
+	}
+	if s.Metrics != nil {
+		opts = append(opts, WithMetrics(s.Metrics))
+	}
+	c, err := NewChatGPTClient(opts...)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return c, nil
+}
+
+// Chat replies to the conversation in the request, streaming the reply.
+func (s *GRPCServer) Chat(req *chatproxypb.ChatRequest, stream chatproxypb.ChatProxy_ChatServer) error {
+	if len(req.Messages) == 0 {
+		return status.Error(codes.InvalidArgument, "a conversation needs at least one message")
+	}
+	c, err := s.newClient(stream.Context(), req.Model)
+	if err != nil {
+		return err
+	}
+	for i, m := range req.Messages {
+		switch {
+		case i == 0 && m.Role == RoleSystem:
+			c.SetPurpose(m.Content)
+		case m.Role == RoleUser, m.Role == RoleBot, m.Role == RoleSystem:
+			c.RecordMessage(m.Role, m.Content)
+		default:
+			return status.Errorf(codes.InvalidArgument, "unknown role %q, expected one of %s, %s or %s", m.Role, RoleSystem, RoleUser, RoleBot)
+		}
+	}
+	return streamReply(c, stream.Send, func() (string, error) {
+		return c.GetCompletion()
+	})
+}
+
+// Ask answers the question in the request, about its context if it has
+// any, streaming the answer.
+func (s *GRPCServer) Ask(req *chatproxypb.AskRequest, stream chatproxypb.ChatProxy_AskServer) error {
+	if strings.TrimSpace(req.Question) == "" {
+		return status.Error(codes.InvalidArgument, "a question is required")
+	}
+	c, err := s.newClient(stream.Context(), req.Model)
+	if err != nil {
+		return err
+	}
+	return streamReply(c, stream.Send, func() (string, error) {
+		if len(req.Context) > 0 {
+			return c.AskAbout(strings.Join(req.Context, "\n"), req.Question)
+		}
+		return c.Ask(req.Question)
+	})
+}
+
+// streamReply sends the reply that complete gets as it is generated, or
+// all at once if the client doesn't stream it, and records it in the
+// transcript.
+func streamReply(c *ChatGPTClient, send func(*chatproxypb.Reply) error, complete func() (string, error)) error {
+	c.streaming = true
+	streamed := false
+	var sendErr error
+	c.onToken = func(token string) {
+		streamed = true
+		if sendErr == nil {
+			sendErr = send(&chatproxypb.Reply{Content: token})
+		}
+	}
+	reply, err := complete()
+	c.onToken = nil
+	if err != nil {
+		return rpcError(err)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	c.RecordMessage(RoleBot, reply)
+	if !streamed {
+		return send(&chatproxypb.Reply{Content: reply})
+	}
+	return nil
+}
+
+// Embed returns an embedding vector for each text in the request.
+func (s *GRPCServer) Embed(ctx context.Context, req *chatproxypb.EmbedRequest) (*chatproxypb.EmbedResponse, error) {
+	if len(req.Texts) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one text is required")
+	}
+	c, err := s.newClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	embeddings, err := c.Vectorize("grpc", req.Texts)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	resp := &chatproxypb.EmbedResponse{}
+	for _, e := range embeddings {
+		resp.Embeddings = append(resp.Embeddings, &chatproxypb.Embedding{Vector: e.Vector})
+	}
+	return resp, nil
+}
+
+// Query embeds the documents in the request and returns the passages of
+// them most relevant to the query, the most relevant first.
+func (s *GRPCServer) Query(ctx context.Context, req *chatproxypb.QueryRequest) (*chatproxypb.QueryResponse, error) {
+	if strings.TrimSpace(req.Query) == "" || len(req.Documents) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "a query and at least one document are required")
+	}
+	c, err := s.newClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range req.Documents {
+		err := c.embedDocument(doc.Origin, doc.Content)
+		if err != nil {
+			return nil, rpcError(err)
+		}
+	}
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = QueryLimit
+	}
+	passages, err := c.relevantPassages(req.Query, limit)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	resp := &chatproxypb.QueryResponse{}
+	for _, p := range passages {
+		resp.Passages = append(resp.Passages, &chatproxypb.Passage{Text: p.PlainText, Score: p.Score})
+	}
+	return resp, nil
+}
+
+// rpcError returns err as a gRPC status, with the code that matches it.
+func rpcError(err error) error {
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		code = codes.Unauthenticated
+	case errors.Is(err, ErrRateLimited):
+		code = codes.ResourceExhausted
+	case errors.Is(err, ErrContextTooLong), errors.Is(err, ErrModerated):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrCostDeclined):
+		code = codes.FailedPrecondition
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
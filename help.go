@@ -0,0 +1,299 @@
+package chatproxy
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// catchHelp, when set, is given a command's flags instead of its help being
+// printed, so that commandFlagSet can collect them.
+var catchHelp func(fs *flag.FlagSet)
+
+// showHelp prints the help for the named command, whose flags are fs. It is
+// called when a command is run with -h or --help, or with invalid flags.
+func showHelp(w io.Writer, name string, fs *flag.FlagSet) {
+	if catchHelp != nil {
+		catchHelp(fs)
+		return
+	}
+	cmd, _ := lookupCommand(name)
+	cmd.Name = name
+	printCommandHelp(w, cmd, fs)
+}
+
+// commandFlagSet returns the flags of cmd, by running it with -help and
+// catching the flags it would print help for. It must not be called
+// concurrently.
+func commandFlagSet(cmd Command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	catchHelp = func(f *flag.FlagSet) {
+		fs = f
+	}
+	defer func() { catchHelp = nil }()
+	cmd.Run([]string{cmd.Name, "-help"})
+	return fs
+}
+
+// helpFlag is a flag as it is listed in help, with its shorthands.
+type helpFlag struct {
+	*flag.Flag
+	short []string
+}
+
+// names returns the flag's names as they are listed in help, such as
+// "-y, --yes".
+func (f helpFlag) names() []string {
+	var names []string
+	for _, s := range f.short {
+		names = append(names, "-"+s)
+	}
+	return append(names, "--"+f.Name)
+}
+
+// defaultValue returns the flag's default for help, quoted if it is a
+// string, or the empty string if it is the zero value.
+func (f helpFlag) defaultValue(kind string) string {
+	switch f.DefValue {
+	case "", "false", "0":
+		return ""
+	}
+	if kind == "string" {
+		return fmt.Sprintf("%q", f.DefValue)
+	}
+	return f.DefValue
+}
+
+// helpFlags splits the flags in fs into those particular to the command and
+// those every command shares, merging shorthands such as -y into the flag
+// they stand for.
+func helpFlags(fs *flag.FlagSet) (own, common []helpFlag) {
+	shared := map[string]bool{}
+	newCommandFlags("").VisitAll(func(f *flag.Flag) {
+		shared[f.Name] = true
+	})
+	shorthands := map[string][]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if long, ok := strings.CutPrefix(f.Usage, "shorthand for --"); ok {
+			shorthands[long] = append(shorthands[long], f.Name)
+		}
+	})
+	fs.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Usage, "shorthand for --") {
+			return
+		}
+		hf := helpFlag{Flag: f, short: shorthands[f.Name]}
+		if shared[f.Name] {
+			common = append(common, hf)
+		} else {
+			own = append(own, hf)
+		}
+	})
+	return own, common
+}
+
+// printCommandHelp prints the help for cmd, whose flags are fs.
+func printCommandHelp(w io.Writer, cmd Command, fs *flag.FlagSet) {
+	fmt.Fprintln(w, strings.TrimSpace(fmt.Sprintf("Usage: %s [flags] %s", cmd.Name, cmd.Usage)))
+	if cmd.Description != "" {
+		fmt.Fprintf(w, "\n%s\n", wrapText(cmd.Description, 80))
+	}
+	own, common := helpFlags(fs)
+	printFlags(w, "Flags", own)
+	printFlags(w, "Common flags", common)
+}
+
+func printFlags(w io.Writer, title string, flags []helpFlag) {
+	if len(flags) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, f := range flags {
+		kind, usage := flag.UnquoteUsage(f.Flag)
+		indent := "  "
+		if len(f.short) == 0 {
+			indent = "      "
+		}
+		fmt.Fprintf(w, "%s%s", indent, strings.Join(f.names(), ", "))
+		if kind != "" {
+			fmt.Fprintf(w, " %s", kind)
+		}
+		fmt.Fprintf(w, "\n        %s", usage)
+		if def := f.defaultValue(kind); def != "" {
+			fmt.Fprintf(w, " (default %s)", def)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// wrapText wraps each paragraph of text at width columns, breaking lines
+// between words.
+func wrapText(text string, width int) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		wrapped := ""
+		for _, word := range strings.Fields(line) {
+			if wrapped != "" && len(wrapped)+1+len(word) > width {
+				lines = append(lines, wrapped)
+				wrapped = ""
+			}
+			if wrapped != "" {
+				wrapped += " "
+			}
+			wrapped += word
+		}
+		lines = append(lines, wrapped)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// exitStatuses describe the exit codes, for the man pages.
+var exitStatuses = []struct {
+	code    int
+	meaning string
+}{
+	{ExitOK, "Success, including --help and --dry-run."},
+	{ExitError, "Any other error."},
+	{ExitUsage, "Invalid flags or arguments."},
+	{ExitUnauthorized, "The API key is missing or was rejected."},
+	{ExitRateLimited, "Rate limited by the API."},
+	{ExitContextTooLong, "The conversation is too long for the model's context window."},
+	{ExitNoStagedChanges, "No staged changes to write a commit message for."},
+	{ExitCostNotConfirmed, "An expensive prompt wasn't confirmed."},
+	{ExitChecklistFailed, "A required checklist criterion failed."},
+}
+
+// manEscape escapes s for troff, so that hyphens, backslashes and lines
+// starting with a dot are printed as they are.
+func manEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// manParagraphs writes text as troff paragraphs, one per blank line
+// separated paragraph.
+func manParagraphs(w io.Writer, text string) {
+	for i, para := range strings.Split(text, "\n\n") {
+		if i > 0 {
+			fmt.Fprintln(w, ".PP")
+		}
+		fmt.Fprintln(w, manEscape(para))
+	}
+}
+
+func manFlags(w io.Writer, section string, flags []helpFlag) {
+	if len(flags) == 0 {
+		return
+	}
+	fmt.Fprintf(w, ".SH %q\n", section)
+	for _, f := range flags {
+		kind, usage := flag.UnquoteUsage(f.Flag)
+		fmt.Fprintln(w, ".TP")
+		names := make([]string, len(f.names()))
+		for i, name := range f.names() {
+			names[i] = `\fB` + manEscape(name) + `\fR`
+		}
+		fmt.Fprint(w, strings.Join(names, ", "))
+		if kind != "" {
+			fmt.Fprintf(w, ` \fI%s\fR`, manEscape(kind))
+		}
+		fmt.Fprintln(w)
+		if def := f.defaultValue(kind); def != "" {
+			usage += " (default " + def + ")"
+		}
+		fmt.Fprintln(w, manEscape(usage))
+	}
+}
+
+// WriteManPage writes a man page for cmd, named chatproxy-<name> in section 1.
+func WriteManPage(w io.Writer, cmd Command) error {
+	title := "chatproxy-" + cmd.Name
+	fmt.Fprintf(w, ".TH %s 1 \"\" \"chatproxy %s\" \"Chatproxy Manual\"\n", strings.ToUpper(manEscape(title)), manEscape(version()))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintf(w, "%s \\- %s\n", manEscape(title), manEscape(cmd.Summary))
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintf(w, ".B chatproxy %s\n", manEscape(cmd.Name))
+	fmt.Fprintf(w, "[\\fIflags\\fR] %s\n", manEscape(cmd.Usage))
+	if cmd.Description != "" {
+		fmt.Fprintln(w, ".SH DESCRIPTION")
+		manParagraphs(w, cmd.Description)
+	}
+	own, common := helpFlags(commandFlagSet(cmd))
+	manFlags(w, "OPTIONS", own)
+	manFlags(w, "COMMON OPTIONS", common)
+	fmt.Fprintln(w, ".SH \"EXIT STATUS\"")
+	for _, s := range exitStatuses {
+		fmt.Fprintf(w, ".TP\n.B %d\n%s\n", s.code, manEscape(s.meaning))
+	}
+	fmt.Fprintln(w, ".SH \"SEE ALSO\"")
+	_, err := fmt.Fprintln(w, ".BR chatproxy (1)")
+	return err
+}
+
+// WriteMainManPage writes the chatproxy(1) man page, listing the commands.
+func WriteMainManPage(w io.Writer) error {
+	fmt.Fprintf(w, ".TH CHATPROXY 1 \"\" \"chatproxy %s\" \"Chatproxy Manual\"\n", manEscape(version()))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `chatproxy \- ask, chat, summarise and more with OpenAI's chat models`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, ".B chatproxy")
+	fmt.Fprintln(w, `\fIcommand\fR [\fIflags\fR] [\fIarguments\fR]`)
+	fmt.Fprintln(w, ".SH COMMANDS")
+	var seeAlso []string
+	for _, cmd := range Commands {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", manEscape(cmd.Name), manEscape(cmd.Summary))
+		seeAlso = append(seeAlso, fmt.Sprintf(".BR chatproxy\\-%s (1)", manEscape(cmd.Name)))
+	}
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	fmt.Fprintln(w, ".TP\n.B OPENAI_API_KEY\nThe API key, unless a credential profile is selected.")
+	fmt.Fprintln(w, ".TP\n.B CHATPROXY_PROFILE\nThe credential profile to use from the config file.")
+	fmt.Fprintln(w, ".SH \"SEE ALSO\"")
+	_, err := fmt.Fprintln(w, strings.Join(seeAlso, ",\n"))
+	return err
+}
+
+// WriteManPages writes chatproxy.1 and a chatproxy-<name>.1 page for every
+// command to dir.
+func WriteManPages(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = writeManFile(filepath.Join(dir, "chatproxy.1"), WriteMainManPage)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range Commands {
+		cmd := cmd
+		err = writeManFile(filepath.Join(dir, "chatproxy-"+cmd.Name+".1"), func(w io.Writer) error {
+			return WriteManPage(w, cmd)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeManFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
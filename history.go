@@ -0,0 +1,176 @@
+package chatproxy
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryContext is the number of lines either side of the line asked
+// about that ExplainHistory blames.
+const HistoryContext = 10
+
+// HistoryCommitLimit is the most commits whose messages ExplainHistory
+// reads, the most recent first.
+const HistoryCommitLimit = 20
+
+var historyTarget = regexp.MustCompile(`^(.+):(\d+)$`)
+
+// ParseHistoryTarget splits a target such as client.go:42 into the file and
+// the line. The line is 0 if the target is just a file.
+func ParseHistoryTarget(target string) (file string, line int, err error) {
+	m := historyTarget.FindStringSubmatch(target)
+	if m == nil {
+		return target, 0, nil
+	}
+	line, err = strconv.Atoi(m[2])
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("%q isn't a line number", m[2])
+	}
+	return m[1], line, nil
+}
+
+// blamedLine is a line of a file with the commit that last changed it.
+type blamedLine struct {
+	number int
+	hash   string
+	author string
+	date   string
+	text   string
+}
+
+// blame returns the lines of file from start to end with the commits that
+// last changed them, or the whole file if end is 0. Lines not yet committed
+// have no hash.
+func blame(file string, start, end int) ([]blamedLine, error) {
+	args := []string{"blame", "--line-porcelain"}
+	if end > 0 {
+		args = append(args, "-L", fmt.Sprintf("%d,%d", start, end))
+	}
+	cmd := exec.Command("git", append(args, "--", file)...)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	err := runGit(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var lines []blamedLine
+	var current blamedLine
+	header := true
+	for _, text := range strings.Split(out.String(), "\n") {
+		if header {
+			// The first line of each entry is the commit hash and the
+			// line's number in the original and final file.
+			fields := strings.Fields(text)
+			if len(fields) < 3 {
+				continue
+			}
+			current.number, _ = strconv.Atoi(fields[2])
+			if strings.Trim(fields[0], "0") != "" {
+				current.hash = fields[0]
+			}
+			header = false
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			current.text = text[1:]
+			lines = append(lines, current)
+			current, header = blamedLine{}, true
+			continue
+		}
+		key, value, _ := strings.Cut(text, " ")
+		switch key {
+		case "author":
+			current.author = value
+		case "author-time":
+			if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+				current.date = time.Unix(secs, 0).UTC().Format("2006-01-02")
+			}
+		}
+	}
+	return lines, nil
+}
+
+// commitMessages returns the commits with the given hashes, newest first.
+func commitMessages(hashes []string) ([]GitCommit, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"log", "--no-walk", gitLogFormat}, hashes...)
+	cmd := exec.Command("git", args...)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	err := runGit(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitLog(out.String()), nil
+}
+
+// ExplainHistory explains why the code in file is the way it is, from git
+// blame and the messages of the commits that wrote it. With a line, it
+// looks at the HistoryContext lines either side of it; otherwise, at the
+// whole file, which must fit in the model's context.
+func (c *ChatGPTClient) ExplainHistory(file string, line int) (string, error) {
+	start, end := 1, 0
+	if line > 0 {
+		start, end = line-HistoryContext, line+HistoryContext
+		if start < 1 {
+			start = 1
+		}
+	}
+	lines, err := blame(file, start, end)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%s has no lines to explain", file)
+	}
+	excerpt := new(strings.Builder)
+	var hashes []string
+	for _, l := range lines {
+		marker := " "
+		if l.number == line {
+			marker = ">"
+		}
+		if l.hash == "" {
+			fmt.Fprintf(excerpt, "%s%5d (not committed yet) %s\n", marker, l.number, l.text)
+			continue
+		}
+		fmt.Fprintf(excerpt, "%s%5d %.7s (%s %s) %s\n", marker, l.number, l.hash, l.author, l.date, l.text)
+		if !containsString(hashes, l.hash) {
+			hashes = append(hashes, l.hash)
+		}
+	}
+	if line == 0 && guessTokens(excerpt.String()) > c.diffBudget() {
+		return "", fmt.Errorf("%s is too large to explain as a whole; give a line, such as %s:42", file, file)
+	}
+	commits, err := commitMessages(hashes)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) > HistoryCommitLimit {
+		commits = commits[:HistoryCommitLimit]
+	}
+	c.SetPurpose(`Please explain why the code shown is the way it is, for an engineer new to it, from git blame and the messages of the commits that wrote it.
+	Each line of the code is prefixed with its line number, the commit that last changed it, its author and the date; a line marked with > is the one the user is asking about.
+	Say what each change was for and how the code came to its current form, citing commits by their short hash.
+	Point out anything that looks odd but deliberate, such as a workaround for a bug.
+	Where the history doesn't say why, say so rather than guess.`)
+	msg := "File: " + file + "\n" + excerpt.String()
+	if len(commits) > 0 {
+		msg += "\nCommits:\n"
+		for _, commit := range commits {
+			msg += fmt.Sprintf("- %.7s %s\n", commit.Hash, commit.Subject)
+			if commit.Body != "" {
+				msg += "  " + strings.ReplaceAll(commit.Body, "\n", "\n  ") + "\n"
+			}
+		}
+	}
+	c.RecordMessage(RoleUser, msg)
+	return c.GetCompletion()
+}
@@ -0,0 +1,107 @@
+package chatproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// hookMarker identifies a prepare-commit-msg hook written by InstallHook.
+const hookMarker = "# Installed by chatproxy hook install."
+
+// hookScript is the prepare-commit-msg hook, run by git with the path of
+// the message file and the source of the message. It only fills in the
+// message for a plain git commit, not when the message comes from -m, a
+// template, a merge, a squash or an existing commit, and never stops the
+// commit if the message can't be written.
+const hookScript = `#!/bin/sh
+%s
+# Pre-fills the commit message with one written for the staged changes.
+case "$2" in
+"")
+	%s hook run "$1" || true
+	;;
+esac
+`
+
+// hookPath returns the path of the repository's prepare-commit-msg hook,
+// following core.hooksPath if it is set.
+func hookPath() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-path", "hooks/prepare-commit-msg")
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	err := runGit(cmd)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(strings.TrimSpace(out.String()))
+}
+
+// InstallHook installs a prepare-commit-msg hook in the current repository
+// that pre-fills the commit message, so that it works with git commit and
+// GUI clients. The hook runs the current executable. An existing hook not
+// installed by chatproxy is only replaced if force is true. It returns the
+// path of the hook.
+func InstallHook(force bool) (string, error) {
+	path, err := hookPath()
+	if err != nil {
+		return "", err
+	}
+	existing, err := os.ReadFile(path)
+	if err == nil && !bytes.Contains(existing, []byte(hookMarker)) && !force {
+		return "", fmt.Errorf("%s already exists; use --force to replace it", path)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	script := fmt.Sprintf(hookScript, hookMarker, shellQuote(exe))
+	return path, os.WriteFile(path, []byte(script), 0755)
+}
+
+// UninstallHook removes the prepare-commit-msg hook installed by
+// InstallHook. A hook that chatproxy didn't install is left alone.
+func UninstallHook() (string, error) {
+	path, err := hookPath()
+	if err != nil {
+		return "", err
+	}
+	existing, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("there is no prepare-commit-msg hook at %s", path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Contains(existing, []byte(hookMarker)) {
+		return "", fmt.Errorf("%s wasn't installed by chatproxy; remove it yourself if you want to", path)
+	}
+	return path, os.Remove(path)
+}
+
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// PrefillCommitMessage writes a commit message for the staged changes to
+// the start of the message file at path, above the comments git put there.
+func (c *ChatGPTClient) PrefillCommitMessage(path string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	msg, err := c.Commit()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(msg+"\n"+string(existing)), 0644)
+}
@@ -0,0 +1,127 @@
+package chatproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ImageProtocol is a terminal escape sequence protocol for displaying images.
+type ImageProtocol int
+
+const (
+	// ImageProtocolNone means images are referred to by their file path.
+	ImageProtocolNone ImageProtocol = iota
+	// ImageProtocolITerm2 is the inline image protocol of iTerm2, also supported by WezTerm.
+	ImageProtocolITerm2
+	// ImageProtocolKitty is the terminal graphics protocol of kitty.
+	ImageProtocolKitty
+)
+
+var markdownImage = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+
+// MaxImageBytes limits the size of images that will be displayed.
+var MaxImageBytes int64 = 20 << 20
+
+// DetectImageProtocol guesses which inline image protocol, if any, the
+// current terminal supports from its environment.
+func DetectImageProtocol() ImageProtocol {
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty"):
+		return ImageProtocolKitty
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return ImageProtocolITerm2
+	}
+	return ImageProtocolNone
+}
+
+// ImageReferences returns the locations (URLs or file paths) of the
+// markdown images referenced in text.
+func ImageReferences(text string) []string {
+	var refs []string
+	for _, m := range markdownImage.FindAllStringSubmatch(text, -1) {
+		refs = append(refs, m[2])
+	}
+	return refs
+}
+
+// DisplayImages shows the images referenced in a reply inline in the
+// terminal. Where the terminal has no image support, or output is not a
+// terminal, the path or URL of each image is printed instead.
+func (c *ChatGPTClient) DisplayImages(reply string) {
+	refs := ImageReferences(reply)
+	if len(refs) == 0 {
+		return
+	}
+	protocol := ImageProtocolNone
+	if isTerminal(c.output) {
+		protocol = DetectImageProtocol()
+	}
+	for _, ref := range refs {
+		if protocol == ImageProtocolNone {
+			c.LogOut("Image:", ref)
+			continue
+		}
+		data, err := loadImage(ref)
+		if err != nil {
+			c.LogErr(fmt.Errorf("loading image %s: %w", ref, err))
+			continue
+		}
+		if protocol == ImageProtocolKitty {
+			writeKittyImage(c.output, data)
+		} else {
+			writeITerm2Image(c.output, filepath.Base(ref), data)
+		}
+	}
+}
+
+// loadImage reads a local image, or downloads a remote one.
+func loadImage(ref string) ([]byte, error) {
+	if !strings.HasPrefix(ref, "http://") && !strings.HasPrefix(ref, "https://") {
+		return os.ReadFile(ref)
+	}
+	resp, err := http.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, MaxImageBytes))
+}
+
+func writeITerm2Image(w io.Writer, name string, data []byte) {
+	fmt.Fprintf(w, "\033]1337;File=name=%s;size=%d;inline=1:%s\a\n",
+		base64.StdEncoding.EncodeToString([]byte(name)), len(data), base64.StdEncoding.EncodeToString(data))
+}
+
+// writeKittyImage sends the image in chunks, as the kitty protocol limits
+// each escape sequence to 4096 bytes of payload.
+func writeKittyImage(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	first := true
+	for len(encoded) > 0 {
+		chunk := encoded
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		encoded = encoded[len(chunk):]
+		more := 0
+		if len(encoded) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\033_Ga=T,f=100,m=%d;%s\033\\", more, chunk)
+			first = false
+		} else {
+			fmt.Fprintf(w, "\033_Gm=%d;%s\033\\", more, chunk)
+		}
+	}
+	fmt.Fprintln(w)
+}
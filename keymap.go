@@ -0,0 +1,268 @@
+package chatproxy
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeyBindings selects the editing keys used by the chat line editor.
+type KeyBindings string
+
+const (
+	// EmacsKeyBindings are the default editing keys, as used by readline:
+	// Ctrl-A and Ctrl-E move to the start and end of the line, Alt-B and
+	// Alt-F move by word, Ctrl-K and Ctrl-U delete to the end and start of the
+	// line, and Ctrl-P and Ctrl-N step through history.
+	EmacsKeyBindings KeyBindings = "emacs"
+	// ViKeyBindings start each line in insert mode. Escape switches to normal
+	// mode, which supports the usual motions (h, l, w, b, 0, $), edits (x, X,
+	// D, C, S, dd, cc, dw, db) and history (j, k).
+	ViKeyBindings KeyBindings = "vi"
+)
+
+// ParseKeyBindings returns the key bindings with the given name.
+func ParseKeyBindings(name string) (KeyBindings, error) {
+	switch kb := KeyBindings(strings.ToLower(strings.TrimSpace(name))); kb {
+	case EmacsKeyBindings, ViKeyBindings:
+		return kb, nil
+	}
+	return "", fmt.Errorf("unknown key bindings %q, expected emacs or vi", name)
+}
+
+// WithKeyBindings selects the editing keys used when line editing is enabled.
+func WithKeyBindings(kb KeyBindings) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.keyBindings = kb
+		return c
+	}
+}
+
+const (
+	keyEsc       = 0x1b
+	keyDel       = 0x7f
+	keyStart     = "\x01"
+	keyBack      = "\x02"
+	keyEnd       = "\x05"
+	keyForward   = "\x06"
+	keyKillEnd   = "\x0b"
+	keyNext      = "\x0e"
+	keyPrevious  = "\x10"
+	keyKillStart = "\x15"
+	keyKillWord  = "\x17"
+	keyWordBack  = "\x1b[1;3D"
+	keyWordNext  = "\x1b[1;3C"
+	pasteBegin   = "\x1b[200~"
+	pasteFinish  = "\x1b[201~"
+)
+
+// KeyMapReader translates the keystrokes read from a terminal into the
+// editing keys understood by the line editor, so that alternative key
+// bindings can be layered over it.
+type KeyMapReader struct {
+	r        io.Reader
+	bindings KeyBindings
+
+	normal  bool
+	pending byte
+	escape  bool
+	pasting bool
+	out     []byte
+	err     error
+}
+
+// NewKeyMapReader returns a KeyMapReader that reads keystrokes from r.
+func NewKeyMapReader(r io.Reader, bindings KeyBindings) *KeyMapReader {
+	return &KeyMapReader{r: r, bindings: bindings}
+}
+
+// Reset returns to the initial editing mode, ready for a new line.
+func (k *KeyMapReader) Reset() {
+	k.normal = false
+	k.pending = 0
+	k.escape = false
+}
+
+func (k *KeyMapReader) Read(p []byte) (int, error) {
+	for len(k.out) == 0 {
+		if k.err != nil {
+			return 0, k.err
+		}
+		buf := make([]byte, 256)
+		n, err := k.r.Read(buf)
+		k.translate(buf[:n])
+		k.err = err
+	}
+	n := copy(p, k.out)
+	k.out = k.out[n:]
+	return n, nil
+}
+
+// translate processes a single read from the terminal. A read holds either
+// one keystroke or pasted text, which lets an escape key pressed on its own
+// be told apart from the start of an escape sequence.
+func (k *KeyMapReader) translate(b []byte) {
+	for len(b) > 0 {
+		n := keyLength(b)
+		key := string(b[:n])
+		b = b[n:]
+		switch {
+		case key == pasteBegin:
+			k.pasting, k.normal = true, false
+			k.emit(key)
+		case key == pasteFinish:
+			k.pasting = false
+			k.emit(key)
+		case k.pasting:
+			k.emit(key)
+		case k.bindings == ViKeyBindings:
+			k.vi(key)
+		default:
+			k.emacs(key)
+		}
+	}
+}
+
+// keyLength returns the length of the keystroke at the start of b: an
+// escape sequence, an Alt modified key, or a single byte.
+func keyLength(b []byte) int {
+	if b[0] != keyEsc || len(b) == 1 {
+		return 1
+	}
+	if b[1] != '[' && b[1] != 'O' {
+		return 2
+	}
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(b)
+}
+
+func (k *KeyMapReader) emit(keys ...string) {
+	for _, key := range keys {
+		k.out = append(k.out, key...)
+	}
+}
+
+// emacs adds the Alt word movement keys, which may also be typed as escape
+// followed by the key.
+func (k *KeyMapReader) emacs(key string) {
+	if k.escape {
+		k.escape = false
+		if key[0] != keyEsc {
+			key = "\x1b" + key
+		}
+	}
+	switch key {
+	case "\x1b":
+		k.escape = true
+	case "\x1bb", "\x1bB":
+		k.emit(keyWordBack)
+	case "\x1bf", "\x1bF":
+		k.emit(keyWordNext)
+	case "\x1b\x7f", "\x1b\x08":
+		k.emit(keyKillWord)
+	default:
+		if len(key) == 2 && key[0] == keyEsc {
+			// Other Alt keys are not bound.
+			return
+		}
+		k.emit(key)
+	}
+}
+
+func (k *KeyMapReader) vi(key string) {
+	if len(key) == 2 && key[0] == keyEsc {
+		k.vi("\x1b")
+		k.vi(key[1:])
+		return
+	}
+	if !k.normal {
+		if key == "\x1b" {
+			k.normal = true
+			k.emit(keyBack)
+			return
+		}
+		k.emit(key)
+		return
+	}
+	if len(key) > 1 || key[0] < ' ' && key[0] != keyEsc {
+		// Escape sequences such as the arrow keys, enter and control keys
+		// behave the same in both modes.
+		k.pending = 0
+		k.emit(key)
+		return
+	}
+	if k.pending != 0 {
+		op := k.pending
+		k.pending = 0
+		k.viOperator(op, key[0])
+		return
+	}
+	switch key[0] {
+	case 'h':
+		k.emit(keyBack)
+	case 'l':
+		k.emit(keyForward)
+	case '0', '^':
+		k.emit(keyStart)
+	case '$':
+		k.emit(keyEnd)
+	case 'w', 'W', 'e', 'E':
+		k.emit(keyWordNext)
+	case 'b', 'B':
+		k.emit(keyWordBack)
+	case 'x':
+		k.emit(keyForward, string(rune(keyDel)))
+	case 'X':
+		k.emit(string(rune(keyDel)))
+	case 'D':
+		k.emit(keyKillEnd)
+	case 'C':
+		k.emit(keyKillEnd)
+		k.normal = false
+	case 'S':
+		k.emit(keyStart, keyKillEnd)
+		k.normal = false
+	case 'i':
+		k.normal = false
+	case 'a':
+		k.emit(keyForward)
+		k.normal = false
+	case 'I':
+		k.emit(keyStart)
+		k.normal = false
+	case 'A':
+		k.emit(keyEnd)
+		k.normal = false
+	case 'k':
+		k.emit(keyPrevious)
+	case 'j':
+		k.emit(keyNext)
+	case 'd', 'c':
+		k.pending = key[0]
+	}
+}
+
+// viOperator applies a delete (d) or change (c) operator to a motion.
+func (k *KeyMapReader) viOperator(op, motion byte) {
+	switch motion {
+	case op:
+		k.emit(keyStart, keyKillEnd)
+	case '$':
+		k.emit(keyKillEnd)
+	case '0', '^':
+		k.emit(keyKillStart)
+	case 'b', 'B':
+		k.emit(keyKillWord)
+	case 'w', 'W':
+		k.emit(keyWordNext, keyKillWord)
+	default:
+		return
+	}
+	if op == 'c' {
+		k.normal = false
+	}
+}
@@ -0,0 +1,184 @@
+package chatproxy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// lineEditor reads user input from an interactive terminal, providing
+// line editing and completion. The terminal is only put into raw mode
+// while a line is being read, so streamed output behaves normally.
+type lineEditor struct {
+	fd       int
+	terminal *term.Terminal
+	keys     *KeyMapReader
+
+	mu  sync.Mutex
+	raw *term.State
+}
+
+func newLineEditor(in *os.File, out io.Writer, prompt string, bindings KeyBindings) *lineEditor {
+	keys := NewKeyMapReader(in, bindings)
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{keys, out}
+	t := term.NewTerminal(rw, prompt)
+	t.AutoCompleteCallback = completeFileCommand
+	t.SetBracketedPasteMode(true)
+	return &lineEditor{fd: int(in.Fd()), terminal: t, keys: keys}
+}
+
+// ReadLine reads a line of input. Text pasted into the terminal is returned
+// as a single line, even when it contains newlines, so a pasted snippet
+// becomes one message. Pressing enter after the paste sends it.
+func (e *lineEditor) ReadLine() (string, error) {
+	err := e.makeRaw()
+	if err != nil {
+		return "", err
+	}
+	defer e.restore()
+	e.keys.Reset()
+	var pasted []string
+	for {
+		line, err := e.terminal.ReadLine()
+		if err == term.ErrPasteIndicator {
+			pasted = append(pasted, line)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(pasted) == 0 {
+			return line, nil
+		}
+		if line != "" {
+			pasted = append(pasted, line)
+		}
+		return strings.Join(pasted, "\n"), nil
+	}
+}
+
+func (e *lineEditor) makeRaw() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		return err
+	}
+	e.raw = state
+	return nil
+}
+
+func (e *lineEditor) restore() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.raw != nil {
+		term.Restore(e.fd, e.raw)
+		e.raw = nil
+	}
+}
+
+// Close restores the terminal, even if a line is still being read, and
+// turns off bracketed paste mode.
+func (e *lineEditor) Close() {
+	e.restore()
+	e.terminal.SetBracketedPasteMode(false)
+}
+
+// WithLineEditing enables line editing and file path tab-completion when the
+// chat is read from an interactive terminal. It has no effect when input is
+// piped or redirected.
+func WithLineEditing(enabled bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.lineEditing = enabled
+		return c
+	}
+}
+
+// startLineEditor attaches a line editor to the client if line editing is
+// enabled and both stdin and stdout are terminals.
+func (c *ChatGPTClient) startLineEditor() {
+	if !c.lineEditing || c.editor != nil {
+		return
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return
+	}
+	c.editor = newLineEditor(os.Stdin, c.output, "USER) ", c.keyBindings)
+}
+
+// stopLineEditor detaches the line editor, restoring the terminal.
+func (c *ChatGPTClient) stopLineEditor() {
+	if c.editor == nil {
+		return
+	}
+	c.editor.Close()
+	c.editor = nil
+}
+
+// completeFileCommand completes file paths for the load (>) and write (<)
+// chat commands when tab is pressed.
+func completeFileCommand(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || pos != len(line) {
+		return "", 0, false
+	}
+	if !strings.HasPrefix(line, ">") && !strings.HasPrefix(line, "<") {
+		return "", 0, false
+	}
+	partial := line[1:]
+	if strings.Contains(partial, " ") {
+		return "", 0, false
+	}
+	completed := CompletePath(partial)
+	if completed == partial {
+		return "", 0, false
+	}
+	newLine := line[:1] + completed
+	return newLine, len(newLine), true
+}
+
+// CompletePath extends a partial file path as far as is unambiguous given
+// the files on disk. A unique directory match is completed with a trailing
+// separator so completion can continue into it.
+func CompletePath(partial string) string {
+	// Glob cleans a leading ./ from its matches, so set it aside.
+	if rest, ok := strings.CutPrefix(partial, "./"); ok && rest != "" {
+		return "./" + completePath(rest)
+	}
+	return completePath(partial)
+}
+
+func completePath(partial string) string {
+	matches, err := filepath.Glob(escapeGlob(partial) + "*")
+	if err != nil || len(matches) == 0 {
+		return partial
+	}
+	if len(matches) == 1 {
+		match := matches[0]
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			match += string(filepath.Separator)
+		}
+		return match
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) < len(partial) {
+		return partial
+	}
+	return prefix
+}
+
+func escapeGlob(path string) string {
+	r := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+	return r.Replace(path)
+}
@@ -3,8 +3,6 @@ package chatproxy
 import (
 	"fmt"
 	"strings"
-
-	"github.com/fatih/color"
 )
 
 // Log logs a chat message with the given role and message. It helps maintain a comprehensive log of interactions
@@ -19,16 +17,40 @@ func (c *ChatGPTClient) Log(role string, message string) {
 }
 
 func (c *ChatGPTClient) logWithFormatting(m ChatMessage) {
-	formatted := fmt.Sprintf("%s) %s", strings.ToUpper(m.Role), m.Content)
 	switch m.Role {
-	case RoleBot:
-		fmt.Fprintln(c.transcript, formatted)
-	case RoleUser:
-		fmt.Fprintln(c.transcript, formatted)
-	case RoleSystem:
-		fmt.Fprintln(c.transcript, formatted)
+	case RoleBot, RoleUser, RoleSystem:
+		c.Logger().Info(recordMessage, "role", m.Role, "content", c.redact(m.Content))
 	default:
-		fmt.Fprintln(c.output, formatted) // Default output with no color
+		fmt.Fprintf(c.output, "%s) %s\n", strings.ToUpper(m.Role), m.Content) // Default output with no color
+	}
+}
+
+// Verbosity controls how much progress information, such as the tokens
+// loaded from each file, the client prints to its error stream.
+type Verbosity int
+
+const (
+	// VerbosityQuiet prints no progress information.
+	VerbosityQuiet Verbosity = iota - 1
+	// VerbosityNormal prints progress information. It is the default.
+	VerbosityNormal
+	// VerbosityVerbose also prints details of each request to the API.
+	VerbosityVerbose
+)
+
+// WithVerbosity sets how much progress information the client prints.
+func WithVerbosity(v Verbosity) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.verbosity = v
+		return c
+	}
+}
+
+// progressf prints progress information to the error stream, so that it
+// doesn't pollute piped output, unless the client is quiet.
+func (c *ChatGPTClient) progressf(format string, args ...any) {
+	if c.verbosity >= VerbosityNormal {
+		fmt.Fprintf(c.errorStream, format, args...)
 	}
 }
 
@@ -36,13 +58,13 @@ func (c *ChatGPTClient) logWithFormatting(m ChatMessage) {
 // part of the conversation, such as instructions or system status updates.
 func (c *ChatGPTClient) LogOut(message ...any) {
 	fmt.Fprintln(c.output, message...)
-	fmt.Fprintln(c.transcript, message...)
+	c.Logger().Info(recordNote, "content", c.redact(strings.TrimSuffix(fmt.Sprintln(message...), "\n")))
 }
 
-// LogErr logs errors in the ChatGPTClient's errorStream.
+// LogErr logs errors at error level, which are written to the ChatGPTClient's errorStream.
 // This makes it possible to capture and handle errors in a standardized manner, enabling efficient debugging and error handling.
 func (c *ChatGPTClient) LogErr(err error) {
-	fmt.Fprint(c.errorStream, err)
+	c.Logger().Error(err.Error())
 }
 
 // Prompt formats and prints system prompts to the output. It uses yellow color to differentiate system messages
@@ -51,7 +73,10 @@ func (c *ChatGPTClient) LogErr(err error) {
 func (c *ChatGPTClient) Prompt(prompts ...string) {
 	for _, prompt := range prompts {
 		formattedPrompt := fmt.Sprintf("SYSTEM) %s", prompt)
-		color.New(color.FgYellow).Fprintln(c.output, formattedPrompt) // Yellow for system
+		c.theme.System.Fprintln(c.output, formattedPrompt)
+	}
+	if c.editor != nil {
+		return // The line editor prints its own prompt
 	}
 	fmt.Fprint(c.output, "USER) ")
 }
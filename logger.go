@@ -0,0 +1,218 @@
+package chatproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// The messages of the records a client logs that make up its transcript.
+// Each has a content attribute, and messages and replies a role too.
+const (
+	// recordMessage is a message in the conversation, written to text
+	// transcripts after its role, as "USER) hello".
+	recordMessage = "message"
+	// recordReply is a reply from the model, written to text transcripts
+	// as it is.
+	recordReply = "reply"
+	// recordNote is text that isn't part of the conversation, such as
+	// instructions shown to the user.
+	recordNote = "note"
+)
+
+// isTranscriptRecord reports whether a record with msg is part of the
+// transcript rather than a log of what the client did.
+func isTranscriptRecord(msg string) bool {
+	return msg == recordMessage || msg == recordReply || msg == recordNote
+}
+
+// WithLogHandler sends the client's log records to h as well as to its
+// transcript and error stream. Each record has the client's session ID and
+// command as attributes. Messages are logged at info level as "message"
+// records, with role and content attributes, replies as "reply" records,
+// and each completion as a "completion" record, with the model, provider,
+// prompt and completion tokens, estimated cost and latency. Progress of
+// requests is logged at debug level and errors at error level.
+func WithLogHandler(h slog.Handler) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.logHandlers = append(c.logHandlers, h)
+		return c
+	}
+}
+
+// Logger returns a logger that sends records to the client's transcript,
+// its error stream and the handlers given to WithLogHandler.
+func (c *ChatGPTClient) Logger() *slog.Logger {
+	stream := c.logStream
+	if stream == nil {
+		stream = streamHandler{w: c.errorStream, verbose: c.verbosity >= VerbosityVerbose}
+	}
+	handlers := fanoutHandler{transcriptHandler{w: c.transcript}, stream}
+	logger := slog.New(append(handlers, c.logHandlers...)).With("session", c.sessionID)
+	if c.command != "" {
+		logger = logger.With("command", c.command)
+	}
+	return logger
+}
+
+// SessionID returns the ID of the client's session, which distinguishes
+// its log records from those of other clients and conversations.
+func (c *ChatGPTClient) SessionID() string {
+	return c.sessionID
+}
+
+func newSessionID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// envLogHandler returns a handler writing records to w in the format named
+// by CHATPROXY_LOG_FORMAT, json or text, at the level named by
+// CHATPROXY_LOG_LEVEL, info unless it is set, or nil if no format is set.
+// It takes the place of the readable records written to the error stream,
+// for log collectors.
+func envLogHandler(w io.Writer) (slog.Handler, error) {
+	format := os.Getenv("CHATPROXY_LOG_FORMAT")
+	if format == "" {
+		return nil, nil
+	}
+	opts := &slog.HandlerOptions{}
+	if level := os.Getenv("CHATPROXY_LOG_LEVEL"); level != "" {
+		var l slog.Level
+		err := l.UnmarshalText([]byte(level))
+		if err != nil {
+			return nil, fmt.Errorf("CHATPROXY_LOG_LEVEL: %w", err)
+		}
+		opts.Level = l
+	}
+	switch format {
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("CHATPROXY_LOG_FORMAT: unknown format %q, expected json or text", format)
+}
+
+// fanoutHandler sends each record to all of its handlers that are enabled
+// for the record's level.
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// transcriptHandler writes the records that make up the transcript to it,
+// in the human readable form transcripts have always had, or as JSON lines
+// for a JSON transcript. Other records are left out.
+type transcriptHandler struct {
+	w io.Writer
+}
+
+func (h transcriptHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.w != nil && level >= slog.LevelInfo
+}
+
+func (h transcriptHandler) Handle(_ context.Context, r slog.Record) error {
+	var role, content string
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "role":
+			role = a.Value.String()
+		case "content":
+			content = a.Value.String()
+		}
+		return true
+	})
+	t, isJSON := h.w.(*jsonTranscript)
+	switch r.Message {
+	case recordMessage:
+		if isJSON {
+			t.record(role, content)
+			return nil
+		}
+		_, err := fmt.Fprintf(h.w, "%s) %s\n", strings.ToUpper(role), content)
+		return err
+	case recordReply:
+		if isJSON {
+			t.record(RoleBot, content)
+			return nil
+		}
+		_, err := fmt.Fprintln(h.w, content)
+		return err
+	case recordNote:
+		_, err := fmt.Fprintln(h.w, content)
+		return err
+	}
+	return nil
+}
+
+func (h transcriptHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h transcriptHandler) WithGroup(string) slog.Handler      { return h }
+
+// streamHandler writes records for people to read to a client's error
+// stream: warnings and errors always, and, if the client is verbose, the
+// rest, as their message followed by their attributes. Records that make
+// up the transcript are left out.
+type streamHandler struct {
+	w       io.Writer
+	verbose bool
+}
+
+func (h streamHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.w != nil && (level >= slog.LevelWarn || h.verbose)
+}
+
+func (h streamHandler) Handle(_ context.Context, r slog.Record) error {
+	if isTranscriptRecord(r.Message) {
+		return nil
+	}
+	line := r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		line += " " + a.String()
+		return true
+	})
+	_, err := fmt.Fprintln(h.w, line)
+	return err
+}
+
+func (h streamHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h streamHandler) WithGroup(string) slog.Handler      { return h }
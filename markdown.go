@@ -0,0 +1,173 @@
+package chatproxy
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+var (
+	mdHeading   = color.New(color.Bold, color.Underline)
+	mdBold      = color.New(color.Bold)
+	mdCode      = color.New(color.FgCyan)
+	mdRule      = color.New(color.Faint)
+	mdTableHead = color.New(color.Bold)
+
+	inlineCode = regexp.MustCompile("`([^`]+)`")
+	inlineBold = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	listItem   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	tableRule  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// WithMarkdown controls whether assistant replies written to the output are
+// rendered with terminal formatting (headings, lists, tables and fenced code)
+// rather than as raw markdown. Transcripts always record the raw markdown.
+func WithMarkdown(markdown bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.markdown = markdown
+		return c
+	}
+}
+
+// MarkdownRenderer renders markdown line by line, so that replies can be
+// formatted as they are streamed. It tracks whether it is inside a fenced
+// code block between calls.
+type MarkdownRenderer struct {
+	inCode    bool
+	tableRows int
+}
+
+// RenderLine formats a single line of markdown for display in a terminal.
+func (r *MarkdownRenderer) RenderLine(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "```") {
+		r.inCode = !r.inCode
+		lang := strings.TrimPrefix(trimmed, "```")
+		if r.inCode && lang != "" {
+			return mdRule.Sprintf("┌─ %s", lang)
+		}
+		return mdRule.Sprint("└─")
+	}
+	if r.inCode {
+		return mdRule.Sprint("│ ") + mdCode.Sprint(line)
+	}
+	if !strings.HasPrefix(trimmed, "|") {
+		r.tableRows = 0
+	}
+	switch {
+	case strings.HasPrefix(trimmed, "#"):
+		return mdHeading.Sprint(strings.TrimSpace(strings.TrimLeft(trimmed, "#")))
+	case trimmed == "---" || trimmed == "***":
+		return mdRule.Sprint(strings.Repeat("─", 40))
+	case strings.HasPrefix(trimmed, "|"):
+		return r.renderTableRow(trimmed)
+	}
+	if m := listItem.FindStringSubmatch(line); m != nil {
+		return m[1] + "• " + renderInline(m[2])
+	}
+	if strings.HasPrefix(trimmed, "> ") {
+		return mdRule.Sprint("▌ ") + renderInline(strings.TrimPrefix(trimmed, "> "))
+	}
+	return renderInline(line)
+}
+
+func (r *MarkdownRenderer) renderTableRow(row string) string {
+	r.tableRows++
+	if tableRule.MatchString(row) {
+		return mdRule.Sprint(strings.Repeat("─", len(row)))
+	}
+	cells := strings.Split(strings.Trim(row, "|"), "|")
+	for i, cell := range cells {
+		cell = strings.TrimSpace(cell)
+		if r.tableRows == 1 {
+			cells[i] = mdTableHead.Sprint(cell)
+		} else {
+			cells[i] = renderInline(cell)
+		}
+	}
+	return strings.Join(cells, mdRule.Sprint(" │ "))
+}
+
+func renderInline(s string) string {
+	s = inlineCode.ReplaceAllStringFunc(s, func(m string) string {
+		return mdCode.Sprint(strings.Trim(m, "`"))
+	})
+	return inlineBold.ReplaceAllStringFunc(s, func(m string) string {
+		return mdBold.Sprint(strings.Trim(m, "*"))
+	})
+}
+
+// RenderMarkdown formats a complete markdown document for display in a terminal.
+func RenderMarkdown(md string) string {
+	r := new(MarkdownRenderer)
+	lines := strings.Split(md, "\n")
+	for i, line := range lines {
+		lines[i] = r.RenderLine(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CodeBlock is a fenced code block found in markdown text.
+type CodeBlock struct {
+	Lang string
+	Code string
+}
+
+// CodeBlocks returns the complete fenced code blocks in the markdown text,
+// in the order they appear. An unterminated final block is ignored.
+func CodeBlocks(text string) []CodeBlock {
+	var blocks []CodeBlock
+	var current *CodeBlock
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "```") {
+			if current != nil {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		if current != nil {
+			current.Code = strings.Join(lines, "\n")
+			blocks = append(blocks, *current)
+			current, lines = nil, nil
+			continue
+		}
+		current = &CodeBlock{Lang: strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))}
+	}
+	return blocks
+}
+
+// LogReply writes an assistant reply to the output, rendering it as
+// markdown if enabled, and records the raw reply in the transcript. A reply
+// that was just streamed to the output is only recorded in the transcript.
+func (c *ChatGPTClient) LogReply(reply string) {
+	if c.replyStreamed {
+		c.replyStreamed = false
+		c.transcribeReply(reply)
+		return
+	}
+	if c.markdown {
+		fmt.Fprintln(c.output, RenderMarkdown(reply))
+	} else {
+		fmt.Fprintln(c.output, reply)
+	}
+	c.transcribeReply(reply)
+	c.DisplayImages(reply)
+}
+
+// stdoutIsTerminal reports whether standard output is an interactive terminal.
+func stdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// inputIsTerminal reports whether the client's input is a terminal that the
+// user can answer questions on. Readers given to WithInput count as one.
+func (c *ChatGPTClient) inputIsTerminal() bool {
+	f, ok := c.inputSource.(*os.File)
+	return !ok || term.IsTerminal(int(f.Fd()))
+}
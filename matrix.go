@@ -0,0 +1,411 @@
+package chatproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MatrixMessageLimit is the most characters the bot sends in one message;
+// longer replies are sent as several, as events are limited to 64 KiB.
+const MatrixMessageLimit = 16000
+
+// MatrixPollTimeout is how long a sync waits for an event to arrive before
+// returning none.
+const MatrixPollTimeout = 30 * time.Second
+
+// MatrixAPI is a client for the parts of the Matrix client-server API that
+// the matrix command uses to chat: syncing, joining the rooms it is invited
+// to, downloading files and sending replies.
+//
+// The client doesn't encrypt or decrypt events itself. To use the bot in
+// end-to-end encrypted rooms, run it through an E2EE aware proxy such as
+// pantalaimon, by setting MATRIX_HOMESERVER_URL to the proxy.
+type MatrixAPI struct {
+	Homeserver string
+	Token      string
+	// UserID is the bot's own user, whose messages it ignores.
+	UserID     string
+	HTTPClient *http.Client
+
+	txn atomic.Int64
+}
+
+// NewMatrixAPI returns a client for the homeserver at
+// MATRIX_HOMESERVER_URL, as the user whose access token is in
+// MATRIX_ACCESS_TOKEN.
+func NewMatrixAPI() (*MatrixAPI, error) {
+	homeserver := os.Getenv("MATRIX_HOMESERVER_URL")
+	token := os.Getenv("MATRIX_ACCESS_TOKEN")
+	if homeserver == "" || token == "" {
+		return nil, fmt.Errorf("%w: set MATRIX_HOMESERVER_URL and MATRIX_ACCESS_TOKEN to the homeserver and the bot user's access token", ErrUnauthorized)
+	}
+	m := &MatrixAPI{
+		Homeserver: strings.TrimSuffix(homeserver, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: MatrixPollTimeout + DefaultTimeout},
+	}
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	err := m.call(http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &whoami)
+	if err != nil {
+		return nil, err
+	}
+	m.UserID = whoami.UserID
+	return m, nil
+}
+
+// call makes a request to the client-server API, decoding the reply into
+// result if it isn't nil.
+func (m *MatrixAPI) call(method, path string, body, result any) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+m.Token)
+	return doJSON(m.HTTPClient, method, m.Homeserver+path, header, body, result)
+}
+
+// MatrixEvent is an event in a room.
+type MatrixEvent struct {
+	Type     string `json:"type"`
+	Sender   string `json:"sender"`
+	EventID  string `json:"event_id"`
+	StateKey string `json:"state_key"`
+	Content  struct {
+		MsgType    string `json:"msgtype"`
+		Body       string `json:"body"`
+		URL        string `json:"url"`
+		Membership string `json:"membership"`
+		Info       struct {
+			Size int64 `json:"size"`
+		} `json:"info"`
+	} `json:"content"`
+}
+
+// MatrixSync is what has happened in the bot's rooms since a sync.
+type MatrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []MatrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []MatrixEvent `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+// Sync returns what has happened since the sync whose next batch is since,
+// waiting up to timeout for something to. With no since, it returns the
+// current state, so that history isn't answered.
+func (m *MatrixAPI) Sync(since string, timeout time.Duration) (MatrixSync, error) {
+	q := url.Values{}
+	q.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+	if since != "" {
+		q.Set("since", since)
+	}
+	var sync MatrixSync
+	err := m.call(http.MethodGet, "/_matrix/client/v3/sync?"+q.Encode(), nil, &sync)
+	return sync, err
+}
+
+// Join joins a room the bot was invited to.
+func (m *MatrixAPI) Join(room string) error {
+	return m.call(http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(room), map[string]any{}, nil)
+}
+
+// SendMessage sends text to a room, as several messages if it is longer
+// than MatrixMessageLimit.
+func (m *MatrixAPI) SendMessage(room, text string) error {
+	for _, part := range splitMessage(text, MatrixMessageLimit) {
+		txn := fmt.Sprintf("chatproxy-%d-%d", time.Now().UnixNano(), m.txn.Add(1))
+		path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(room), txn)
+		err := m.call(http.MethodPut, path, map[string]string{"msgtype": "m.text", "body": part}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DownloadFile saves the file at an mxc:// URL to path.
+func (m *MatrixAPI) DownloadFile(mxc, path string) error {
+	media, ok := strings.CutPrefix(mxc, "mxc://")
+	if !ok {
+		return fmt.Errorf("%q isn't a Matrix content URL", mxc)
+	}
+	req, err := http.NewRequest(http.MethodGet, m.Homeserver+"/_matrix/client/v1/media/download/"+media, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	hc := m.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", filepath.Base(path), resp.Status)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.LimitReader(resp.Body, MaxUploadBytes))
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// MatrixBot chats with the people allowed to use it in the Matrix rooms
+// they invite it to, with a conversation for each room. Each room's
+// conversation is kept as a saved session, named matrix- and the room id,
+// so that it survives restarts and can be loaded in the chat command. As
+// in the terminal chat, the first message of a conversation describes the
+// purpose of the assistant, or names one of the purposes in the config,
+// and !new starts another. Files sent to the room are read with GetContent
+// and added to the conversation.
+type MatrixBot struct {
+	// Allowed are the user ids, such as @alice:example.org, of the people
+	// the bot answers and accepts invites from. It ignores everyone else,
+	// as they would otherwise be using your API key.
+	Allowed []string
+
+	api    *MatrixAPI
+	client *ChatGPTClient
+	rooms  map[string]*ChatGPTClient
+	since  string
+}
+
+// NewMatrixBot returns a bot that receives messages with api and holds
+// its conversations with the settings of c.
+func NewMatrixBot(api *MatrixAPI, c *ChatGPTClient, allowed []string) *MatrixBot {
+	return &MatrixBot{Allowed: allowed, api: api, client: c, rooms: map[string]*ChatGPTClient{}}
+}
+
+// Run answers messages until ctx is done.
+func (b *MatrixBot) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		err := b.Poll()
+		if err != nil {
+			b.client.LogErr(err)
+			// Back off rather than retrying a failing API at once.
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+// Poll waits for the next events in the bot's rooms, joins the rooms it is
+// invited to, and answers the messages. The messages sent before the first
+// poll are history, and aren't answered.
+func (b *MatrixBot) Poll() error {
+	timeout := MatrixPollTimeout
+	if b.since == "" {
+		timeout = 0
+	}
+	sync, err := b.api.Sync(b.since, timeout)
+	if err != nil {
+		return err
+	}
+	history := b.since == ""
+	b.since = sync.NextBatch
+	for room, invite := range sync.Rooms.Invite {
+		for _, e := range invite.InviteState.Events {
+			if e.Type == "m.room.member" && e.StateKey == b.api.UserID && e.Content.Membership == "invite" && b.allowed(e.Sender) {
+				err := b.api.Join(room)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	if history {
+		return nil
+	}
+	for room, joined := range sync.Rooms.Join {
+		for _, e := range joined.Timeline.Events {
+			if e.Sender == b.api.UserID || !b.allowed(e.Sender) {
+				continue
+			}
+			reply := b.handle(room, e)
+			if reply == "" {
+				continue
+			}
+			err := b.api.SendMessage(room, reply)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *MatrixBot) allowed(user string) bool {
+	for _, a := range b.Allowed {
+		if strings.EqualFold(a, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle answers an event in a room, returning the reply to send.
+func (b *MatrixBot) handle(room string, e MatrixEvent) string {
+	switch {
+	case e.Type == "m.room.encrypted":
+		return "This room is end-to-end encrypted, and I can't read its messages. Run me through an E2EE aware proxy, such as pantalaimon, to chat here."
+	case e.Type != "m.room.message":
+		return ""
+	}
+	c := b.conversation(room)
+	text := strings.TrimSpace(e.Content.Body)
+	switch e.Content.MsgType {
+	case "m.text":
+	case "m.file":
+		if len(c.chatHistory) == 0 {
+			return "Please describe the purpose of this assistant before sending files."
+		}
+		msg, err := b.readFile(c, e.Content.URL, e.Content.Body, e.Content.Info.Size)
+		if err != nil {
+			c.LogErr(err)
+			return "Couldn't read " + e.Content.Body + ": " + err.Error()
+		}
+		c.RecordMessage(RoleUser, msg)
+		b.save(room, c)
+		return fmt.Sprintf("Loaded %s (about %d tokens).", e.Content.Body, guessTokens(msg))
+	default:
+		return ""
+	}
+	if text == "!new" {
+		c = b.client.newConversation()
+		b.rooms[room] = c
+		b.save(room, c)
+		return c.purposePrompt()
+	}
+	if text == "" {
+		return ""
+	}
+	if len(c.chatHistory) == 0 {
+		c.SetPurpose(c.expandPurpose(text))
+		b.save(room, c)
+		return "Purpose set."
+	}
+	c.RecordMessage(RoleUser, text)
+	reply, err := c.GetCompletion()
+	if err != nil {
+		c.LogErr(err)
+		c.rollbackUnanswered()
+		return "Error: " + err.Error()
+	}
+	c.RecordMessage(RoleBot, reply)
+	b.save(room, c)
+	return reply
+}
+
+// conversation returns the room's conversation, loading its saved session
+// if it has one.
+func (b *MatrixBot) conversation(room string) *ChatGPTClient {
+	c, ok := b.rooms[room]
+	if ok {
+		return c
+	}
+	c = b.client.newConversation()
+	b.rooms[room] = c
+	if stateless() {
+		return c
+	}
+	path, err := matrixSessionPath(room)
+	if err == nil {
+		var session Session
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &session)
+		}
+		if err == nil {
+			err = c.RestoreSession(session)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.LogErr(fmt.Errorf("loading the session of %s: %w", room, err))
+		}
+	}
+	return c
+}
+
+// save saves the room's conversation as its session, unless in stateless
+// mode, where it is kept only in memory.
+func (b *MatrixBot) save(room string, c *ChatGPTClient) {
+	if stateless() {
+		return
+	}
+	path, err := matrixSessionPath(room)
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(c.Session(), "", "  ")
+		if err == nil {
+			err = OverwriteFile(string(data), path)
+		}
+	}
+	if err != nil {
+		c.LogErr(fmt.Errorf("saving the session of %s: %w", room, err))
+	}
+}
+
+var notSessionName = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// matrixSessionPath returns the path of the saved session of a room, such
+// as matrix-abc123-example-org for !abc123:example.org.
+func matrixSessionPath(room string) (string, error) {
+	name := strings.Trim(notSessionName.ReplaceAllString(room, "-"), "-")
+	return SessionPath("matrix-" + name)
+}
+
+// readFile downloads a file sent to a room and reads it with GetContent,
+// naming it as it was sent.
+func (b *MatrixBot) readFile(c *ChatGPTClient, mxc, name string, size int64) (string, error) {
+	if size > MaxUploadBytes {
+		return "", fmt.Errorf("it is larger than %d MB", MaxUploadBytes>>20)
+	}
+	dir, err := os.MkdirTemp("", "chatproxy-matrix")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if name == "" {
+		name = "file"
+	}
+	path := filepath.Join(dir, filepath.Base(name))
+	err = b.api.DownloadFile(mxc, path)
+	if err != nil {
+		return "", err
+	}
+	msg, err := c.GetContent(path)
+	if err != nil {
+		return "", err
+	}
+	// Name the file as it was sent, rather than by the temporary directory.
+	return strings.ReplaceAll(msg, "--"+dir+string(filepath.Separator), "--"), nil
+}
@@ -0,0 +1,278 @@
+package chatproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MCPProtocolVersion is the version of the Model Context Protocol that the
+// MCP server speaks.
+const MCPProtocolVersion = "2024-11-05"
+
+// The JSON-RPC 2.0 error codes the MCP server replies with.
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+)
+
+// jsonRPCMessage is a JSON-RPC 2.0 request, notification or response. A
+// notification has no ID, and a response has a result or an error rather
+// than a method.
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonRPCError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// MCPServer serves chatproxy's features as Model Context Protocol tools, so
+// that editors and other LLM clients can call them: reading files,
+// directories and URLs, finding the passages of them relevant to a query,
+// summarising them, and writing a commit message for the staged changes.
+// Each tool call is a conversation of its own, with the client's settings.
+type MCPServer struct {
+	client *ChatGPTClient
+	mu     sync.Mutex
+	out    *json.Encoder
+}
+
+// NewMCPServer returns an MCPServer whose tools use the settings of c.
+func NewMCPServer(c *ChatGPTClient) *MCPServer {
+	return &MCPServer{client: c}
+}
+
+// mcpTool is a tool the MCP server offers.
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+
+	call func(c *ChatGPTClient, args mcpArguments) (string, error)
+}
+
+// mcpArguments are the arguments of a tool call.
+type mcpArguments struct {
+	Path  string   `json:"path"`
+	Paths []string `json:"paths"`
+	Query string   `json:"query"`
+	Limit int      `json:"limit"`
+}
+
+// objectSchema returns the JSON schema of an object with the properties
+// given, of which those named by required must be present.
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var pathProperty = map[string]any{
+	"type":        "string",
+	"description": "A file, directory or URL",
+}
+
+// mcpTools are the tools the MCP server offers.
+var mcpTools = []mcpTool{
+	{
+		Name:        "read",
+		Description: "Read the text of a file, a directory of files, or a web page, as chatproxy does for --context. PDFs, Word documents and notebooks are converted to text.",
+		InputSchema: objectSchema(map[string]any{"path": pathProperty}, "path"),
+		call: func(c *ChatGPTClient, args mcpArguments) (string, error) {
+			content, err := c.GetContent(args.Path)
+			if err != nil {
+				return "", err
+			}
+			// The content is the caller's to use, so a feed's entries have
+			// been read.
+			c.saveFeedReads()
+			return content, nil
+		},
+	},
+	{
+		Name:        "query",
+		Description: "Find the passages of some files, directories or web pages most relevant to a query, using embeddings, the most relevant first.",
+		InputSchema: objectSchema(map[string]any{
+			"query": map[string]any{"type": "string", "description": "What to look for"},
+			"paths": map[string]any{"type": "array", "items": pathProperty, "description": "The files, directories or URLs to search"},
+			"limit": map[string]any{"type": "integer", "description": fmt.Sprintf("The most passages to return (default %d)", QueryLimit)},
+		}, "query", "paths"),
+		call: func(c *ChatGPTClient, args mcpArguments) (string, error) {
+			if len(args.Paths) == 0 {
+				return "", errors.New("paths must name at least one file, directory or URL")
+			}
+			for _, path := range args.Paths {
+				content, err := c.GetContent(path)
+				if err != nil {
+					return "", err
+				}
+				err = c.embedDocument(path, content)
+				if err != nil {
+					return "", err
+				}
+			}
+			limit := args.Limit
+			if limit <= 0 {
+				limit = QueryLimit
+			}
+			passages, err := c.relevantPassages(args.Query, limit)
+			if err != nil {
+				return "", err
+			}
+			texts := make([]string, 0, len(passages))
+			for _, p := range passages {
+				texts = append(texts, fmt.Sprintf("[score %.2f]\n%s", p.Score, p.PlainText))
+			}
+			return strings.Join(texts, "\n\n"), nil
+		},
+	},
+	{
+		Name:        "tldr",
+		Description: "Summarise a file, a directory of files, or a web page.",
+		InputSchema: objectSchema(map[string]any{"path": pathProperty}, "path"),
+		call: func(c *ChatGPTClient, args mcpArguments) (string, error) {
+			return c.TLDR(args.Path)
+		},
+	},
+	{
+		Name:        "commit_message",
+		Description: "Write a commit message for the changes staged in the git repository the server runs in, in the format set in chatproxy's config.",
+		InputSchema: objectSchema(map[string]any{}),
+		call: func(c *ChatGPTClient, args mcpArguments) (string, error) {
+			return c.Commit()
+		},
+	},
+}
+
+// Serve reads JSON-RPC messages from r, one per line as the stdio
+// transport sends them, and writes the replies to w, until r ends.
+func (s *MCPServer) Serve(r io.Reader, w io.Writer) error {
+	s.out = json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(MaxUploadBytes))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg jsonRPCMessage
+		err := json.Unmarshal([]byte(line), &msg)
+		if err != nil {
+			s.reply(json.RawMessage("null"), nil, &jsonRPCError{Code: jsonRPCParseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "" {
+			// A response to a request the server didn't make.
+			continue
+		}
+		result, rpcErr := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			// Notifications get no reply.
+			continue
+		}
+		s.reply(msg.ID, result, rpcErr)
+	}
+	return scanner.Err()
+}
+
+// reply writes the response to the request with the given id.
+func (s *MCPServer) reply(id json.RawMessage, result any, rpcErr *jsonRPCError) {
+	resp := jsonRPCMessage{JSONRPC: "2.0", ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &jsonRPCError{Code: jsonRPCInvalidRequest, Message: err.Error()}
+		}
+		resp.Result = data
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.out.Encode(resp)
+	if err != nil {
+		s.client.LogErr(err)
+	}
+}
+
+// handle answers a request, returning its result.
+func (s *MCPServer) handle(method string, params json.RawMessage) (any, *jsonRPCError) {
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": MCPProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": "chatproxy", "version": version()},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": mcpTools}, nil
+	case "tools/call":
+		var call struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		err := json.Unmarshal(params, &call)
+		if err != nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: err.Error()}
+		}
+		return s.callTool(call.Name, call.Arguments)
+	}
+	if strings.HasPrefix(method, "notifications/") {
+		return nil, nil
+	}
+	return nil, &jsonRPCError{Code: jsonRPCMethodNotFound, Message: "method not found: " + method}
+}
+
+// callTool calls the named tool. A tool that fails replies with its error
+// as the result, marked as one, so that the model calling it can see what
+// went wrong.
+func (s *MCPServer) callTool(name string, arguments json.RawMessage) (any, *jsonRPCError) {
+	for _, tool := range mcpTools {
+		if tool.Name != name {
+			continue
+		}
+		var args mcpArguments
+		if len(arguments) > 0 {
+			err := json.Unmarshal(arguments, &args)
+			if err != nil {
+				return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: err.Error()}
+			}
+		}
+		c := s.client.newConversation()
+		c.embeddings = nil
+		s.client.Log(RoleSystem, "MCP tool call: "+name+" "+string(arguments))
+		text, err := tool.call(c, args)
+		if err != nil {
+			s.client.LogErr(err)
+			return mcpToolResult(err.Error(), true), nil
+		}
+		return mcpToolResult(text, false), nil
+	}
+	return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "unknown tool: " + name}
+}
+
+func mcpToolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
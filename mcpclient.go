@@ -0,0 +1,392 @@
+package chatproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// MCPToolMaxSteps limits how many rounds of tool calls the model may make
+// for a single reply.
+var MCPToolMaxSteps = 10
+
+// MCPServerConfig is an MCP server that chat sessions start and offer the
+// tools of to the model, set in the user config:
+//
+//	mcp_servers:
+//	  github:
+//	    command: github-mcp-server
+//	    args: [stdio]
+//	    env:
+//	      GITHUB_PERSONAL_ACCESS_TOKEN: ghp_...
+//	    trusted: true
+//
+// Each call of a tool is confirmed first, unless the server is trusted.
+type MCPServerConfig struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	Trusted bool              `yaml:"trusted"`
+}
+
+func (cfg MCPServerConfig) validate(name string) error {
+	if cfg.Command == "" {
+		return fmt.Errorf("mcp_servers.%s.command is required", name)
+	}
+	if !toolName.MatchString(name) {
+		return fmt.Errorf("mcp_servers.%s: server names may only contain letters, digits, _ and -", name)
+	}
+	return nil
+}
+
+// MCPClient is a connection to an MCP server, whose tools the model can
+// call through a client made WithMCP.
+type MCPClient struct {
+	// Name is the name of the server, which prefixes the names of its tools
+	// as the model sees them.
+	Name string
+	// Trusted servers' tools are called without asking first.
+	Trusted bool
+
+	mu     sync.Mutex
+	in     *bufio.Reader
+	out    io.Writer
+	closer io.Closer
+	cmd    *exec.Cmd
+	nextID int
+	tools  []mcpTool
+}
+
+// StartMCPServer starts the MCP server cfg describes and connects to it
+// over its stdin and stdout. Its stderr is passed through to ours.
+func StartMCPServer(name string, cfg MCPServerConfig) (*MCPClient, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("starting MCP server %s: %w", name, err)
+	}
+	m, err := ConnectMCP(name, stdout, stdin)
+	if err != nil {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	m.Trusted = cfg.Trusted
+	m.cmd = cmd
+	return m, nil
+}
+
+// startMCPServers starts the MCP servers in the config, in order of name,
+// stopping those already started if one fails to start.
+func startMCPServers(configs map[string]MCPServerConfig) ([]*MCPClient, error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var servers []*MCPClient
+	for _, name := range names {
+		server, err := StartMCPServer(name, configs[name])
+		if err != nil {
+			for _, started := range servers {
+				started.Close()
+			}
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// ConnectMCP connects to an MCP server that replies on r to the messages
+// written to w, and lists its tools. Closing the client closes w.
+func ConnectMCP(name string, r io.Reader, w io.WriteCloser) (*MCPClient, error) {
+	m := &MCPClient{Name: name, in: bufio.NewReader(r), out: w, closer: w}
+	var initialized struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	err := m.call("initialize", map[string]any{
+		"protocolVersion": MCPProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "chatproxy", "version": version()},
+	}, &initialized)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to MCP server %s: %w", name, err)
+	}
+	err = m.notify("notifications/initialized")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to MCP server %s: %w", name, err)
+	}
+	var listed struct {
+		Tools []mcpTool `json:"tools"`
+	}
+	err = m.call("tools/list", map[string]any{}, &listed)
+	if err != nil {
+		return nil, fmt.Errorf("listing the tools of MCP server %s: %w", name, err)
+	}
+	m.tools = listed.Tools
+	return m, nil
+}
+
+// Tools returns the names of the server's tools.
+func (m *MCPClient) Tools() []string {
+	names := make([]string, 0, len(m.tools))
+	for _, tool := range m.tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+// Close disconnects from the server, and if it was started by
+// StartMCPServer, waits for it to exit.
+func (m *MCPClient) Close() error {
+	err := m.closer.Close()
+	if m.cmd != nil {
+		m.cmd.Wait()
+	}
+	return err
+}
+
+// notify sends a notification, which gets no reply.
+func (m *MCPClient) notify(method string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.NewEncoder(m.out).Encode(jsonRPCMessage{JSONRPC: "2.0", Method: method})
+}
+
+// call sends a request and decodes the result of its response into result.
+// Requests from the server are answered as well as the client can while it
+// waits.
+func (m *MCPClient) call(method string, params, result any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	id := json.RawMessage(fmt.Sprint(m.nextID))
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(m.out).Encode(jsonRPCMessage{JSONRPC: "2.0", ID: id, Method: method, Params: data})
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := m.in.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) == 0 {
+			if err == io.EOF {
+				return errors.New("the server closed the connection")
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var msg jsonRPCMessage
+		err = json.Unmarshal(line, &msg)
+		if err != nil {
+			return fmt.Errorf("reading the server's reply: %w", err)
+		}
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			// A request from the server; the only one a client must answer
+			// is ping.
+			reply := jsonRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage("{}")}
+			if msg.Method != "ping" {
+				reply = jsonRPCMessage{JSONRPC: "2.0", ID: msg.ID, Error: &jsonRPCError{Code: jsonRPCMethodNotFound, Message: "method not found: " + msg.Method}}
+			}
+			err := json.NewEncoder(m.out).Encode(reply)
+			if err != nil {
+				return err
+			}
+		case msg.Method != "":
+			// A notification, such as a log message.
+		case string(msg.ID) == string(id):
+			if msg.Error != nil {
+				return msg.Error
+			}
+			if result == nil {
+				return nil
+			}
+			return json.Unmarshal(msg.Result, result)
+		}
+	}
+}
+
+// CallTool calls one of the server's tools, returning the text of its
+// result. A tool that reports an error returns its text as the error.
+func (m *MCPClient) CallTool(name string, arguments json.RawMessage) (string, error) {
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	err := m.call("tools/call", map[string]any{"name": name, "arguments": arguments}, &result)
+	if err != nil {
+		return "", err
+	}
+	texts := make([]string, 0, len(result.Content))
+	for _, content := range result.Content {
+		if content.Type == "text" {
+			texts = append(texts, content.Text)
+		} else {
+			texts = append(texts, "["+content.Type+" content omitted]")
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if result.IsError {
+		return "", errors.New(text)
+	}
+	return text, nil
+}
+
+// toolName matches the names the API allows for functions.
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// clientTool is a tool of an MCP server, as the model sees it.
+type clientTool struct {
+	function *openai.FunctionDefine
+	server   *MCPClient
+	name     string
+}
+
+// WithMCP offers the tools of the MCP servers to the model, which may call
+// them while it writes a reply. Each is named after its server and the
+// tool, such as github__create_issue.
+func WithMCP(servers ...*MCPClient) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		for _, server := range servers {
+			for _, tool := range server.tools {
+				name := server.Name + "__" + tool.Name
+				if !toolName.MatchString(name) {
+					continue
+				}
+				params := new(openai.FunctionParams)
+				data, _ := json.Marshal(tool.InputSchema)
+				json.Unmarshal(data, params)
+				params.Type = openai.JSONSchemaTypeObject
+				c.tools = append(c.tools, clientTool{
+					function: &openai.FunctionDefine{Name: name, Description: tool.Description, Parameters: params},
+					server:   server,
+					name:     tool.Name,
+				})
+			}
+		}
+		return c
+	}
+}
+
+// completeWithTools requests a reply that may call the client's tools,
+// calling them and sending their results back until the model replies
+// without calling one. Function calls can't be streamed, so the reply is
+// written to the output when it is complete, as a streamed one would be.
+func (c *ChatGPTClient) completeWithTools(req openai.ChatCompletionRequest) (string, error) {
+	for step := 0; step < MCPToolMaxSteps; step++ {
+		req.Stream = false
+		for _, tool := range c.tools {
+			req.Functions = append(req.Functions, tool.function)
+		}
+		ctx, cancel := c.requestContext()
+		start := time.Now()
+		resp, err := c.client.CreateChatCompletion(ctx, req)
+		if err != nil {
+			defer cancel()
+			return c.completionError(ctx, err)
+		}
+		cancel()
+		if len(resp.Choices) == 0 {
+			return "", errors.New("the API returned no reply")
+		}
+		msg := resp.Choices[0].Message
+		c.recordUsage(req.Model, promptText(req), msg.Content, time.Since(start))
+		if msg.FunctionCall == nil {
+			c.writeReply(msg.Content)
+			return msg.Content, nil
+		}
+		result := c.callTool(msg.FunctionCall.Name, msg.FunctionCall.Arguments)
+		c.chatHistory = append(c.chatHistory,
+			ChatMessage{Role: RoleBot, Name: msg.FunctionCall.Name, Arguments: msg.FunctionCall.Arguments},
+			ChatMessage{Role: RoleFunction, Name: msg.FunctionCall.Name, Content: result},
+		)
+		req = c.completionRequest()
+	}
+	return "", fmt.Errorf("the model was still calling tools after %d steps", MCPToolMaxSteps)
+}
+
+// callTool calls the tool the model asked for, after confirming it if its
+// server isn't trusted, returning the result to send back to the model.
+func (c *ChatGPTClient) callTool(name, arguments string) string {
+	for _, tool := range c.tools {
+		if tool.function.Name != name {
+			continue
+		}
+		c.Log(RoleSystem, fmt.Sprintf("Tool call: %s %s", name, arguments))
+		if !tool.server.Trusted {
+			c.Prompt(fmt.Sprintf("Call tool? (y/N)\n%s %s", name, arguments))
+			answer, err := c.readLine()
+			if err != nil || strings.ToLower(strings.TrimSpace(answer)) != "y" {
+				return "The user declined to call " + name
+			}
+		} else {
+			c.theme.System.Fprintln(c.output, "SYSTEM) Calling "+name)
+		}
+		result, err := tool.server.CallTool(tool.name, json.RawMessage(arguments))
+		if err != nil {
+			result = "Error: " + err.Error()
+		}
+		if len(result) > AgentMaxOutput {
+			result = result[:AgentMaxOutput] + "\n... output truncated"
+		}
+		c.Log(RoleSystem, "Tool result: "+result)
+		return result
+	}
+	return "There is no tool called " + name
+}
+
+// writeReply writes a reply that was received whole to the output as
+// streamReply would have, for clients that stream their replies.
+func (c *ChatGPTClient) writeReply(reply string) {
+	if !c.streaming {
+		return
+	}
+	c.theme.Assistant.Fprint(c.output, "ASSISTANT) ")
+	if c.markdown {
+		fmt.Fprint(c.output, RenderMarkdown(reply))
+	} else {
+		fmt.Fprint(c.output, reply)
+	}
+	c.theme.Assistant.Fprintln(c.output)
+	if c.onToken != nil {
+		c.onToken(reply)
+	}
+	c.replyStreamed = true
+}
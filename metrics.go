@@ -0,0 +1,177 @@
+package chatproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsPath is the path metrics are served on.
+const MetricsPath = "/metrics"
+
+// metricsBuckets are the upper bounds, in seconds, of the buckets of the
+// request duration histogram. Completions take seconds rather than
+// milliseconds, so the buckets are wider than is usual for an HTTP server.
+var metricsBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Metrics counts the requests a server answers, how long they take, and the
+// tokens and cost of their completions, and serves them on MetricsPath in
+// the Prometheus text format, for operators to scrape:
+//
+//   - chatproxy_requests_total, by server, caller and code, the HTTP status
+//     or gRPC code of the reply, from which error rates follow
+//   - chatproxy_request_duration_seconds, a histogram by server
+//   - chatproxy_tokens_total, by model and type, prompt or completion
+//   - chatproxy_cost_dollars_total, the estimated cost, by model
+//
+// A Metrics is safe to use from many goroutines.
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[[3]string]int
+	durations map[string]*histogram
+	tokens    map[[2]string]int
+	cost      map[string]float64
+}
+
+type histogram struct {
+	counts []int
+	sum    float64
+	count  int
+}
+
+// NewMetrics returns a Metrics with nothing recorded yet.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  map[[3]string]int{},
+		durations: map[string]*histogram{},
+		tokens:    map[[2]string]int{},
+		cost:      map[string]float64{},
+	}
+}
+
+// WithMetrics records the tokens and cost of each completion in m.
+func WithMetrics(m *Metrics) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.metrics = m
+		return c
+	}
+}
+
+// ObserveRequest records a request to server from caller, which was
+// answered with code after d.
+func (m *Metrics) ObserveRequest(server, caller, code string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[3]string{server, caller, code}]++
+	h, ok := m.durations[server]
+	if !ok {
+		h = &histogram{counts: make([]int, len(metricsBuckets))}
+		m.durations[server] = h
+	}
+	for i, le := range metricsBuckets {
+		if d.Seconds() <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += d.Seconds()
+	h.count++
+}
+
+// ObserveUsage records the tokens and cost of a completion by model.
+func (m *Metrics) ObserveUsage(model string, u Usage) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[[2]string{model, "prompt"}] += u.PromptTokens
+	m.tokens[[2]string{model, "completion"}] += u.CompletionTokens
+	m.cost[model] += u.Cost
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != MetricsPath {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, m.text())
+}
+
+// text returns the metrics in the Prometheus text format, in a stable
+// order.
+func (m *Metrics) text() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := new(strings.Builder)
+
+	out.WriteString("# HELP chatproxy_requests_total Requests answered, by server, caller and reply code.\n")
+	out.WriteString("# TYPE chatproxy_requests_total counter\n")
+	requests := make([][3]string, 0, len(m.requests))
+	for k := range m.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return strings.Join(requests[i][:], "\x00") < strings.Join(requests[j][:], "\x00")
+	})
+	for _, k := range requests {
+		fmt.Fprintf(out, "chatproxy_requests_total{server=%s,caller=%s,code=%s} %d\n", labelValue(k[0]), labelValue(k[1]), labelValue(k[2]), m.requests[k])
+	}
+
+	out.WriteString("# HELP chatproxy_request_duration_seconds How long requests took to answer, by server.\n")
+	out.WriteString("# TYPE chatproxy_request_duration_seconds histogram\n")
+	for _, server := range sortedKeys(m.durations) {
+		h := m.durations[server]
+		for i, le := range metricsBuckets {
+			fmt.Fprintf(out, "chatproxy_request_duration_seconds_bucket{server=%s,le=\"%g\"} %d\n", labelValue(server), le, h.counts[i])
+		}
+		fmt.Fprintf(out, "chatproxy_request_duration_seconds_bucket{server=%s,le=\"+Inf\"} %d\n", labelValue(server), h.count)
+		fmt.Fprintf(out, "chatproxy_request_duration_seconds_sum{server=%s} %g\n", labelValue(server), h.sum)
+		fmt.Fprintf(out, "chatproxy_request_duration_seconds_count{server=%s} %d\n", labelValue(server), h.count)
+	}
+
+	out.WriteString("# HELP chatproxy_tokens_total Estimated tokens used by completions, by model and type.\n")
+	out.WriteString("# TYPE chatproxy_tokens_total counter\n")
+	tokens := make([][2]string, 0, len(m.tokens))
+	for k := range m.tokens {
+		tokens = append(tokens, k)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i][0] < tokens[j][0] || tokens[i][0] == tokens[j][0] && tokens[i][1] < tokens[j][1]
+	})
+	for _, k := range tokens {
+		fmt.Fprintf(out, "chatproxy_tokens_total{model=%s,type=%s} %d\n", labelValue(k[0]), labelValue(k[1]), m.tokens[k])
+	}
+
+	out.WriteString("# HELP chatproxy_cost_dollars_total Estimated cost of completions in US dollars, by model.\n")
+	out.WriteString("# TYPE chatproxy_cost_dollars_total counter\n")
+	for _, model := range sortedKeys(m.cost) {
+		fmt.Fprintf(out, "chatproxy_cost_dollars_total{model=%s} %g\n", labelValue(model), m.cost[model])
+	}
+	return out.String()
+}
+
+// labelValue quotes a label value as the text format requires.
+func labelValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
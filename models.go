@@ -0,0 +1,112 @@
+package chatproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ModelContextSize is the context window, in tokens, of the models chatproxy
+// knows about.
+var ModelContextSize = map[string]int{
+	openai.GPT4:             8192,
+	openai.GPT40613:         8192,
+	openai.GPT432K:          32768,
+	openai.GPT3Dot5Turbo:    4096,
+	openai.GPT3Dot5Turbo16K: 16384,
+
+	// Mistral
+	"mistral-large-latest": 128000,
+	"mistral-small-latest": 32000,
+	"open-mistral-nemo":    128000,
+	"codestral-latest":     32000,
+
+	// Groq
+	"llama-3.1-70b-versatile": 131072,
+	"llama-3.1-8b-instant":    131072,
+	"mixtral-8x7b-32768":      32768,
+	"gemma2-9b-it":            8192,
+}
+
+// ModelInfo describes a model available to the API key. ContextSize and
+// Pricing are zero for models chatproxy doesn't know about.
+type ModelInfo struct {
+	ID          string  `json:"id"`
+	ContextSize int     `json:"context_size,omitempty"`
+	Pricing     Pricing `json:"pricing"`
+}
+
+// DescribeModels returns the models with the given IDs, sorted by ID, along
+// with their context sizes and pricing where known. Unless all is set, only
+// chat models are included: OpenAI's GPT models, and the other providers'
+// models chatproxy knows about.
+func DescribeModels(ids []string, all bool) []ModelInfo {
+	var models []ModelInfo
+	for _, id := range ids {
+		if _, known := ModelContextSize[id]; !all && !known && !strings.HasPrefix(id, "gpt-") {
+			continue
+		}
+		models = append(models, ModelInfo{
+			ID:          id,
+			ContextSize: ModelContextSize[id],
+			Pricing:     ModelPricing[id],
+		})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models
+}
+
+// Models lists the models available to the client's API key, as described
+// by DescribeModels.
+func (c *ChatGPTClient) Models(ctx context.Context, all bool) ([]ModelInfo, error) {
+	if c.provider == "openrouter" {
+		// OpenRouter's list includes the context window and prices of every
+		// model, all of which are chat models.
+		catalog, err := c.openRouterModels()
+		if err != nil {
+			return nil, err
+		}
+		models := make([]ModelInfo, 0, len(catalog))
+		for _, m := range catalog {
+			models = append(models, m)
+		}
+		sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+		return models, nil
+	}
+	list, err := c.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(list.Models))
+	for i, m := range list.Models {
+		ids[i] = m.ID
+	}
+	return DescribeModels(ids, all), nil
+}
+
+// writeModels prints models as a table, marking the current model with *.
+func writeModels(w io.Writer, models []ModelInfo, current string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  MODEL\tCONTEXT\tPROMPT $/1K\tCOMPLETION $/1K")
+	for _, m := range models {
+		mark := " "
+		if m.ID == current {
+			mark = "*"
+		}
+		context, prompt, completion := "-", "-", "-"
+		if m.ContextSize > 0 {
+			context = formatThousands(m.ContextSize)
+		}
+		if m.Pricing != (Pricing{}) {
+			prompt = fmt.Sprintf("%g", m.Pricing.Prompt)
+			completion = fmt.Sprintf("%g", m.Pricing.Completion)
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\t%s\n", mark, m.ID, context, prompt, completion)
+	}
+	return tw.Flush()
+}
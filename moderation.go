@@ -0,0 +1,123 @@
+package chatproxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// What to do with prompts or replies that the moderation endpoint flags.
+const (
+	// ModerationOff doesn't check them. It is the default.
+	ModerationOff = "off"
+	// ModerationFlag logs a warning and carries on.
+	ModerationFlag = "flag"
+	// ModerationBlock refuses them with ErrModerated.
+	ModerationBlock = "block"
+)
+
+// ModerationActions are the moderation actions that can be configured.
+var ModerationActions = []string{ModerationOff, ModerationFlag, ModerationBlock}
+
+// WithModeration sets what to do with prompts and replies flagged by the
+// moderation endpoint of the client's API, one of ModerationActions each.
+// Prompts are checked before they are sent, and replies before they are
+// returned, so replies that may be blocked aren't streamed. The last
+// message of a conversation whose prompt or reply is blocked is rolled
+// back, so that it can go on.
+func WithModeration(prompts, replies string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.moderatePrompts = prompts
+		c.moderateReplies = replies
+		return c
+	}
+}
+
+// moderatePrompt checks the messages of the request opts would make that
+// the user has sent since the last reply.
+func (c *ChatGPTClient) moderatePrompt(opts ...CompletionOption) error {
+	if !moderating(c.moderatePrompts) {
+		return nil
+	}
+	req := c.completionRequest(opts...)
+	var prompt []string
+	for i := len(req.Messages) - 1; i >= 0 && req.Messages[i].Role != RoleBot; i-- {
+		if req.Messages[i].Role == RoleUser {
+			prompt = append([]string{req.Messages[i].Content}, prompt...)
+		}
+	}
+	err := c.moderate(c.moderatePrompts, "prompt", strings.Join(prompt, "\n\n"))
+	if err != nil && c.moderatePrompts == ModerationBlock {
+		c.RollbackLastMessage()
+	}
+	return err
+}
+
+// moderate checks text, the named subject, with the moderation endpoint
+// and acts on it if it is flagged. If it can't be checked, it is blocked,
+// or with ModerationFlag, a warning is logged.
+func (c *ChatGPTClient) moderate(action, subject, text string) error {
+	if !moderating(action) || strings.TrimSpace(text) == "" {
+		return nil
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	resp, err := c.client.Moderations(ctx, openai.ModerationRequest{Input: text, Model: c.moderationModel})
+	if err != nil {
+		err = fmt.Errorf("checking the %s with the moderation endpoint: %w", subject, err)
+		if action == ModerationBlock {
+			return err
+		}
+		c.Logger().Warn(err.Error())
+		return nil
+	}
+	flagged := false
+	var categories []string
+	for _, r := range resp.Results {
+		flagged = flagged || r.Flagged
+		categories = append(categories, flaggedCategories(r.Categories)...)
+	}
+	if !flagged {
+		return nil
+	}
+	c.Logger().Warn("flagged by moderation", "subject", subject, "categories", categories, "action", action)
+	if action == ModerationBlock {
+		return fmt.Errorf("%w: the %s was flagged for %s", ErrModerated, subject, describeCategories(categories))
+	}
+	return nil
+}
+
+func moderating(action string) bool {
+	return action == ModerationFlag || action == ModerationBlock
+}
+
+// flaggedCategories returns the names of the categories flagged, as the
+// moderation endpoint names them.
+func flaggedCategories(c openai.ResultCategories) []string {
+	var names []string
+	for _, category := range []struct {
+		name    string
+		flagged bool
+	}{
+		{"hate", c.Hate},
+		{"hate/threatening", c.HateThreatening},
+		{"self-harm", c.SelfHarm},
+		{"sexual", c.Sexual},
+		{"sexual/minors", c.SexualMinors},
+		{"violence", c.Violence},
+		{"violence/graphic", c.ViolenceGraphic},
+	} {
+		if category.flagged {
+			names = append(names, category.name)
+		}
+	}
+	return names
+}
+
+func describeCategories(categories []string) string {
+	if len(categories) == 0 {
+		return "violating the usage policies"
+	}
+	return strings.Join(categories, ", ")
+}
@@ -0,0 +1,73 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Notebook is the subset of a Jupyter notebook that is worth sending to the
+// model. Cell outputs are deliberately ignored, as they are frequently
+// base64 encoded images that waste tokens.
+type Notebook struct {
+	Metadata struct {
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []NotebookCell `json:"cells"`
+}
+
+// NotebookCell is a single code or markdown cell of a Jupyter notebook.
+type NotebookCell struct {
+	CellType string         `json:"cell_type"`
+	Source   notebookSource `json:"source"`
+}
+
+// notebookSource handles cell sources, which may be either a single string
+// or a list of lines depending on the tool that wrote the notebook.
+type notebookSource string
+
+func (s *notebookSource) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*s = notebookSource(strings.Join(lines, ""))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*s = notebookSource(text)
+	return nil
+}
+
+// notebookText renders a Jupyter notebook as only its markdown and code cells.
+func notebookText(path string, content []byte) (string, error) {
+	var nb Notebook
+	err := json.Unmarshal(content, &nb)
+	if err != nil {
+		return "", fmt.Errorf("parsing notebook %s: %w", path, err)
+	}
+	return nb.String(), nil
+}
+
+// String renders the notebook as markdown, with code cells fenced using
+// the notebook's kernel language.
+func (nb Notebook) String() string {
+	lang := nb.Metadata.LanguageInfo.Name
+	b := new(strings.Builder)
+	for _, cell := range nb.Cells {
+		source := strings.TrimSpace(string(cell.Source))
+		if source == "" {
+			continue
+		}
+		switch cell.CellType {
+		case "code":
+			fmt.Fprintf(b, "```%s\n%s\n```\n\n", lang, source)
+		case "markdown", "raw":
+			fmt.Fprintf(b, "%s\n\n", source)
+		}
+	}
+	return b.String()
+}
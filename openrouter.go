@@ -0,0 +1,180 @@
+package chatproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenRouter asks apps to identify themselves with these headers, which
+// credit requests to chatproxy in its rankings.
+const (
+	openRouterReferer = "https://github.com/mr-joshcrane/chatproxy"
+	openRouterTitle   = "chatproxy"
+)
+
+// CheapestModelPrefix starts a model that names several models to choose
+// from, such as "cheapest:openai/gpt-4o-mini,anthropic/claude-3-haiku".
+// Each completion is sent to the cheapest of them whose context window
+// fits the prompt, by the prices OpenRouter publishes, or, with other
+// providers, those chatproxy knows.
+const CheapestModelPrefix = "cheapest:"
+
+// openRouterCatalogTTL is how long OpenRouter's list of models and their
+// prices is used before it is fetched again.
+const openRouterCatalogTTL = time.Hour
+
+// openRouterCatalogs caches OpenRouter's models by base URL, for every
+// client, as the list is the same for everyone.
+var openRouterCatalogs = struct {
+	sync.Mutex
+	byURL map[string]openRouterCatalog
+}{byURL: map[string]openRouterCatalog{}}
+
+type openRouterCatalog struct {
+	models  map[string]ModelInfo
+	fetched time.Time
+}
+
+// openRouterTransport adds the headers OpenRouter asks for to each request.
+type openRouterTransport struct {
+	base http.RoundTripper
+}
+
+func (t openRouterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	setOpenRouterHeaders(req.Header)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func setOpenRouterHeaders(h http.Header) {
+	h.Set("HTTP-Referer", openRouterReferer)
+	h.Set("X-Title", openRouterTitle)
+}
+
+// withOpenRouterHeaders returns hc, or a default client if it is nil, with
+// the headers OpenRouter asks for added to each request.
+func withOpenRouterHeaders(hc *http.Client) *http.Client {
+	if hc == nil {
+		return &http.Client{Transport: openRouterTransport{}}
+	}
+	copied := *hc
+	copied.Transport = openRouterTransport{base: hc.Transport}
+	return &copied
+}
+
+// openRouterModels returns the models OpenRouter routes to, by ID, with
+// their context windows and prices, fetching the list at most once an hour.
+func (c *ChatGPTClient) openRouterModels() (map[string]ModelInfo, error) {
+	base := c.apiBaseURL()
+	openRouterCatalogs.Lock()
+	defer openRouterCatalogs.Unlock()
+	cached, ok := openRouterCatalogs.byURL[base]
+	if ok && time.Since(cached.fetched) < openRouterCatalogTTL {
+		return cached.models, nil
+	}
+	var list struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+			Pricing       struct {
+				Prompt     string `json:"prompt"`
+				Completion string `json:"completion"`
+			} `json:"pricing"`
+		} `json:"data"`
+	}
+	err := doJSON(withOpenRouterHeaders(c.httpClient), http.MethodGet, base+"/models", nil, nil, &list)
+	if err != nil {
+		return nil, fmt.Errorf("listing OpenRouter's models: %w", err)
+	}
+	models := make(map[string]ModelInfo, len(list.Data))
+	for _, m := range list.Data {
+		// Prices are given in dollars per token, as strings.
+		prompt, _ := strconv.ParseFloat(m.Pricing.Prompt, 64)
+		completion, _ := strconv.ParseFloat(m.Pricing.Completion, 64)
+		models[m.ID] = ModelInfo{
+			ID:          m.ID,
+			ContextSize: m.ContextLength,
+			Pricing:     Pricing{Prompt: prompt * 1000, Completion: completion * 1000},
+		}
+	}
+	openRouterCatalogs.byURL[base] = openRouterCatalog{models: models, fetched: time.Now()}
+	return models, nil
+}
+
+// describeModel returns the context window and prices of a model, from
+// those chatproxy knows, or else from OpenRouter's list if it has been
+// fetched, and whether its prices are known. A variant, such as :floor or
+// :nitro, which OpenRouter uses to choose how a model is routed, is
+// ignored, except for :free, whose models cost nothing.
+func describeModel(model string) (ModelInfo, bool) {
+	if pricing, ok := ModelPricing[model]; ok {
+		return ModelInfo{ID: model, ContextSize: ModelContextSize[model], Pricing: pricing}, true
+	}
+	id, variant, _ := strings.Cut(model, ":")
+	openRouterCatalogs.Lock()
+	defer openRouterCatalogs.Unlock()
+	for _, catalog := range openRouterCatalogs.byURL {
+		m, ok := catalog.models[model]
+		if !ok {
+			m, ok = catalog.models[id]
+			if variant == "free" {
+				m.Pricing = Pricing{}
+			}
+		}
+		if ok {
+			m.ID = model
+			return m, true
+		}
+	}
+	return ModelInfo{ID: model, ContextSize: ModelContextSize[model]}, false
+}
+
+// routeModel returns the model to send a request to: the client's model,
+// or for a model starting with CheapestModelPrefix, the cheapest of those
+// it names whose context window fits the prompt. The cheapest is the one
+// whose prompt, and a reply of a thousand tokens, would cost the least.
+// Models whose prices aren't known are skipped.
+func (c *ChatGPTClient) routeModel(req openai.ChatCompletionRequest) (string, error) {
+	list, ok := strings.CutPrefix(req.Model, CheapestModelPrefix)
+	if !ok {
+		return req.Model, nil
+	}
+	if c.provider == "openrouter" {
+		_, err := c.openRouterModels()
+		if err != nil {
+			return "", err
+		}
+	}
+	tokens := guessTokens(promptText(req))
+	var candidates []ModelInfo
+	for _, model := range strings.Split(list, ",") {
+		m, known := describeModel(strings.TrimSpace(model))
+		if !known {
+			continue
+		}
+		if m.ContextSize > 0 && tokens >= m.ContextSize {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("none of the models in %q has known prices and a context window that fits the prompt of about %d tokens", req.Model, tokens)
+	}
+	cost := func(m ModelInfo) float64 {
+		return float64(tokens)/1000*m.Pricing.Prompt + m.Pricing.Completion
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return cost(candidates[i]) < cost(candidates[j]) })
+	c.Logger().Debug("routing to the cheapest model", "model", candidates[0].ID, "models", list)
+	return candidates[0].ID, nil
+}
@@ -0,0 +1,68 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Output formats accepted by the --output flag.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// Result is the outcome of a command, printed as a JSON document by
+// --output json so that the tools can be composed in scripts and CI.
+type Result struct {
+	Answer    string      `json:"answer,omitempty"`
+	Cards     []Flashcard `json:"cards,omitempty"`
+	Model     string      `json:"model"`
+	Usage     Usage       `json:"usage"`
+	Citations []string    `json:"citations,omitempty"`
+}
+
+// parseOutputFormat checks that format is one of the supported formats.
+func parseOutputFormat(format string) (string, error) {
+	switch format {
+	case OutputText, OutputJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, expected %s or %s", format, OutputText, OutputJSON)
+}
+
+// resultWriter prepares the client to print a result in format, returning
+// the writer the result should be printed to. For JSON, anything else the
+// client prints goes to its error stream instead, so that standard output
+// holds only the JSON document, and replies are neither streamed nor
+// rendered.
+func (c *ChatGPTClient) resultWriter(format string) io.Writer {
+	out := c.output
+	if format == OutputJSON {
+		c.output = c.errorStream
+		c.streaming = false
+		c.markdown = false
+	}
+	return out
+}
+
+// result returns the result of the client's completions so far.
+func (c *ChatGPTClient) result(answer string, citations ...string) Result {
+	return Result{
+		Answer:    answer,
+		Model:     c.model,
+		Usage:     c.sessionUsage,
+		Citations: citations,
+	}
+}
+
+// printResult prints r to w as a single line of JSON, returning the
+// command's exit code.
+func (c *ChatGPTClient) printResult(w io.Writer, r Result) int {
+	err := json.NewEncoder(w).Encode(r)
+	if err != nil {
+		c.LogErr(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package chatproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Kinds of personal information that can be masked.
+const (
+	PIIEmail = "email"
+	PIIPhone = "phone"
+	PIIName  = "name"
+)
+
+// MaskedPII is a piece of personal information masked from prompts, and
+// the placeholder it was replaced with, such as [EMAIL 1].
+type MaskedPII struct {
+	Kind        string `json:"kind"`
+	Value       string `json:"value"`
+	Placeholder string `json:"placeholder"`
+}
+
+var (
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)
+	// phonePattern matches runs of digits that may be phone numbers, which
+	// are checked by isPhoneNumber.
+	phonePattern = regexp.MustCompile(`(?:\+|\(|\b)\d[\d ().-]{6,}\d\b`)
+	datePattern  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}|^\d{1,2}[./-]\d{1,2}[./-]\d{2,4}$`)
+	ipv4Pattern  = regexp.MustCompile(`^\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}$`)
+	// namePattern matches names given a title, or starting with a common
+	// first name. Names are hard to tell from other capitalised words, so
+	// names that matter should be listed in the config as well.
+	namePattern = regexp.MustCompile(`\b(?:(?:Mr|Mrs|Ms|Mx|Miss|Dr|Prof)\.? +[A-Z][a-z]+(?: +[A-Z][a-z]+)?|(?:` +
+		strings.Join(commonFirstNames, "|") + `) +[A-Z][a-z]+(?:-[A-Z][a-z]+)?)\b`)
+)
+
+var commonFirstNames = []string{
+	"James", "John", "Robert", "Michael", "William", "David", "Richard", "Joseph", "Thomas", "Charles",
+	"Daniel", "Matthew", "Paul", "Steven", "Andrew", "Joshua", "Kevin", "Brian", "George",
+	"Mary", "Patricia", "Jennifer", "Linda", "Elizabeth", "Barbara", "Susan", "Jessica", "Sarah", "Karen",
+	"Emily", "Emma", "Olivia", "Sophie", "Laura", "Anna", "Rachel", "Hannah", "Alice", "Chloe",
+	"Mohammed", "Muhammad", "Ahmed", "Raj", "Priya", "Carlos", "Maria", "Jose",
+}
+
+// isPhoneNumber reports whether s, a match of phonePattern, is likely to be
+// a phone number rather than a date, an IP address or another number.
+func isPhoneNumber(s string) bool {
+	if datePattern.MatchString(s) || ipv4Pattern.MatchString(s) {
+		return false
+	}
+	digits := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	// Numbers without separators are more often IDs than phone numbers,
+	// unless they are in international format.
+	if !strings.ContainsAny(s, " ().-") && !strings.HasPrefix(s, "+") {
+		return false
+	}
+	return digits >= 9 && digits <= 15
+}
+
+// piiMasker replaces personal information with placeholders, giving each
+// value the same placeholder every time it is seen, so that the model can
+// still tell people apart, and remembers what it masked.
+type piiMasker struct {
+	mu           sync.Mutex
+	names        []*regexp.Regexp
+	placeholders map[string]string
+	counts       map[string]int
+	masked       []MaskedPII
+}
+
+func newPIIMasker(names []string) *piiMasker {
+	m := &piiMasker{placeholders: map[string]string{}, counts: map[string]int{}}
+	for _, name := range names {
+		m.names = append(m.names, regexp.MustCompile(`\b`+regexp.QuoteMeta(name)+`\b`))
+	}
+	return m
+}
+
+// mask returns s with the personal information in it replaced, calling
+// found for each value not masked before.
+func (m *piiMasker) mask(s string, found func(MaskedPII)) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replace := func(kind string) func(string) string {
+		return func(value string) string {
+			if kind == PIIPhone && !isPhoneNumber(value) {
+				return value
+			}
+			placeholder, ok := m.placeholders[value]
+			if !ok {
+				m.counts[kind]++
+				placeholder = fmt.Sprintf("[%s %d]", strings.ToUpper(kind), m.counts[kind])
+				m.placeholders[value] = placeholder
+				masked := MaskedPII{Kind: kind, Value: value, Placeholder: placeholder}
+				m.masked = append(m.masked, masked)
+				found(masked)
+			}
+			return placeholder
+		}
+	}
+	s = emailPattern.ReplaceAllStringFunc(s, replace(PIIEmail))
+	s = phonePattern.ReplaceAllStringFunc(s, replace(PIIPhone))
+	for _, name := range m.names {
+		s = name.ReplaceAllStringFunc(s, replace(PIIName))
+	}
+	return namePattern.ReplaceAllStringFunc(s, replace(PIIName))
+}
+
+// WithPIIMasking sets whether emails, phone numbers and names are masked
+// from prompts before they are sent to the API, replaced with placeholders
+// such as [EMAIL 1]. Names given are masked wherever they appear, as well
+// as those chatproxy recognises. Each value masked is reported to the
+// error stream, unless the client is quiet, and by PIIReport. Server
+// commands report only its kind and placeholder.
+func WithPIIMasking(enabled bool, names ...string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.maskPII = enabled
+		c.piiNames = append(c.piiNames, names...)
+		return c
+	}
+}
+
+// PIIReport returns the personal information masked from the client's
+// prompts so far, in the order it was found.
+func (c *ChatGPTClient) PIIReport() []MaskedPII {
+	if c.pii == nil {
+		return nil
+	}
+	c.pii.mu.Lock()
+	defer c.pii.mu.Unlock()
+	return append([]MaskedPII(nil), c.pii.masked...)
+}
+
+// maskPIIIn returns s with personal information masked if the client masks
+// it, reporting each value masked for the first time.
+func (c *ChatGPTClient) maskPIIIn(s string) string {
+	if !c.maskPII {
+		return s
+	}
+	if c.pii == nil {
+		c.pii = newPIIMasker(c.piiNames)
+	}
+	return c.pii.mask(s, func(m MaskedPII) {
+		if c.serving {
+			c.progressf("Masked %s as %s\n", m.Kind, m.Placeholder)
+			return
+		}
+		c.progressf("Masked %s %q as %s\n", m.Kind, m.Value, m.Placeholder)
+	})
+}
+
+// asServer marks the client as serving other users, whose personal
+// information is then not echoed to the server's log as it is masked.
+func asServer() ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.serving = true
+		return c
+	}
+}
@@ -0,0 +1,204 @@
+package chatproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the executables on PATH that chatproxy runs
+// as subcommands, as git does: "chatproxy foo" runs chatproxy-foo, when foo
+// isn't one of chatproxy's own commands, with the rest of the arguments.
+const PluginPrefix = "chatproxy-"
+
+// PluginProtocol is the version of the protocol plugins are run with.
+const PluginProtocol = 1
+
+// A plugin is run with these environment variables set, so that it can use
+// chatproxy's config and client without an API key of its own:
+//
+//   - CHATPROXY_PLUGIN_URL is the base URL of an API served to the plugin
+//     by chatproxy while it runs, ending in /v1
+//   - CHATPROXY_PLUGIN_TOKEN is the bearer token the API requires
+//   - CHATPROXY_PLUGIN_PROTOCOL is PluginProtocol
+//
+// The API has two endpoints. GET /v1/config returns a PluginConfig, as
+// JSON. POST /v1/chat/completions answers chat completion requests in the
+// OpenAI API's format, streamed or not, with chatproxy's client, so that
+// plugins can use an OpenAI SDK, or NewPluginClient from Go.
+const (
+	PluginURLEnv      = "CHATPROXY_PLUGIN_URL"
+	PluginTokenEnv    = "CHATPROXY_PLUGIN_TOKEN"
+	PluginProtocolEnv = "CHATPROXY_PLUGIN_PROTOCOL"
+)
+
+// PluginConfig is the config a plugin is given: the settings chatproxy's
+// client has for the plugin's command, including any under commands in the
+// config, and the parts of the config that plugins can share. Keys, and
+// settings that only chatproxy's own servers use, aren't given.
+type PluginConfig struct {
+	Protocol    int               `json:"protocol"`
+	Version     string            `json:"version"`
+	Command     string            `json:"command"`
+	Model       string            `json:"model"`
+	Provider    string            `json:"provider"`
+	Streaming   bool              `json:"streaming"`
+	Temperature float32           `json:"temperature"`
+	Purposes    map[string]string `json:"purposes,omitempty"`
+	Ignore      []string          `json:"ignore,omitempty"`
+}
+
+// NewPluginClient returns a client for a plugin, which makes its
+// completions through the chatproxy that ran it. It returns an error if
+// the program wasn't run as a plugin.
+func NewPluginClient(opts ...ClientOption) (*ChatGPTClient, error) {
+	u, token, err := pluginEnv()
+	if err != nil {
+		return nil, err
+	}
+	// The plugin API's address and token come last, so that a base URL in
+	// the config doesn't replace them.
+	return NewChatGPTClient(append(opts, WithBaseURL(u+"/"), WithToken(token))...)
+}
+
+// LoadPluginConfig returns the config of the chatproxy that ran the
+// plugin. It returns an error if the program wasn't run as a plugin.
+func LoadPluginConfig() (PluginConfig, error) {
+	var cfg PluginConfig
+	u, token, err := pluginEnv()
+	if err != nil {
+		return cfg, err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	err = doJSON(http.DefaultClient, http.MethodGet, u+"/config", header, nil, &cfg)
+	return cfg, err
+}
+
+func pluginEnv() (string, string, error) {
+	u, token := os.Getenv(PluginURLEnv), os.Getenv(PluginTokenEnv)
+	if u == "" || token == "" {
+		return "", "", fmt.Errorf("%s and %s aren't set; plugins must be run by chatproxy", PluginURLEnv, PluginTokenEnv)
+	}
+	return strings.TrimSuffix(u, "/"), token, nil
+}
+
+// PluginNames returns the names of the plugins on PATH, without
+// PluginPrefix, in sorted order. Plugins with the names of chatproxy's own
+// commands are left out, as they can't be run.
+func PluginNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), PluginPrefix)
+			if !ok || name == "" || seen[name] {
+				continue
+			}
+			if _, builtin := lookupCommand(name); builtin {
+				continue
+			}
+			if _, err := exec.LookPath(filepath.Join(dir, e.Name())); err != nil {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runPlugin runs the plugin at path as the command name, serving it the
+// plugin API until it exits, and returns its exit code.
+func runPlugin(name, path string, args []string) int {
+	// The plugin, not chatproxy, owns the terminal.
+	client, err := newCommandClient(WithCommand(name), WithOutput(io.Discard, os.Stderr), unattended())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCode(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	token := make([]byte, 24)
+	rand.Read(token)
+	server := &pluginServer{client: client, token: hex.EncodeToString(token)}
+	srv := &http.Server{Handler: server}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		PluginURLEnv+"=http://"+ln.Addr().String()+"/v1",
+		PluginTokenEnv+"="+server.token,
+		fmt.Sprintf("%s=%d", PluginProtocolEnv, PluginProtocol),
+	)
+	// The plugin receives interrupts from the terminal too, and decides what
+	// to do with them; chatproxy waits for it to exit.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return 0
+}
+
+// pluginServer serves the plugin API to a plugin, to which it gave token.
+type pluginServer struct {
+	client *ChatGPTClient
+	token  string
+}
+
+func (p *pluginServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := callerFor(map[string]string{"plugin": p.token}, r.Header.Get("Authorization")); !ok {
+		writeAPIError(w, http.StatusUnauthorized, "invalid_request_error", "incorrect token for the plugin API")
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/config":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		c := p.client
+		writeJSON(w, PluginConfig{
+			Protocol:    PluginProtocol,
+			Version:     version(),
+			Command:     c.command,
+			Model:       c.model,
+			Provider:    c.provider,
+			Streaming:   c.streaming,
+			Temperature: c.temperature,
+			Purposes:    c.config.Purposes,
+			Ignore:      c.config.Ignore,
+		})
+	case GatewayPath:
+		p.client.newConversation().serveCompletion(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, "invalid_request_error", "only /v1/config and "+GatewayPath+" are served")
+	}
+}
@@ -0,0 +1,93 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// PullRequest is the title and description of a pull request.
+type PullRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// DefaultBranch returns the branch the origin remote's HEAD points to, such
+// as origin/main, or main if there isn't one.
+func DefaultBranch() string {
+	out, err := exec.Command("git", "symbolic-ref", "--short", "refs/remotes/origin/HEAD").Output()
+	if err != nil {
+		return "main"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// currentBranch returns the name of the branch checked out.
+func currentBranch() (string, error) {
+	out, err := exec.Command("git", "symbolic-ref", "--short", "HEAD").Output()
+	if err != nil {
+		return "", errors.New("HEAD isn't on a branch")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// PullRequestFor writes the title and description of a pull request that
+// merges the current branch into base, from the branch's commits and its
+// diff from where it left base. A diff too large for the model's context is
+// sent as a summary of the files changed.
+func (c *ChatGPTClient) PullRequestFor(base string) (PullRequest, error) {
+	return c.pullRequestBetween(base, "HEAD")
+}
+
+// pullRequestBetween writes the title and description of a pull request
+// that merges the commit head into base.
+func (c *ChatGPTClient) pullRequestBetween(base, head string) (PullRequest, error) {
+	commits, err := gitLog(base+".."+head, false)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	if len(commits) == 0 {
+		return PullRequest{}, fmt.Errorf("there are no commits on this branch that aren't in %s", base)
+	}
+	diff, err := rangeDiff(base+"..."+head, c.diffBudget())
+	if err != nil {
+		return PullRequest{}, err
+	}
+	c.SetPurpose(`Please write the title and description of a pull request for the git commits and diff provided, for the engineers who will review it.
+	The title is at most 72 characters, in the imperative mood, saying what the change does.
+	The description, in Markdown, starts with a short summary of what the change does and why, then lists the notable changes.
+	Mention how the change was tested if the commits say, and point out anything reviewers should look at closely, such as risky or breaking changes.
+	Reply with only a JSON object, like this:
+	{"title": "Retry failed uploads", "body": "Uploads that fail with a timeout are now retried..."}`)
+	c.RecordMessage(RoleUser, "Commits:\n"+formatCommits(commits)+"\nDiff:\n"+diff)
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return PullRequest{}, err
+	}
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	var pr PullRequest
+	err = json.Unmarshal([]byte(reply), &pr)
+	if err != nil {
+		return PullRequest{}, errors.New("couldn't parse the pull request: " + err.Error())
+	}
+	if pr.Title == "" {
+		return PullRequest{}, errors.New("the model didn't write a pull request title")
+	}
+	return pr, nil
+}
+
+// WritePullRequest writes the pull request to w as its title, a blank line
+// and its description, or as JSON if format is OutputJSON.
+func WritePullRequest(w io.Writer, pr PullRequest, format string) error {
+	if format == OutputJSON {
+		return json.NewEncoder(w).Encode(pr)
+	}
+	_, err := fmt.Fprintf(w, "%s\n\n%s\n", pr.Title, strings.TrimSpace(pr.Body))
+	return err
+}
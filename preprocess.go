@@ -0,0 +1,97 @@
+package chatproxy
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Preprocessor converts the raw contents of a file into text that is better
+// suited to being sent to the model. Preprocessors allow new file formats
+// to be supported without modifying chatproxy.
+type Preprocessor interface {
+	// Match reports whether the preprocessor handles the file at path,
+	// given its detected MIME type.
+	Match(path string, mimeType string) bool
+	// Process transforms the file contents into text.
+	Process(path string, content []byte) (string, error)
+}
+
+// ExtensionPreprocessor is a Preprocessor that matches files by their
+// extension or MIME type, and transforms them with a function.
+type ExtensionPreprocessor struct {
+	Extensions []string
+	MIMETypes  []string
+	Transform  func(path string, content []byte) (string, error)
+}
+
+// Match reports whether the path has one of the preprocessor's extensions,
+// or the content has one of its MIME types.
+func (p ExtensionPreprocessor) Match(path string, mimeType string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range p.Extensions {
+		if strings.ToLower(e) == ext {
+			return true
+		}
+	}
+	for _, m := range p.MIMETypes {
+		if m == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// Process transforms the file contents using the preprocessor's Transform function.
+func (p ExtensionPreprocessor) Process(path string, content []byte) (string, error) {
+	return p.Transform(path, content)
+}
+
+// DefaultPreprocessors returns the preprocessors every client starts with,
+// summarising tabular data and stripping notebook outputs.
+func DefaultPreprocessors() []Preprocessor {
+	return []Preprocessor{
+		ExtensionPreprocessor{
+			Extensions: []string{".csv", ".tsv", ".xlsx"},
+			Transform:  tableText,
+		},
+		ExtensionPreprocessor{
+			Extensions: []string{".ipynb"},
+			Transform:  notebookText,
+		},
+	}
+}
+
+// WithPreprocessor registers an additional Preprocessor on the ChatGPTClient. Registered
+// preprocessors take precedence over the defaults, so they can also override built in handling.
+func WithPreprocessor(p Preprocessor) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.preprocessors = append([]Preprocessor{p}, c.preprocessors...)
+		return c
+	}
+}
+
+// DetectMIMEType guesses the MIME type of a file, first from its extension
+// and then by sniffing its contents.
+func DetectMIMEType(path string, content []byte) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		mediaType, _, _ := mime.ParseMediaType(t)
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	return mediaType
+}
+
+// preprocess runs the first matching preprocessor over the content. It
+// reports false if no preprocessor matched.
+func preprocess(preprocessors []Preprocessor, path string, content []byte) (string, bool, error) {
+	mimeType := DetectMIMEType(path, content)
+	for _, p := range preprocessors {
+		if p.Match(path, mimeType) {
+			text, err := p.Process(path, content)
+			return text, true, err
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,128 @@
+package chatproxy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Profile is a named set of API credentials, such as separate keys for
+// work and personal use, defined under profiles in the user config file:
+//
+//	profile: personal
+//	profiles:
+//	  personal:
+//	    api_key_env: PERSONAL_OPENAI_API_KEY
+//	  work:
+//	    api_key_command: security find-generic-password -s openai-work -w
+//	    organization: org-123
+//
+// The key is taken from the first of these that gives one: the environment
+// variable named by api_key_env, the output of api_key_command, which can
+// read it from a keychain, and finally api_key itself.
+type Profile struct {
+	Provider      string `yaml:"provider,omitempty"`
+	APIKey        string `yaml:"api_key,omitempty"`
+	APIKeyEnv     string `yaml:"api_key_env,omitempty"`
+	APIKeyCommand string `yaml:"api_key_command,omitempty"`
+	Organization  string `yaml:"organization,omitempty"`
+}
+
+// apiKey resolves the key for the profile with the given name.
+func (p Profile) apiKey(name string) (string, error) {
+	if p.APIKeyEnv != "" {
+		if key := os.Getenv(p.APIKeyEnv); key != "" {
+			return key, nil
+		}
+	}
+	if p.APIKeyCommand != "" {
+		out, err := exec.Command("sh", "-c", p.APIKeyCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("profile %s: running api_key_command: %w", name, err)
+		}
+		if key := strings.TrimSpace(string(out)); key != "" {
+			return key, nil
+		}
+	}
+	if p.APIKey != "" {
+		return p.APIKey, nil
+	}
+	return "", fmt.Errorf("%w: profile %s has no API key: set api_key_env, api_key_command or api_key", ErrUnauthorized, name)
+}
+
+// WithProfile selects the named credential profile from the config file,
+// overriding the profile set there or in $CHATPROXY_PROFILE.
+func WithProfile(name string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.profile = name
+		return c
+	}
+}
+
+// Profile returns the name of the client's credential profile, or the empty
+// string if the key comes from $OPENAI_API_KEY.
+func (c *ChatGPTClient) Profile() string {
+	return c.profile
+}
+
+// newAPIClient creates an API client for the client's base URL and HTTP
+// client, with the key passed to WithToken, from the client's profile, or
+// from $OPENAI_API_KEY if no profile is selected.
+func (c *ChatGPTClient) newAPIClient() (*openai.Client, error) {
+	cfg, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	cfg.BaseURL = c.apiBaseURL()
+	if c.httpClient != nil {
+		cfg.HTTPClient = c.httpClient
+	}
+	if c.provider == "openrouter" {
+		cfg.HTTPClient = withOpenRouterHeaders(c.httpClient)
+	}
+	return openai.NewClientWithConfig(cfg), nil
+}
+
+// credentials returns an API config holding the client's key and
+// organization.
+func (c *ChatGPTClient) credentials() (openai.ClientConfig, error) {
+	key, org, err := c.apiKey()
+	if err != nil {
+		return openai.ClientConfig{}, err
+	}
+	cfg := openai.DefaultConfig(key)
+	cfg.OrgID = org
+	return cfg, nil
+}
+
+// apiKey returns the key passed to WithToken, from the client's profile
+// with the profile's organization, or from $OPENAI_API_KEY if no profile is
+// selected.
+func (c *ChatGPTClient) apiKey() (key, org string, err error) {
+	if c.token != nil {
+		return *c.token, "", nil
+	}
+	if c.profile == "" {
+		env := specFor(c.provider).keyEnv
+		token, ok := os.LookupEnv(env)
+		if !ok {
+			return "", "", fmt.Errorf("%w: must have %s env var set or pass token explicitly", ErrUnauthorized, env)
+		}
+		return token, "", nil
+	}
+	p, ok := c.config.Profiles[c.profile]
+	if !ok {
+		return "", "", fmt.Errorf("unknown profile %q", c.profile)
+	}
+	key, err = p.apiKey(c.profile)
+	if err != nil {
+		return "", "", err
+	}
+	if p.Provider != "" {
+		c.provider = p.Provider
+	}
+	return key, p.Organization, nil
+}
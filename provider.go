@@ -0,0 +1,67 @@
+package chatproxy
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Providers are the API providers that can be selected in the config file.
+var Providers = []string{"openai", "mistral", "groq", "openrouter"}
+
+// providerSpec is how to reach a provider's OpenAI compatible chat
+// completions API: where it is, the environment variable holding its key,
+// and the model used unless another is chosen.
+type providerSpec struct {
+	baseURL      string
+	keyEnv       string
+	defaultModel string
+}
+
+var providerSpecs = map[string]providerSpec{
+	"openai":  {baseURL: openai.DefaultConfig("").BaseURL, keyEnv: "OPENAI_API_KEY", defaultModel: openai.GPT4},
+	"mistral": {baseURL: "https://api.mistral.ai/v1", keyEnv: "MISTRAL_API_KEY", defaultModel: "mistral-large-latest"},
+	"groq":    {baseURL: "https://api.groq.com/openai/v1", keyEnv: "GROQ_API_KEY", defaultModel: "llama-3.1-70b-versatile"},
+	// OpenRouter routes to many providers' models, named with the provider,
+	// such as anthropic/claude-3.5-sonnet, through one key.
+	"openrouter": {baseURL: "https://openrouter.ai/api/v1", keyEnv: "OPENROUTER_API_KEY", defaultModel: "openrouter/auto"},
+}
+
+// specFor returns the spec of the named provider, or OpenAI's if there is
+// no such provider.
+func specFor(provider string) providerSpec {
+	spec, ok := providerSpecs[provider]
+	if !ok {
+		return providerSpecs["openai"]
+	}
+	return spec
+}
+
+// WithProvider selects the API provider, one of Providers, whose key is
+// read from its environment variable, such as GROQ_API_KEY, unless a
+// profile or WithToken gives one. Changing the provider also changes the
+// model to the provider's default, so WithModel should come after it.
+func WithProvider(name string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.setProvider(name)
+		return c
+	}
+}
+
+// setProvider selects the named provider, with its default model if it
+// isn't the provider already selected.
+func (c *ChatGPTClient) setProvider(name string) {
+	if name != c.provider {
+		c.model = specFor(name).defaultModel
+	}
+	c.provider = name
+}
+
+// apiBaseURL returns the base URL of the API the client sends requests to:
+// the one configured, or else the provider's.
+func (c *ChatGPTClient) apiBaseURL() string {
+	if c.baseURL != "" {
+		return strings.TrimSuffix(c.baseURL, "/")
+	}
+	return specFor(c.provider).baseURL
+}
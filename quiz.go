@@ -0,0 +1,165 @@
+package chatproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuizConfig controls the comprehension questions generated by the Quiz
+// strategy. It can be set under quiz in the config file.
+type QuizConfig struct {
+	// Questions is the number of questions to ask.
+	Questions int `yaml:"questions"`
+	// Difficulty describes how challenging the questions should be, e.g. "easy" or "hard".
+	Difficulty string `yaml:"difficulty"`
+	// Rubric describes how the questions should be chosen and the answers
+	// scored out of 10.
+	Rubric string `yaml:"rubric"`
+	// ScoresPath is the file scores are appended to. If empty, scores are
+	// stored in the application's state directory.
+	ScoresPath string `yaml:"scores_path"`
+}
+
+// DefaultQuizConfig returns the quiz settings used when none are configured.
+func DefaultQuizConfig() QuizConfig {
+	return QuizConfig{
+		Questions:  5,
+		Difficulty: "mixed",
+		Rubric: "Use Bloom's Taxonomy (2001) to generate the questions and to judge the depth of understanding shown. " +
+			"Do not generate questions about Bloom's Taxonomy.",
+	}
+}
+
+// WithQuiz configures the comprehension questions asked by the Quiz
+// strategy. Fields left unset keep the settings from the config, or the
+// defaults.
+func WithQuiz(config QuizConfig) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.quiz.override(config)
+		return c
+	}
+}
+
+func (q QuizConfig) validate() error {
+	if q.Questions < 0 {
+		return fmt.Errorf("quiz.questions %d must not be negative", q.Questions)
+	}
+	return nil
+}
+
+func (q *QuizConfig) override(o QuizConfig) {
+	if o.Questions != 0 {
+		q.Questions = o.Questions
+	}
+	if o.Difficulty != "" {
+		q.Difficulty = o.Difficulty
+	}
+	if o.Rubric != "" {
+		q.Rubric = o.Rubric
+	}
+	if o.ScoresPath != "" {
+		q.ScoresPath = o.ScoresPath
+	}
+}
+
+// Prompt builds the instructions sent to the model to start a quiz.
+func (q QuizConfig) Prompt() string {
+	return fmt.Sprintf(`Given the above text, generate %d reading comprehension questions of %s difficulty.
+	If I respond to the questions, you will give me a score out of 10 and how I can improve my answer.
+	Rubric: %s
+	Produce only the questions, the user will provide the answers.
+	Always give feedback in the form "Feedback: N/10 - explanation".
+
+	BOT: Q: What is the end goal of teaching.
+	USER: A: To know the answers to questions.
+	BOT: Feedback: 2/10 - This demonstrates only a surface understanding.
+	USER: A: To transfer knowledge in such a way that the learner can apply it in new situations.
+	BOT: Feedback: 10/10 - This gets a the heart of the answer.
+	`, q.Questions, q.Difficulty, q.Rubric)
+}
+
+type Quiz struct{ input string }
+
+// Execute method for Quiz strategy asks the model for
+// comprehension questions about the conversation so far.
+// Arguments after the ? override the number of questions
+// and difficulty, e.g. "? 3 hard". Feedback scores given
+// for the rest of the session are recorded.
+func (s Quiz) Execute(c *ChatGPTClient) error {
+	config := c.quiz
+	for _, arg := range strings.Fields(strings.TrimPrefix(s.input, "?")) {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			config.Questions = n
+		} else {
+			config.Difficulty = arg
+		}
+	}
+	c.quizActive = true
+	c.RecordMessage(RoleUser, config.Prompt())
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return err
+	}
+	c.RecordMessage(RoleBot, reply)
+	return nil
+}
+
+// QuizScore is a single score awarded to an answer during a quiz.
+type QuizScore struct {
+	Time    time.Time `json:"time"`
+	Purpose string    `json:"purpose"`
+	Score   int       `json:"score"`
+}
+
+var feedbackScore = regexp.MustCompile(`Feedback:\s*(\d+)\s*/\s*10`)
+
+// QuizScores extracts the scores out of 10 awarded in a feedback reply.
+func QuizScores(reply string) []int {
+	var scores []int
+	for _, m := range feedbackScore.FindAllStringSubmatch(reply, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err == nil {
+			scores = append(scores, n)
+		}
+	}
+	return scores
+}
+
+// recordQuizScores appends any scores in the reply to the scores file, when
+// a quiz is in progress.
+func (c *ChatGPTClient) recordQuizScores(reply string) error {
+	if !c.quizActive {
+		return nil
+	}
+	scores := QuizScores(reply)
+	if len(scores) == 0 {
+		return nil
+	}
+	path := c.quiz.ScoresPath
+	if path == "" {
+		dir, err := stateFileDir("quiz")
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, "scores.jsonl")
+	}
+	purpose := ""
+	if len(c.chatHistory) > 0 {
+		purpose = strings.TrimPrefix(c.chatHistory[0].Content, "PURPOSE: ")
+	}
+	var lines bytes.Buffer
+	enc := json.NewEncoder(&lines)
+	for _, score := range scores {
+		err := enc.Encode(QuizScore{Time: time.Now().UTC(), Purpose: purpose, Score: score})
+		if err != nil {
+			return err
+		}
+	}
+	return appendState(path, lines.Bytes())
+}
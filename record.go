@@ -0,0 +1,146 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// AsciicastRecorder records terminal output with its timing in the
+// asciicast v2 format, so a session can be replayed with asciinema or
+// embedded in documentation.
+type AsciicastRecorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	start   time.Time
+	partial []byte
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// NewAsciicastRecorder writes the asciicast header for a terminal of the
+// given size to w, and returns a recorder for the output that follows.
+func NewAsciicastRecorder(w io.Writer, width, height int) (*AsciicastRecorder, error) {
+	start := time.Now()
+	header, err := json.Marshal(asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Env:       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(append(header, '\n'))
+	if err != nil {
+		return nil, err
+	}
+	return &AsciicastRecorder{w: w, start: start}, nil
+}
+
+// Write records p as output at the current time. A multi-byte character
+// split across writes is held back until it is complete.
+func (r *AsciicastRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.partial, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.partial = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return len(p), nil
+	}
+	// Output is recorded before the terminal translates newlines, so do
+	// that here for players that render it verbatim.
+	out := strings.ReplaceAll(string(data[:cut]), "\r\n", "\n")
+	out = strings.ReplaceAll(out, "\n", "\r\n")
+	elapsed := time.Since(r.start).Seconds()
+	event, err := json.Marshal([]any{elapsed, "o", out})
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.w.Write(append(event, '\n'))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WithRecording records everything the chat writes to the terminal,
+// including echoed input, to w in asciicast v2 format.
+func WithRecording(w io.Writer) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.recording = w
+		return c
+	}
+}
+
+// startRecording tees the client's output and error streams into an
+// asciicast recording, if one was requested.
+func (c *ChatGPTClient) startRecording() error {
+	if c.recording == nil {
+		return nil
+	}
+	width, height := 80, 24
+	if f, ok := c.output.(interface{ Fd() uintptr }); ok {
+		if w, h, err := term.GetSize(int(f.Fd())); err == nil {
+			width, height = w, h
+		}
+	}
+	rec, err := NewAsciicastRecorder(c.recording, width, height)
+	if err != nil {
+		return err
+	}
+	c.recorder = rec
+	c.output = recordedWriter{c.output, rec}
+	c.errorStream = recordedWriter{c.errorStream, rec}
+	return nil
+}
+
+// recordEcho records input that the terminal echoed itself, which is not
+// seen by the output stream.
+func (c *ChatGPTClient) recordEcho(line string) {
+	if c.recorder != nil {
+		c.recorder.Write([]byte(line))
+	}
+}
+
+// recordedWriter copies writes into a recording. It reports the file
+// descriptor of the writer it wraps, so terminal detection is unaffected.
+type recordedWriter struct {
+	w   io.Writer
+	rec *AsciicastRecorder
+}
+
+func (r recordedWriter) Write(p []byte) (int, error) {
+	n, err := r.w.Write(p)
+	r.rec.Write(p[:n])
+	return n, err
+}
+
+func (r recordedWriter) Fd() uintptr {
+	if f, ok := r.w.(interface{ Fd() uintptr }); ok {
+		return f.Fd()
+	}
+	return ^uintptr(0)
+}
@@ -0,0 +1,80 @@
+package chatproxy
+
+import (
+	"regexp"
+)
+
+// Redacted replaces each secret found in a message.
+const Redacted = "[REDACTED]"
+
+// secretPattern matches a secret, which is replaced by repl, a template
+// for regexp.Regexp.ReplaceAllString that keeps the text around it.
+type secretPattern struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// secretPatterns match the secrets that end up in messages by accident,
+// most often when a file such as .env is loaded with the rest of a
+// directory.
+var secretPatterns = []secretPattern{
+	// Keys for OpenAI, Anthropic and OpenRouter, among others, start sk-.
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`), Redacted},
+	{regexp.MustCompile(`\bgsk_[A-Za-z0-9]{20,}`), Redacted},
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}`), Redacted},
+	{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), Redacted},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`), Redacted},
+	// AWS access key IDs, and secret access keys, which can only be told
+	// apart from other base64 by the name they are given.
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), Redacted},
+	{regexp.MustCompile(`(?i)(aws_?secret_?access_?key\W{0,3}\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`), "${1}" + Redacted},
+	// JSON Web Tokens: a header and payload, which are base64 encoded JSON
+	// objects, and a signature.
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), Redacted},
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), Redacted},
+}
+
+// WithRedaction also redacts whatever patterns match from messages, as it
+// does API keys, AWS credentials and JWTs, replacing them with Redacted.
+func WithRedaction(patterns ...*regexp.Regexp) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.redactPatterns = append(c.redactPatterns, patterns...)
+		return c
+	}
+}
+
+// WithPromptRedaction sets whether secrets are redacted from messages
+// before they are sent to the API, as well as from the transcript and logs.
+// It is off by default, as redacting a secret can change what the model is
+// asked, such as to explain why a token is rejected.
+func WithPromptRedaction(enabled bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.redactPrompts = enabled
+		return c
+	}
+}
+
+// redact replaces the secrets in s, and any text matching the client's
+// redaction patterns, with Redacted.
+func (c *ChatGPTClient) redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	for _, re := range c.redactPatterns {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+// compileRedactPatterns compiles the redaction patterns from the config.
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
@@ -0,0 +1,94 @@
+package chatproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ReleaseNotesConfig sets who release notes are written for and how they
+// sound, usually for a project in its project config:
+//
+//	release_notes:
+//	  audience: data scientists using the Python client
+//	  tone: friendly, with no jargon
+type ReleaseNotesConfig struct {
+	Audience string `yaml:"audience"`
+	Tone     string `yaml:"tone"`
+}
+
+// The audience and tone of release notes that don't configure them.
+const (
+	DefaultReleaseAudience = "the project's users, who don't read its code"
+	DefaultReleaseTone     = "friendly and clear"
+)
+
+func (cfg *ReleaseNotesConfig) override(o ReleaseNotesConfig) {
+	if o.Audience != "" {
+		cfg.Audience = o.Audience
+	}
+	if o.Tone != "" {
+		cfg.Tone = o.Tone
+	}
+}
+
+var pullRequestMerge = regexp.MustCompile(`^Merge pull request (#\d+) from `)
+
+// mergedPullRequests returns the titles of the pull requests merged in
+// revRange, from the merge commits GitHub writes, each with its number.
+func mergedPullRequests(revRange string) ([]string, error) {
+	merges, err := gitLog(revRange, true)
+	if err != nil {
+		return nil, err
+	}
+	var titles []string
+	for _, merge := range merges {
+		m := pullRequestMerge.FindStringSubmatch(merge.Subject)
+		if m == nil {
+			continue
+		}
+		title, _, _ := strings.Cut(merge.Body, "\n")
+		if title == "" {
+			continue
+		}
+		titles = append(titles, fmt.Sprintf("%s (%s)", title, m[1]))
+	}
+	return titles, nil
+}
+
+// ReleaseNotes writes user-facing release notes, in Markdown, for the
+// commits and merged pull requests in revRange, such as v1.2.0..v1.3.0.
+// They are less technical than a changelog, written for the audience and
+// in the tone given by cfg.
+func (c *ChatGPTClient) ReleaseNotes(revRange string, cfg ReleaseNotesConfig) (string, error) {
+	commits, err := gitLog(revRange, false)
+	if err != nil {
+		return "", err
+	}
+	pulls, err := mergedPullRequests(revRange)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 && len(pulls) == 0 {
+		return "", fmt.Errorf("there are no commits in %s", revRange)
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = DefaultReleaseAudience
+	}
+	if cfg.Tone == "" {
+		cfg.Tone = DefaultReleaseTone
+	}
+	version, _ := releaseOf(rangeEnd(revRange))
+	c.SetPurpose(`Please write the release notes for version ` + version + ` from the git commits and merged pull requests provided.
+	Write them for ` + cfg.Audience + `, in a tone that is ` + cfg.Tone + `.
+	Describe what users can now do and what was fixed, rather than how the code changed.
+	Start with a short summary of the release's highlights, then list the notable changes under headings, and call out anything users must do when they upgrade.
+	Leave out changes that don't matter to users, such as refactoring, tests and CI.
+	Reply with only the release notes, in Markdown.`)
+	msg := "Commits:\n" + formatCommits(commits)
+	if len(pulls) > 0 {
+		msg += "\nMerged pull requests:\n- " + strings.Join(pulls, "\n- ") + "\n"
+	}
+	c.RecordMessage(RoleUser, msg)
+	return c.GetCompletion()
+}
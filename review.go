@@ -0,0 +1,211 @@
+package chatproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Finding is an issue found in a code review, at a line of the new version
+// of a file.
+type Finding struct {
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Severity   string `json:"severity"`
+	Issue      string `json:"issue"`
+	Suggestion string `json:"suggestion"`
+}
+
+// The severities of findings, from most to least serious.
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// The formats review findings can be written in.
+const (
+	ReviewFormatText   = "text"
+	ReviewFormatJSON   = "json"
+	ReviewFormatGitHub = "github"
+)
+
+// ReviewFormats are the formats review findings can be written in.
+var ReviewFormats = []string{ReviewFormatText, ReviewFormatJSON, ReviewFormatGitHub}
+
+// ReviewDiff returns the diff to review for target: the contents of a
+// patch file, if target is one, or else the changes from the git ref
+// target to the working tree. With no target, it is the changes not yet
+// committed.
+func ReviewDiff(target string) (string, error) {
+	if target != "" {
+		if info, err := os.Stat(target); err == nil && !info.IsDir() {
+			data, err := os.ReadFile(target)
+			return string(data), err
+		}
+	}
+	if target == "" {
+		target = "HEAD"
+	}
+	cmd := exec.Command("git", "diff", target)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	err := runGit(cmd)
+	if err != nil {
+		return "", err
+	}
+	if out.Len() == 0 {
+		return "", fmt.Errorf("there are no changes from %s to review", target)
+	}
+	return out.String(), nil
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// numberDiff prefixes each line of the hunks in diff that is in the new
+// version of its file with its line number there, so that the model can
+// say which line a finding is at.
+func numberDiff(diff string) string {
+	numbered := new(strings.Builder)
+	line := 0
+	for _, text := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		if m := hunkHeader.FindStringSubmatch(text); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			fmt.Fprintf(numbered, "%s\n", text)
+			continue
+		}
+		switch {
+		case line > 0 && (strings.HasPrefix(text, "+") || strings.HasPrefix(text, " ")):
+			fmt.Fprintf(numbered, "%5d %s\n", line, text)
+			line++
+		case line > 0 && (strings.HasPrefix(text, "-") || strings.HasPrefix(text, "\\")):
+			// A removed line, or "\ No newline at end of file".
+			fmt.Fprintf(numbered, "%5s %s\n", "", text)
+		default:
+			// A file header, or the end of the hunks.
+			line = 0
+			fmt.Fprintf(numbered, "%s\n", text)
+		}
+	}
+	return numbered.String()
+}
+
+// ReviewCode reviews the unified diff, returning the model's findings about
+// its changes with the file and line each is at, its severity and a
+// suggested fix.
+func (c *ChatGPTClient) ReviewCode(diff string) ([]Finding, error) {
+	c.SetPurpose(`Please review the changes in the unified diff provided, as an experienced engineer would review a pull request.
+	Look for bugs, security problems, missing error handling, unclear code and missing tests, in the lines that were added or changed.
+	Each line in the new version of a file is prefixed with its line number; give that line number for each finding.
+	Rate each finding's severity as high for bugs and security problems, medium for problems that should be fixed before merging, or low for suggestions.
+	Reply with only a JSON array of findings, or [] if there are none, like this:
+	[{"file": "client.go", "line": 42, "severity": "high", "issue": "The error from Close is ignored", "suggestion": "Return the error from Close"}]`)
+	c.RecordMessage(RoleUser, numberDiff(diff))
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return nil, err
+	}
+	return parseReviewReply(reply)
+}
+
+// ExplainDiff walks a reviewer through the changes in the unified diff, in
+// Markdown: what changed, why it is probably structured the way it is, and
+// where the risks are. Unlike ReviewCode, it explains the change rather
+// than judging it. The diff must fit in the model's context.
+func (c *ChatGPTClient) ExplainDiff(diff string) (string, error) {
+	if guessTokens(diff) > c.diffBudget() {
+		return "", errors.New("the diff is too large to explain at once; give a smaller range or patch")
+	}
+	c.SetPurpose(`Please walk a reviewer through the changes in the unified diff provided, before they read it, in Markdown.
+	Start with a short overview of what the change does as a whole.
+	Then go through the changes in the order that makes them easiest to follow, which is not necessarily the order of the files, saying what each part does and how it fits with the rest.
+	Say why the change is probably structured the way it is, marking what you infer as inference.
+	Finish with the risk areas: the parts most likely to hide a bug or break something, and what to check there.
+	Refer to files, functions and lines by name, and don't restate the diff line by line.`)
+	c.RecordMessage(RoleUser, diff)
+	return c.GetCompletion()
+}
+
+// parseReviewReply reads the model's findings. Unknown severities are
+// treated as low.
+func parseReviewReply(reply string) ([]Finding, error) {
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	findings := []Finding{}
+	err := json.Unmarshal([]byte(reply), &findings)
+	if err != nil {
+		return nil, errors.New("couldn't parse the review: " + err.Error())
+	}
+	for i, f := range findings {
+		f.Severity = strings.ToLower(f.Severity)
+		if f.Severity != SeverityHigh && f.Severity != SeverityMedium {
+			f.Severity = SeverityLow
+		}
+		findings[i] = f
+	}
+	return findings, nil
+}
+
+// reviewComment is a comment on a line of a pull request in a review.
+type reviewComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Side string `json:"side"`
+	Body string `json:"body"`
+}
+
+// reviewRequest is the request body of GitHub's create a review API.
+type reviewRequest struct {
+	Body     string          `json:"body"`
+	Event    string          `json:"event"`
+	Comments []reviewComment `json:"comments"`
+}
+
+// githubReview returns a review with a comment on the line of each finding.
+func githubReview(findings []Finding) reviewRequest {
+	review := reviewRequest{
+		Body:     fmt.Sprintf("%d findings from an automated review.", len(findings)),
+		Event:    "COMMENT",
+		Comments: []reviewComment{},
+	}
+	for _, f := range findings {
+		body := fmt.Sprintf("**%s**: %s", f.Severity, f.Issue)
+		if f.Suggestion != "" {
+			body += "\n\nSuggestion: " + f.Suggestion
+		}
+		review.Comments = append(review.Comments, reviewComment{Path: f.File, Line: f.Line, Side: "RIGHT", Body: body})
+	}
+	return review
+}
+
+// WriteFindings writes review findings to w in format: as text, one
+// finding per line with its suggestion below; as a JSON array; or as the
+// request body of GitHub's create a review API, with a comment on the
+// line of each finding.
+func WriteFindings(w io.Writer, findings []Finding, format string) error {
+	switch format {
+	case ReviewFormatJSON:
+		return json.NewEncoder(w).Encode(findings)
+	case ReviewFormatGitHub:
+		return json.NewEncoder(w).Encode(githubReview(findings))
+	}
+	text := new(strings.Builder)
+	for _, f := range findings {
+		fmt.Fprintf(text, "%s:%d: [%s] %s\n", f.File, f.Line, f.Severity, f.Issue)
+		if f.Suggestion != "" {
+			fmt.Fprintf(text, "  Suggestion: %s\n", f.Suggestion)
+		}
+	}
+	fmt.Fprintf(text, "%d findings.\n", len(findings))
+	_, err := io.WriteString(w, text.String())
+	return err
+}
@@ -0,0 +1,157 @@
+package chatproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The kinds of semantic version bump.
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+// VersionBump is a suggested next release, with the reasoning for it.
+type VersionBump struct {
+	Current   string `json:"current"`
+	Next      string `json:"next"`
+	Bump      string `json:"bump"`
+	Reasoning string `json:"reasoning"`
+}
+
+var semverTag = regexp.MustCompile(`^(v?)(\d+)\.(\d+)\.(\d+)`)
+
+// NextVersion returns the version after current, a semantic version such
+// as v1.2.3, for the given bump. A leading v is kept, and any pre-release
+// or build suffix is dropped.
+func NextVersion(current, bump string) (string, error) {
+	m := semverTag.FindStringSubmatch(current)
+	if m == nil {
+		return "", fmt.Errorf("%q isn't a semantic version", current)
+	}
+	major, _ := strconv.Atoi(m[2])
+	minor, _ := strconv.Atoi(m[3])
+	patch, _ := strconv.Atoi(m[4])
+	switch bump {
+	case BumpMajor:
+		major, minor, patch = major+1, 0, 0
+	case BumpMinor:
+		minor, patch = minor+1, 0
+	case BumpPatch:
+		patch++
+	default:
+		return "", fmt.Errorf("unknown bump %q, expected major, minor or patch", bump)
+	}
+	return fmt.Sprintf("%s%d.%d.%d", m[1], major, minor, patch), nil
+}
+
+// latestTag returns the most recent tag reachable from HEAD, or the empty
+// string if there isn't one.
+func latestTag() string {
+	out, err := exec.Command("git", "describe", "--tags", "--abbrev=0").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// SuggestVersionBump suggests whether the release after the latest tag
+// should be a major, minor or patch release, from the commits and the diff
+// since the tag. Without a tag, every commit is considered, from v0.0.0.
+// A diff too large for the model's context is sent as a summary of the
+// files changed.
+func (c *ChatGPTClient) SuggestVersionBump() (VersionBump, error) {
+	current, revRange := latestTag(), "HEAD"
+	if current != "" {
+		revRange = current + "..HEAD"
+	}
+	commits, err := gitLog(revRange, false)
+	if err != nil {
+		return VersionBump{}, err
+	}
+	if len(commits) == 0 {
+		return VersionBump{}, fmt.Errorf("there are no commits since %s", current)
+	}
+	var diff string
+	if current != "" {
+		diff, err = rangeDiff(current+"..HEAD", c.diffBudget())
+		if err != nil {
+			return VersionBump{}, err
+		}
+	}
+	if current == "" {
+		current = "v0.0.0"
+	}
+	c.SetPurpose(`Please suggest the next semantic version for a release containing the git commits and diff provided.
+	Under Semantic Versioning, a change that breaks compatibility for users of the public API or command line is a major release, new backwards compatible functionality is a minor release, and only backwards compatible bug fixes are a patch release.
+	Judge from what the changes do, not only from how the commits describe them, and name the changes that decided it.
+	Reply with only a JSON object, like this:
+	{"bump": "minor", "reasoning": "Adds the --timeout flag, which is new functionality; nothing existing changes"}`)
+	msg := "Commits since " + current + ":\n" + formatCommits(commits)
+	if diff != "" {
+		msg += "\nDiff:\n" + diff
+	}
+	c.RecordMessage(RoleUser, msg)
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return VersionBump{}, err
+	}
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	bump := VersionBump{Current: current}
+	err = json.Unmarshal([]byte(reply), &bump)
+	if err != nil {
+		return VersionBump{}, errors.New("couldn't parse the suggested bump: " + err.Error())
+	}
+	bump.Bump = strings.ToLower(bump.Bump)
+	bump.Next, err = NextVersion(current, bump.Bump)
+	if err != nil {
+		return VersionBump{}, err
+	}
+	return bump, nil
+}
+
+// rangeDiff returns the diff of revRange, such as v1.2.0..HEAD, without
+// lockfiles and the like, or just the files changed if the diff is larger
+// than budget tokens.
+func rangeDiff(revRange string, budget int) (string, error) {
+	cmd := exec.Command("git", "diff", revRange)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	err := runGit(cmd)
+	if err != nil {
+		return "", err
+	}
+	diff := CommitConfig{}.excludeFromDiff(out.String())
+	if guessTokens(diff) <= budget {
+		return diff, nil
+	}
+	cmd = exec.Command("git", "diff", "--stat", revRange)
+	out.Reset()
+	cmd.Stdout = out
+	err = runGit(cmd)
+	if err != nil {
+		return "", err
+	}
+	return "The full diff is too large to show, so here are the files changed:\n" + out.String(), nil
+}
+
+// WriteVersionBump writes the suggestion to w as text, or as JSON if
+// format is OutputJSON.
+func WriteVersionBump(w io.Writer, bump VersionBump, format string) error {
+	if format == OutputJSON {
+		return json.NewEncoder(w).Encode(bump)
+	}
+	_, err := fmt.Fprintf(w, "%s -> %s (%s)\n%s\n", bump.Current, bump.Next, bump.Bump, bump.Reasoning)
+	return err
+}
@@ -0,0 +1,162 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SessionVersion is the version of the session format written by SaveSession.
+const SessionVersion = 1
+
+// Session is the serialized form of a conversation, allowing it to be saved
+// and restored later.
+type Session struct {
+	Version  int           `json:"version"`
+	Model    string        `json:"model"`
+	SavedAt  time.Time     `json:"saved_at"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// Session returns a snapshot of the client's current conversation.
+func (c *ChatGPTClient) Session() Session {
+	messages := make([]ChatMessage, len(c.chatHistory))
+	copy(messages, c.chatHistory)
+	return Session{
+		Version:  SessionVersion,
+		Model:    c.model,
+		SavedAt:  time.Now().UTC(),
+		Messages: messages,
+	}
+}
+
+// RestoreSession replaces the client's conversation with the one in session.
+func (c *ChatGPTClient) RestoreSession(session Session) error {
+	if session.Version > SessionVersion {
+		return fmt.Errorf("session version %d is newer than supported version %d", session.Version, SessionVersion)
+	}
+	c.chatHistory = make([]ChatMessage, len(session.Messages))
+	copy(c.chatHistory, session.Messages)
+	return nil
+}
+
+// SaveSession writes the current conversation to path as JSON.
+func (c *ChatGPTClient) SaveSession(path string) error {
+	data, err := json.MarshalIndent(c.Session(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if inMemoryState(path) {
+		err = writeState(path, data)
+	} else {
+		err = OverwriteFile(string(data), path)
+	}
+	if err != nil {
+		return err
+	}
+	c.Log(RoleSystem, "Session saved to "+path)
+	return nil
+}
+
+// LoadSession replaces the current conversation with one previously saved
+// to path by SaveSession.
+func (c *ChatGPTClient) LoadSession(path string) error {
+	data, err := readState(path)
+	if err != nil {
+		return err
+	}
+	var session Session
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return fmt.Errorf("reading session %s: %w", path, err)
+	}
+	err = c.RestoreSession(session)
+	if err != nil {
+		return err
+	}
+	c.Log(RoleSystem, "Session loaded from "+path)
+	return nil
+}
+
+// SessionPath resolves a session name to a file path. Bare names are stored
+// in the application's state directory, while anything that looks like a
+// path is used as is.
+func SessionPath(name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) || filepath.Ext(name) != "" {
+		return name, nil
+	}
+	dir, err := stateFileDir("sessions")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// SessionNames returns the names of the sessions saved in the state
+// directory, in sorted order.
+func SessionNames() ([]string, error) {
+	dir, err := stateFileDir("sessions")
+	if err != nil {
+		return nil, err
+	}
+	return stateNames(dir, ".json")
+}
+
+type SaveSession struct{ input string }
+
+// Execute method for SaveSession strategy snapshots the
+// conversation to a named file, so it can be resumed later
+// without leaving the chat.
+func (s SaveSession) Execute(c *ChatGPTClient) error {
+	path, err := sessionArgument(s.input)
+	if err != nil {
+		return err
+	}
+	err = c.SaveSession(path)
+	if err != nil {
+		return err
+	}
+	c.LogOut("Session saved to", path)
+	return nil
+}
+
+type LoadSession struct{ input string }
+
+// Execute method for LoadSession strategy replaces the
+// conversation with a previously saved one, so work can
+// pick up where it left off.
+func (s LoadSession) Execute(c *ChatGPTClient) error {
+	path, err := sessionArgument(s.input)
+	if err != nil {
+		return err
+	}
+	err = c.LoadSession(path)
+	if err != nil {
+		return err
+	}
+	c.LogOut(fmt.Sprintf("Loaded %d messages from %s", len(c.chatHistory), path))
+	return nil
+}
+
+func sessionArgument(input string) (string, error) {
+	_, name, _ := strings.Cut(input, " ")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("need a session name, e.g. %s my-session", strings.Fields(input)[0])
+	}
+	return SessionPath(name)
+}
+
+// newConversation returns a client for a new conversation, with the
+// settings, API client and transcript of c.
+func (c *ChatGPTClient) newConversation() *ChatGPTClient {
+	conv := *c
+	conv.chatHistory = []ChatMessage{}
+	conv.onToken = nil
+	conv.sessionID = newSessionID()
+	conv.pii = nil
+	conv.feedReads = nil
+	return &conv
+}
@@ -0,0 +1,192 @@
+package chatproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// SetupProfile is the name of the credential profile written by setup.
+const SetupProfile = "default"
+
+// keychain stores the API key in the operating system's keychain, from
+// which lookup, run as a profile's api_key_command, reads it back.
+type keychain struct {
+	name   string
+	store  func(key string) error
+	lookup string
+}
+
+// systemKeychain returns the keychain for this system, if its command line
+// tool is installed: security on macOS, or secret-tool from libsecret.
+func systemKeychain() (keychain, bool) {
+	if runtime.GOOS == "darwin" {
+		if _, err := exec.LookPath("security"); err == nil {
+			return keychain{
+				name: "the macOS keychain",
+				store: func(key string) error {
+					return runKeychain(exec.Command("security", "add-generic-password", "-U", "-a", "chatproxy", "-s", "openai", "-w", key))
+				},
+				lookup: "security find-generic-password -a chatproxy -s openai -w",
+			}, true
+		}
+	}
+	if _, err := exec.LookPath("secret-tool"); err == nil {
+		return keychain{
+			name: "the secret service keyring",
+			store: func(key string) error {
+				cmd := exec.Command("secret-tool", "store", "--label=chatproxy", "service", "chatproxy", "account", "openai")
+				cmd.Stdin = strings.NewReader(key)
+				return runKeychain(cmd)
+			},
+			lookup: "secret-tool lookup service chatproxy account openai",
+		}, true
+	}
+	return keychain{}, false
+}
+
+// runKeychain runs a keychain command, including its output in any error.
+func runKeychain(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if msg := strings.TrimSpace(string(out)); err != nil && msg != "" {
+		return fmt.Errorf("%s: %s", cmd.Args[0], msg)
+	}
+	return err
+}
+
+// setupConfig is the config file written by setup.
+type setupConfig struct {
+	Model      string             `yaml:"model"`
+	Profile    string             `yaml:"profile"`
+	Profiles   map[string]Profile `yaml:"profiles"`
+	Transcript struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"transcript"`
+}
+
+// RunSetup asks for an API key, a default model and whether to record
+// transcripts, and writes them to the user config file. The key is stored
+// in the system keychain if there is one and the user agrees, or else in
+// the config file, which only the user can read. An existing config file
+// is never overwritten.
+func RunSetup(in io.Reader, out io.Writer) error {
+	path, err := ConfigPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists; edit it to change your settings", path)
+	}
+	r := bufio.NewReader(in)
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(out, prompt)
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+	fmt.Fprintf(out, "Setting up chatproxy. Your answers are saved to %s.\n", path)
+	fmt.Fprintln(out, "Create an API key at https://platform.openai.com/account/api-keys if you don't have one.")
+	var key string
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprint(out, "OpenAI API key: ")
+		secret, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return err
+		}
+		key = strings.TrimSpace(string(secret))
+	} else {
+		key, err = ask("OpenAI API key: ")
+		if err != nil {
+			return err
+		}
+	}
+	if key == "" {
+		return errors.New("setup needs an API key")
+	}
+	cfg := setupConfig{Profile: SetupProfile}
+	cfg.Model, err = ask("Default model [gpt-4]: ")
+	if err != nil {
+		return err
+	}
+	if cfg.Model == "" {
+		cfg.Model = "gpt-4"
+	}
+	answer, err := ask("Record transcripts of your chats? [Y/n]: ")
+	if err != nil {
+		return err
+	}
+	cfg.Transcript.Enabled = !strings.HasPrefix(strings.ToLower(answer), "n")
+	profile := Profile{APIKey: key}
+	if kc, ok := systemKeychain(); ok {
+		answer, err := ask(fmt.Sprintf("Store the key in %s rather than the config file? [Y/n]: ", kc.name))
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(strings.ToLower(answer), "n") {
+			err = kc.store(key)
+			if err != nil {
+				return fmt.Errorf("storing the key in %s: %w", kc.name, err)
+			}
+			profile = Profile{APIKeyCommand: kc.lookup}
+		}
+	}
+	cfg.Profiles = map[string]Profile{SetupProfile: profile}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Saved %s. Run 'chatproxy doctor' to check everything works.\n", path)
+	return nil
+}
+
+// newCommandClient creates the client for a CLI command. If there's no API
+// key because chatproxy hasn't been set up, it offers to run setup first,
+// when there is a terminal to ask on.
+func newCommandClient(opts ...ClientOption) (*ChatGPTClient, error) {
+	client, err := NewChatGPTClient(opts...)
+	if !errors.Is(err, ErrUnauthorized) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return client, err
+	}
+	path, pathErr := ConfigPath()
+	if pathErr != nil {
+		return nil, err
+	}
+	if _, statErr := os.Stat(path); statErr == nil {
+		return nil, err
+	}
+	fmt.Fprintln(os.Stderr, "No API key was found: $OPENAI_API_KEY isn't set and there is no config file.")
+	fmt.Fprint(os.Stderr, "Set up chatproxy now? [Y/n]: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "n") {
+		return nil, err
+	}
+	err = RunSetup(os.Stdin, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return NewChatGPTClient(opts...)
+}
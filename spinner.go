@@ -0,0 +1,61 @@
+package chatproxy
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Spinner shows an animated progress indicator with the elapsed time, giving
+// feedback while waiting for a reply that is not being streamed.
+type Spinner struct {
+	output   io.Writer
+	interval time.Duration
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// StartSpinner starts a spinner on output. Nothing is shown when output is
+// not a terminal, so piped output is not polluted.
+func StartSpinner(output io.Writer) *Spinner {
+	s := &Spinner{output: output, interval: 100 * time.Millisecond, stop: make(chan struct{})}
+	if !isTerminal(output) {
+		return s
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *Spinner) run() {
+	defer s.wg.Done()
+	start := time.Now()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		fmt.Fprintf(s.output, "\r%s waiting for reply %.1fs", spinnerFrames[frame%len(spinnerFrames)], time.Since(start).Seconds())
+		select {
+		case <-s.stop:
+			fmt.Fprint(s.output, "\r\033[K")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop halts the spinner and clears it from the output. It is safe to call
+// more than once, and on a nil Spinner.
+func (s *Spinner) Stop() {
+	if s == nil {
+		return
+	}
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.wg.Wait()
+}
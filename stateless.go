@@ -0,0 +1,190 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateless reports whether CHATPROXY_STATELESS is set to true, for
+// containers and CI, where the filesystem may be read only and logs are
+// collected from stdout and stderr. In stateless mode, no transcript or
+// usage log files are written; transcripts are written to the error
+// stream, stderr for commands, as JSON lines of TranscriptEntry instead.
+// Config files aren't read either, so the config comes from the
+// environment alone: CHATPROXY_CONFIG_DATA, if it is set, and the
+// variables that override the config. What commands remember between
+// runs, such as the feeds read, digests sent, Matrix rooms' sessions,
+// flashcard decks, quiz scores and chat sessions saved by name, is kept in
+// memory for as long as the process runs.
+func stateless() bool {
+	on, _ := strconv.ParseBool(os.Getenv("CHATPROXY_STATELESS"))
+	return on
+}
+
+// memoryState holds the state files written in stateless mode, by path.
+var memoryState = struct {
+	sync.Mutex
+	files map[string][]byte
+}{files: map[string][]byte{}}
+
+// stateFileDir returns the named state directory, as getStateDir does, but
+// doesn't create it in stateless mode, as files written there with
+// writeState are then kept in memory.
+func stateFileDir(name string) (string, error) {
+	if !stateless() {
+		return getStateDir(name)
+	}
+	return stateDirPath(name)
+}
+
+// inMemoryState reports whether the file at path is kept in memory, as it
+// is in the state directory and chatproxy is in stateless mode. Files
+// elsewhere, such as a session saved to a path the user gave, are written
+// as usual.
+func inMemoryState(path string) bool {
+	if !stateless() {
+		return false
+	}
+	root, err := stateDirPath("")
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readState reads the state file at path, or in stateless mode, what was
+// last written to it with writeState.
+func readState(path string) ([]byte, error) {
+	if !inMemoryState(path) {
+		return os.ReadFile(path)
+	}
+	memoryState.Lock()
+	defer memoryState.Unlock()
+	data, ok := memoryState.files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+// writeState writes the state file at path, or in stateless mode, keeps it
+// in memory.
+func writeState(path string, data []byte) error {
+	if !inMemoryState(path) {
+		return os.WriteFile(path, data, 0600)
+	}
+	memoryState.Lock()
+	defer memoryState.Unlock()
+	memoryState.files[path] = append([]byte(nil), data...)
+	return nil
+}
+
+// appendState appends data to the state file at path, creating it if
+// needed, or in stateless mode, to the copy kept in memory.
+func appendState(path string, data []byte) error {
+	if !inMemoryState(path) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	memoryState.Lock()
+	defer memoryState.Unlock()
+	memoryState.files[path] = append(memoryState.files[path], data...)
+	return nil
+}
+
+// stateNames returns the names, without ext, of the state files in dir
+// ending in ext, in sorted order, including those kept in memory in
+// stateless mode.
+func stateNames(dir, ext string) ([]string, error) {
+	var paths []string
+	if inMemoryState(dir) {
+		memoryState.Lock()
+		for path := range memoryState.files {
+			if filepath.Dir(path) == dir && strings.HasSuffix(path, ext) {
+				paths = append(paths, path)
+			}
+		}
+		memoryState.Unlock()
+		sort.Strings(paths)
+	} else {
+		var err error
+		paths, err = filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = strings.TrimSuffix(filepath.Base(path), ext)
+	}
+	return names, nil
+}
+
+// TranscriptEntry is a message in a transcript written as JSON, with no
+// role for notes that aren't part of the conversation.
+type TranscriptEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Role    string    `json:"role,omitempty"`
+	Content string    `json:"content"`
+}
+
+// jsonTranscript writes a transcript as JSON lines, one TranscriptEntry for
+// each message, so that log collectors can parse it. It is safe to use from
+// many goroutines, as servers share their transcript between requests.
+type jsonTranscript struct {
+	mu      sync.Mutex
+	w       io.Writer
+	command string
+}
+
+// WithJSONTranscript records the transcript to w as JSON lines, one
+// TranscriptEntry for each message, rather than as text, for log
+// collectors.
+func WithJSONTranscript(w io.Writer) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.transcript = &jsonTranscript{w: w, command: c.command}
+		return c
+	}
+}
+
+// record writes a message with its role.
+func (t *jsonTranscript) record(role, content string) {
+	line, err := json.Marshal(TranscriptEntry{Time: time.Now().UTC(), Command: t.command, Role: role, Content: content})
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(append(line, '\n'))
+}
+
+// Write records text written to the transcript other than messages, such
+// as notes, as an entry without a role.
+func (t *jsonTranscript) Write(p []byte) (int, error) {
+	t.record("", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// transcribeReply logs a reply, which is recorded in the transcript as is
+// if the transcript is text.
+func (c *ChatGPTClient) transcribeReply(reply string) {
+	c.Logger().Info(recordReply, "role", RoleBot, "content", c.redact(reply))
+}
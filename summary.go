@@ -0,0 +1,91 @@
+package chatproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SummaryLength is the length of summary asked for by tldr's --length flag.
+type SummaryLength string
+
+const (
+	// SummaryAny leaves the length to the model, asking only for brevity.
+	SummaryAny SummaryLength = ""
+	// SummaryOneLine asks for a single line.
+	SummaryOneLine SummaryLength = "one-line"
+	// SummaryParagraph asks for one short paragraph.
+	SummaryParagraph SummaryLength = "paragraph"
+	// SummaryBullets asks for a short list of bullet points.
+	SummaryBullets SummaryLength = "bullets"
+)
+
+// ParseSummaryLength parses the name of a summary length.
+func ParseSummaryLength(s string) (SummaryLength, error) {
+	switch l := SummaryLength(s); l {
+	case SummaryAny, SummaryOneLine, SummaryParagraph, SummaryBullets:
+		return l, nil
+	}
+	return "", fmt.Errorf("unknown summary length %q, expected %s, %s or %s", s, SummaryOneLine, SummaryParagraph, SummaryBullets)
+}
+
+// SummaryOptions control the summary written by Summarise and TLDRAll.
+type SummaryOptions struct {
+	Length SummaryLength
+	// Compare asks for the sources to be compared rather than combined,
+	// when there is more than one.
+	Compare bool
+	// Instructions are extra instructions, such as what to focus on.
+	Instructions string
+}
+
+// purpose returns the purpose for summarising the given number of sources.
+func (o SummaryOptions) purpose(sources int) string {
+	purpose := new(strings.Builder)
+	purpose.WriteString("Please summarise the provided text as best you can.")
+	switch o.Length {
+	case SummaryOneLine:
+		purpose.WriteString(" Summarise it in a single line.")
+	case SummaryParagraph:
+		purpose.WriteString(" Summarise it in one short paragraph.")
+	case SummaryBullets:
+		purpose.WriteString(" Summarise it as a short list of bullet points.")
+	default:
+		purpose.WriteString(" The shorter the better.")
+	}
+	if sources > 1 {
+		purpose.WriteString(" The text comes from several sources, each starting with a SOURCE line.")
+		if o.Compare {
+			purpose.WriteString(" Compare them, pointing out where they agree and where they differ.")
+		} else {
+			purpose.WriteString(" Combine them into one summary, saying which source a point comes from when it matters.")
+		}
+	}
+	if o.Instructions != "" {
+		purpose.WriteString("\n" + o.Instructions)
+	}
+	return purpose.String()
+}
+
+// Summarise summarises content, such as text piped from another command.
+func (c *ChatGPTClient) Summarise(content string, opts SummaryOptions) (summary string, err error) {
+	c.SetPurpose(opts.purpose(1))
+	c.RecordMessage(RoleUser, content)
+	return c.GetCompletion()
+}
+
+// TLDRAll summarises the content of several files, directories or URLs
+// together, combining or comparing them as opts ask.
+func (c *ChatGPTClient) TLDRAll(paths []string, opts SummaryOptions) (summary string, err error) {
+	c.SetPurpose(opts.purpose(len(paths)))
+	for _, path := range paths {
+		msg, err := c.GetContent(path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		if len(paths) > 1 {
+			msg = fmt.Sprintf("SOURCE: %s\n%s", path, msg)
+		}
+		c.RecordMessage(RoleUser, msg)
+	}
+	return c.GetCompletion()
+}
@@ -0,0 +1,275 @@
+package chatproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TableSampleRows is the number of rows included verbatim when a table is
+// summarised. The remaining rows are only represented by column statistics.
+var TableSampleRows = 10
+
+// Table is a simple in memory representation of tabular data, such as a CSV
+// file or a worksheet from an Excel workbook.
+type Table struct {
+	Name    string
+	Headers []string
+	Rows    [][]string
+}
+
+// TablesFromFile parses a CSV, TSV or XLSX file into one or more tables.
+// Workbooks produce one table per worksheet.
+func TablesFromFile(path string) ([]Table, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return TablesFromBytes(path, content)
+}
+
+// TablesFromBytes parses the contents of a CSV, TSV or XLSX file, with the
+// format determined by the extension of path.
+func TablesFromBytes(path string, content []byte) ([]Table, error) {
+	name := filepath.Base(path)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return tableFromDelimited(name, content, ',')
+	case ".tsv":
+		return tableFromDelimited(name, content, '\t')
+	case ".xlsx":
+		return tablesFromWorkbook(name, content)
+	}
+	return nil, fmt.Errorf("%s is not a supported table format", path)
+}
+
+// Summary renders the table compactly: the headers, the first few rows and
+// per column statistics, so that large tables fit within a token budget
+// while still giving the model enough to reason about.
+func (t Table) Summary(sampleRows int) string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "TABLE %s: %d rows x %d columns\n", t.Name, len(t.Rows), len(t.Headers))
+	fmt.Fprintf(b, "| %s |\n", strings.Join(t.Headers, " | "))
+	sample := t.Rows
+	if len(sample) > sampleRows {
+		sample = sample[:sampleRows]
+	}
+	for _, row := range sample {
+		fmt.Fprintf(b, "| %s |\n", strings.Join(row, " | "))
+	}
+	if len(t.Rows) > len(sample) {
+		fmt.Fprintf(b, "... %d more rows\n", len(t.Rows)-len(sample))
+	}
+	b.WriteString("COLUMN STATS:\n")
+	for i, header := range t.Headers {
+		fmt.Fprintf(b, "- %s: %s\n", header, t.columnStats(i))
+	}
+	return b.String()
+}
+
+func (t Table) columnStats(col int) string {
+	var numbers []float64
+	distinct := map[string]bool{}
+	empty := 0
+	for _, row := range t.Rows {
+		if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+			empty++
+			continue
+		}
+		distinct[row[col]] = true
+		if n, err := strconv.ParseFloat(strings.TrimSpace(row[col]), 64); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	nonEmpty := len(t.Rows) - empty
+	if nonEmpty > 0 && len(numbers) == nonEmpty {
+		min, max, sum := math.Inf(1), math.Inf(-1), 0.0
+		for _, n := range numbers {
+			min = math.Min(min, n)
+			max = math.Max(max, n)
+			sum += n
+		}
+		return fmt.Sprintf("numeric, min %g, max %g, mean %g, %d empty", min, max, sum/float64(len(numbers)), empty)
+	}
+	return fmt.Sprintf("text, %d distinct values, %d empty", len(distinct), empty)
+}
+
+// tableText summarises each table in a tabular file, rather than
+// returning the raw file contents.
+func tableText(path string, content []byte) (string, error) {
+	tables, err := TablesFromBytes(path, content)
+	if err != nil {
+		return "", err
+	}
+	var summaries []string
+	for _, t := range tables {
+		summaries = append(summaries, t.Summary(TableSampleRows))
+	}
+	return strings.Join(summaries, "\n"), nil
+}
+
+func tableFromDelimited(name string, content []byte, delimiter rune) ([]Table, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return []Table{newTable(name, records)}, nil
+}
+
+func newTable(name string, records [][]string) Table {
+	t := Table{Name: name}
+	if len(records) == 0 {
+		return t
+	}
+	t.Headers = records[0]
+	t.Rows = records[1:]
+	return t
+}
+
+type sharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type worksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func tablesFromWorkbook(name string, content []byte) ([]Table, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	var sheets []*zip.File
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "xl/sharedStrings.xml":
+			var ss sharedStrings
+			if err := decodeZipXML(f, &ss); err != nil {
+				return nil, err
+			}
+			for _, item := range ss.Items {
+				text := item.Text
+				for _, run := range item.Runs {
+					text += run.Text
+				}
+				strs = append(strs, text)
+			}
+		case strings.HasPrefix(f.Name, "xl/worksheets/") && strings.HasSuffix(f.Name, ".xml"):
+			sheets = append(sheets, f)
+		}
+	}
+	if len(sheets) == 0 {
+		return nil, errors.New("workbook contains no worksheets")
+	}
+	// Worksheets are named sheet1.xml, sheet2.xml and so on, in the order
+	// they were added, so sheet10.xml comes after sheet9.xml.
+	sort.Slice(sheets, func(i, j int) bool {
+		ni, nj := sheetNumber(sheets[i].Name), sheetNumber(sheets[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return sheets[i].Name < sheets[j].Name
+	})
+
+	var tables []Table
+	for _, f := range sheets {
+		var ws worksheet
+		if err := decodeZipXML(f, &ws); err != nil {
+			return nil, err
+		}
+		var records [][]string
+		for _, row := range ws.Rows {
+			var record []string
+			for i, cell := range row.Cells {
+				col := columnIndex(cell.Ref)
+				if col < 0 {
+					col = i
+				}
+				for len(record) < col {
+					record = append(record, "")
+				}
+				value := cell.Value
+				switch cell.Type {
+				case "s":
+					idx, err := strconv.Atoi(cell.Value)
+					if err == nil && idx < len(strs) {
+						value = strs[idx]
+					}
+				case "inlineStr":
+					value = cell.Inline
+				}
+				record = append(record, value)
+			}
+			records = append(records, record)
+		}
+		sheet := strings.TrimSuffix(filepath.Base(f.Name), ".xml")
+		tables = append(tables, newTable(name+":"+sheet, records))
+	}
+	return tables, nil
+}
+
+// sheetNumber returns the number a worksheet's name ends with, such as 10
+// for xl/worksheets/sheet10.xml, or -1 if it has none.
+func sheetNumber(name string) int {
+	base := strings.TrimSuffix(filepath.Base(name), ".xml")
+	digits := strings.TrimRightFunc(base, func(r rune) bool { return r >= '0' && r <= '9' })
+	n, err := strconv.Atoi(base[len(digits):])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func decodeZipXML(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(io.LimitReader(rc, 256<<20)).Decode(v)
+}
+
+// columnIndex converts the column letters of a cell reference such as "C12"
+// into a zero based index. It returns -1 if the reference has no letters.
+func columnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return -1
+	}
+	return col - 1
+}
@@ -0,0 +1,321 @@
+package chatproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTelegramAPI is the Telegram Bot API used unless TELEGRAM_API_URL
+// is set, as it is for a local Bot API server.
+const DefaultTelegramAPI = "https://api.telegram.org"
+
+// TelegramMessageLimit is the most characters Telegram allows in a message;
+// longer replies are sent as several.
+const TelegramMessageLimit = 4096
+
+// TelegramPollTimeout is how long a request for updates waits for one to
+// arrive before returning none.
+const TelegramPollTimeout = 30 * time.Second
+
+// TelegramAPI is a client for the parts of the Telegram Bot API that the
+// telegram command uses to chat: receiving messages and documents, and
+// sending replies.
+type TelegramAPI struct {
+	Token      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewTelegramAPI returns a client for the bot whose token is in
+// TELEGRAM_BOT_TOKEN.
+func NewTelegramAPI() (*TelegramAPI, error) {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("%w: set TELEGRAM_BOT_TOKEN to the token @BotFather gave the bot", ErrUnauthorized)
+	}
+	baseURL := os.Getenv("TELEGRAM_API_URL")
+	if baseURL == "" {
+		baseURL = DefaultTelegramAPI
+	}
+	return &TelegramAPI{
+		Token:      token,
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: TelegramPollTimeout + DefaultTimeout},
+	}, nil
+}
+
+// call calls a Bot API method with params, decoding its result into result
+// if it isn't nil.
+func (t *TelegramAPI) call(method string, params, result any) error {
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	err := doJSON(t.HTTPClient, http.MethodPost, t.endpoint("/bot")+"/"+method, http.Header{}, params, &reply)
+	if err != nil {
+		// Don't report the token, which is part of the URL.
+		return errors.New(strings.ReplaceAll(err.Error(), t.Token, "<token>"))
+	}
+	if !reply.OK {
+		return fmt.Errorf("telegram %s: %s", method, reply.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+func (t *TelegramAPI) endpoint(prefix string) string {
+	return strings.TrimSuffix(t.BaseURL, "/") + prefix + t.Token
+}
+
+// TelegramUpdate is a message sent to the bot.
+type TelegramUpdate struct {
+	ID      int `json:"update_id"`
+	Message *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From struct {
+			ID       int64  `json:"id"`
+			Username string `json:"username"`
+		} `json:"from"`
+		Text     string `json:"text"`
+		Caption  string `json:"caption"`
+		Document *struct {
+			FileID   string `json:"file_id"`
+			FileName string `json:"file_name"`
+			FileSize int64  `json:"file_size"`
+		} `json:"document"`
+	} `json:"message"`
+}
+
+// Updates returns the messages sent to the bot from offset on, waiting up
+// to TelegramPollTimeout for one to arrive.
+func (t *TelegramAPI) Updates(offset int) ([]TelegramUpdate, error) {
+	var updates []TelegramUpdate
+	err := t.call("getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         int(TelegramPollTimeout.Seconds()),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// SendMessage sends text to a chat, as several messages if it is longer
+// than TelegramMessageLimit.
+func (t *TelegramAPI) SendMessage(chat int64, text string) error {
+	for _, part := range splitMessage(text, TelegramMessageLimit) {
+		err := t.call("sendMessage", map[string]any{"chat_id": chat, "text": part}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMessage splits text into parts of at most limit characters, at line
+// breaks where it can.
+func splitMessage(text string, limit int) []string {
+	runes := []rune(text)
+	var parts []string
+	for len(runes) > limit {
+		cut := limit
+		if i := strings.LastIndex(string(runes[:limit]), "\n"); i > 0 {
+			cut = len([]rune(string(runes[:limit])[:i])) + 1
+		}
+		parts = append(parts, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	return append(parts, string(runes))
+}
+
+// DownloadFile saves the file with the given id to path.
+func (t *TelegramAPI) DownloadFile(fileID, path string) error {
+	var file struct {
+		FilePath string `json:"file_path"`
+	}
+	err := t.call("getFile", map[string]string{"file_id": fileID}, &file)
+	if err != nil {
+		return err
+	}
+	hc := t.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Get(t.endpoint("/file/bot") + "/" + file.FilePath)
+	if err != nil {
+		return errors.New(strings.ReplaceAll(err.Error(), t.Token, "<token>"))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", filepath.Base(path), resp.Status)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.LimitReader(resp.Body, MaxUploadBytes))
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// TelegramBot chats with the people allowed to use it over Telegram, with a
+// conversation for each chat. As in the terminal chat, the first message of
+// a conversation describes the purpose of the assistant, or names one of
+// the purposes in the config. Documents sent to the bot are read with
+// GetContent and added to the conversation, and their caption, if any, is
+// sent as a message.
+type TelegramBot struct {
+	// Allowed are the usernames or numeric user ids of the people the bot
+	// answers. It ignores everyone else, as they would otherwise be using
+	// your API key.
+	Allowed []string
+
+	api    *TelegramAPI
+	client *ChatGPTClient
+	chats  map[int64]*ChatGPTClient
+	offset int
+}
+
+// NewTelegramBot returns a bot that receives messages with api and holds
+// its conversations with the settings of c.
+func NewTelegramBot(api *TelegramAPI, c *ChatGPTClient, allowed []string) *TelegramBot {
+	return &TelegramBot{Allowed: allowed, api: api, client: c, chats: map[int64]*ChatGPTClient{}}
+}
+
+// Run answers messages until ctx is done.
+func (b *TelegramBot) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		err := b.Poll()
+		if err != nil {
+			b.client.LogErr(err)
+			// Back off rather than retrying a failing API at once.
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+// Poll waits for the next messages sent to the bot and answers them.
+func (b *TelegramBot) Poll() error {
+	updates, err := b.api.Updates(b.offset)
+	if err != nil {
+		return err
+	}
+	for _, u := range updates {
+		b.offset = u.ID + 1
+		if u.Message == nil || !b.allowed(u.Message.From.ID, u.Message.From.Username) {
+			continue
+		}
+		reply := b.handle(u)
+		if reply == "" {
+			continue
+		}
+		err := b.api.SendMessage(u.Message.Chat.ID, reply)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *TelegramBot) allowed(id int64, username string) bool {
+	for _, a := range b.Allowed {
+		a = strings.TrimPrefix(a, "@")
+		if a == strconv.FormatInt(id, 10) || (username != "" && strings.EqualFold(a, username)) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle answers a message, returning the reply to send.
+func (b *TelegramBot) handle(u TelegramUpdate) string {
+	m := u.Message
+	c, ok := b.chats[m.Chat.ID]
+	if !ok {
+		c = b.client.newConversation()
+		b.chats[m.Chat.ID] = c
+	}
+	text := strings.TrimSpace(m.Text)
+	switch text {
+	case "/start", "/new":
+		b.chats[m.Chat.ID] = b.client.newConversation()
+		return c.purposePrompt()
+	}
+	if m.Document != nil {
+		if len(c.chatHistory) == 0 {
+			return "Please describe the purpose of this assistant before sending documents."
+		}
+		msg, err := b.readDocument(c, m.Document.FileID, m.Document.FileName, m.Document.FileSize)
+		if err != nil {
+			c.LogErr(err)
+			return "Couldn't read " + m.Document.FileName + ": " + err.Error()
+		}
+		c.RecordMessage(RoleUser, msg)
+		text = strings.TrimSpace(m.Caption)
+		if text == "" {
+			return fmt.Sprintf("Loaded %s (about %d tokens).", m.Document.FileName, guessTokens(msg))
+		}
+	}
+	if text == "" {
+		return ""
+	}
+	if len(c.chatHistory) == 0 {
+		c.SetPurpose(c.expandPurpose(text))
+		return "Purpose set."
+	}
+	c.RecordMessage(RoleUser, text)
+	reply, err := c.GetCompletion()
+	if err != nil {
+		c.rollbackUnanswered()
+		c.LogErr(err)
+		return "Error: " + err.Error()
+	}
+	c.RecordMessage(RoleBot, reply)
+	return reply
+}
+
+// readDocument downloads a document sent to the bot and reads it with
+// GetContent, naming it as it was sent.
+func (b *TelegramBot) readDocument(c *ChatGPTClient, fileID, name string, size int64) (string, error) {
+	if size > MaxUploadBytes {
+		return "", fmt.Errorf("it is larger than %d MB", MaxUploadBytes>>20)
+	}
+	dir, err := os.MkdirTemp("", "chatproxy-telegram")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if name == "" {
+		name = "document"
+	}
+	path := filepath.Join(dir, filepath.Base(name))
+	err = b.api.DownloadFile(fileID, path)
+	if err != nil {
+		return "", err
+	}
+	msg, err := c.GetContent(path)
+	if err != nil {
+		return "", err
+	}
+	// Name the document as it was sent, rather than by the temporary directory.
+	return strings.ReplaceAll(msg, "--"+dir+string(filepath.Separator), "--"), nil
+}
@@ -0,0 +1,120 @@
+package chatproxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// BuiltinTemplates are prompt templates available without any configuration.
+// Templates saved in the templates directory with the same name take precedence.
+var BuiltinTemplates = map[string]string{
+	"review": `Please review the code above as an experienced engineer.
+List bugs, risky changes and unclear code first, then style suggestions.
+{{if .Input}}Pay particular attention to: {{.Input}}{{end}}`,
+	"triage": `Please triage the following bug report.
+Summarise the problem, identify the likely component, suggest a severity
+(critical, high, medium or low) and list any missing information.
+
+{{.Input}}`,
+	"explain": `Please explain {{if .Input}}{{.Input}}{{else}}the content above{{end}} to a new team member,
+starting with the big picture before going into detail.`,
+}
+
+// TemplateData is made available to prompt templates when they are expanded.
+type TemplateData struct {
+	// Args are the whitespace separated arguments given after the template name.
+	Args []string
+	// Input is the full text given after the template name.
+	Input string
+}
+
+// TemplateDir returns the directory user prompt templates are loaded from.
+// Each template is a text/template file named <name>.tmpl.
+func TemplateDir() (string, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// LoadTemplate returns the source of the named prompt template, checking
+// the template directory before the built in templates.
+func LoadTemplate(name string) (string, error) {
+	dir, err := TemplateDir()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".tmpl"))
+	if err == nil {
+		return string(data), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if src, ok := BuiltinTemplates[name]; ok {
+		return src, nil
+	}
+	return "", fmt.Errorf("no template named %q, available templates: %s", name, strings.Join(TemplateNames(), ", "))
+}
+
+// TemplateNames lists the names of the available prompt templates.
+func TemplateNames() []string {
+	seen := map[string]bool{}
+	for name := range BuiltinTemplates {
+		seen[name] = true
+	}
+	if dir, err := TemplateDir(); err == nil {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		for _, m := range matches {
+			seen[strings.TrimSuffix(filepath.Base(m), ".tmpl")] = true
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExpandTemplate expands the named prompt template with the given input.
+func ExpandTemplate(name string, input string) (string, error) {
+	src, err := LoadTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(name).Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("parsing template %s: %w", name, err)
+	}
+	b := new(strings.Builder)
+	err = tmpl.Execute(b, TemplateData{Args: strings.Fields(input), Input: input})
+	if err != nil {
+		return "", fmt.Errorf("expanding template %s: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+type Template struct{ input string }
+
+// Execute method for Template strategy expands a saved
+// prompt template, sending it as the user's message, so
+// common recipes are only one command away.
+func (s Template) Execute(c *ChatGPTClient) error {
+	rest := strings.TrimSpace(strings.TrimPrefix(s.input, "/template"))
+	name, input, _ := strings.Cut(rest, " ")
+	if name == "" {
+		return fmt.Errorf("need a template name, available templates: %s", strings.Join(TemplateNames(), ", "))
+	}
+	prompt, err := ExpandTemplate(name, strings.TrimSpace(input))
+	if err != nil {
+		return err
+	}
+	return Default{prompt}.Execute(c)
+}
@@ -0,0 +1,117 @@
+package chatproxy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// Theme holds the colors used to distinguish the participants of a conversation.
+type Theme struct {
+	Assistant *color.Color
+	System    *color.Color
+}
+
+// DefaultTheme returns the standard theme: green assistant replies and
+// yellow system prompts.
+func DefaultTheme() Theme {
+	return Theme{
+		Assistant: color.New(color.FgGreen),
+		System:    color.New(color.FgYellow),
+	}
+}
+
+var colorAttributes = map[string]color.Attribute{
+	"black":     color.FgBlack,
+	"red":       color.FgRed,
+	"green":     color.FgGreen,
+	"yellow":    color.FgYellow,
+	"blue":      color.FgBlue,
+	"magenta":   color.FgMagenta,
+	"cyan":      color.FgCyan,
+	"white":     color.FgWhite,
+	"bold":      color.Bold,
+	"faint":     color.Faint,
+	"italic":    color.Italic,
+	"underline": color.Underline,
+}
+
+// ParseTheme builds a theme from a specification such as
+// "assistant=cyan,system=magenta+bold". Roles that are not
+// mentioned keep their default colors, and "none" removes a role's color.
+func ParseTheme(spec string) (Theme, error) {
+	theme := DefaultTheme()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return Theme{}, fmt.Errorf("invalid theme entry %q, expected role=color", entry)
+		}
+		var attrs []color.Attribute
+		for _, name := range strings.Split(value, "+") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "none" {
+				continue
+			}
+			attr, ok := colorAttributes[name]
+			if !ok {
+				return Theme{}, fmt.Errorf("unknown color %q in theme", name)
+			}
+			attrs = append(attrs, attr)
+		}
+		c := color.New(attrs...)
+		switch strings.ToLower(strings.TrimSpace(role)) {
+		case "assistant":
+			theme.Assistant = c
+		case "system":
+			theme.System = c
+		default:
+			return Theme{}, fmt.Errorf("unknown theme role %q", role)
+		}
+	}
+	return theme, nil
+}
+
+// WithTheme sets the colors used by the ChatGPTClient when writing to its output.
+func WithTheme(theme Theme) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.theme = theme
+		return c
+	}
+}
+
+// ColorEnabled reports whether colored output should be written to w. Color
+// is disabled when the NO_COLOR environment variable is set and not empty, as
+// https://no-color.org asks, or when w is not an interactive terminal, so
+// that piped and logged output stays clean.
+func ColorEnabled(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// isTerminal reports whether w is an interactive terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(interface{ Fd() uintptr })
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// applyColorMode enables or disables every color in the theme according to
+// whether the output supports color.
+func (t Theme) applyColorMode(enabled bool) {
+	for _, c := range []*color.Color{t.Assistant, t.System} {
+		if enabled {
+			c.EnableColor()
+		} else {
+			c.DisableColor()
+		}
+	}
+}
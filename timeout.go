@@ -0,0 +1,53 @@
+package chatproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultTimeout is how long a completion or embedding request may take,
+// including receiving the whole reply, unless the timeout is changed.
+const DefaultTimeout = 5 * time.Minute
+
+// WithTimeout limits how long each completion and embedding request may
+// take, so that a hung connection can't block forever. Zero disables the
+// limit.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.timeout = d
+		return c
+	}
+}
+
+// withCallContext makes the client's API requests part of ctx, so that
+// they are cancelled with the server call they were made for.
+func withCallContext(ctx context.Context) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.callCtx = ctx
+		return c
+	}
+}
+
+// requestContext returns the context for an API request, which is cancelled
+// once the client's timeout has passed, or with the call it was made for.
+func (c *ChatGPTClient) requestContext() (context.Context, context.CancelFunc) {
+	parent := c.callCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if c.timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, c.timeout)
+}
+
+// timeoutError explains err if it was caused by the request's context
+// timing out.
+func (c *ChatGPTClient) timeoutError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("no reply within %s: %w", c.timeout, context.DeadlineExceeded)
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package chatproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TranscriptPollInterval is how often a followed transcript is checked for
+// new lines.
+var TranscriptPollInterval = 250 * time.Millisecond
+
+// TranscriptPaths returns the paths of the recorded transcripts (audit
+// logs), oldest first. Transcripts are found in the directory set in the
+// config file, if any.
+func TranscriptPaths() ([]string, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	dir := expandHome(cfg.Transcript.Dir)
+	if dir == "" {
+		dir, err = getAuditLogDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	// Transcripts are named by their creation time, so sort chronologically.
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// FindTranscript returns the path of the transcript for a session. The
+// session may be a path to a transcript, or the prefix of a transcript
+// name such as "2023-06-01" or "2023-06-01_14-30", in which case the most
+// recent match is used. An empty session selects the most recent transcript.
+func FindTranscript(session string) (string, error) {
+	if session != "" {
+		if _, err := os.Stat(session); err == nil {
+			return session, nil
+		}
+	}
+	paths, err := TranscriptPaths()
+	if err != nil {
+		return "", err
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		if strings.HasPrefix(filepath.Base(paths[i]), session) {
+			return paths[i], nil
+		}
+	}
+	if session == "" {
+		return "", errors.New("no transcripts found")
+	}
+	return "", fmt.Errorf("no transcript found for session %q", session)
+}
+
+// FollowTranscript writes the transcript at path to w, coloring each line
+// by the role that produced it. If follow is set, it then waits for lines
+// to be appended, like tail -f, until the context is cancelled.
+func FollowTranscript(ctx context.Context, path string, w io.Writer, theme Theme, follow bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var partial string
+	role := ""
+	for {
+		chunk, err := r.ReadString('\n')
+		partial += chunk
+		if err == nil {
+			role = writeTranscriptLine(w, theme, strings.TrimSuffix(partial, "\n"), role)
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		if !follow {
+			if partial != "" {
+				writeTranscriptLine(w, theme, partial, role)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(TranscriptPollInterval):
+		}
+	}
+}
+
+// writeTranscriptLine writes a line in the color of its role. Lines
+// without a role prefix continue the previous message, so they keep the
+// color of the role returned by the previous call.
+func writeTranscriptLine(w io.Writer, theme Theme, line, role string) string {
+	if prefix, _, ok := strings.Cut(line, ") "); ok {
+		switch strings.ToLower(prefix) {
+		case RoleUser, RoleBot, RoleSystem:
+			role = strings.ToLower(prefix)
+		}
+	}
+	switch role {
+	case RoleBot:
+		theme.Assistant.Fprintln(w, line)
+	case RoleSystem:
+		theme.System.Fprintln(w, line)
+	default:
+		fmt.Fprintln(w, line)
+	}
+	return role
+}
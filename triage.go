@@ -0,0 +1,258 @@
+package chatproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// TriageLimit is the most open issues the triage command reads by default.
+const TriageLimit = 100
+
+// TriageExcerpt is the most characters of each issue's description that
+// TriageIssues sends to the model, cut further if the issues would not fit
+// in its context.
+const TriageExcerpt = 600
+
+// TriagePriorities are the priorities an issue can be given, from most to
+// least urgent.
+var TriagePriorities = []string{"high", "medium", "low"}
+
+// OpenIssues returns up to limit of the repository's open issues, newest
+// first, leaving out pull requests.
+func (g *GitHub) OpenIssues(limit int) ([]GitHubIssue, error) {
+	var issues []GitHubIssue
+	for page := 1; len(issues) < limit; page++ {
+		var batch []struct {
+			apiIssue
+			PullRequest json.RawMessage `json:"pull_request"`
+		}
+		err := g.do(http.MethodGet, fmt.Sprintf("/repos/%s/issues?state=open&per_page=100&page=%d", g.Repo, page), nil, &batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, gi := range batch {
+			if gi.PullRequest == nil && len(issues) < limit {
+				issues = append(issues, gi.issue())
+			}
+		}
+		if len(batch) < 100 {
+			break
+		}
+	}
+	return issues, nil
+}
+
+// Labels returns the names of the labels defined in the repository.
+func (g *GitHub) Labels() ([]string, error) {
+	var labels []struct {
+		Name string `json:"name"`
+	}
+	err := g.do(http.MethodGet, "/repos/"+g.Repo+"/labels?per_page=100", nil, &labels)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.Name
+	}
+	return names, nil
+}
+
+// AddLabels adds labels to an issue, keeping those it already has.
+func (g *GitHub) AddLabels(number int, labels []string) error {
+	return g.do(http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/labels", g.Repo, number), map[string][]string{"labels": labels}, nil)
+}
+
+// TriagedIssue is an open issue with the labels and priority suggested for
+// it. Labels are only those the issue doesn't already have.
+type TriagedIssue struct {
+	Number   int      `json:"number"`
+	Title    string   `json:"title"`
+	Summary  string   `json:"summary"`
+	Labels   []string `json:"labels"`
+	Priority string   `json:"priority"`
+}
+
+// TriageCluster is a group of issues about the same thing.
+type TriageCluster struct {
+	Theme   string         `json:"theme"`
+	Summary string         `json:"summary"`
+	Issues  []TriagedIssue `json:"issues"`
+}
+
+// TriageReport is the open issues of a repository, grouped into clusters.
+type TriageReport struct {
+	Repo     string          `json:"repo"`
+	Clusters []TriageCluster `json:"clusters"`
+}
+
+// Issues returns the number of issues in the report.
+func (r TriageReport) Issues() int {
+	n := 0
+	for _, cluster := range r.Clusters {
+		n += len(cluster.Issues)
+	}
+	return n
+}
+
+// triageExcerpts lists the issues with their descriptions cut short enough
+// for all of them to fit in budget tokens, or left out if even that fails.
+func triageExcerpts(issues []GitHubIssue, budget int) string {
+	for excerpt := TriageExcerpt; ; excerpt /= 2 {
+		list := new(strings.Builder)
+		for _, issue := range issues {
+			fmt.Fprintf(list, "#%d %s", issue.Number, issue.Title)
+			if len(issue.Labels) > 0 {
+				fmt.Fprintf(list, " [labels: %s]", strings.Join(issue.Labels, ", "))
+			}
+			list.WriteString("\n")
+			body := strings.Join(strings.Fields(issue.Body), " ")
+			if excerpt > 0 && body != "" {
+				if len(body) > excerpt {
+					body = body[:excerpt] + "..."
+				}
+				fmt.Fprintf(list, "  %s\n", body)
+			}
+		}
+		if excerpt == 0 || guessTokens(list.String()) <= budget {
+			return list.String()
+		}
+	}
+}
+
+// TriageIssues summarises the open issues of repo, groups those about the
+// same thing into clusters, and suggests a priority for each and labels
+// from those the repository defines. Issues the model leaves out are put in
+// a cluster of their own so that the report covers them all.
+func (c *ChatGPTClient) TriageIssues(repo string, issues []GitHubIssue, labels []string) (TriageReport, error) {
+	if len(issues) == 0 {
+		return TriageReport{Repo: repo}, nil
+	}
+	labelRule := "Suggest labels that describe each issue, such as bug, enhancement or documentation."
+	if len(labels) > 0 {
+		labelRule = "Suggest labels for each issue only from the repository's labels: " + strings.Join(labels, ", ") + "."
+	}
+	c.SetPurpose(`Please triage the open GitHub issues provided, for the maintainers of the repository.
+		Group issues about the same problem or area into clusters, give each cluster a short theme and a one-sentence summary, and summarise each issue in one sentence.
+		` + labelRule + `
+		Give each issue a priority, one of ` + strings.Join(TriagePriorities, ", ") + `: high for data loss, security problems, crashes and regressions that many users hit, low for nice-to-haves and cosmetic problems.
+		Reply with only a JSON object, like this:
+		{"clusters": [{"theme": "Uploads", "summary": "Large uploads time out.", "issues": [{"number": 12, "summary": "Uploads over 1GB time out.", "labels": ["bug"], "priority": "high"}]}]}`)
+	c.RecordMessage(RoleUser, "Repository: "+repo+"\n\n"+triageExcerpts(issues, c.diffBudget()))
+	reply, err := c.GetCompletion()
+	if err != nil {
+		return TriageReport{}, err
+	}
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	var triage struct {
+		Clusters []TriageCluster `json:"clusters"`
+	}
+	err = json.Unmarshal([]byte(reply), &triage)
+	if err != nil {
+		return TriageReport{}, errors.New("couldn't parse the triage: " + err.Error())
+	}
+	byNumber := make(map[int]GitHubIssue, len(issues))
+	for _, issue := range issues {
+		byNumber[issue.Number] = issue
+	}
+	report := TriageReport{Repo: repo}
+	seen := make(map[int]bool)
+	for _, cluster := range triage.Clusters {
+		var kept []TriagedIssue
+		for _, ti := range cluster.Issues {
+			issue, ok := byNumber[ti.Number]
+			if !ok || seen[ti.Number] {
+				continue
+			}
+			seen[ti.Number] = true
+			ti.Title = issue.Title
+			ti.Labels = newLabels(ti.Labels, labels, issue.Labels)
+			ti.Priority = strings.ToLower(ti.Priority)
+			if !containsString(TriagePriorities, ti.Priority) {
+				ti.Priority = ""
+			}
+			kept = append(kept, ti)
+		}
+		if len(kept) > 0 {
+			cluster.Issues = kept
+			report.Clusters = append(report.Clusters, cluster)
+		}
+	}
+	var missed []TriagedIssue
+	for _, issue := range issues {
+		if !seen[issue.Number] {
+			missed = append(missed, TriagedIssue{Number: issue.Number, Title: issue.Title})
+		}
+	}
+	if len(missed) > 0 {
+		report.Clusters = append(report.Clusters, TriageCluster{Theme: "Not triaged", Issues: missed})
+	}
+	return report, nil
+}
+
+// newLabels returns the suggested labels that the issue doesn't already
+// have, spelled as the repository defines them, and leaving out any it
+// doesn't define if it defines some.
+func newLabels(suggested, defined, existing []string) []string {
+	var labels []string
+	for _, label := range suggested {
+		if len(defined) > 0 {
+			label = matchLabel(label, defined)
+		}
+		if label == "" || matchLabel(label, existing) != "" || matchLabel(label, labels) != "" {
+			continue
+		}
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// matchLabel returns the label in labels that is the same as label but for
+// case, or the empty string if there isn't one.
+func matchLabel(label string, labels []string) string {
+	for _, l := range labels {
+		if strings.EqualFold(l, strings.TrimSpace(label)) {
+			return l
+		}
+	}
+	return ""
+}
+
+// WriteTriageReport writes the report to w in Markdown, or as JSON if
+// format is OutputJSON.
+func WriteTriageReport(w io.Writer, report TriageReport, format string) error {
+	if format == OutputJSON {
+		return json.NewEncoder(w).Encode(report)
+	}
+	md := new(strings.Builder)
+	fmt.Fprintf(md, "# Triage: %s\n\n", report.Repo)
+	fmt.Fprintf(md, "%d open issues in %d clusters.\n", report.Issues(), len(report.Clusters))
+	for _, cluster := range report.Clusters {
+		fmt.Fprintf(md, "\n## %s (%d)\n\n", cluster.Theme, len(cluster.Issues))
+		if cluster.Summary != "" {
+			fmt.Fprintf(md, "%s\n\n", cluster.Summary)
+		}
+		for _, issue := range cluster.Issues {
+			fmt.Fprintf(md, "- #%d %s", issue.Number, issue.Title)
+			if issue.Priority != "" {
+				fmt.Fprintf(md, " — **%s**", issue.Priority)
+			}
+			if len(issue.Labels) > 0 {
+				fmt.Fprintf(md, " — labels: %s", strings.Join(issue.Labels, ", "))
+			}
+			md.WriteString("\n")
+			if issue.Summary != "" {
+				fmt.Fprintf(md, "  %s\n", issue.Summary)
+			}
+		}
+	}
+	_, err := io.WriteString(w, md.String())
+	return err
+}
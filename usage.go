@@ -0,0 +1,126 @@
+package chatproxy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Pricing is the price in US dollars per thousand tokens for a model.
+type Pricing struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// ModelPricing holds the published prices of the models chatproxy knows about.
+var ModelPricing = map[string]Pricing{
+	openai.GPT4:             {Prompt: 0.03, Completion: 0.06},
+	openai.GPT40613:         {Prompt: 0.03, Completion: 0.06},
+	openai.GPT432K:          {Prompt: 0.06, Completion: 0.12},
+	openai.GPT3Dot5Turbo:    {Prompt: 0.0015, Completion: 0.002},
+	openai.GPT3Dot5Turbo16K: {Prompt: 0.003, Completion: 0.004},
+
+	// Mistral
+	"mistral-large-latest": {Prompt: 0.002, Completion: 0.006},
+	"mistral-small-latest": {Prompt: 0.0002, Completion: 0.0006},
+	"open-mistral-nemo":    {Prompt: 0.00015, Completion: 0.00015},
+	"codestral-latest":     {Prompt: 0.0002, Completion: 0.0006},
+
+	// Groq
+	"llama-3.1-70b-versatile": {Prompt: 0.00059, Completion: 0.00079},
+	"llama-3.1-8b-instant":    {Prompt: 0.00005, Completion: 0.00008},
+	"mixtral-8x7b-32768":      {Prompt: 0.00024, Completion: 0.00024},
+	"gemma2-9b-it":            {Prompt: 0.0002, Completion: 0.0002},
+}
+
+// Usage counts the tokens consumed by one or more completions.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Total returns the combined prompt and completion tokens.
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Add returns the sum of two usages.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Cost:             u.Cost + other.Cost,
+	}
+}
+
+// EstimateUsage estimates the usage and cost of a completion on model, given
+// the prompt and completion text.
+func EstimateUsage(model string, prompt, completion string) Usage {
+	u := Usage{
+		PromptTokens:     guessTokens(prompt),
+		CompletionTokens: guessTokens(completion),
+	}
+	info, _ := describeModel(model)
+	price := info.Pricing
+	u.Cost = float64(u.PromptTokens)/1000*price.Prompt + float64(u.CompletionTokens)/1000*price.Completion
+	return u
+}
+
+// WithUsageReport prints the token usage and estimated cost after every
+// completion, so users can see when a conversation is getting expensive.
+func WithUsageReport(report bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.usageReport = report
+		return c
+	}
+}
+
+// LastUsage returns the estimated usage of the most recent completion.
+func (c *ChatGPTClient) LastUsage() Usage {
+	return c.lastUsage
+}
+
+// SessionUsage returns the estimated usage of every completion made by the client.
+func (c *ChatGPTClient) SessionUsage() Usage {
+	return c.sessionUsage
+}
+
+func (c *ChatGPTClient) recordUsage(model, prompt, completion string, latency time.Duration) {
+	c.answeredBy = fallbackModel{provider: c.provider, model: model}
+	c.lastUsage = EstimateUsage(model, prompt, completion)
+	c.sessionUsage = c.sessionUsage.Add(c.lastUsage)
+	c.Logger().Info("completion",
+		"model", model,
+		"provider", c.provider,
+		"prompt_tokens", c.lastUsage.PromptTokens,
+		"completion_tokens", c.lastUsage.CompletionTokens,
+		"cost", c.lastUsage.Cost,
+		"latency", latency.Round(time.Millisecond),
+	)
+	c.logUsage(model)
+	c.metrics.ObserveUsage(model, c.lastUsage)
+	if c.usageReport {
+		c.theme.System.Fprintf(c.output, "prompt %s / completion %s / total session %s tokens (~$%.2f)\n",
+			formatThousands(c.lastUsage.PromptTokens),
+			formatThousands(c.lastUsage.CompletionTokens),
+			formatThousands(c.sessionUsage.Total()),
+			c.sessionUsage.Cost,
+		)
+	}
+}
+
+func formatThousands(n int) string {
+	s := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + formatThousands(-n)
+	}
+	out := ""
+	for len(s) > 3 {
+		out = "," + s[len(s)-3:] + out
+		s = s[:len(s)-3]
+	}
+	return fmt.Sprint(s, out)
+}
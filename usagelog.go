@@ -0,0 +1,183 @@
+package chatproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// UsageRecord is the estimated usage of one completion, as recorded in the
+// usage log.
+type UsageRecord struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Model   string    `json:"model"`
+	Usage
+}
+
+// UsageSummary totals the usage of the records that share a key, such as a
+// day, command or model.
+type UsageSummary struct {
+	Key      string `json:"key"`
+	Requests int    `json:"requests"`
+	Usage
+}
+
+// Ways usage can be grouped by SummariseUsage.
+const (
+	UsageByDay     = "day"
+	UsageByCommand = "command"
+	UsageByModel   = "model"
+)
+
+// UsageLogPath returns the path of the usage log, a file of JSON lines with
+// one UsageRecord for every completion.
+func UsageLogPath() (string, error) {
+	dir, err := getStateDir("usage")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage.jsonl"), nil
+}
+
+// WithUsageLog records the usage of every completion to w instead of the
+// usage log.
+func WithUsageLog(w io.Writer) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.usageLog = w
+		return c
+	}
+}
+
+// logUsage appends the usage of the last completion to the usage log. The
+// log is opened for each record, so that concurrent commands can share it.
+// In stateless mode there is no usage log.
+func (c *ChatGPTClient) logUsage(model string) {
+	record := UsageRecord{Time: time.Now(), Command: c.command, Model: model, Usage: c.lastUsage}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if c.usageLog != nil {
+		c.usageLog.Write(line)
+		return
+	}
+	if stateless() {
+		return
+	}
+	path, err := UsageLogPath()
+	if err != nil {
+		c.Logger().Debug("not recording usage", "error", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		c.Logger().Debug("not recording usage", "error", err)
+		return
+	}
+	defer f.Close()
+	f.Write(line)
+}
+
+// ReadUsageLog reads the usage records from r, skipping lines that can't
+// be parsed.
+func ReadUsageLog(r io.Reader) ([]UsageRecord, error) {
+	var records []UsageRecord
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record UsageRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// SummariseUsage totals the records made since the given time, grouped by
+// day, command or model, and sorted by key.
+func SummariseUsage(records []UsageRecord, by string, since time.Time) ([]UsageSummary, error) {
+	totals := map[string]*UsageSummary{}
+	for _, r := range records {
+		if r.Time.Before(since) {
+			continue
+		}
+		var key string
+		switch by {
+		case UsageByDay:
+			key = r.Time.Local().Format("2006-01-02")
+		case UsageByCommand:
+			key = r.Command
+		case UsageByModel:
+			key = r.Model
+		default:
+			return nil, fmt.Errorf("can't group usage by %q, expected %s, %s or %s", by, UsageByDay, UsageByCommand, UsageByModel)
+		}
+		if key == "" {
+			key = "(none)"
+		}
+		s, ok := totals[key]
+		if !ok {
+			s = &UsageSummary{Key: key}
+			totals[key] = s
+		}
+		s.Requests++
+		s.Usage = s.Usage.Add(r.Usage)
+	}
+	summaries := make([]UsageSummary, 0, len(totals))
+	for _, s := range totals {
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Key < summaries[j].Key })
+	return summaries, nil
+}
+
+// ParseSince parses the start of a usage report: a date such as 2023-06-01,
+// a number of days such as 7d, or a duration such as 12h.
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.New("since must be a date such as 2023-06-01, days such as 7d, or a duration such as 12h")
+	}
+	return now.Add(-d), nil
+}
+
+// writeUsageSummaries prints summaries as a table with a total row.
+func writeUsageSummaries(w io.Writer, by string, summaries []UsageSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tREQUESTS\tPROMPT\tCOMPLETION\tCOST\n", strings.ToUpper(by))
+	total := UsageSummary{Key: "TOTAL"}
+	for _, s := range summaries {
+		writeUsageRow(tw, s)
+		total.Requests += s.Requests
+		total.Usage = total.Usage.Add(s.Usage)
+	}
+	writeUsageRow(tw, total)
+	return tw.Flush()
+}
+
+func writeUsageRow(w io.Writer, s UsageSummary) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\t$%.2f\n", s.Key, s.Requests,
+		formatThousands(s.PromptTokens), formatThousands(s.CompletionTokens), s.Cost)
+}
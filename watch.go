@@ -0,0 +1,146 @@
+package chatproxy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watcher tracks files and directories on disk and reports which of them
+// have changed since they were last checked. It polls modification times
+// rather than relying on platform specific notifications.
+type Watcher struct {
+	snapshots map[string]time.Time
+}
+
+// NewWatcher returns a Watcher that is not yet watching any paths.
+func NewWatcher() *Watcher {
+	return &Watcher{snapshots: map[string]time.Time{}}
+}
+
+// Add starts watching path, recording its current state.
+func (w *Watcher) Add(path string) {
+	w.snapshots[path] = lastModified(path)
+}
+
+// Paths returns the watched paths in sorted order.
+func (w *Watcher) Paths() []string {
+	var paths []string
+	for p := range w.snapshots {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Changed returns the watched paths that have been modified, created or
+// removed since they were added or last reported as changed.
+func (w *Watcher) Changed() []string {
+	var changed []string
+	for _, path := range w.Paths() {
+		latest := lastModified(path)
+		if !latest.Equal(w.snapshots[path]) {
+			w.snapshots[path] = latest
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// lastModified returns the most recent modification time of the path, or of
+// any non hidden file beneath it when the path is a directory. A missing
+// path has the zero time.
+func lastModified(path string) time.Time {
+	var latest time.Time
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != path && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest
+}
+
+// WithWatch enables watch mode, where files loaded into the chat are
+// re-loaded whenever they change on disk, so the conversation always
+// reasons over their current contents. Changes are checked before each turn.
+func WithWatch(watch bool) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		if watch {
+			c.watcher = NewWatcher()
+		} else {
+			c.watcher = nil
+		}
+		return c
+	}
+}
+
+// Watch starts watching a path that has been loaded into the conversation
+// or embedded. It has no effect unless watch mode is enabled.
+func (c *ChatGPTClient) Watch(path string) {
+	if c.watcher == nil {
+		return
+	}
+	c.watcher.Add(path)
+}
+
+// RefreshWatched re-loads any watched paths that have changed on disk,
+// recording their new contents in the conversation and re-creating their
+// embeddings if they had been embedded.
+func (c *ChatGPTClient) RefreshWatched() error {
+	if c.watcher == nil {
+		return nil
+	}
+	for _, path := range c.watcher.Changed() {
+		c.LogOut("Reloading changed path", path)
+		msg, err := c.GetContent(path)
+		if err != nil {
+			return err
+		}
+		c.RecordMessage(RoleUser, "The following content has changed on disk and replaces any previous version:\n"+msg)
+		if c.hasEmbeddings(path) {
+			// Embeddings are made from the file as it is, not as it is
+			// formatted for the conversation, so that Go is still chunked
+			// by declaration.
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			c.RemoveEmbeddings(path)
+			c.CreateEmbeddings(path, bytes.NewReader(src))
+		}
+	}
+	return nil
+}
+
+func (c *ChatGPTClient) hasEmbeddings(origin string) bool {
+	for _, e := range c.embeddings {
+		if e.Origin == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveEmbeddings discards all embeddings that were created from origin.
+func (c *ChatGPTClient) RemoveEmbeddings(origin string) {
+	kept := c.embeddings[:0]
+	for _, e := range c.embeddings {
+		if e.Origin != origin {
+			kept = append(kept, e)
+		}
+	}
+	c.embeddings = kept
+}
@@ -0,0 +1,576 @@
+package chatproxy
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/net/websocket"
+)
+
+//go:embed web.html
+var webPage []byte
+
+// MaxUploadBytes limits the total size of the files uploaded in one request
+// to the web UI.
+var MaxUploadBytes int64 = 32 << 20
+
+// WebServer serves a minimal browser chat UI backed by a ChatGPTClient, so
+// the library's features can be used without a terminal. The UI holds a
+// single conversation, as it is meant to be run locally by one person, but
+// each WebSocket connection to /api/ws has a conversation of its own, as
+// does each request to the OpenAI compatible API at GatewayPath.
+type WebServer struct {
+	mu     sync.Mutex
+	client *ChatGPTClient
+	mux    *http.ServeMux
+}
+
+// NewWebServer returns a WebServer for the conversation held by c. As in
+// the terminal chat, the first message sent describes the purpose of the
+// assistant.
+func NewWebServer(c *ChatGPTClient) *WebServer {
+	s := &WebServer{client: c, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/api/history", s.handleHistory)
+	s.mux.HandleFunc("/api/chat", s.handleChat)
+	s.mux.HandleFunc("/api/upload", s.handleUpload)
+	s.mux.HandleFunc("/api/sessions", s.handleSessions)
+	s.mux.HandleFunc("/api/sessions/save", s.handleSaveSession)
+	s.mux.HandleFunc("/api/sessions/load", s.handleLoadSession)
+	s.mux.HandleFunc(GatewayPath, s.handleCompletions)
+	s.mux.Handle("/api/ws", websocket.Handler(s.handleWebSocket))
+	return s
+}
+
+// ServeHTTP rejects requests made by pages from other origins, which could
+// otherwise reach the server on localhost, before handling the request.
+// Requests must be addressed to localhost by name or loopback address, so
+// that a page whose own name has been rebound to 127.0.0.1 can't reach it
+// as its own origin either.
+func (s *WebServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !loopbackHost(r.Host) {
+		http.Error(w, "requests must be made to localhost", http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			http.Error(w, "cross origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// loopbackHost reports whether host, a Host header with or without a port,
+// is localhost or a loopback address.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(webPage)
+}
+
+func (s *WebServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, s.client.chatHistory)
+}
+
+// handleChat sends a message and streams the reply back as server-sent
+// events: a "token" event for each part of the reply as it arrives, then
+// "done", or "error" if the completion failed.
+func (s *WebServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Message string `json:"message"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "a message is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	events := &eventStream{w: w}
+	c := s.client
+	if len(c.chatHistory) == 0 {
+		c.SetPurpose(req.Message)
+		events.send("done", "Purpose set")
+		return
+	}
+	c.RecordMessage(RoleUser, req.Message)
+	streamed := false
+	c.onToken = func(token string) {
+		streamed = true
+		events.send("token", token)
+	}
+	reply, err := c.GetCompletion()
+	c.onToken = nil
+	if err != nil {
+		c.rollbackUnanswered()
+		c.LogErr(err)
+		events.send("error", err.Error())
+		return
+	}
+	if !streamed {
+		events.send("token", reply)
+	}
+	c.RecordMessage(RoleBot, reply)
+	events.send("done", "")
+}
+
+// webSocketEvent is a message sent to a WebSocket client. The events are
+// those of handleChat, with the same data.
+type webSocketEvent struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// handleCompletions answers a chat completion request in the OpenAI API's
+// format with the server's settings, so that HTTP clients and OpenAI SDKs
+// can use the server without a WebSocket.
+func (s *WebServer) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	s.newConversation().serveCompletion(w, r)
+}
+
+// serveCompletion answers a chat completion request in the OpenAI API's
+// format, replying to the conversation in it with c, which should be a new
+// conversation. With "stream": true, the reply is streamed as server-sent
+// events of chat.completion.chunk objects, ending with "data: [DONE]", as
+// the OpenAI API streams them.
+func (c *ChatGPTClient) serveCompletion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed")
+		return
+	}
+	var req gatewayRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, MaxUploadBytes)).Decode(&req)
+	if err != nil || len(req.Messages) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", "the body must be a chat completion request with messages")
+		return
+	}
+	if req.Model != "" {
+		c.model = req.Model
+	}
+	for i, m := range req.Messages {
+		switch {
+		case i == 0 && m.Role == RoleSystem:
+			c.SetPurpose(messageText(m.Content))
+		case m.Role == RoleUser, m.Role == RoleBot, m.Role == RoleSystem:
+			c.RecordMessage(m.Role, messageText(m.Content))
+		default:
+			writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("unknown role %q, expected one of %s, %s or %s", m.Role, RoleSystem, RoleUser, RoleBot))
+			return
+		}
+	}
+	id := "chatcmpl-" + newCompletionID()
+	created := time.Now().Unix()
+	if !req.Stream {
+		c.streaming = false
+		reply, err := c.GetCompletion()
+		if err != nil {
+			c.LogErr(err)
+			status, kind := apiErrorStatus(err)
+			writeAPIError(w, status, kind, err.Error())
+			return
+		}
+		c.RecordMessage(RoleBot, reply)
+		usage := c.LastUsage()
+		writeJSON(w, openai.ChatCompletionResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   c.model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: RoleBot, Content: reply},
+				FinishReason: openai.FinishReasonStop,
+			}},
+			Usage: openai.Usage{PromptTokens: usage.PromptTokens, CompletionTokens: usage.CompletionTokens, TotalTokens: usage.Total()},
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	chunk := func(delta openai.ChatCompletionStreamChoiceDelta, finish *openai.FinishReason) {
+		writeEvent(w, completionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   c.model,
+			Choices: []completionChunkChoice{{Delta: delta, FinishReason: finish}},
+		})
+	}
+	chunk(openai.ChatCompletionStreamChoiceDelta{Role: RoleBot}, nil)
+	c.streaming = true
+	streamed := false
+	c.onToken = func(token string) {
+		streamed = true
+		chunk(openai.ChatCompletionStreamChoiceDelta{Content: token}, nil)
+	}
+	reply, err := c.GetCompletion()
+	c.onToken = nil
+	if err != nil {
+		// The status has been sent, so the error is sent as an event, as
+		// the OpenAI API does.
+		c.LogErr(err)
+		_, kind := apiErrorStatus(err)
+		writeEvent(w, map[string]any{"error": map[string]string{"message": err.Error(), "type": kind}})
+		return
+	}
+	if !streamed {
+		chunk(openai.ChatCompletionStreamChoiceDelta{Content: reply}, nil)
+	}
+	c.RecordMessage(RoleBot, reply)
+	stop := openai.FinishReasonStop
+	chunk(openai.ChatCompletionStreamChoiceDelta{}, &stop)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// completionChunk is a chat.completion.chunk, as the OpenAI API streams
+// them. Unlike openai.ChatCompletionStreamResponse, the finish reason of
+// a chunk before the last is null, rather than an empty string.
+type completionChunk struct {
+	ID      string                  `json:"id"`
+	Object  string                  `json:"object"`
+	Created int64                   `json:"created"`
+	Model   string                  `json:"model"`
+	Choices []completionChunkChoice `json:"choices"`
+}
+
+type completionChunkChoice struct {
+	Index        int                                    `json:"index"`
+	Delta        openai.ChatCompletionStreamChoiceDelta `json:"delta"`
+	FinishReason *openai.FinishReason                   `json:"finish_reason"`
+}
+
+// writeEvent writes v as the data of a server-sent event, without an event
+// name, as the OpenAI API streams completions, and flushes it.
+func writeEvent(w http.ResponseWriter, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// apiErrorStatus returns the HTTP status and OpenAI error type to reply to
+// a failed completion with.
+func apiErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, "invalid_request_error"
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests, "rate_limit_error"
+	case errors.Is(err, ErrContextTooLong), errors.Is(err, ErrCostDeclined), errors.Is(err, ErrModerated):
+		return http.StatusBadRequest, "invalid_request_error"
+	}
+	return http.StatusBadGateway, "api_error"
+}
+
+// newCompletionID returns a random id for a completion.
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleWebSocket holds a conversation of its own with a WebSocket client,
+// starting from the saved session named by the session query parameter if
+// there is one. The client sends messages as {"message": "..."}, the first
+// of which, in a new conversation, describes the purpose of the assistant.
+// Each reply is streamed back as a "token" event for each part of it as it
+// arrives, then "done", or "error" if the completion failed.
+func (s *WebServer) handleWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	c := s.newConversation()
+	if name := ws.Request().URL.Query().Get("session"); name != "" {
+		path, err := sessionPathFor(name)
+		if err == nil {
+			err = c.LoadSession(path)
+		}
+		if err != nil {
+			websocket.JSON.Send(ws, webSocketEvent{Event: "error", Data: err.Error()})
+			return
+		}
+	}
+	for {
+		var req struct {
+			Message string `json:"message"`
+		}
+		err := websocket.JSON.Receive(ws, &req)
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(req.Message) == "" {
+			websocket.JSON.Send(ws, webSocketEvent{Event: "error", Data: "a message is required"})
+			continue
+		}
+		if len(c.chatHistory) == 0 {
+			c.SetPurpose(req.Message)
+			websocket.JSON.Send(ws, webSocketEvent{Event: "done", Data: "Purpose set"})
+			continue
+		}
+		c.RecordMessage(RoleUser, req.Message)
+		streamed := false
+		c.onToken = func(token string) {
+			streamed = true
+			websocket.JSON.Send(ws, webSocketEvent{Event: "token", Data: token})
+		}
+		reply, err := c.GetCompletion()
+		c.onToken = nil
+		if err != nil {
+			c.rollbackUnanswered()
+			c.LogErr(err)
+			websocket.JSON.Send(ws, webSocketEvent{Event: "error", Data: err.Error()})
+			continue
+		}
+		if !streamed {
+			websocket.JSON.Send(ws, webSocketEvent{Event: "token", Data: reply})
+		}
+		c.RecordMessage(RoleBot, reply)
+		websocket.JSON.Send(ws, webSocketEvent{Event: "done"})
+	}
+}
+
+// newConversation returns a client for a conversation of its own, with the
+// settings, API client and transcript of the server's.
+func (s *WebServer) newConversation() *ChatGPTClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.newConversation()
+}
+
+// handleUpload adds the uploaded files to the conversation, reading them
+// with MessageFromFiles as if they had been loaded from a directory.
+func (s *WebServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadBytes)
+	err := r.ParseMultipartForm(MaxUploadBytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		http.Error(w, "no files uploaded", http.StatusBadRequest)
+		return
+	}
+	for _, fh := range files {
+		if name := filepath.Base(fh.Filename); name == "." || name == ".." || name == string(filepath.Separator) {
+			http.Error(w, fmt.Sprintf("%q isn't a file name", fh.Filename), http.StatusBadRequest)
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.client.chatHistory) == 0 {
+		http.Error(w, "describe the purpose of the assistant before uploading files", http.StatusConflict)
+		return
+	}
+	dir, err := os.MkdirTemp("", "chatproxy-upload")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	// Each file is saved in a directory of its own, so that files with the
+	// same name don't overwrite each other.
+	var subdirs []string
+	for i, fh := range files {
+		subdir := filepath.Join(dir, fmt.Sprintf("%04d", i))
+		err := os.Mkdir(subdir, 0700)
+		if err == nil {
+			err = saveUpload(fh, filepath.Join(subdir, filepath.Base(fh.Filename)))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subdirs = append(subdirs, subdir)
+	}
+	msg, err := s.client.MessageFromFiles(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Name the files as they were uploaded, rather than by the temporary directory.
+	for _, subdir := range subdirs {
+		msg = strings.ReplaceAll(msg, "--"+subdir+string(filepath.Separator), "--")
+	}
+	s.client.RecordMessage(RoleUser, msg)
+	writeJSON(w, map[string]int{"files": len(files), "tokens": guessTokens(msg)})
+}
+
+func saveUpload(fh *multipart.FileHeader, path string) error {
+	src, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func (s *WebServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	names, err := SessionNames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, names)
+}
+
+func (s *WebServer) handleSaveSession(w http.ResponseWriter, r *http.Request) {
+	path, ok := webSessionPath(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.client.SaveSession(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, s.client.chatHistory)
+}
+
+func (s *WebServer) handleLoadSession(w http.ResponseWriter, r *http.Request) {
+	path, ok := webSessionPath(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.client.LoadSession(path)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, s.client.chatHistory)
+}
+
+// webSessionPath reads the session name from a request. Only bare names are
+// accepted, so a browser can't read or write files outside the sessions
+// directory.
+func webSessionPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return "", false
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, errSessionName.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	path, err := sessionPathFor(req.Name)
+	if errors.Is(err, errSessionName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return path, true
+}
+
+var errSessionName = errors.New("a session name without a path or extension is required")
+
+// sessionPathFor returns the path of the session saved with the given name,
+// which must be a bare name.
+func sessionPathFor(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || filepath.Ext(name) != "" {
+		return "", errSessionName
+	}
+	return SessionPath(name)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// eventStream writes server-sent events, flushing each one so the browser
+// receives it immediately. Data is JSON encoded so it fits on one line.
+type eventStream struct {
+	w http.ResponseWriter
+}
+
+func (e *eventStream) send(event, data string) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, encoded)
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,185 @@
+package chatproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// WebhookEvent is what is posted to each webhook when a completion is
+// made, as JSON.
+type WebhookEvent struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Caller  string    `json:"caller,omitempty"`
+	Model   string    `json:"model"`
+	Prompt  string    `json:"prompt"`
+	Reply   string    `json:"reply"`
+	Usage   Usage     `json:"usage"`
+}
+
+// WebhookSignatureHeader is the header that carries the signature of a
+// webhook's body, when CHATPROXY_WEBHOOK_SECRET is set: "sha256=" and the
+// hex HMAC-SHA256 of the body, keyed with the secret, as GitHub signs its
+// webhooks.
+const WebhookSignatureHeader = "X-Chatproxy-Signature"
+
+// A webhook is tried WebhookAttempts times before it is given up on,
+// waiting WebhookRetryDelay, then twice that, between attempts.
+var (
+	WebhookAttempts   = 3
+	WebhookRetryDelay = time.Second
+)
+
+// webhookDeliveries tracks the webhooks being delivered, so that commands
+// can wait for them before exiting.
+var (
+	webhookDeliveries sync.WaitGroup
+	webhookErrors     sync.Mutex
+)
+
+func validateWebhook(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook %q must be an http or https URL", u)
+	}
+	return nil
+}
+
+// WithWebhooks posts a WebhookEvent to each of the URLs after every
+// completion, so that other systems can act on replies as they are made.
+// Webhooks are delivered in the background, and a failure to deliver one
+// is reported on the error stream rather than failing the completion.
+func WithWebhooks(urls ...string) ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		c.webhooks = urls
+		return c
+	}
+}
+
+// withConfigWebhooks posts to the webhooks set in the config, for server
+// commands that weren't given any with --webhook.
+func withConfigWebhooks() ClientOption {
+	return func(c *ChatGPTClient) *ChatGPTClient {
+		if c.webhooks == nil {
+			c.webhooks = c.config.Webhooks
+		}
+		return c
+	}
+}
+
+// WaitForWebhooks waits until the webhooks of every completion made so far
+// have been delivered or given up on.
+func WaitForWebhooks() {
+	webhookDeliveries.Wait()
+}
+
+// notifyWebhooks posts the last completion, the message it replied to, as
+// it was sent, and its usage to the client's webhooks.
+func (c *ChatGPTClient) notifyWebhooks(model, reply string) {
+	if len(c.webhooks) == 0 {
+		return
+	}
+	var prompt string
+	for i := len(c.chatHistory) - 1; i >= 0; i-- {
+		if c.chatHistory[i].Role == RoleUser {
+			prompt = c.chatHistory[i].Content
+			break
+		}
+	}
+	c.sendWebhooks(WebhookEvent{
+		Time:    time.Now(),
+		Command: c.command,
+		Model:   model,
+		Prompt:  c.maskPIIIn(prompt),
+		Reply:   reply,
+		Usage:   c.lastUsage,
+	})
+}
+
+// sendWebhooks posts the event to each of the client's webhooks in the
+// background, with secrets redacted, as they are from transcripts.
+func (c *ChatGPTClient) sendWebhooks(event WebhookEvent) {
+	event.Prompt, event.Reply = c.redact(event.Prompt), c.redact(event.Reply)
+	body, err := json.Marshal(event)
+	if err != nil {
+		c.LogErr(err)
+		return
+	}
+	hc := c.httpClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second}
+	}
+	signature := ""
+	if secret := os.Getenv("CHATPROXY_WEBHOOK_SECRET"); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	for _, u := range c.webhooks {
+		webhookDeliveries.Add(1)
+		go func(u string) {
+			defer webhookDeliveries.Done()
+			err := postWebhook(hc, u, body, signature)
+			if err != nil {
+				webhookErrors.Lock()
+				defer webhookErrors.Unlock()
+				fmt.Fprintf(c.errorStream, "delivering webhook to %s: %v\n", u, err)
+			}
+		}(u)
+	}
+}
+
+// postWebhook posts the body to a webhook, trying again after network
+// errors and server errors.
+func postWebhook(hc *http.Client, u string, body []byte, signature string) error {
+	delay := WebhookRetryDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = postWebhookOnce(hc, u, body, signature)
+		if err == nil || attempt >= WebhookAttempts {
+			return err
+		}
+		if status, ok := err.(webhookStatusError); ok && status < 500 && status != http.StatusTooManyRequests {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// webhookStatusError is the status of a webhook that failed.
+type webhookStatusError int
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("%d %s", int(e), http.StatusText(int(e)))
+}
+
+func postWebhookOnce(hc *http.Client, u string, body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatproxy/"+version())
+	if signature != "" {
+		req.Header.Set(WebhookSignatureHeader, signature)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return webhookStatusError(resp.StatusCode)
+	}
+	return nil
+}